@@ -0,0 +1,251 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/v3"
+)
+
+// groupPath returns the group with its elements separated by "/"
+func groupPath(group string) string {
+	return strings.Join(pwsafe.SplitGroup(group), "/")
+}
+
+// parseGroupPath returns the group for a path with its elements separated by
+// "/"
+func parseGroupPath(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return ""
+	}
+	return pwsafe.JoinGroup(strings.Split(path, "/")...)
+}
+
+// inGroup returns true if the group is the parent group or one of its
+// subgroups
+func inGroup(group, parent_group string) bool {
+	parent := pwsafe.SplitGroup(parent_group)
+	elements := pwsafe.SplitGroup(group)
+	if len(elements) < len(parent) {
+		return false
+	}
+	for i := range parent {
+		if elements[i] != parent[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// removeEmptyGroup removes the group of a record from the empty groups
+func (s *Server) removeEmptyGroup(group string) {
+	header := s.db.Header().(*v3.Header)
+	var empty []string
+	for _, g := range header.EmptyGroups() {
+		if g != group {
+			empty = append(empty, g)
+		}
+	}
+	header.SetEmptyGroups(empty)
+}
+
+// Group is the API representation of a group
+type Group struct {
+	Path    string `json:"path"`
+	Records int    `json:"records"`
+}
+
+// GroupList is the response of listing groups
+type GroupList struct {
+	Groups []Group `json:"groups"`
+}
+
+// GroupInput names a group to create, or the new path of a renamed group
+type GroupInput struct {
+	Path string `json:"path"`
+}
+
+// groups returns the groups the token may access with the number of records
+// directly in each. Parents of groups with records are groups as well.
+func (s *Server) groups(token *Token) map[string]int {
+	groups := make(map[string]int)
+	add := func(group string, records int) {
+		elements := pwsafe.SplitGroup(group)
+		for i := 1; i <= len(elements); i++ {
+			parent := pwsafe.JoinGroup(elements[:i]...)
+			if token.allowsGroup(parent) {
+				if _, ok := groups[parent]; !ok {
+					groups[parent] = 0
+				}
+			}
+		}
+		if token.allowsGroup(group) && group != "" {
+			groups[group] += records
+		}
+	}
+	for _, record := range s.db.Records() {
+		add(record.Group(), 1)
+	}
+	for _, group := range s.db.Header().EmptyGroups() {
+		add(group, 0)
+	}
+	return groups
+}
+
+func (s *Server) listGroups(req *request) (int, interface{}, error) {
+	list := GroupList{Groups: []Group{}}
+	for group, records := range s.groups(req.token) {
+		list.Groups = append(list.Groups, Group{Path: groupPath(group),
+			Records: records})
+	}
+	sort.Slice(list.Groups, func(i, j int) bool {
+		return list.Groups[i].Path < list.Groups[j].Path
+	})
+	return http.StatusOK, list, nil
+}
+
+// groupParam returns the group named by the path parameter, which the token
+// must be allowed to access
+func groupParam(req *request) (string, error) {
+	path := req.r.URL.Query().Get("path")
+	group := parseGroupPath(path)
+	if group == "" {
+		return "", errorf(http.StatusBadRequest, "expected a path parameter")
+	}
+	if !req.token.allowsGroup(group) {
+		return "", errorf(http.StatusForbidden, "token may not access group "+
+			"%q", path)
+	}
+	return group, nil
+}
+
+// createGroup adds an empty group
+func (s *Server) createGroup(req *request) (int, interface{}, error) {
+	var input GroupInput
+	if err := decode(req, &input); err != nil {
+		return 0, nil, err
+	}
+	group := parseGroupPath(input.Path)
+	if group == "" {
+		return 0, nil, errorf(http.StatusBadRequest, "a path is required")
+	}
+	if !req.token.allowsGroup(group) {
+		return 0, nil, errorf(http.StatusForbidden, "token may not access "+
+			"group %q", input.Path)
+	}
+	if _, ok := s.groups(req.token)[group]; ok {
+		return 0, nil, errorf(http.StatusConflict, "group %q exists",
+			input.Path)
+	}
+	header := s.db.Header().(*v3.Header)
+	header.SetEmptyGroups(append(header.EmptyGroups(), group))
+	if err := s.save(); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusCreated, Group{Path: groupPath(group)}, nil
+}
+
+// renameGroup moves a group with its records and subgroups
+func (s *Server) renameGroup(req *request) (int, interface{}, error) {
+	group, err := groupParam(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	var input GroupInput
+	if err = decode(req, &input); err != nil {
+		return 0, nil, err
+	}
+	target := parseGroupPath(input.Path)
+	if target == "" {
+		return 0, nil, errorf(http.StatusBadRequest, "a path is required")
+	}
+	if !req.token.allowsGroup(target) {
+		return 0, nil, errorf(http.StatusForbidden, "token may not access "+
+			"group %q", input.Path)
+	}
+	if inGroup(target, group) {
+		return 0, nil, errorf(http.StatusBadRequest, "cannot move a group "+
+			"into itself")
+	}
+
+	rename := func(g string) string {
+		elements := pwsafe.SplitGroup(g)
+		rest := elements[len(pwsafe.SplitGroup(group)):]
+		return pwsafe.JoinGroup(append(pwsafe.SplitGroup(target),
+			rest...)...)
+	}
+	var records []*v3.Record
+	for _, r := range s.db.Records() {
+		record := r.(*v3.Record)
+		if !inGroup(record.Group(), group) {
+			continue
+		}
+		if record.Protected() {
+			return 0, nil, errorf(http.StatusConflict, "group holds the "+
+				"protected record %s", record.UUID())
+		}
+		records = append(records, record)
+	}
+	header := s.db.Header().(*v3.Header)
+	var empty []string
+	found := len(records) > 0
+	for _, g := range header.EmptyGroups() {
+		if inGroup(g, group) {
+			g, found = rename(g), true
+		}
+		empty = append(empty, g)
+	}
+	if !found {
+		return 0, nil, errorf(http.StatusNotFound, "no group %q",
+			groupPath(group))
+	}
+
+	now := time.Now()
+	for _, record := range records {
+		record.SetGroup(rename(record.Group()))
+		record.SetMtime(now)
+	}
+	header.SetEmptyGroups(empty)
+	if err = s.save(); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, Group{Path: groupPath(target),
+		Records: len(records)}, nil
+}
+
+// deleteGroup removes a group without records
+func (s *Server) deleteGroup(req *request) (int, interface{}, error) {
+	group, err := groupParam(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, record := range s.db.Records() {
+		if inGroup(record.Group(), group) {
+			return 0, nil, errorf(http.StatusConflict, "group %q has records",
+				groupPath(group))
+		}
+	}
+	header := s.db.Header().(*v3.Header)
+	var empty []string
+	found := false
+	for _, g := range header.EmptyGroups() {
+		if inGroup(g, group) {
+			found = true
+			continue
+		}
+		empty = append(empty, g)
+	}
+	if !found {
+		return 0, nil, errorf(http.StatusNotFound, "no group %q",
+			groupPath(group))
+	}
+	header.SetEmptyGroups(empty)
+	if err = s.save(); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusNoContent, nil, nil
+}
@@ -0,0 +1,225 @@
+package api
+
+// openAPIDocument describes the API, served at /v1/openapi.json
+const openAPIDocument = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "pwsafe",
+    "version": "1",
+    "description": "Access to a password safe database. Requests need a bearer token with the listed scope; tokens restricted to groups only see the records in those groups and their subgroups."
+  },
+  "components": {
+    "securitySchemes": {
+      "bearer": {"type": "http", "scheme": "bearer"}
+    },
+    "schemas": {
+      "Error": {
+        "type": "object",
+        "properties": {"error": {"type": "string"}}
+      },
+      "Status": {
+        "type": "object",
+        "properties": {
+          "locked": {"type": "boolean"},
+          "token": {"type": "string"},
+          "scopes": {"type": "array", "items": {"type": "string", "enum": ["read", "write", "lock"]}},
+          "groups": {"type": "array", "items": {"type": "string"}}
+        }
+      },
+      "CustomField": {
+        "type": "object",
+        "properties": {"Name": {"type": "string"}, "Value": {"type": "string"}}
+      },
+      "Record": {
+        "type": "object",
+        "properties": {
+          "uuid": {"type": "string"},
+          "title": {"type": "string"},
+          "group": {"type": "string", "description": "group path with elements separated by /"},
+          "username": {"type": "string"},
+          "password": {"type": "string", "description": "left out of lists"},
+          "url": {"type": "string"},
+          "email": {"type": "string"},
+          "notes": {"type": "string"},
+          "custom_fields": {"type": "array", "items": {"$ref": "#/components/schemas/CustomField"}},
+          "protected": {"type": "boolean"},
+          "ctime": {"type": "string", "format": "date-time"},
+          "mtime": {"type": "string", "format": "date-time"},
+          "password_mtime": {"type": "string", "format": "date-time"},
+          "expiry": {"type": "string", "format": "date-time"}
+        }
+      },
+      "RecordInput": {
+        "type": "object",
+        "description": "fields that are left out are not changed",
+        "properties": {
+          "title": {"type": "string"},
+          "group": {"type": "string"},
+          "username": {"type": "string"},
+          "password": {"type": "string"},
+          "url": {"type": "string"},
+          "email": {"type": "string"},
+          "notes": {"type": "string"},
+          "custom_fields": {"type": "array", "items": {"$ref": "#/components/schemas/CustomField"}}
+        }
+      },
+      "RecordList": {
+        "type": "object",
+        "properties": {"records": {"type": "array", "items": {"$ref": "#/components/schemas/Record"}}}
+      },
+      "Group": {
+        "type": "object",
+        "properties": {"path": {"type": "string"}, "records": {"type": "integer"}}
+      },
+      "GroupInput": {
+        "type": "object",
+        "required": ["path"],
+        "properties": {"path": {"type": "string"}}
+      },
+      "GroupList": {
+        "type": "object",
+        "properties": {"groups": {"type": "array", "items": {"$ref": "#/components/schemas/Group"}}}
+      }
+    },
+    "responses": {
+      "Error": {
+        "description": "error",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      }
+    },
+    "parameters": {
+      "uuid": {"name": "uuid", "in": "path", "required": true, "schema": {"type": "string"}},
+      "path": {"name": "path", "in": "query", "required": true, "description": "group path with elements separated by /", "schema": {"type": "string"}}
+    }
+  },
+  "security": [{"bearer": []}],
+  "paths": {
+    "/v1/status": {
+      "get": {
+        "summary": "Whether the database is locked, and the scopes of the token",
+        "responses": {
+          "200": {"description": "status", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Status"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/unlock": {
+      "post": {
+        "summary": "Unlock the database (scope lock)",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object", "properties": {"passphrase": {"type": "string"}}}}}},
+        "responses": {
+          "200": {"description": "status", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Status"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/lock": {
+      "post": {
+        "summary": "Wipe the database from memory (scope lock)",
+        "responses": {
+          "200": {"description": "status", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Status"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/generate": {
+      "post": {
+        "summary": "Generate a password with a named password policy of the database, or the default policy",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object", "properties": {"policy": {"type": "string"}}}}}},
+        "responses": {
+          "200": {"description": "password", "content": {"application/json": {"schema": {"type": "object", "properties": {"password": {"type": "string"}, "policy": {"type": "string"}}}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/records": {
+      "get": {
+        "summary": "List records without passwords (scope read)",
+        "parameters": [
+          {"name": "query", "in": "query", "description": "query as used by pwsafe find", "schema": {"type": "string"}},
+          {"name": "search", "in": "query", "description": "fuzzy search terms; orders by relevance", "schema": {"type": "string"}},
+          {"name": "group", "in": "query", "description": "group path", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {"description": "records", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RecordList"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "summary": "Create a record (scope write)",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RecordInput"}}}},
+        "responses": {
+          "201": {"description": "record", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Record"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/records/{uuid}": {
+      "parameters": [{"$ref": "#/components/parameters/uuid"}],
+      "get": {
+        "summary": "Get a record with its password (scope read)",
+        "responses": {
+          "200": {"description": "record", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Record"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "patch": {
+        "summary": "Update a record (scope write); protected records cannot be changed",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RecordInput"}}}},
+        "responses": {
+          "200": {"description": "record", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Record"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Delete a record (scope write); protected records and records used by aliases cannot be deleted",
+        "responses": {
+          "204": {"description": "deleted"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/groups": {
+      "get": {
+        "summary": "List groups with the number of records in each (scope read)",
+        "responses": {
+          "200": {"description": "groups", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/GroupList"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "summary": "Create an empty group (scope write)",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/GroupInput"}}}},
+        "responses": {
+          "201": {"description": "group", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Group"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "patch": {
+        "summary": "Move a group with its records and subgroups (scope write)",
+        "parameters": [{"$ref": "#/components/parameters/path"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/GroupInput"}}}},
+        "responses": {
+          "200": {"description": "group", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Group"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Delete a group without records (scope write)",
+        "parameters": [{"$ref": "#/components/parameters/path"}],
+        "responses": {
+          "204": {"description": "deleted"},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/openapi.json": {
+      "get": {
+        "summary": "This document",
+        "security": [],
+        "responses": {"200": {"description": "OpenAPI document"}}
+      }
+    }
+  }
+}
+`
@@ -0,0 +1,263 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/v3"
+)
+
+// Record is the API representation of a record. Lists leave out the
+// password.
+type Record struct {
+	UUID          string               `json:"uuid"`
+	Title         string               `json:"title"`
+	Group         string               `json:"group"`
+	Username      string               `json:"username,omitempty"`
+	Password      string               `json:"password,omitempty"`
+	URL           string               `json:"url,omitempty"`
+	Email         string               `json:"email,omitempty"`
+	Notes         string               `json:"notes,omitempty"`
+	CustomFields  []pwsafe.CustomField `json:"custom_fields,omitempty"`
+	Protected     bool                 `json:"protected,omitempty"`
+	Ctime         *time.Time           `json:"ctime,omitempty"`
+	Mtime         *time.Time           `json:"mtime,omitempty"`
+	PasswordMtime *time.Time           `json:"password_mtime,omitempty"`
+	Expiry        *time.Time           `json:"expiry,omitempty"`
+}
+
+// RecordInput holds the fields of a created or updated record. Fields that
+// are not given are left unchanged.
+type RecordInput struct {
+	Title        *string               `json:"title"`
+	Group        *string               `json:"group"`
+	Username     *string               `json:"username"`
+	Password     *string               `json:"password"`
+	URL          *string               `json:"url"`
+	Email        *string               `json:"email"`
+	Notes        *string               `json:"notes"`
+	CustomFields *[]pwsafe.CustomField `json:"custom_fields"`
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// newRecord returns the API representation of the record. The password of
+// an alias is the password of its base record if the token may access the
+// base record's group, else the reference itself.
+func newRecord(db *v3.Database, token *Token, record *v3.Record,
+	password bool) Record {
+
+	r := Record{
+		UUID:          record.UUID(),
+		Title:         record.Title(),
+		Group:         groupPath(record.Group()),
+		Username:      record.Username(),
+		URL:           record.URL(),
+		Email:         record.Email(),
+		Notes:         record.Notes(),
+		CustomFields:  record.CustomFields(),
+		Protected:     record.Protected(),
+		Ctime:         optionalTime(record.Ctime()),
+		Mtime:         optionalTime(record.Mtime()),
+		PasswordMtime: optionalTime(record.PasswordMtime()),
+		Expiry:        optionalTime(record.Expiry()),
+	}
+	if password {
+		r.Password = record.Password()
+		if base, _ := db.Base(record); base != nil &&
+			token.allowsGroup(base.Group()) {
+			r.Password = base.Password()
+		}
+	}
+	return r
+}
+
+// RecordList is the response of listing records
+type RecordList struct {
+	Records []Record `json:"records"`
+}
+
+// listRecords lists the records the token may access, filtered by the
+// query, fuzzy search terms or group given as parameters
+func (s *Server) listRecords(req *request) (int, interface{}, error) {
+	params := req.r.URL.Query()
+	var query *pwsafe.Query
+	if q := params.Get("query"); q != "" {
+		var err error
+		if query, err = pwsafe.ParseQuery(q); err != nil {
+			return 0, nil, errorf(http.StatusBadRequest, "%s", err)
+		}
+	}
+	group, has_group := params["group"]
+
+	var records []pwsafe.Record
+	for _, record := range s.db.Records() {
+		if !req.token.allowsGroup(record.Group()) ||
+			query != nil && !query.Match(record) {
+			continue
+		}
+		if has_group && !inGroup(record.Group(), parseGroupPath(group[0])) {
+			continue
+		}
+		records = append(records, record)
+	}
+	if terms := params.Get("search"); terms != "" {
+		results := pwsafe.Search(records, terms)
+		records = records[:0]
+		for _, result := range results {
+			records = append(records, result.Record)
+		}
+	}
+
+	list := RecordList{Records: []Record{}}
+	for _, record := range records {
+		list.Records = append(list.Records, newRecord(s.db,
+			req.token, record.(*v3.Record), false))
+	}
+	return http.StatusOK, list, nil
+}
+
+// record returns the record named by the request path
+func (s *Server) record(req *request) (*v3.Record, error) {
+	uuid := strings.ToLower(strings.TrimPrefix(req.r.URL.Path,
+		"/v1/records/"))
+	req.record = uuid
+	record := s.db.Record(uuid)
+	if record == nil || !req.token.allowsGroup(record.Group()) {
+		return nil, errorf(http.StatusNotFound, "no record %s", uuid)
+	}
+	return record, nil
+}
+
+func (s *Server) getRecord(req *request) (int, interface{}, error) {
+	record, err := s.record(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, newRecord(s.db, req.token, record, true), nil
+}
+
+func (s *Server) createRecord(req *request) (int, interface{}, error) {
+	var input RecordInput
+	if err := decode(req, &input); err != nil {
+		return 0, nil, err
+	}
+	if input.Title == nil || *input.Title == "" {
+		return 0, nil, errorf(http.StatusBadRequest, "a title is required")
+	}
+	record, err := v3.NewRecord()
+	if err != nil {
+		return 0, nil, err
+	}
+	req.record = record.UUID()
+	if err = applyInput(req, s.db, record, &input); err != nil {
+		return 0, nil, err
+	}
+	if err = s.db.AddRecord(record); err != nil {
+		return 0, nil, err
+	}
+	s.removeEmptyGroup(record.Group())
+	if err = s.save(); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusCreated, newRecord(s.db, req.token, record, true), nil
+}
+
+func (s *Server) updateRecord(req *request) (int, interface{}, error) {
+	record, err := s.record(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	if record.Protected() {
+		return 0, nil, errorf(http.StatusConflict, "record is protected")
+	}
+	var input RecordInput
+	if err = decode(req, &input); err != nil {
+		return 0, nil, err
+	}
+	if input.Title != nil && *input.Title == "" {
+		return 0, nil, errorf(http.StatusBadRequest, "a title is required")
+	}
+	if err = applyInput(req, s.db, record, &input); err != nil {
+		return 0, nil, err
+	}
+	record.SetMtime(time.Now())
+	s.removeEmptyGroup(record.Group())
+	if err = s.save(); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, newRecord(s.db, req.token, record, true), nil
+}
+
+// applyInput sets the given fields of the record. The group, and the group
+// of the record an alias or shortcut password refers to, must be ones the
+// token may access.
+func applyInput(req *request, db *v3.Database, record *v3.Record,
+	input *RecordInput) error {
+
+	if input.Password != nil {
+		base, _ := db.Referenced(*input.Password)
+		if base != nil && !req.token.allowsGroup(base.Group()) {
+			return errorf(http.StatusForbidden, "token may not access the "+
+				"referenced record")
+		}
+	}
+	if input.Group != nil {
+		group := parseGroupPath(*input.Group)
+		if !req.token.allowsGroup(group) {
+			return errorf(http.StatusForbidden, "token may not access group "+
+				"%q", *input.Group)
+		}
+		record.SetGroup(group)
+	} else if !req.token.allowsGroup(record.Group()) {
+		return errorf(http.StatusForbidden, "a group is required")
+	}
+	if input.Title != nil {
+		record.SetTitle(*input.Title)
+	}
+	if input.Username != nil {
+		record.SetUsername(*input.Username)
+	}
+	if input.Password != nil {
+		record.ChangePassword(*input.Password)
+	}
+	if input.URL != nil {
+		record.SetURL(*input.URL)
+	}
+	if input.Email != nil {
+		record.SetEmail(*input.Email)
+	}
+	if input.Notes != nil {
+		record.SetNotes(*input.Notes)
+	}
+	if input.CustomFields != nil {
+		record.SetCustomFields(*input.CustomFields)
+	}
+	return nil
+}
+
+func (s *Server) deleteRecord(req *request) (int, interface{}, error) {
+	record, err := s.record(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	if record.Protected() {
+		return 0, nil, errorf(http.StatusConflict, "record is protected")
+	}
+	if dependents := s.db.Dependents(record.UUID()); len(dependents) > 0 {
+		return 0, nil, errorf(http.StatusConflict, "record is used by %d "+
+			"aliases or shortcuts", len(dependents))
+	}
+	s.db.RemoveRecord(record.UUID())
+	if err = s.save(); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusNoContent, nil, nil
+}
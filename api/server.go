@@ -0,0 +1,460 @@
+// Package api implements an HTTP/JSON API for v3 password safe databases.
+//
+// Clients authenticate with bearer tokens. Every token has scopes and may be
+// restricted to groups; requests are written to an audit log. All requests
+// are serialized, so saves never interleave.
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/v3"
+)
+
+// Token scopes
+const (
+	// ScopeRead allows reading records and groups
+	ScopeRead = "read"
+
+	// ScopeWrite allows changing records and groups, and implies ScopeRead
+	ScopeWrite = "write"
+
+	// ScopeLock allows locking and unlocking the database
+	ScopeLock = "lock"
+)
+
+// Token is a bearer token clients authenticate with
+type Token struct {
+	// Name identifies the token in the audit log
+	Name string
+
+	// Hash is the SHA-256 hash of the token
+	Hash [sha256.Size]byte
+
+	// Scopes are the scopes the token grants
+	Scopes []string
+
+	// Groups restricts the token to the records in these groups and their
+	// subgroups, with elements separated by "/". Empty allows every group.
+	Groups []string
+}
+
+// HashToken returns the hash of a token for Token.Hash
+func HashToken(token string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(token))
+}
+
+// Options are the callbacks the Server uses to read and write the database
+type Options struct {
+	// Open reads the database with the passphrase
+	Open func(passphrase string) (*v3.Database, error)
+
+	// Save writes the database with the passphrase it was opened with
+	Save func(db *v3.Database, passphrase string) error
+
+	// Changed returns true if the database changed on disk since it was
+	// last opened or saved; the Server then opens it again. Optional.
+	Changed func() bool
+
+	// Tokens are the tokens clients may authenticate with
+	Tokens []Token
+
+	// Audit receives one JSON object per request. Optional.
+	Audit io.Writer
+}
+
+// Server serves the API. It is an http.Handler.
+type Server struct {
+	opts Options
+
+	mu         sync.Mutex
+	db         *v3.Database
+	passphrase string
+}
+
+// New returns a locked Server
+func New(opts Options) *Server {
+	return &Server{opts: opts}
+}
+
+// Unlock opens the database with the passphrase
+func (s *Server) Unlock(passphrase string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unlock(passphrase)
+}
+
+func (s *Server) unlock(passphrase string) error {
+	db, err := s.opts.Open(passphrase)
+	if err != nil {
+		return err
+	}
+	if s.db != nil {
+		s.db.Wipe()
+	}
+	s.db, s.passphrase = db, passphrase
+	return nil
+}
+
+// Lock wipes the database from memory
+func (s *Server) Lock() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lock()
+}
+
+func (s *Server) lock() {
+	if s.db != nil {
+		s.db.Wipe()
+	}
+	s.db, s.passphrase = nil, ""
+}
+
+// apiError is an error with an HTTP status
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func errorf(status int, format string, args ...interface{}) *apiError {
+	return &apiError{status: status, message: fmt.Sprintf(format, args...)}
+}
+
+// request is a request being handled
+type request struct {
+	w     http.ResponseWriter
+	r     *http.Request
+	token *Token
+
+	// record is the UUID of the record the request is about, for the audit
+	// log
+	record string
+}
+
+// handler handles a request to an endpoint and returns the response value
+type handler func(req *request) (status int, value interface{}, err error)
+
+// route is an API endpoint
+type route struct {
+	method string
+	path   string
+
+	// prefix matches paths below the path, such as record UUIDs
+	prefix bool
+
+	// public endpoints need no token; others need one with the scope, if
+	// any
+	public bool
+	scope  string
+
+	// unlocked requires the database to be unlocked
+	unlocked bool
+
+	handle handler
+}
+
+func (s *Server) routes() []route {
+	return []route{
+		{method: "GET", path: "/v1/openapi.json", public: true,
+			handle: s.openAPI},
+		{method: "GET", path: "/v1/status", handle: s.status},
+		{method: "POST", path: "/v1/unlock", scope: ScopeLock,
+			handle: s.unlockHandler},
+		{method: "POST", path: "/v1/lock", scope: ScopeLock,
+			handle: s.lockHandler},
+		{method: "POST", path: "/v1/generate", unlocked: true,
+			handle: s.generate},
+		{method: "GET", path: "/v1/records", scope: ScopeRead,
+			unlocked: true, handle: s.listRecords},
+		{method: "POST", path: "/v1/records", scope: ScopeWrite,
+			unlocked: true, handle: s.createRecord},
+		{method: "GET", path: "/v1/records/", prefix: true,
+			scope: ScopeRead, unlocked: true, handle: s.getRecord},
+		{method: "PATCH", path: "/v1/records/", prefix: true,
+			scope: ScopeWrite, unlocked: true, handle: s.updateRecord},
+		{method: "DELETE", path: "/v1/records/", prefix: true,
+			scope: ScopeWrite, unlocked: true, handle: s.deleteRecord},
+		{method: "GET", path: "/v1/groups", scope: ScopeRead,
+			unlocked: true, handle: s.listGroups},
+		{method: "POST", path: "/v1/groups", scope: ScopeWrite,
+			unlocked: true, handle: s.createGroup},
+		{method: "PATCH", path: "/v1/groups", scope: ScopeWrite,
+			unlocked: true, handle: s.renameGroup},
+		{method: "DELETE", path: "/v1/groups", scope: ScopeWrite,
+			unlocked: true, handle: s.deleteGroup},
+	}
+}
+
+// ServeHTTP authenticates, routes and audits the request
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := &request{w: w, r: r}
+	status, value, err := s.serve(req)
+	if err != nil {
+		if api_err, ok := err.(*apiError); ok {
+			status = api_err.status
+		} else {
+			status = http.StatusInternalServerError
+		}
+		value = map[string]string{"error": err.Error()}
+	}
+	s.audit(req, status)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if value != nil {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(value)
+	}
+}
+
+func (s *Server) serve(req *request) (int, interface{}, error) {
+	path := req.r.URL.Path
+	var matched *route
+	path_matched := false
+	for _, rt := range s.routes() {
+		if rt.path != path && !(rt.prefix && strings.HasPrefix(path,
+			rt.path) && len(path) > len(rt.path)) {
+			continue
+		}
+		if rt.method != req.r.Method {
+			path_matched = true
+			continue
+		}
+		rt := rt
+		matched = &rt
+		break
+	}
+	if matched == nil {
+		if path_matched {
+			return 0, nil, errorf(http.StatusMethodNotAllowed,
+				"method not allowed")
+		}
+		return 0, nil, errorf(http.StatusNotFound, "not found")
+	}
+
+	if !matched.public {
+		req.token = s.authenticate(req.r)
+		if req.token == nil {
+			req.w.Header().Set("WWW-Authenticate", "Bearer")
+			return 0, nil, errorf(http.StatusUnauthorized,
+				"invalid or missing bearer token")
+		}
+		if matched.scope != "" && !req.token.allows(matched.scope) {
+			return 0, nil, errorf(http.StatusForbidden,
+				"token lacks the %s scope", matched.scope)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if matched.unlocked {
+		if s.db == nil {
+			return 0, nil, errorf(http.StatusLocked, "database is locked")
+		}
+		if s.opts.Changed != nil && s.opts.Changed() {
+			// changed by another program; reading it again keeps its changes
+			if err := s.unlock(s.passphrase); err != nil {
+				s.lock()
+				return 0, nil, err
+			}
+		}
+	}
+	return matched.handle(req)
+}
+
+// authenticate returns the token of the request, or nil if it has none or
+// an unknown one
+func (s *Server) authenticate(r *http.Request) *Token {
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)],
+		prefix) {
+		return nil
+	}
+	hash := HashToken(auth[len(prefix):])
+	var found *Token
+	for i := range s.opts.Tokens {
+		token := &s.opts.Tokens[i]
+		// compare every token in constant time
+		if subtle.ConstantTimeCompare(hash[:], token.Hash[:]) == 1 {
+			found = token
+		}
+	}
+	return found
+}
+
+// allows returns true if the token grants the scope
+func (t *Token) allows(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeWrite && scope == ScopeRead {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsGroup returns true if the token may access records in the group
+func (t *Token) allowsGroup(group string) bool {
+	if len(t.Groups) == 0 {
+		return true
+	}
+	for _, allowed := range t.Groups {
+		if inGroup(group, parseGroupPath(allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// auditEntry is a line of the audit log
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	Token  string    `json:"token,omitempty"`
+	Remote string    `json:"remote,omitempty"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Record string    `json:"record,omitempty"`
+	Status int       `json:"status"`
+}
+
+func (s *Server) audit(req *request, status int) {
+	if s.opts.Audit == nil {
+		return
+	}
+	entry := auditEntry{
+		Time:   time.Now().UTC(),
+		Remote: req.r.RemoteAddr,
+		Method: req.r.Method,
+		Path:   req.r.URL.Path,
+		Record: req.record,
+		Status: status,
+	}
+	if req.token != nil {
+		entry.Token = req.token.Name
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	json.NewEncoder(s.opts.Audit).Encode(entry)
+}
+
+// decode decodes the JSON body of the request
+func decode(req *request, value interface{}) error {
+	dec := json.NewDecoder(io.LimitReader(req.r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(value); err != nil {
+		return errorf(http.StatusBadRequest, "invalid request body: %s", err)
+	}
+	return nil
+}
+
+// save saves the database, reading it again if saving fails so that the
+// failed change is not served
+func (s *Server) save() error {
+	if err := s.opts.Save(s.db, s.passphrase); err != nil {
+		if s.unlock(s.passphrase) != nil {
+			s.lock()
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *Server) openAPI(req *request) (int, interface{}, error) {
+	return http.StatusOK, json.RawMessage(openAPIDocument), nil
+}
+
+type statusResponse struct {
+	Locked bool     `json:"locked"`
+	Token  string   `json:"token"`
+	Scopes []string `json:"scopes"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+func (s *Server) status(req *request) (int, interface{}, error) {
+	return http.StatusOK, statusResponse{
+		Locked: s.db == nil,
+		Token:  req.token.Name,
+		Scopes: req.token.Scopes,
+		Groups: req.token.Groups,
+	}, nil
+}
+
+type unlockRequest struct {
+	Passphrase string `json:"passphrase"`
+}
+
+func (s *Server) unlockHandler(req *request) (int, interface{}, error) {
+	var body unlockRequest
+	if err := decode(req, &body); err != nil {
+		return 0, nil, err
+	}
+	if body.Passphrase == "" {
+		return 0, nil, errorf(http.StatusBadRequest, "a passphrase is required")
+	}
+	if err := s.unlock(body.Passphrase); err != nil {
+		return 0, nil, errorf(http.StatusForbidden, "%s", err)
+	}
+	return http.StatusOK, statusResponse{Locked: false,
+		Token: req.token.Name, Scopes: req.token.Scopes,
+		Groups: req.token.Groups}, nil
+}
+
+func (s *Server) lockHandler(req *request) (int, interface{}, error) {
+	s.lock()
+	return http.StatusOK, statusResponse{Locked: true,
+		Token: req.token.Name, Scopes: req.token.Scopes,
+		Groups: req.token.Groups}, nil
+}
+
+type generateRequest struct {
+	Policy string `json:"policy"`
+}
+
+type generateResponse struct {
+	Password string `json:"password"`
+	Policy   string `json:"policy"`
+}
+
+// generate returns a new password following a named password policy of the
+// database, or the default policy
+func (s *Server) generate(req *request) (int, interface{}, error) {
+	var body generateRequest
+	if err := decode(req, &body); err != nil {
+		return 0, nil, err
+	}
+	policy := pwsafe.DefaultPasswordPolicy
+	if body.Policy != "" {
+		found := false
+		for _, named := range s.db.Header().(*v3.Header).PasswordPolicies() {
+			if named.Name == body.Policy {
+				policy, found = named, true
+				break
+			}
+		}
+		if !found {
+			return 0, nil, errorf(http.StatusNotFound,
+				"no password policy %q", body.Policy)
+		}
+	}
+	password, err := policy.Generate()
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, generateResponse{Password: password,
+		Policy: policy.Describe()}, nil
+}
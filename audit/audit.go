@@ -0,0 +1,317 @@
+// Package audit evaluates the passwords of a database: how guessable they
+// are, whether they are reused, old, expired, against their password
+// policy or known from breaches.
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/utils"
+	"github.com/azdagron/pwsafe/v3"
+)
+
+var (
+	Error     = pwsafe.Error
+	IOError   = pwsafe.IOError
+	Corrupted = pwsafe.Corrupted
+)
+
+// Finding kinds
+const (
+	Weak         = "weak"
+	Reused       = "reused"
+	Old          = "old"
+	Expired      = "expired"
+	NonCompliant = "policy"
+	Breached     = "breached"
+)
+
+// Score penalties for findings besides a weak password, which lowers the
+// strength score itself. A breached password scores 0.
+const (
+	reusedPenalty       = 40
+	oldPenalty          = 15
+	expiredPenalty      = 15
+	nonCompliantPenalty = 10
+)
+
+// Options configure the checks of an audit
+type Options struct {
+	// MinScore is the strength score from 0 to 4 below which passwords are
+	// weak
+	MinScore int
+
+	// MaxAge is the age after which passwords are old. Zero disables the
+	// check.
+	MaxAge time.Duration
+
+	// Policy is checked for records without a policy of their own or a
+	// named policy. If nil, only those records are checked.
+	Policy *pwsafe.PasswordPolicy
+
+	// Breaches are the range files to look up passwords in. If nil,
+	// passwords are not looked up.
+	Breaches *RangeFiles
+
+	// Now is the time ages and expiry are measured at, or the current time
+	// if zero
+	Now time.Time
+}
+
+// DefaultOptions flag passwords scoring below 3 and those older than a
+// year.
+var DefaultOptions = Options{
+	MinScore: 3,
+	MaxAge:   365 * 24 * time.Hour,
+}
+
+// Finding is an issue with the password of a record
+type Finding struct {
+	Kind   string
+	Detail string
+}
+
+// RecordReport is the audit of the password of a record
+type RecordReport struct {
+	UUID  string
+	Title string
+	Group string
+
+	// Score rates the password from 0 to 100
+	Score int
+
+	Strength Strength
+
+	// Changed is when the password was last changed, from the password
+	// modification time or else the creation time
+	Changed time.Time
+
+	// Breaches is how often the password appears in breaches
+	Breaches int
+
+	// ReusedWith are the UUIDs of the other records with the same password
+	ReusedWith []string
+
+	Findings []Finding
+}
+
+// Summary counts the audited records and the records with each finding
+type Summary struct {
+	Records      int
+	Weak         int
+	Reused       int
+	Old          int
+	Expired      int
+	NonCompliant int
+	Breached     int
+
+	// Unchecked counts the passwords whose range file is missing
+	Unchecked int
+}
+
+// Report is the audit of a database
+type Report struct {
+	// Score is the average score of the audited records, 100 if there are
+	// none
+	Score int
+
+	Summary Summary
+
+	// Records are the audited records, lowest score first. Aliases,
+	// shortcuts and records without a password are not audited.
+	Records []RecordReport
+}
+
+// Audit checks the passwords of the database
+func Audit(db *v3.Database, options Options) (*Report, error) {
+	now := options.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// passwords are compared by keyed hashes, so the plain passwords are
+	// not kept around
+	key, err := utils.SecureRandBytes(sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	digests := make(map[string][]int)
+
+	report := &Report{Score: 100}
+	for _, pwsafe_record := range db.Records() {
+		record := pwsafe_record.(*v3.Record)
+		password := record.Password()
+		if base, _ := db.Base(record); base != nil || password == "" {
+			continue
+		}
+		rr, err := auditRecord(db, record, options, now, &report.Summary)
+		if err != nil {
+			return nil, err
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(password))
+		digest := string(mac.Sum(nil))
+		digests[digest] = append(digests[digest], len(report.Records))
+		report.Records = append(report.Records, rr)
+	}
+
+	for _, indexes := range digests {
+		if len(indexes) < 2 {
+			continue
+		}
+		for _, i := range indexes {
+			rr := &report.Records[i]
+			for _, j := range indexes {
+				if j != i {
+					rr.ReusedWith = append(rr.ReusedWith,
+						report.Records[j].UUID)
+				}
+			}
+			sort.Strings(rr.ReusedWith)
+			detail := "same password as another entry"
+			if len(rr.ReusedWith) > 1 {
+				detail = fmt.Sprintf("same password as %d other entries",
+					len(rr.ReusedWith))
+			}
+			rr.Findings = append(rr.Findings, Finding{Reused, detail})
+			rr.Score = clampScore(rr.Score - reusedPenalty)
+			report.Summary.Reused++
+		}
+	}
+
+	total := 0
+	for _, rr := range report.Records {
+		total += rr.Score
+	}
+	report.Summary.Records = len(report.Records)
+	if len(report.Records) > 0 {
+		report.Score = int(math.Round(float64(total) /
+			float64(len(report.Records))))
+	}
+	sort.SliceStable(report.Records, func(i, j int) bool {
+		a, b := report.Records[i], report.Records[j]
+		if a.Score != b.Score {
+			return a.Score < b.Score
+		}
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		return a.Title < b.Title
+	})
+	return report, nil
+}
+
+func auditRecord(db *v3.Database, record *v3.Record, options Options,
+	now time.Time, summary *Summary) (RecordReport, error) {
+
+	password := record.Password()
+	rr := RecordReport{
+		UUID:  record.UUID(),
+		Title: record.Title(),
+		Group: record.Group(),
+		Strength: estimateStrength(password,
+			newRankedDictionary(userInputsDictionary, userInputs(record))),
+	}
+	rr.Score = rr.Strength.Score * 25
+	if rr.Strength.Score < options.MinScore {
+		detail := fmt.Sprintf("strength %d of 4", rr.Strength.Score)
+		if weakness := rr.Strength.Weakness(); weakness != "" {
+			detail += ": " + weakness
+		}
+		rr.Findings = append(rr.Findings, Finding{Weak, detail})
+		summary.Weak++
+	}
+
+	rr.Changed = record.PasswordMtime()
+	if rr.Changed.IsZero() {
+		rr.Changed = record.Ctime()
+	}
+	if !rr.Changed.IsZero() && options.MaxAge > 0 &&
+		now.Sub(rr.Changed) > options.MaxAge {
+
+		rr.Findings = append(rr.Findings, Finding{Old, fmt.Sprintf(
+			"unchanged for %d days", int(now.Sub(rr.Changed).Hours()/24))})
+		rr.Score = clampScore(rr.Score - oldPenalty)
+		summary.Old++
+	}
+	if expiry := record.Expiry(); !expiry.IsZero() && !expiry.After(now) {
+		rr.Findings = append(rr.Findings, Finding{Expired,
+			"expired on " + expiry.Format("2006-01-02")})
+		rr.Score = clampScore(rr.Score - expiredPenalty)
+		summary.Expired++
+	}
+
+	policy, checked := record.PasswordPolicy()
+	if record.PasswordPolicyName() != "" {
+		policy, checked = db.PasswordPolicy(record), true
+	}
+	if !checked && options.Policy != nil {
+		policy, checked = *options.Policy, true
+	}
+	if checked {
+		if violations := policy.Violations(password); len(violations) > 0 {
+			detail := strings.Join(violations, ", ")
+			if policy.Name != "" {
+				detail = "policy " + policy.Name + ": " + detail
+			}
+			rr.Findings = append(rr.Findings, Finding{NonCompliant, detail})
+			rr.Score = clampScore(rr.Score - nonCompliantPenalty)
+			summary.NonCompliant++
+		}
+	}
+
+	if options.Breaches != nil {
+		count, ok, err := options.Breaches.Count(password)
+		if err != nil {
+			return rr, err
+		}
+		if !ok {
+			summary.Unchecked++
+		}
+		if count > 0 {
+			rr.Breaches = count
+			rr.Findings = append(rr.Findings, Finding{Breached,
+				fmt.Sprintf("seen %d times in breaches", count)})
+			rr.Score = 0
+			summary.Breached++
+		}
+	}
+	return rr, nil
+}
+
+// userInputs returns the words of the record an attacker would try: its
+// title, user name, email, URL and groups, whole and split into words
+func userInputs(record *v3.Record) []string {
+	var inputs []string
+	values := []string{record.Title(), record.Username(), record.Email(),
+		record.URL()}
+	values = append(values, pwsafe.SplitGroup(record.Group())...)
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		inputs = append(inputs, value)
+		words := strings.FieldsFunc(value, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if len(words) > 1 {
+			inputs = append(inputs, words...)
+		}
+	}
+	return inputs
+}
+
+func clampScore(score int) int {
+	if score < 0 {
+		return 0
+	}
+	return score
+}
@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/azdagron/pwsafe/utils"
+)
+
+// rangePrefixLength is the number of hex digits of the SHA-1 hash naming a
+// range file
+const rangePrefixLength = 5
+
+// RangeFiles looks up passwords in a directory of Pwned Passwords range
+// files, as downloaded from the k-anonymity API. The file named by the
+// first five hex digits of a password's SHA-1 hash, optionally with a .txt
+// extension, lists the remaining 35 digits of breached hashes as
+// "SUFFIX:COUNT" lines. The passwords never leave the machine.
+type RangeFiles struct {
+	Dir string
+}
+
+// Count returns how often the password appears in breaches. ok is false if
+// the range file of the password is missing.
+func (r RangeFiles) Count(password string) (count int, ok bool, err error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:rangePrefixLength], hash[rangePrefixLength:]
+
+	f, err := r.open(prefix)
+	if err != nil || f == nil {
+		return 0, false, err
+	}
+	defer utils.LogError(f.Close)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 || !strings.EqualFold(line[:colon], suffix) {
+			continue
+		}
+		count, err := strconv.Atoi(line[colon+1:])
+		if err != nil {
+			return 0, false, Corrupted.New("invalid count in range file %s",
+				prefix)
+		}
+		return count, true, nil
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, false, IOError.Wrap(err)
+	}
+	return 0, true, nil
+}
+
+// open opens the range file of the prefix, or returns nil if there is none
+func (r RangeFiles) open(prefix string) (*os.File, error) {
+	for _, name := range []string{prefix, prefix + ".txt",
+		strings.ToLower(prefix), strings.ToLower(prefix) + ".txt"} {
+
+		f, err := os.Open(filepath.Join(r.Dir, name))
+		if err == nil {
+			return f, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, IOError.Wrap(err)
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,126 @@
+package audit
+
+import "strings"
+
+// The dictionaries are ranked: the earlier a word, the more likely it is
+// guessed first. They are short, so passwords built from rarer words are
+// estimated as stronger than they are.
+
+var commonPasswords = `
+123456 password 123456789 12345678 12345 qwerty 1234567 111111 1234567890
+123123 abc123 1234 password1 iloveyou 1q2w3e4r 000000 qwerty123 zaq12wsx
+dragon sunshine princess letmein 654321 monkey 1qaz2wsx 123321 qwertyuiop
+superman asdfghjkl trustno1 football baseball welcome shadow master
+michael jennifer 666666 jordan ashley hunter charlie 121212 killer
+qazwsx 112233 mustang 696969 batman access 7777777 987654321 freedom
+whatever 555555 passw0rd starwars aa123456 654321 lovely hello
+123qwe solo 1qaz2wsx3edc 1111 secret 11111111 loveme 888888 ninja
+azerty 159753 admin welcome1 login abcd1234 computer flower 123abc
+michelle jessica pepper daniel 131313 zxcvbnm asdfgh 1q2w3e4r5t
+1q2w3e 222222 maggie 999999 matrix 987654 pokemon 123654 cheese
+summer nicole chocolate biteme hockey ranger george harley thomas
+tigger robert soccer buster andrew joshua 2000 taylor hannah
+anthony william amanda 6969 changeme 12341234 asdf1234 q1w2e3r4
+samsung google qwe123 zxcvbn 1234qwer letmein1 password123 test test123
+root toor administrator guest default pass1234 master123 p@ssw0rd
+passwort motdepasse contraseña internet 147258369 147258 159357
+11111 00000 1212 7777 987654321 a123456 abcdef abc 123456a 1234abcd
+iloveu princess1 babygirl lovers angel sweety purple jordan23 liverpool
+chelsea arsenal barcelona yankees dallas cowboys eagles lakers
+mercedes ferrari porsche corvette silver orange banana apple
+sunshine1 superman1 batman1 trustme whatever1 qwerty1 qwertz
+asdfasdf zxczxc qweasd qweasdzxc 1qazxsw2 aaaaaa abcabc 123qweasd
+hello123 welcome123 admin123 root123 changeme1 secret1 dragon1
+monkey1 shadow1 master1 football1 baseball1 letmein123 iloveyou1
+summer2020 winter spring autumn january february march april
+august october november december monday friday sunday
+`
+
+var englishWords = `
+the of and to in is you that it he was for on are as with his they at be
+this have from or one had by word but not what all were we when your can
+said there use an each which she do how their if will up other about out
+many then them these so some her would make like him into time has look
+two more write go see number no way could people my than first water been
+call who oil its now find long down day did get come made may part love
+life home world house money family friend friends school work heart light
+night dream star stars moon sun summer winter spring blue red green black
+white yellow orange purple pink gold silver diamond angel devil god jesus
+christ lord king queen prince princess knight dragon tiger lion eagle wolf
+bear shark snake horse dog cat puppy kitty bunny monkey mouse bird fish
+apple banana cherry lemon peach orange mango berry honey sugar candy
+cookie cake pizza coffee tea beer wine whiskey vodka cheese butter bread
+music rock metal guitar piano dance party happy smile sweet lucky magic
+power fire ice snow rain storm thunder ocean river lake mountain forest
+garden flower rose lily daisy tree leaf earth space planet rocket dream
+secret hidden shadow ghost spirit soul freedom peace hope faith trust
+change open close start begin end stop enter access login admin user
+test guest default system server network internet computer laptop phone
+mobile office company business manager master slave boss team player
+game games hunter killer soldier warrior ninja pirate cowboy hero legend
+football soccer baseball basketball hockey tennis golf boxing racing
+summer autumn fall january february march april may june july august
+september october november december monday tuesday wednesday thursday
+friday saturday sunday morning evening today tomorrow forever always
+never nothing something everything everyone nobody somebody anything
+correct horse battery staple monkey purple people eater welcome hello
+goodbye please thanks sorry yes maybe password pass word key lock safe
+door window car truck bike train plane boat ship city town country
+america england france germany italy spain china japan russia canada
+london paris berlin rome tokyo york texas california florida chicago
+boston dallas denver seattle austin miami orlando vegas
+`
+
+var firstNames = `
+michael james john robert david william richard joseph thomas charles
+christopher daniel matthew anthony mark donald steven paul andrew joshua
+kenneth kevin brian george timothy ronald edward jason jeffrey ryan jacob
+gary nicholas eric jonathan stephen larry justin scott brandon benjamin
+samuel gregory alexander frank patrick raymond jack dennis jerry tyler
+aaron jose adam nathan henry douglas zachary peter kyle ethan walter noah
+jeremy christian keith roger terry gerald harold sean austin carl arthur
+lawrence dylan jesse jordan bryan billy joe bruce gabriel logan albert
+mary patricia jennifer linda elizabeth barbara susan jessica sarah karen
+lisa nancy betty margaret sandra ashley kimberly emily donna michelle
+carol amanda dorothy melissa deborah stephanie rebecca sharon laura
+cynthia kathleen amy angela shirley anna brenda pamela emma nicole helen
+samantha katherine christine debra rachel carolyn janet catherine maria
+heather diane ruth julie olivia joyce virginia victoria kelly lauren
+christina joan evelyn judith megan andrea cheryl hannah jacqueline martha
+gloria teresa ann sara madison frances kathryn janice jean abigail alice
+judy sophia grace denise amber doris marilyn danielle beverly isabella
+theresa diana natalie brittany charlotte marie kayla alexis lori alex
+max charlie buddy bailey bella lucy daisy molly sadie maggie sophie chloe
+`
+
+// rankedDictionary maps the words of a dictionary to their rank, starting
+// at 1
+type rankedDictionary struct {
+	name  string
+	ranks map[string]int
+}
+
+func newRankedDictionary(name string, words []string) rankedDictionary {
+	ranks := make(map[string]int, len(words))
+	for i, word := range words {
+		word = strings.ToLower(word)
+		if _, ok := ranks[word]; !ok && word != "" {
+			ranks[word] = i + 1
+		}
+	}
+	return rankedDictionary{name: name, ranks: ranks}
+}
+
+// Dictionary names
+const (
+	passwordsDictionary  = "passwords"
+	englishDictionary    = "english"
+	namesDictionary      = "names"
+	userInputsDictionary = "user_inputs"
+)
+
+var dictionaries = []rankedDictionary{
+	newRankedDictionary(passwordsDictionary, strings.Fields(commonPasswords)),
+	newRankedDictionary(englishDictionary, strings.Fields(englishWords)),
+	newRankedDictionary(namesDictionary, strings.Fields(firstNames)),
+}
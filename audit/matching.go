@@ -0,0 +1,679 @@
+package audit
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Match patterns
+const (
+	DictionaryPattern = "dictionary"
+	SpatialPattern    = "spatial"
+	SequencePattern   = "sequence"
+	RepeatPattern     = "repeat"
+	DatePattern       = "date"
+	BruteforcePattern = "bruteforce"
+)
+
+// Match is a part of a password that follows a pattern
+type Match struct {
+	Pattern string
+	Token   string
+
+	// Guesses is the estimated number of guesses to find the token
+	Guesses float64
+
+	// Dictionary is the name of the dictionary of a dictionary match, and
+	// Reversed and L33t tell whether the word was reversed or had letters
+	// substituted
+	Dictionary string
+	Reversed   bool
+	L33t       bool
+
+	// i and j are the positions of the first and last rune of the token
+	i, j int
+}
+
+// matchAll returns the matches of all patterns in the password
+func matchAll(password []rune, user_inputs rankedDictionary,
+	reference_year int) []Match {
+
+	dicts := append([]rankedDictionary{user_inputs}, dictionaries...)
+	var matches []Match
+	matches = append(matches, dictionaryMatches(password, dicts)...)
+	matches = append(matches, reversedMatches(password, dicts)...)
+	matches = append(matches, l33tMatches(password, dicts)...)
+	matches = append(matches, spatialMatches(password)...)
+	matches = append(matches, sequenceMatches(password)...)
+	matches = append(matches, repeatMatches(password, user_inputs,
+		reference_year)...)
+	matches = append(matches, dateMatches(password, reference_year)...)
+	sort.SliceStable(matches, func(a, b int) bool {
+		if matches[a].i != matches[b].i {
+			return matches[a].i < matches[b].i
+		}
+		return matches[a].j < matches[b].j
+	})
+	return matches
+}
+
+func lowerRunes(runes []rune) []rune {
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	return lower
+}
+
+func dictionaryMatches(password []rune,
+	dicts []rankedDictionary) []Match {
+
+	lower := lowerRunes(password)
+	var matches []Match
+	for i := range lower {
+		for j := i; j < len(lower); j++ {
+			word := string(lower[i : j+1])
+			for _, dict := range dicts {
+				rank, ok := dict.ranks[word]
+				if !ok {
+					continue
+				}
+				token := string(password[i : j+1])
+				matches = append(matches, Match{
+					Pattern:    DictionaryPattern,
+					Token:      token,
+					Guesses:    float64(rank) * uppercaseVariations(token),
+					Dictionary: dict.name,
+					i:          i,
+					j:          j,
+				})
+			}
+		}
+	}
+	return matches
+}
+
+func reversedMatches(password []rune, dicts []rankedDictionary) []Match {
+	n := len(password)
+	reversed := make([]rune, n)
+	for i, r := range password {
+		reversed[n-1-i] = r
+	}
+	var matches []Match
+	for _, match := range dictionaryMatches(reversed, dicts) {
+		if match.j == match.i {
+			continue
+		}
+		match.i, match.j = n-1-match.j, n-1-match.i
+		match.Token = string(password[match.i : match.j+1])
+		match.Guesses *= 2
+		match.Reversed = true
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+// l33tTable lists the characters substituted for letters
+var l33tTable = map[rune]string{
+	'a': "4@",
+	'b': "8",
+	'c': "({[<",
+	'e': "3",
+	'g': "69",
+	'i': "1!|",
+	'l': "1|7",
+	'o': "0",
+	's': "$5",
+	't': "+7",
+	'x': "%",
+	'z': "2",
+}
+
+// maxL33tSubstitutions limits the substitution tables tried per password
+const maxL33tSubstitutions = 128
+
+// l33tSubstitutions returns the tables of substitutions, mapping the
+// substituted characters to letters, that can be undone in the password
+func l33tSubstitutions(password []rune) []map[rune]rune {
+	letters := make(map[rune][]rune)
+	for letter, subs := range l33tTable {
+		for _, sub := range subs {
+			if strings.ContainsRune(string(password), sub) {
+				letters[sub] = append(letters[sub], letter)
+			}
+		}
+	}
+	subs := make([]rune, 0, len(letters))
+	for sub := range letters {
+		subs = append(subs, sub)
+		sort.Slice(letters[sub], func(i, j int) bool {
+			return letters[sub][i] < letters[sub][j]
+		})
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i] < subs[j] })
+
+	tables := []map[rune]rune{{}}
+	for _, sub := range subs {
+		var next []map[rune]rune
+		for _, table := range tables {
+			for _, letter := range letters[sub] {
+				if len(next) >= maxL33tSubstitutions {
+					break
+				}
+				extended := make(map[rune]rune, len(table)+1)
+				for k, v := range table {
+					extended[k] = v
+				}
+				extended[sub] = letter
+				next = append(next, extended)
+			}
+		}
+		tables = next
+	}
+	if len(subs) == 0 {
+		return nil
+	}
+	return tables
+}
+
+func l33tMatches(password []rune, dicts []rankedDictionary) []Match {
+	best := make(map[[2]int]Match)
+	lower := lowerRunes(password)
+	for _, table := range l33tSubstitutions(lower) {
+		translated := make([]rune, len(lower))
+		for i, r := range lower {
+			if letter, ok := table[r]; ok {
+				r = letter
+			}
+			translated[i] = r
+		}
+		for _, match := range dictionaryMatches(translated, dicts) {
+			if match.j == match.i {
+				continue
+			}
+			used := make(map[rune]rune)
+			for _, r := range lower[match.i : match.j+1] {
+				if letter, ok := table[r]; ok {
+					used[r] = letter
+				}
+			}
+			if len(used) == 0 {
+				continue
+			}
+			token := string(password[match.i : match.j+1])
+			match.Token = token
+			// the translated word is lowercase, so its guesses are its rank
+			match.Guesses *= uppercaseVariations(token) *
+				l33tVariations(lower[match.i:match.j+1], used)
+			match.L33t = true
+			key := [2]int{match.i, match.j}
+			if previous, ok := best[key]; !ok ||
+				match.Guesses < previous.Guesses {
+				best[key] = match
+			}
+		}
+	}
+	matches := make([]Match, 0, len(best))
+	for _, match := range best {
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+// uppercaseVariations returns the number of ways the letters of the token
+// could be capitalized, counting the common ones as few
+func uppercaseVariations(token string) float64 {
+	upper, lower := 0, 0
+	for _, r := range token {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		}
+	}
+	if upper == 0 {
+		return 1
+	}
+	runes := []rune(token)
+	first_upper := unicode.IsUpper(runes[0])
+	last_upper := unicode.IsUpper(runes[len(runes)-1])
+	if lower == 0 || (upper == 1 && (first_upper || last_upper)) {
+		return 2
+	}
+	return variations(upper, lower)
+}
+
+// l33tVariations returns the number of ways the substitutions could have
+// been applied to the token
+func l33tVariations(token []rune, used map[rune]rune) float64 {
+	result := 1.0
+	for sub, letter := range used {
+		subbed, unsubbed := 0, 0
+		for _, r := range token {
+			switch r {
+			case sub:
+				subbed++
+			case letter:
+				unsubbed++
+			}
+		}
+		if subbed == 0 || unsubbed == 0 {
+			result *= 2
+		} else {
+			result *= variations(subbed, unsubbed)
+		}
+	}
+	return result
+}
+
+// variations returns the number of ways to pick up to min(a, b) of a+b
+// items
+func variations(a, b int) float64 {
+	n := a + b
+	k := a
+	if b < k {
+		k = b
+	}
+	sum := 0.0
+	for i := 1; i <= k; i++ {
+		sum += binomial(n, i)
+	}
+	return sum
+}
+
+func binomial(n, k int) float64 {
+	if k > n {
+		return 0
+	}
+	result := 1.0
+	for i := 1; i <= k; i++ {
+		result = result * float64(n-k+i) / float64(i)
+	}
+	return result
+}
+
+// keyboard describes the adjacency of the keys of a keyboard layout. The
+// neighbors of a key are listed by direction; each neighbor holds the
+// unshifted and, for keyboards, the shifted character.
+type keyboard struct {
+	neighbors map[rune][]string
+	shifted   string
+
+	// starts and degree are the number of keys and the average number of
+	// neighbors of a key
+	starts int
+	degree float64
+}
+
+// newKeyboard builds a keyboard from rows of space separated keys, with the
+// column of the first key of each row. slanted keyboards have keys
+// adjacent to two keys in the rows above and below, aligned keyboards to
+// three.
+func newKeyboard(rows []string, offsets []int, slanted bool) keyboard {
+	type position struct{ x, y int }
+	keys := make(map[position]string)
+	for y, row := range rows {
+		for x, key := range strings.Fields(row) {
+			keys[position{offsets[y] + x, y}] = key
+		}
+	}
+	directions := []position{{-1, 0}, {-1, -1}, {0, -1}, {1, -1}, {1, 0},
+		{1, 1}, {0, 1}, {-1, 1}}
+	if slanted {
+		directions = []position{{-1, 0}, {0, -1}, {1, -1}, {1, 0}, {0, 1},
+			{-1, 1}}
+	}
+
+	kb := keyboard{neighbors: make(map[rune][]string)}
+	total := 0
+	for pos, key := range keys {
+		neighbors := make([]string, len(directions))
+		for i, dir := range directions {
+			neighbors[i] = keys[position{pos.x + dir.x, pos.y + dir.y}]
+			if neighbors[i] != "" {
+				total++
+			}
+		}
+		for i, r := range key {
+			kb.neighbors[r] = neighbors
+			if i == 1 {
+				kb.shifted += string(r)
+			}
+		}
+	}
+	kb.starts = len(keys)
+	kb.degree = float64(total) / float64(len(keys))
+	return kb
+}
+
+var keyboards = []keyboard{
+	newKeyboard([]string{
+		"`~ 1! 2@ 3# 4$ 5% 6^ 7& 8* 9( 0) -_ =+",
+		"qQ wW eE rR tT yY uU iI oO pP [{ ]} \\|",
+		"aA sS dD fF gG hH jJ kK lL ;: '\"",
+		"zZ xX cC vV bB nN mM ,< .> /?",
+	}, []int{0, 1, 1, 1}, true),
+	newKeyboard([]string{
+		"/ * -",
+		"7 8 9 +",
+		"4 5 6",
+		"1 2 3",
+		"0 .",
+	}, []int{1, 0, 0, 0, 1}, false),
+}
+
+func spatialMatches(password []rune) []Match {
+	var matches []Match
+	for _, kb := range keyboards {
+		i := 0
+		for i < len(password)-1 {
+			j := i + 1
+			last_direction := -1
+			turns := 0
+			shifted := 0
+			if strings.ContainsRune(kb.shifted, password[i]) {
+				shifted = 1
+			}
+			for {
+				found := false
+				if j < len(password) {
+					neighbors := kb.neighbors[password[j-1]]
+					for direction, neighbor := range neighbors {
+						index := strings.IndexRune(neighbor, password[j])
+						if index < 0 {
+							continue
+						}
+						found = true
+						if index > 0 {
+							shifted++
+						}
+						if direction != last_direction {
+							turns++
+							last_direction = direction
+						}
+						break
+					}
+				}
+				if found {
+					j++
+					continue
+				}
+				if j-i > 2 {
+					matches = append(matches, Match{
+						Pattern: SpatialPattern,
+						Token:   string(password[i:j]),
+						Guesses: spatialGuesses(kb, j-i, turns, shifted),
+						i:       i,
+						j:       j - 1,
+					})
+				}
+				i = j
+				break
+			}
+		}
+	}
+	return matches
+}
+
+func spatialGuesses(kb keyboard, length, turns, shifted int) float64 {
+	guesses := 0.0
+	for i := 2; i <= length; i++ {
+		possible_turns := turns
+		if i-1 < possible_turns {
+			possible_turns = i - 1
+		}
+		for j := 1; j <= possible_turns; j++ {
+			guesses += binomial(i-1, j-1) * float64(kb.starts) *
+				math.Pow(kb.degree, float64(j))
+		}
+	}
+	if shifted > 0 {
+		unshifted := length - shifted
+		if unshifted == 0 {
+			guesses *= 2
+		} else {
+			guesses *= variations(shifted, unshifted)
+		}
+	}
+	return guesses
+}
+
+// maxSequenceDelta is the largest step between the characters of a sequence
+const maxSequenceDelta = 5
+
+func sequenceMatches(password []rune) []Match {
+	if len(password) < 2 {
+		return nil
+	}
+	var matches []Match
+	add := func(i, j int, delta rune) {
+		if j-i <= 1 && delta != 1 && delta != -1 {
+			return
+		}
+		if delta == 0 || delta > maxSequenceDelta ||
+			delta < -maxSequenceDelta {
+			return
+		}
+		token := password[i : j+1]
+		base := 26.0
+		switch first := token[0]; {
+		case strings.ContainsRune("aAzZ019", first):
+			base = 4
+		case unicode.IsDigit(first):
+			base = 10
+		}
+		if delta < 0 {
+			base *= 2
+		}
+		matches = append(matches, Match{
+			Pattern: SequencePattern,
+			Token:   string(token),
+			Guesses: base * float64(len(token)),
+			i:       i,
+			j:       j,
+		})
+	}
+
+	i := 0
+	last_delta := password[1] - password[0]
+	for k := 2; k < len(password); k++ {
+		delta := password[k] - password[k-1]
+		if delta == last_delta {
+			continue
+		}
+		add(i, k-1, last_delta)
+		i = k - 1
+		last_delta = delta
+	}
+	add(i, len(password)-1, last_delta)
+	return matches
+}
+
+// repeatMatches returns the runs of a repeated unit. The guesses are those
+// of the unit times the repetitions.
+func repeatMatches(password []rune, user_inputs rankedDictionary,
+	reference_year int) []Match {
+
+	var matches []Match
+	i := 0
+	for i < len(password) {
+		best_length, best_count := 0, 0
+		for length := 1; i+2*length <= len(password); length++ {
+			unit := string(password[i : i+length])
+			count := 1
+			for i+(count+1)*length <= len(password) &&
+				string(password[i+count*length:i+(count+1)*length]) == unit {
+				count++
+			}
+			if count >= 2 && length*count > best_length*best_count {
+				best_length, best_count = length, count
+			}
+		}
+		if best_count == 0 {
+			i++
+			continue
+		}
+		unit := password[i : i+best_length]
+		unit_guesses, _ := mostGuessable(unit,
+			matchAll(unit, user_inputs, reference_year))
+		end := i + best_length*best_count
+		matches = append(matches, Match{
+			Pattern: RepeatPattern,
+			Token:   string(password[i:end]),
+			Guesses: unit_guesses * float64(best_count),
+			i:       i,
+			j:       end - 1,
+		})
+		i = end
+	}
+	return matches
+}
+
+// minYearSpace is the smallest number of years guessed around the reference
+// year
+const minYearSpace = 20
+
+// dateSeparators are the characters separating the parts of a date
+const dateSeparators = " /\\_.-"
+
+// dateMatches returns the dates of 4 to 8 digits, optionally with the parts
+// separated, and the years from 1900 on
+func dateMatches(password []rune, reference_year int) []Match {
+	var matches []Match
+	add := func(i, j, year int, date, separated bool) {
+		space := abs(reference_year - year)
+		if space < minYearSpace {
+			space = minYearSpace
+		}
+		guesses := float64(space)
+		if date {
+			guesses *= 365
+		}
+		if separated {
+			guesses *= 4
+		}
+		matches = append(matches, Match{
+			Pattern: DatePattern,
+			Token:   string(password[i : j+1]),
+			Guesses: guesses,
+			i:       i,
+			j:       j,
+		})
+	}
+
+	for i := range password {
+		for j := i + 3; j < len(password) && j < i+10; j++ {
+			token := string(password[i : j+1])
+			if year, err := strconv.Atoi(token); err == nil && j == i+3 &&
+				year >= 1900 && year <= reference_year+minYearSpace {
+				add(i, j, year, false, false)
+			} else if year, ok := parseDate(token, reference_year); ok {
+				add(i, j, year, true, !allDigits(token))
+			}
+		}
+	}
+
+	// leave out the dates within other dates
+	var outer []Match
+	for _, match := range matches {
+		contained := false
+		for _, other := range matches {
+			if other.i <= match.i && other.j >= match.j &&
+				other.j-other.i > match.j-match.i {
+				contained = true
+				break
+			}
+		}
+		if !contained {
+			outer = append(outer, match)
+		}
+	}
+	return outer
+}
+
+func allDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// parseDate returns the year of a date of day, month and year in any
+// common order, choosing the reading closest to the reference year
+func parseDate(token string, reference_year int) (int, bool) {
+	var splits [][]string
+	if allDigits(token) {
+		if len(token) < 4 || len(token) > 8 {
+			return 0, false
+		}
+		for a := 1; a < len(token)-1; a++ {
+			for b := a + 1; b < len(token); b++ {
+				splits = append(splits, []string{token[:a], token[a:b],
+					token[b:]})
+			}
+		}
+	} else {
+		for _, separator := range dateSeparators {
+			parts := strings.Split(token, string(separator))
+			if len(parts) == 3 && allDigits(parts[0]) &&
+				allDigits(parts[1]) && allDigits(parts[2]) {
+				splits = append(splits, parts)
+			}
+		}
+	}
+
+	best, found := 0, false
+	for _, parts := range splits {
+		for _, order := range [][3]int{{0, 1, 2}, {0, 2, 1}, {2, 0, 1},
+			{2, 1, 0}} {
+
+			year_part, first, second := parts[order[0]], parts[order[1]],
+				parts[order[2]]
+			year, ok := parseYear(year_part)
+			if !ok || len(first) > 2 || len(second) > 2 {
+				continue
+			}
+			month, _ := strconv.Atoi(first)
+			day, _ := strconv.Atoi(second)
+			if !(month >= 1 && month <= 12 && day >= 1 && day <= 31) {
+				month, day = day, month
+				if !(month >= 1 && month <= 12 && day >= 1 && day <= 31) {
+					continue
+				}
+			}
+			if !found || abs(year-reference_year) < abs(best-reference_year) {
+				best, found = year, true
+			}
+		}
+	}
+	return best, found
+}
+
+// parseYear parses a four digit year from 1000 to 2099, or a two digit year
+func parseYear(s string) (int, bool) {
+	year, err := strconv.Atoi(s)
+	switch {
+	case err != nil:
+		return 0, false
+	case len(s) == 4 && year >= 1000 && year <= 2099:
+		return year, true
+	case len(s) == 2 && year > 50:
+		return 1900 + year, true
+	case len(s) == 2:
+		return 2000 + year, true
+	}
+	return 0, false
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
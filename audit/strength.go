@@ -0,0 +1,248 @@
+package audit
+
+import (
+	"math"
+	"strings"
+	"time"
+)
+
+// The strength estimate follows zxcvbn: the password is split into the
+// sequence of patterns (dictionary words, keyboard walks, sequences,
+// repeats, dates) and brute forced parts that takes the fewest guesses to
+// find, and the guesses of the parts are multiplied.
+
+const (
+	bruteforceCardinality           = 10
+	minGuessesBeforeGrowingSequence = 10000
+	minSubmatchGuessesSingleChar    = 10
+	minSubmatchGuessesMultiChar     = 50
+
+	// maxEstimatedLength limits the runes of a password that are matched;
+	// longer passwords are strong anyway
+	maxEstimatedLength = 100
+)
+
+// Strength is an estimate of how hard a password is to guess
+type Strength struct {
+	// Guesses is the estimated number of guesses to find the password
+	Guesses float64
+
+	// Entropy is the base 2 logarithm of the guesses
+	Entropy float64
+
+	// Score ranks the guesses from 0 (too guessable) to 4 (very
+	// unguessable)
+	Score int
+
+	// Matches are the parts of the password the estimate is made of
+	Matches []Match
+}
+
+// EstimateStrength estimates the strength of the password. user_inputs are
+// words an attacker would try first, such as the user name.
+func EstimateStrength(password string, user_inputs ...string) Strength {
+	var words []string
+	for _, input := range user_inputs {
+		words = append(words, strings.ToLower(input))
+	}
+	return estimateStrength(password,
+		newRankedDictionary(userInputsDictionary, words))
+}
+
+func estimateStrength(password string,
+	user_inputs rankedDictionary) Strength {
+
+	runes := []rune(password)
+	if len(runes) > maxEstimatedLength {
+		runes = runes[:maxEstimatedLength]
+	}
+	guesses, sequence := mostGuessable(runes,
+		matchAll(runes, user_inputs, time.Now().Year()))
+	if math.IsInf(guesses, 1) {
+		guesses = math.MaxFloat64
+	}
+	return Strength{
+		Guesses: guesses,
+		Entropy: math.Log2(guesses),
+		Score:   guessesScore(guesses),
+		Matches: sequence,
+	}
+}
+
+func guessesScore(guesses float64) int {
+	const delta = 5
+	switch {
+	case guesses < 1e3+delta:
+		return 0
+	case guesses < 1e6+delta:
+		return 1
+	case guesses < 1e8+delta:
+		return 2
+	case guesses < 1e10+delta:
+		return 3
+	}
+	return 4
+}
+
+// Weakness describes the pattern that makes the password guessable, or is
+// empty if the score is 3 or more
+func (s Strength) Weakness() string {
+	if s.Score >= 3 {
+		return ""
+	}
+	var longest *Match
+	for i := range s.Matches {
+		match := &s.Matches[i]
+		if match.Pattern != BruteforcePattern && (longest == nil ||
+			len([]rune(match.Token)) > len([]rune(longest.Token))) {
+			longest = match
+		}
+	}
+	if longest == nil {
+		return "too short"
+	}
+	var description string
+	switch longest.Pattern {
+	case DictionaryPattern:
+		switch longest.Dictionary {
+		case passwordsDictionary:
+			description = "common password"
+		case namesDictionary:
+			description = "common name"
+		case userInputsDictionary:
+			description = "contains the title, user name or URL"
+		default:
+			description = "dictionary word"
+		}
+		if longest.Reversed {
+			description += ", reversed"
+		}
+		if longest.L33t {
+			description += ", with substitutions"
+		}
+	case SpatialPattern:
+		description = "keyboard pattern"
+	case SequencePattern:
+		description = "character sequence"
+	case RepeatPattern:
+		description = "repeated characters"
+	case DatePattern:
+		description = "date or year"
+	}
+	return description
+}
+
+// mostGuessable returns the guesses of the sequence of non-overlapping
+// matches and brute forced parts covering the password that is the easiest
+// to guess, and the sequence. A sequence of l matches takes l! times the
+// product of their guesses, plus a penalty for longer sequences.
+func mostGuessable(password []rune, matches []Match) (float64, []Match) {
+	n := len(password)
+	if n == 0 {
+		return 1, nil
+	}
+	by_end := make([][]Match, n)
+	for _, match := range matches {
+		by_end[match.j] = append(by_end[match.j], match)
+	}
+
+	// for the sequences ending at k by length: the last match, the product
+	// of the guesses and the total guesses
+	last := make([]map[int]Match, n)
+	products := make([]map[int]float64, n)
+	totals := make([]map[int]float64, n)
+	for k := range last {
+		last[k] = make(map[int]Match)
+		products[k] = make(map[int]float64)
+		totals[k] = make(map[int]float64)
+	}
+
+	update := func(match Match, length int) {
+		k := match.j
+		product := matchGuesses(match, n)
+		if length > 1 {
+			product *= products[match.i-1][length-1]
+		}
+		total := factorial(length)*product +
+			math.Pow(minGuessesBeforeGrowingSequence, float64(length-1))
+		for other_length, other_total := range totals[k] {
+			if other_length <= length && other_total <= total {
+				return
+			}
+		}
+		last[k][length] = match
+		products[k][length] = product
+		totals[k][length] = total
+	}
+	bruteforce := func(i, j int) Match {
+		return Match{
+			Pattern: BruteforcePattern,
+			Token:   string(password[i : j+1]),
+			Guesses: math.Pow(bruteforceCardinality, float64(j-i+1)),
+			i:       i,
+			j:       j,
+		}
+	}
+
+	for k := 0; k < n; k++ {
+		for _, match := range by_end[k] {
+			if match.i == 0 {
+				update(match, 1)
+				continue
+			}
+			for length := range last[match.i-1] {
+				update(match, length+1)
+			}
+		}
+		update(bruteforce(0, k), 1)
+		for i := 1; i <= k; i++ {
+			for length, previous := range last[i-1] {
+				// adjacent brute forced parts are one part
+				if previous.Pattern != BruteforcePattern {
+					update(bruteforce(i, k), length+1)
+				}
+			}
+		}
+	}
+
+	best_length := 0
+	for length, total := range totals[n-1] {
+		if best_length == 0 || total < totals[n-1][best_length] ||
+			(total == totals[n-1][best_length] && length < best_length) {
+			best_length = length
+		}
+	}
+	guesses := totals[n-1][best_length]
+	sequence := make([]Match, best_length)
+	for k, length := n-1, best_length; k >= 0; length-- {
+		match := last[k][length]
+		match.Guesses = matchGuesses(match, n)
+		sequence[length-1] = match
+		k = match.i - 1
+	}
+	return guesses, sequence
+}
+
+// matchGuesses returns the guesses of the match, at least as many as a short
+// brute forced part unless it is the whole password
+func matchGuesses(match Match, length int) float64 {
+	min := 1.0
+	if match.j-match.i+1 < length {
+		min = minSubmatchGuessesMultiChar
+		if match.i == match.j {
+			min = minSubmatchGuessesSingleChar
+		}
+		if match.Pattern == BruteforcePattern {
+			min++
+		}
+	}
+	return math.Max(match.Guesses, min)
+}
+
+func factorial(n int) float64 {
+	result := 1.0
+	for i := 2; i <= n; i++ {
+		result *= float64(i)
+	}
+	return result
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/azdagron/pwsafe/v3"
+	"github.com/bgentry/speakeasy"
+)
+
+type addCommand struct {
+	commonParams
+	Title    string
+	Group    string
+	Username string
+	Password string
+	URL      string
+	Email    string
+	Notes    string
+	Generate bool
+	Length   int
+}
+
+func (c *addCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+	flagset.StringVar(&c.Title, "title", "", "title of the entry; prompts for the entry fields if empty")
+	flagset.StringVar(&c.Group, "group", "", "group of the entry, with elements separated by /")
+	flagset.StringVar(&c.Username, "username", "", "username of the entry")
+	flagset.StringVar(&c.Password, "password", "", "password of the entry")
+	flagset.StringVar(&c.URL, "url", "", "url of the entry")
+	flagset.StringVar(&c.Email, "email", "", "email address of the entry")
+	flagset.StringVar(&c.Notes, "notes", "", "notes of the entry")
+	flagset.BoolVar(&c.Generate, "generate", false, "if true, generates the password")
+	flagset.IntVar(&c.Length, "length", 0, "length of generated passwords; 0 uses the length of the password policy")
+}
+
+func (c *addCommand) Execute(args []string) (err error) {
+	if c.Title == "" {
+		if err = c.ask(); err != nil {
+			return err
+		}
+	}
+	db, passphrase, err := c.openOrCreate()
+	if err != nil {
+		return err
+	}
+
+	record, err := v3.NewRecord()
+	if err != nil {
+		return err
+	}
+	record.SetTitle(c.Title)
+	record.SetGroup(parseGroupPath(c.Group))
+	record.SetUsername(c.Username)
+	record.SetPassword(c.Password)
+	record.SetURL(c.URL)
+	record.SetEmail(c.Email)
+	record.SetNotes(c.Notes)
+	if c.Generate && c.Password == "" {
+		policy := db.PasswordPolicy(record)
+		if c.Length > 0 {
+			policy.Length = c.Length
+		}
+		if c.Password, err = policy.Generate(); err != nil {
+			return err
+		}
+		record.SetPassword(c.Password)
+	}
+	if c.Password != "" {
+		record.SetPasswordMtime(time.Now())
+	}
+	if err = db.AddRecord(record); err != nil {
+		return err
+	}
+
+	if err = c.save(db, passphrase); err != nil {
+		return err
+	}
+	fmt.Println(record.UUID())
+	return nil
+}
+
+// ask prompts for the entry fields that were not given as flags
+func (c *addCommand) ask() (err error) {
+	for c.Title == "" {
+		if c.Title, err = prompt("Title", ""); err != nil {
+			return err
+		}
+	}
+	fields := []struct {
+		name  string
+		value *string
+	}{
+		{"Group", &c.Group},
+		{"Username", &c.Username},
+		{"URL", &c.URL},
+		{"Email", &c.Email},
+	}
+	for _, field := range fields {
+		if *field.value, err = prompt(field.name, *field.value); err != nil {
+			return err
+		}
+	}
+	if c.Password != "" || c.Generate {
+		return nil
+	}
+	c.Password, err = speakeasy.Ask("Password (empty to generate): ")
+	if err != nil {
+		return err
+	}
+	c.Generate = c.Password == ""
+	return nil
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/azdagron/pwsafe/utils"
+	"github.com/azdagron/pwsafe/v3"
+)
+
+const (
+	// agentListenFdEnv tells a detached agent the file descriptor of the
+	// listening socket it inherited
+	agentListenFdEnv = "PWSAFE_AGENT_LISTEN_FD"
+
+	// agentDirPrefix prefixes the private directories created for agent
+	// sockets. The agent removes them when it stops.
+	agentDirPrefix = "pwsafe-agent-"
+)
+
+type agentCommand struct {
+	TTL        time.Duration
+	Socket     string
+	Foreground bool
+	Kill       bool
+	Lock       bool
+	List       bool
+}
+
+func (c *agentCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	flagset.DurationVar(&c.TTL, "ttl", 15*time.Minute, "time a database stays unlocked in the agent; 0 keeps it until the agent stops")
+	flagset.StringVar(&c.Socket, "socket", "", "path of the agent socket (defaults to a new private directory)")
+	flagset.BoolVar(&c.Foreground, "foreground", false, "if true, runs the agent in the foreground")
+	flagset.BoolVar(&c.Kill, "kill", false, "if true, stops the agent named by "+agentSockEnv)
+	flagset.BoolVar(&c.Lock, "lock", false, "if true, has the agent named by "+agentSockEnv+" forget all databases")
+	flagset.BoolVar(&c.List, "list", false, "if true, lists the databases held by the agent named by "+agentSockEnv)
+}
+
+func (c *agentCommand) Execute(args []string) (err error) {
+	if c.Kill || c.Lock || c.List {
+		client := newAgentClient()
+		if client == nil {
+			return fmt.Errorf("%s is not set", agentSockEnv)
+		}
+		switch {
+		case c.Kill:
+			return client.stop()
+		case c.Lock:
+			return client.lock("")
+		}
+		databases, err := client.list()
+		if err != nil {
+			return err
+		}
+		for _, db := range databases {
+			if db.Expires.IsZero() {
+				fmt.Println(db.Path)
+			} else {
+				fmt.Printf("%s\tlocks in %s\n", db.Path,
+					time.Until(db.Expires).Round(time.Second))
+			}
+		}
+		return nil
+	}
+
+	if fd := os.Getenv(agentListenFdEnv); fd != "" {
+		// detached child of a background agent
+		os.Unsetenv(agentListenFdEnv)
+		n, err := strconv.Atoi(fd)
+		if err != nil {
+			return err
+		}
+		listener, err := net.FileListener(os.NewFile(uintptr(n), "agent"))
+		if err != nil {
+			return err
+		}
+		return newAgent(listener, c.Socket, c.TTL).serve()
+	}
+
+	if err = checkPeerCredentials(); err != nil {
+		return err
+	}
+	socket := c.Socket
+	if socket == "" {
+		socket, err = defaultAgentSocket()
+	} else {
+		socket, err = filepath.Abs(socket)
+	}
+	if err != nil {
+		return err
+	}
+	listener, err := listenAgent(socket)
+	if err != nil {
+		return err
+	}
+
+	if c.Foreground {
+		printAgentEnv(socket, os.Getpid())
+		return newAgent(listener, socket, c.TTL).serve()
+	}
+
+	pid, err := startAgent(listener.(*net.UnixListener), socket, c.TTL)
+	listener.Close()
+	if err != nil {
+		os.Remove(socket)
+		return err
+	}
+	printAgentEnv(socket, pid)
+	return nil
+}
+
+// printAgentEnv prints shell commands setting PWSAFE_AGENT_SOCK, like
+// ssh-agent does
+func printAgentEnv(socket string, pid int) {
+	fmt.Printf("%s=%s; export %s;\n", agentSockEnv, shellQuote(socket),
+		agentSockEnv)
+	fmt.Printf("echo Agent pid %d;\n", pid)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// defaultAgentSocket returns a socket path in a new directory only the user
+// can access
+func defaultAgentSocket() (string, error) {
+	dir, err := ioutil.TempDir(os.Getenv("XDG_RUNTIME_DIR"), agentDirPrefix)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, fmt.Sprintf("agent.%d", os.Getpid())), nil
+}
+
+// listenAgent listens on the socket, which only the user may connect to
+func listenAgent(socket string) (net.Listener, error) {
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(socket, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// startAgent starts a detached agent serving the listener and returns its
+// process id
+func startAgent(listener *net.UnixListener, socket string,
+	ttl time.Duration) (int, error) {
+
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	file, err := listener.File()
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	// the socket must survive closing the listener in this process
+	listener.SetUnlinkOnClose(false)
+
+	cmd := exec.Command(exe, "agent", "-socket", socket,
+		"-ttl", ttl.String())
+	cmd.Env = append(os.Environ(), agentListenFdEnv+"=3")
+	cmd.ExtraFiles = []*os.File{file}
+	detach(cmd)
+	if err = cmd.Start(); err != nil {
+		return 0, err
+	}
+	pid := cmd.Process.Pid
+	return pid, cmd.Process.Release()
+}
+
+// errNotHeld is returned for databases the agent does not hold. Clients
+// fall back to asking for the passphrase.
+var errNotHeld = errors.New(agentLocked)
+
+// agent holds unlocked databases for other pwsafe processes of the user
+type agent struct {
+	listener net.Listener
+	socket   string
+	ttl      time.Duration
+
+	mu        sync.Mutex
+	databases map[string]*agentEntry
+}
+
+// agentEntry is an unlocked database
+type agentEntry struct {
+	db         *v3.Database
+	passphrase string
+	expires    time.Time
+	timer      *time.Timer
+
+	// size and mtime of the file when it was read, to notice changes made
+	// without the agent
+	size  int64
+	mtime time.Time
+}
+
+func newAgent(listener net.Listener, socket string,
+	ttl time.Duration) *agent {
+
+	return &agent{
+		listener:  listener,
+		socket:    socket,
+		ttl:       ttl,
+		databases: make(map[string]*agentEntry),
+	}
+}
+
+// serve handles connections until the agent is stopped or interrupted
+func (a *agent) serve() error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		<-signals
+		a.listener.Close()
+	}()
+	defer a.cleanup()
+
+	for {
+		conn, err := a.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go a.handle(conn)
+	}
+}
+
+// cleanup forgets all databases and removes the socket
+func (a *agent) cleanup() {
+	a.mu.Lock()
+	for path := range a.databases {
+		a.forget(path)
+	}
+	a.mu.Unlock()
+	os.Remove(a.socket)
+	if dir := filepath.Dir(a.socket); strings.HasPrefix(filepath.Base(dir),
+		agentDirPrefix) {
+		os.Remove(dir)
+	}
+}
+
+func (a *agent) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(agentTimeout))
+
+	uid, err := peerUID(conn)
+	if err != nil || uid != os.Getuid() {
+		return
+	}
+
+	var req agentRequest
+	var resp *agentResponse
+	if err = json.NewDecoder(conn).Decode(&req); err != nil {
+		resp = &agentResponse{Error: "invalid request: " + err.Error()}
+	} else {
+		resp = a.dispatch(req)
+	}
+	json.NewEncoder(conn).Encode(resp)
+
+	if req.Method == agentStop && resp.Error == "" {
+		a.listener.Close()
+	}
+}
+
+func (a *agent) dispatch(req agentRequest) *agentResponse {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var resp agentResponse
+	var err error
+	switch req.Method {
+	case agentOpen:
+		resp.Database, err = a.open(req.Path)
+	case agentAdd:
+		err = a.add(req.Path, req.Passphrase)
+	case agentSave:
+		err = a.save(req.Path, req.Database, req.Iterations, req.Backups)
+	case agentLock:
+		if req.Path == "" {
+			for path := range a.databases {
+				a.forget(path)
+			}
+		} else {
+			a.forget(req.Path)
+		}
+	case agentList:
+		for path, entry := range a.databases {
+			resp.Databases = append(resp.Databases, agentDatabase{
+				Path:    path,
+				Expires: entry.expires,
+			})
+		}
+	case agentStop:
+	default:
+		err = fmt.Errorf("unknown method %q", req.Method)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return &resp
+}
+
+// open returns the database at the path as JSON. A database changed on disk
+// since it was read is read again.
+func (a *agent) open(path string) (json.RawMessage, error) {
+	entry := a.databases[path]
+	if entry == nil {
+		return nil, errNotHeld
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		a.forget(path)
+		return nil, err
+	}
+	if info.Size() != entry.size || !info.ModTime().Equal(entry.mtime) {
+		if err = a.load(path, entry.passphrase); err != nil {
+			a.forget(path)
+			return nil, errNotHeld
+		}
+		entry = a.databases[path]
+	}
+	return entry.db.MarshalJSON()
+}
+
+// add unlocks the database at the path and holds it for the TTL
+func (a *agent) add(path, passphrase string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("path %q is not absolute", path)
+	}
+	if err := a.load(path, passphrase); err != nil {
+		return err
+	}
+	entry := a.databases[path]
+	if entry.timer != nil {
+		entry.timer.Stop()
+		entry.timer, entry.expires = nil, time.Time{}
+	}
+	if a.ttl > 0 {
+		var timer *time.Timer
+		timer = time.AfterFunc(a.ttl, func() {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			if e := a.databases[path]; e != nil && e.timer == timer {
+				a.forget(path)
+			}
+		})
+		entry.expires, entry.timer = time.Now().Add(a.ttl), timer
+	}
+	return nil
+}
+
+// load reads the database at the path, replacing the held copy but keeping
+// its expiry
+func (a *agent) load(path, passphrase string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	db, err := v3.Open(path, func() (string, error) {
+		return passphrase, nil
+	})
+	if err != nil {
+		return err
+	}
+	entry := &agentEntry{
+		db:         db,
+		passphrase: passphrase,
+		size:       info.Size(),
+		mtime:      info.ModTime(),
+	}
+	if old := a.databases[path]; old != nil {
+		entry.expires, entry.timer = old.expires, old.timer
+		old.db.Wipe()
+	}
+	a.databases[path] = entry
+	return nil
+}
+
+// save saves the JSON database to the path with the held passphrase
+func (a *agent) save(path string, data json.RawMessage, iterations,
+	backups int) error {
+
+	entry := a.databases[path]
+	if entry == nil {
+		return errNotHeld
+	}
+	db, err := v3.ReadJSON(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if iterations == 0 {
+		iterations = entry.db.Iterations()
+	}
+	if err = db.SetIterations(iterations); err != nil {
+		return err
+	}
+	if err = utils.RotateBackups(path, backups); err != nil {
+		return err
+	}
+	// record the changes since the held database in the audit log
+	db.SetAuditBaseline(entry.db)
+	if err = db.Save(path, entry.passphrase); err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	entry.db.Wipe()
+	entry.db, entry.size, entry.mtime = db, info.Size(), info.ModTime()
+	return nil
+}
+
+// forget wipes the database at the path from memory
+func (a *agent) forget(path string) {
+	entry := a.databases[path]
+	if entry == nil {
+		return
+	}
+	if entry.timer != nil {
+		entry.timer.Stop()
+	}
+	entry.db.Wipe()
+	delete(a.databases, path)
+}
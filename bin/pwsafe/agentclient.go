@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/azdagron/pwsafe/v3"
+)
+
+// agentSockEnv names the environment variable holding the agent socket path
+const agentSockEnv = "PWSAFE_AGENT_SOCK"
+
+// agentTimeout limits the time of a single agent request
+const agentTimeout = 30 * time.Second
+
+// Agent protocol methods. Every connection carries a single JSON request
+// followed by a single JSON response.
+const (
+	agentOpen   = "open"
+	agentAdd    = "add"
+	agentSave   = "save"
+	agentLock   = "lock"
+	agentList   = "list"
+	agentStop   = "stop"
+	agentLocked = "locked"
+)
+
+type agentRequest struct {
+	Method     string          `json:"method"`
+	Path       string          `json:"path,omitempty"`
+	Passphrase string          `json:"passphrase,omitempty"`
+	Database   json.RawMessage `json:"database,omitempty"`
+
+	// Iterations and Backups apply to save; zero iterations keeps the
+	// current count
+	Iterations int `json:"iterations,omitempty"`
+	Backups    int `json:"backups,omitempty"`
+}
+
+type agentResponse struct {
+	Error     string          `json:"error,omitempty"`
+	Database  json.RawMessage `json:"database,omitempty"`
+	Databases []agentDatabase `json:"databases,omitempty"`
+}
+
+// agentDatabase describes a database held by the agent
+type agentDatabase struct {
+	Path    string    `json:"path"`
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// errAgentLocked is returned when the agent does not hold the database
+var errAgentLocked = errors.New("database is not unlocked in the agent")
+
+// agentClient talks to the agent listening on a socket
+type agentClient struct {
+	socket string
+}
+
+// newAgentClient returns a client for the agent named by PWSAFE_AGENT_SOCK,
+// or nil if it is not set
+func newAgentClient() *agentClient {
+	socket := os.Getenv(agentSockEnv)
+	if socket == "" {
+		return nil
+	}
+	return &agentClient{socket: socket}
+}
+
+func (c *agentClient) call(req agentRequest) (*agentResponse, error) {
+	conn, err := net.DialTimeout("unix", c.socket, agentTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(agentTimeout))
+
+	if err = json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+	var resp agentResponse
+	if err = json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, err
+	}
+	switch resp.Error {
+	case "":
+		return &resp, nil
+	case agentLocked:
+		return nil, errAgentLocked
+	default:
+		return nil, errors.New("agent: " + resp.Error)
+	}
+}
+
+// open returns the database at the path if the agent holds it
+func (c *agentClient) open(path string) (*v3.Database, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.call(agentRequest{Method: agentOpen, Path: path})
+	if err != nil {
+		return nil, err
+	}
+	return v3.ReadJSON(bytes.NewReader(resp.Database))
+}
+
+// add unlocks the database at the path in the agent
+func (c *agentClient) add(path, passphrase string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	_, err = c.call(agentRequest{
+		Method:     agentAdd,
+		Path:       path,
+		Passphrase: passphrase,
+	})
+	return err
+}
+
+// save has the agent save the database to the path with the passphrase it
+// holds, keeping the number of backups
+func (c *agentClient) save(path string, db *v3.Database, iterations,
+	backups int) error {
+
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	data, err := db.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	_, err = c.call(agentRequest{
+		Method:     agentSave,
+		Path:       path,
+		Database:   data,
+		Iterations: iterations,
+		Backups:    backups,
+	})
+	return err
+}
+
+// lock has the agent forget the database at the path, or every database if
+// the path is empty
+func (c *agentClient) lock(path string) error {
+	if path != "" {
+		var err error
+		if path, err = filepath.Abs(path); err != nil {
+			return err
+		}
+	}
+	_, err := c.call(agentRequest{Method: agentLock, Path: path})
+	return err
+}
+
+// list returns the databases held by the agent
+func (c *agentClient) list() ([]agentDatabase, error) {
+	resp, err := c.call(agentRequest{Method: agentList})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Databases, nil
+}
+
+// stop stops the agent
+func (c *agentClient) stop() error {
+	_, err := c.call(agentRequest{Method: agentStop})
+	return err
+}
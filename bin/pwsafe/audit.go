@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/audit"
+	"github.com/azdagron/pwsafe/v3"
+)
+
+type auditCommand struct {
+	commonParams
+	Format   string
+	MinScore int
+	MaxAge   int
+	Policy   string
+	HIBP     string
+	All      bool
+	ExitCode bool
+}
+
+func (c *auditCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+	flagset.StringVar(&c.Format, "format", "text", "output format (text, json)")
+	flagset.IntVar(&c.MinScore, "min-score", audit.DefaultOptions.MinScore, "strength score from 0 to 4 below which passwords are weak")
+	flagset.IntVar(&c.MaxAge, "max-age", 365, "days after which unchanged passwords are old; 0 disables the check")
+	flagset.StringVar(&c.Policy, "policy", "", "password policy checked for entries without one: \"default\" or the name of a policy of the database; if empty, only entries with a policy are checked")
+	flagset.StringVar(&c.HIBP, "hibp", "", "directory of Pwned Passwords range files to look up passwords in")
+	flagset.BoolVar(&c.All, "all", false, "if true, lists the entries without findings too")
+	flagset.BoolVar(&c.ExitCode, "exit-code", false, "if true, exits with status 1 if there are findings")
+}
+
+func (c *auditCommand) Execute(args []string) (err error) {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments")
+	}
+	if c.Format != "text" && c.Format != "json" {
+		return fmt.Errorf("unknown format %q; expected text or json",
+			c.Format)
+	}
+	if c.MinScore < 0 || c.MinScore > 4 {
+		return fmt.Errorf("-min-score must be between 0 and 4")
+	}
+	if c.HIBP != "" {
+		if info, err := os.Stat(c.HIBP); err != nil || !info.IsDir() {
+			return fmt.Errorf("%s is not a directory of range files", c.HIBP)
+		}
+	}
+
+	db, _, err := c.open()
+	if err != nil {
+		return err
+	}
+	options := audit.Options{
+		MinScore: c.MinScore,
+		MaxAge:   time.Duration(c.MaxAge) * 24 * time.Hour,
+	}
+	if options.Policy, err = c.policy(db); err != nil {
+		return err
+	}
+	if c.HIBP != "" {
+		options.Breaches = &audit.RangeFiles{Dir: c.HIBP}
+	}
+
+	report, err := audit.Audit(db, options)
+	if err != nil {
+		return err
+	}
+	if c.Format == "json" {
+		err = printAuditJSON(os.Stdout, report)
+	} else {
+		c.printText(os.Stdout, db, report)
+	}
+	if err != nil {
+		return err
+	}
+	if c.ExitCode {
+		for _, record := range report.Records {
+			if len(record.Findings) > 0 {
+				return exitStatus(1)
+			}
+		}
+	}
+	return nil
+}
+
+// policy returns the policy named by -policy, or nil if it is empty
+func (c *auditCommand) policy(db *v3.Database) (*pwsafe.PasswordPolicy,
+	error) {
+
+	switch c.Policy {
+	case "":
+		return nil, nil
+	case "default":
+		policy := pwsafe.DefaultPasswordPolicy
+		return &policy, nil
+	}
+	for _, policy := range db.Header().(*v3.Header).PasswordPolicies() {
+		if policy.Name == c.Policy {
+			return &policy, nil
+		}
+	}
+	return nil, fmt.Errorf("no password policy named %q", c.Policy)
+}
+
+func (c *auditCommand) printText(w io.Writer, db *v3.Database,
+	report *audit.Report) {
+
+	summary := report.Summary
+	fmt.Fprintf(w, "score %d/100, %d entries audited\n", report.Score,
+		summary.Records)
+	fmt.Fprintf(w, "weak %d, reused %d, old %d, expired %d, policy %d",
+		summary.Weak, summary.Reused, summary.Old, summary.Expired,
+		summary.NonCompliant)
+	if c.HIBP != "" {
+		fmt.Fprintf(w, ", breached %d", summary.Breached)
+		if summary.Unchecked > 0 {
+			fmt.Fprintf(w, " (%d not checked: range files missing)",
+				summary.Unchecked)
+		}
+	}
+	fmt.Fprintln(w)
+
+	for _, record := range report.Records {
+		if len(record.Findings) == 0 && !c.All {
+			continue
+		}
+		fmt.Fprintf(w, "\n%3d  %s [%s]\n", record.Score,
+			joinTitlePath(record.Group, record.Title), record.UUID)
+		for _, finding := range record.Findings {
+			detail := finding.Detail
+			if finding.Kind == audit.Reused {
+				var paths []string
+				for _, uuid := range record.ReusedWith {
+					paths = append(paths, titlePath(db.Record(uuid)))
+				}
+				detail += ": " + strings.Join(paths, ", ")
+			}
+			fmt.Fprintf(w, "     %s: %s\n", finding.Kind, detail)
+		}
+	}
+}
+
+type auditJSON struct {
+	Score   int               `json:"score"`
+	Summary auditSummaryJSON  `json:"summary"`
+	Records []auditRecordJSON `json:"records"`
+}
+
+type auditSummaryJSON struct {
+	Records      int `json:"records"`
+	Weak         int `json:"weak"`
+	Reused       int `json:"reused"`
+	Old          int `json:"old"`
+	Expired      int `json:"expired"`
+	NonCompliant int `json:"policy"`
+	Breached     int `json:"breached"`
+	Unchecked    int `json:"unchecked"`
+}
+
+type auditRecordJSON struct {
+	UUID       string             `json:"uuid"`
+	Title      string             `json:"title"`
+	Group      string             `json:"group"`
+	Score      int                `json:"score"`
+	Strength   auditStrengthJSON  `json:"strength"`
+	Changed    *time.Time         `json:"changed,omitempty"`
+	Breaches   int                `json:"breaches"`
+	ReusedWith []string           `json:"reused_with"`
+	Findings   []auditFindingJSON `json:"findings"`
+}
+
+type auditStrengthJSON struct {
+	Score    int      `json:"score"`
+	Guesses  float64  `json:"guesses"`
+	Entropy  float64  `json:"entropy"`
+	Weakness string   `json:"weakness,omitempty"`
+	Patterns []string `json:"patterns"`
+}
+
+type auditFindingJSON struct {
+	Kind   string `json:"kind"`
+	Detail string `json:"detail"`
+}
+
+// printAuditJSON prints the report as a JSON document. Groups are written
+// with their elements separated by "/". The matched parts of the passwords
+// are left out; only their patterns are listed.
+func printAuditJSON(w io.Writer, report *audit.Report) error {
+	summary := report.Summary
+	doc := auditJSON{
+		Score: report.Score,
+		Summary: auditSummaryJSON{
+			Records:      summary.Records,
+			Weak:         summary.Weak,
+			Reused:       summary.Reused,
+			Old:          summary.Old,
+			Expired:      summary.Expired,
+			NonCompliant: summary.NonCompliant,
+			Breached:     summary.Breached,
+			Unchecked:    summary.Unchecked,
+		},
+		Records: []auditRecordJSON{},
+	}
+	for _, record := range report.Records {
+		item := auditRecordJSON{
+			UUID:  record.UUID,
+			Title: record.Title,
+			Group: groupPath(record.Group),
+			Score: record.Score,
+			Strength: auditStrengthJSON{
+				Score:    record.Strength.Score,
+				Guesses:  record.Strength.Guesses,
+				Entropy:  record.Strength.Entropy,
+				Weakness: record.Strength.Weakness(),
+				Patterns: []string{},
+			},
+			Breaches:   record.Breaches,
+			ReusedWith: append([]string{}, record.ReusedWith...),
+			Findings:   []auditFindingJSON{},
+		}
+		if !record.Changed.IsZero() {
+			changed := record.Changed.UTC()
+			item.Changed = &changed
+		}
+		for _, match := range record.Strength.Matches {
+			item.Strength.Patterns = append(item.Strength.Patterns,
+				match.Pattern)
+		}
+		for _, finding := range record.Findings {
+			item.Findings = append(item.Findings, auditFindingJSON{
+				Kind:   finding.Kind,
+				Detail: finding.Detail,
+			})
+		}
+		doc.Records = append(doc.Records, item)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
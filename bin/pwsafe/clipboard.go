@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/atotto/clipboard"
+)
+
+// clearClipboardName is the name of the command run in a detached child
+// to clear the clipboard
+const clearClipboardName = "clear-clipboard"
+
+// clipboardParams are the flags of commands that copy secrets
+type clipboardParams struct {
+	Clear   time.Duration
+	Primary bool
+}
+
+func (p *clipboardParams) AddFlags(flagset *flag.FlagSet) {
+	flagset.DurationVar(&p.Clear, "clear", 45*time.Second, "time after which copied secrets are cleared from the clipboard; 0 never clears")
+	flagset.BoolVar(&p.Primary, "primary", false, "if true, uses the X11/Wayland primary selection instead of the clipboard")
+}
+
+// copySecret copies the secret to the clipboard. Unless the clear timeout is
+// zero, a detached child process restores the previous clipboard contents
+// after the timeout if the clipboard still holds the secret.
+func (p *clipboardParams) copySecret(secret string) error {
+	sel, err := newSelection(p.Primary)
+	if err != nil {
+		return err
+	}
+	previous, err := sel.read()
+	if err != nil {
+		previous = ""
+	}
+	if err = sel.write(secret); err != nil {
+		return err
+	}
+	if p.Clear <= 0 {
+		return nil
+	}
+	if previous == secret {
+		previous = ""
+	}
+	return startClearClipboard(clearRequest{
+		Primary:  p.Primary,
+		Delay:    p.Clear,
+		Secret:   secret,
+		Previous: previous,
+	})
+}
+
+// clearRequest is passed to the detached child on its standard input, so the
+// secret never appears in its arguments
+type clearRequest struct {
+	Primary  bool          `json:"primary"`
+	Delay    time.Duration `json:"delay"`
+	Secret   string        `json:"secret"`
+	Previous string        `json:"previous"`
+}
+
+func startClearClipboard(req clearRequest) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, clearClipboardName)
+	detach(cmd)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("unable to start clipboard clearing: %s", err)
+	}
+	// write the request before returning, the child then outlives us
+	_, err = stdin.Write(data)
+	if close_err := stdin.Close(); err == nil {
+		err = close_err
+	}
+	if err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+type clearClipboardCommand struct{}
+
+func (c *clearClipboardCommand) ConfigureFlags(flagset *flag.FlagSet) {}
+
+func (c *clearClipboardCommand) Execute(args []string) error {
+	data, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	var req clearRequest
+	if err = json.Unmarshal(data, &req); err != nil {
+		return err
+	}
+
+	time.Sleep(req.Delay)
+
+	sel, err := newSelection(req.Primary)
+	if err != nil {
+		return err
+	}
+	current, err := sel.read()
+	if err != nil || current != req.Secret {
+		// something else has been copied since
+		return err
+	}
+	if req.Previous != "" {
+		return sel.write(req.Previous)
+	}
+	return sel.clear()
+}
+
+// selection is the clipboard or the primary selection. It uses the Wayland
+// or X11 command line tools when available, falling back to the platform
+// clipboard otherwise.
+type selection struct {
+	copyCmd  []string
+	pasteCmd []string
+	clearCmd []string
+}
+
+func newSelection(primary bool) (*selection, error) {
+	switch {
+	case os.Getenv("WAYLAND_DISPLAY") != "" && hasCommand("wl-copy"):
+		var flags []string
+		if primary {
+			flags = []string{"--primary"}
+		}
+		return &selection{
+			copyCmd:  append([]string{"wl-copy"}, flags...),
+			pasteCmd: append([]string{"wl-paste", "--no-newline"}, flags...),
+			clearCmd: append([]string{"wl-copy", "--clear"}, flags...),
+		}, nil
+	case os.Getenv("DISPLAY") != "" && hasCommand("xclip"):
+		name := "clipboard"
+		if primary {
+			name = "primary"
+		}
+		return &selection{
+			copyCmd:  []string{"xclip", "-selection", name, "-in"},
+			pasteCmd: []string{"xclip", "-selection", name, "-out"},
+		}, nil
+	case os.Getenv("DISPLAY") != "" && hasCommand("xsel"):
+		flag := "--clipboard"
+		if primary {
+			flag = "--primary"
+		}
+		return &selection{
+			copyCmd:  []string{"xsel", flag, "--input"},
+			pasteCmd: []string{"xsel", flag, "--output"},
+			clearCmd: []string{"xsel", flag, "--clear"},
+		}, nil
+	case primary:
+		return nil, fmt.Errorf("the primary selection needs wl-copy, xclip " +
+			"or xsel")
+	}
+	return &selection{}, nil
+}
+
+func hasCommand(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+func (s *selection) read() (string, error) {
+	if s.pasteCmd == nil {
+		return clipboard.ReadAll()
+	}
+	cmd := exec.Command(s.pasteCmd[0], s.pasteCmd[1:]...)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func (s *selection) write(text string) error {
+	if s.copyCmd == nil {
+		return clipboard.WriteAll(text)
+	}
+	cmd := exec.Command(s.copyCmd[0], s.copyCmd[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
+
+func (s *selection) clear() error {
+	if s.clearCmd == nil {
+		return s.write("")
+	}
+	return exec.Command(s.clearCmd[0], s.clearCmd[1:]...).Run()
+}
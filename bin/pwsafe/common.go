@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bufio"
 	"flag"
-	"os/user"
+	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
 
-	"github.com/bgentry/speakeasy"
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/utils"
+	"github.com/azdagron/pwsafe/v3"
 )
 
 type command interface {
@@ -14,33 +19,178 @@ type command interface {
 }
 
 type commonParams struct {
+	Database   string
 	Path       string
-	Passphrase string
+	Iterations int
+	Backups    int
+	Passphrase passphraseParams
+	Pinentry   string
+
+	// agent is set when the database was opened from the agent, which then
+	// saves it as well
+	agent *agentClient
+
+	// batch is set by commands whose standard input and output are not the
+	// user's, which therefore must not ask for the passphrase on the terminal
+	batch bool
 }
 
 func (p *commonParams) AddFlags(flagset *flag.FlagSet) {
+	flagset.StringVar(&p.Database, "db", "", "name of a database in the config file (defaults to $"+databaseEnv+")")
 	flagset.StringVar(&p.Path, "path", defaultPath(), "path to database")
-	flagset.StringVar(&p.Passphrase, "passphrase", "", "database passphrase")
+	flagset.IntVar(&p.Iterations, "iterations", 0, "key stretching iterations used when saving; 0 keeps the current count")
+	flagset.IntVar(&p.Backups, "backups", 0, "number of backups kept when saving")
+	p.Passphrase.AddFlags(flagset, "passphrase", "database passphrase")
+	flagset.StringVar(&p.Pinentry, "pinentry", os.Getenv(pinentryEnv), "pinentry program to ask for passphrases with (defaults to $"+pinentryEnv+")")
 }
 
-func defaultPath() string {
-	u, err := user.Current()
+// openOrCreate opens the database, or creates a new database if the database
+// does not exist yet. The database passphrase is returned for saving.
+func (p *commonParams) openOrCreate() (db *v3.Database, passphrase string,
+	err error) {
+
+	if p.Path == "" {
+		return nil, "", fmt.Errorf("no database; use -path or -db")
+	}
+	if _, err := os.Stat(p.Path); os.IsNotExist(err) {
+		db, err = v3.NewDatabase()
+		if err != nil {
+			return nil, "", err
+		}
+		passphrase, err = askNewPassphrase(&p.Passphrase, p.Pinentry)
+		if err != nil {
+			return nil, "", err
+		}
+		return db, passphrase, nil
+	}
+	return p.open()
+}
+
+// open opens an existing database. The database passphrase is returned for
+// saving.
+//
+// If PWSAFE_AGENT_SOCK is set, the database is taken from the agent without
+// asking for the passphrase. The returned passphrase is empty then and save
+// goes through the agent. A database the agent does not hold yet is added to
+// it after asking for the passphrase.
+func (p *commonParams) open() (db *v3.Database, passphrase string,
+	err error) {
+
+	if p.Path == "" {
+		return nil, "", fmt.Errorf("no database; use -path or -db")
+	}
+
+	client := newAgentClient()
+	if client != nil {
+		db, err = client.open(p.Path)
+		if err == nil {
+			p.agent = client
+			return db, "", nil
+		}
+		if err != errAgentLocked {
+			fmt.Fprintf(os.Stderr, "agent unavailable: %s\n", err)
+			client = nil
+		}
+	}
+
+	if p.batch && !p.Passphrase.configured() && p.Pinentry == "" {
+		return nil, "", fmt.Errorf("unable to ask for the passphrase; use " +
+			"the agent, a -passphrase source or -pinentry")
+	}
+	db, err = v3.Open(p.Path, makePassphraseFn("Passphrase: ", &p.Passphrase,
+		p.Pinentry, &passphrase))
 	if err != nil {
-		panic(err)
+		return nil, "", err
+	}
+	if client != nil {
+		if err := client.add(p.Path, passphrase); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to add the database to the "+
+				"agent: %s\n", err)
+		}
 	}
-	return filepath.Join(u.HomeDir, "default.psafe")
+	return db, passphrase, nil
+}
+
+// save saves the database opened with open or openOrCreate, rotating the
+// backups first
+func (p *commonParams) save(db *v3.Database, passphrase string) error {
+	return p.saveWithBackups(db, passphrase, p.Backups)
 }
 
-func makePassphraseFn(passphrase string, out *string) func() (string, error) {
-	return func() (val string, err error) {
-		if passphrase == "" {
-			val, err = speakeasy.Ask("Passphrase: ")
-		} else {
-			val, err = passphrase, nil
+// saveWithBackups saves the database like save, keeping backups copies
+// instead of -backups
+func (p *commonParams) saveWithBackups(db *v3.Database, passphrase string,
+	backups int) error {
+
+	if p.agent != nil {
+		return p.agent.save(p.Path, db, p.Iterations, backups)
+	}
+	if p.Iterations != 0 {
+		if err := db.SetIterations(p.Iterations); err != nil {
+			return err
 		}
-		if out != nil {
-			*out = val
+	}
+	if err := utils.RotateBackups(p.Path, backups); err != nil {
+		return err
+	}
+	return db.Save(p.Path, passphrase)
+}
+
+// openOther opens another database, such as one to compare or merge with.
+// The passphrase of the database is tried first unless the source is
+// configured.
+func openOther(path, passphrase string, source *passphraseParams,
+	pinentry string) (*v3.Database, error) {
+
+	if passphrase != "" && !source.configured() {
+		db, err := v3.Open(path, func() (string, error) {
+			return passphrase, nil
+		})
+		if !pwsafe.BadPassphrase.Contains(err) {
+			return db, err
 		}
-		return val, err
 	}
+	return v3.Open(path, makePassphraseFn("Passphrase of "+path+": ", source,
+		pinentry, nil))
+}
+
+// defaultPath returns the database used without -path or a configured path,
+// or an empty string if there is no home directory
+func defaultPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, "default.psafe")
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// prompt asks for a line of input. An empty answer returns the default
+// value.
+func prompt(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(os.Stderr, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: ", question)
+	}
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return def, nil
+	}
+	return line, nil
+}
+
+// confirm asks a yes or no question, defaulting to no
+func confirm(question string) bool {
+	answer, err := prompt(question+" [y/N]", "")
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
 }
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	// configEnv names the environment variable overriding the path of the
+	// configuration file
+	configEnv = "PWSAFE_CONFIG"
+
+	// databaseEnv names the environment variable selecting the database
+	// when -db is not given
+	databaseEnv = "PWSAFE_DB"
+)
+
+// databaseConfig holds the settings of a database in the configuration
+// file. The top level of the file holds the defaults for all databases.
+type databaseConfig struct {
+	Path           string `toml:"path"`
+	Keyfile        string `toml:"keyfile"`
+	Iterations     *int   `toml:"iterations"`
+	ClipboardClear string `toml:"clipboard_clear"`
+	Backups        *int   `toml:"backups"`
+	Format         string `toml:"format"`
+}
+
+// config is the configuration file
+type config struct {
+	databaseConfig
+
+	// Default is the database used without -db
+	Default   string                    `toml:"default"`
+	Databases map[string]databaseConfig `toml:"databases"`
+}
+
+// setting describes a configurable setting
+type setting struct {
+	name string
+	env  string
+
+	// flag receives the value as its default, for the listed commands or
+	// for every command with the flag if there are none
+	flag     string
+	commands []string
+
+	// locates is true for the settings locating the database, whose
+	// environment variables do not apply to a database named with -db
+	locates bool
+
+	get      func(c *databaseConfig) (string, bool)
+	validate func(value string) error
+	builtin  func() string
+}
+
+// settings are the configurable settings
+var settings = []setting{
+	{
+		name: "path",
+		env:  "PWSAFE_PATH",
+		flag: "path",
+		get: func(c *databaseConfig) (string, bool) {
+			return expandPath(c.Path), c.Path != ""
+		},
+		builtin: defaultPath,
+		locates: true,
+	},
+	{
+		name: "keyfile",
+		env:  "PWSAFE_KEYFILE",
+		flag: "passphrase-file",
+		get: func(c *databaseConfig) (string, bool) {
+			return expandPath(c.Keyfile), c.Keyfile != ""
+		},
+		locates: true,
+	},
+	{
+		name: "iterations",
+		env:  "PWSAFE_ITERATIONS",
+		flag: "iterations",
+		get: func(c *databaseConfig) (string, bool) {
+			return intSetting(c.Iterations)
+		},
+		validate: func(value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 2048 {
+				return fmt.Errorf("expected at least 2048")
+			}
+			return nil
+		},
+	},
+	{
+		name: "clipboard_clear",
+		env:  "PWSAFE_CLIPBOARD_CLEAR",
+		flag: "clear",
+		get: func(c *databaseConfig) (string, bool) {
+			return c.ClipboardClear, c.ClipboardClear != ""
+		},
+		validate: func(value string) error {
+			_, err := time.ParseDuration(value)
+			return err
+		},
+		builtin: func() string { return "45s" },
+	},
+	{
+		name: "backups",
+		env:  "PWSAFE_BACKUPS",
+		flag: "backups",
+		get: func(c *databaseConfig) (string, bool) {
+			return intSetting(c.Backups)
+		},
+		validate: func(value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return fmt.Errorf("expected a number of backups")
+			}
+			return nil
+		},
+		builtin: func() string { return "0" },
+	},
+	{
+		name:     "format",
+		env:      "PWSAFE_FORMAT",
+		flag:     "format",
+		commands: []string{"list"},
+		get: func(c *databaseConfig) (string, bool) {
+			return c.Format, c.Format != ""
+		},
+		validate: func(value string) error {
+			switch value {
+			case "table", "json", "jsonl":
+				return nil
+			}
+			return fmt.Errorf("expected table, json or jsonl")
+		},
+		builtin: func() string { return "table" },
+	},
+}
+
+func intSetting(n *int) (string, bool) {
+	if n == nil {
+		return "", false
+	}
+	return strconv.Itoa(*n), true
+}
+
+// settingValue is the effective value of a setting
+type settingValue struct {
+	setting
+	value  string
+	source string
+
+	// ignored names the environment variable set but not applied to a
+	// database named with -db
+	ignored string
+}
+
+// configPath returns the path of the configuration file
+func configPath() string {
+	if path := os.Getenv(configEnv); path != "" {
+		return path
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "pwsafe", "config.toml")
+}
+
+// loadConfig reads the configuration file. A missing file is an empty
+// configuration.
+func loadConfig(path string) (*config, error) {
+	var cfg config
+	if path == "" {
+		return &cfg, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	md, err := toml.Decode(string(data), &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("%s: unknown key %s", path, undecoded[0])
+	}
+	return &cfg, nil
+}
+
+// resolveSettings returns the effective settings of the named database.
+// Environment variables override the settings of the database, which
+// override the defaults at the top level of the configuration file, except
+// that PWSAFE_PATH and PWSAFE_KEYFILE do not move a database named
+// explicitly. An empty name selects $PWSAFE_DB or the default database of
+// the file, if any.
+func resolveSettings(cfg *config, name string) (
+	database string, values []settingValue, err error) {
+
+	database = name
+	if database == "" {
+		database = os.Getenv(databaseEnv)
+	}
+	if database == "" {
+		database = cfg.Default
+	}
+	values, err = databaseSettings(cfg, database, name != "")
+	if err != nil {
+		return "", nil, err
+	}
+	return database, values, nil
+}
+
+// databaseSettings returns the effective settings of the named database, or
+// of no database in particular if the name is empty. For a database named
+// explicitly, the environment variables of the settings locating it are
+// ignored.
+func databaseSettings(cfg *config, database string, explicit bool) (
+	values []settingValue, err error) {
+
+	var db_cfg *databaseConfig
+	if database != "" {
+		c, ok := cfg.Databases[database]
+		if !ok {
+			return nil, fmt.Errorf("unknown database %q", database)
+		}
+		db_cfg = &c
+	}
+
+	for _, s := range settings {
+		v := settingValue{setting: s, source: "default"}
+		if s.builtin != nil {
+			v.value = s.builtin()
+		}
+		if value, ok := s.get(&cfg.databaseConfig); ok {
+			v.value, v.source = value, "config"
+		}
+		if db_cfg != nil {
+			if value, ok := s.get(db_cfg); ok {
+				v.value, v.source = value, "databases."+database
+			}
+		}
+		if value := os.Getenv(s.env); value != "" {
+			if explicit && s.locates {
+				v.ignored = s.env
+			} else {
+				v.value, v.source = value, s.env
+			}
+		}
+		if v.value != "" && v.source != "default" && s.validate != nil {
+			if err := s.validate(v.value); err != nil {
+				return nil, fmt.Errorf("invalid %s %q from %s: %s",
+					s.name, v.value, v.source, err)
+			}
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// applySettings sets the flags of commands taking a database that were not
+// given on the command line to the effective settings. A -path given without
+// -db names no configured database, so only the top level settings apply.
+func applySettings(cmdname string, flagset *flag.FlagSet) error {
+	db_flag := flagset.Lookup("db")
+	if db_flag == nil || flagset.Lookup("path") == nil {
+		return nil
+	}
+	given := make(map[string]bool)
+	flagset.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+		if strings.HasPrefix(f.Name, "passphrase") {
+			// any passphrase source replaces the keyfile
+			given["passphrase-file"] = true
+		}
+	})
+
+	cfg, err := loadConfig(configPath())
+	if err != nil {
+		return err
+	}
+	var values []settingValue
+	if given["path"] && !given["db"] {
+		values, err = databaseSettings(cfg, "", false)
+	} else {
+		_, values, err = resolveSettings(cfg, db_flag.Value.String())
+	}
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		if v.source == "default" || given[v.flag] ||
+			flagset.Lookup(v.flag) == nil {
+			continue
+		}
+		if len(v.commands) > 0 && !containsString(v.commands, cmdname) {
+			continue
+		}
+		if err := flagset.Set(v.flag, v.value); err != nil {
+			return fmt.Errorf("invalid %s from %s: %s", v.name, v.source,
+				err)
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// expandPath expands a leading ~ and environment variables
+func expandPath(path string) string {
+	path = os.ExpandEnv(path)
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
+
+type configCommand struct {
+	Database string
+	List     bool
+}
+
+func (c *configCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	flagset.StringVar(&c.Database, "db", "", "name of the database to show the settings of")
+	flagset.BoolVar(&c.List, "list", false, "if true, lists the configured databases")
+}
+
+func (c *configCommand) Execute(args []string) (err error) {
+	path := configPath()
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	if c.List {
+		names := make([]string, 0, len(cfg.Databases))
+		for name := range cfg.Databases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, name := range names {
+			mark := ""
+			if name == cfg.Default {
+				mark = " (default)"
+			}
+			fmt.Fprintf(w, "%s%s\t%s\n", name, mark,
+				expandPath(cfg.Databases[name].Path))
+		}
+		return w.Flush()
+	}
+
+	database, values, err := resolveSettings(cfg, c.Database)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		path += " (not found)"
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "config\t%s\n", path)
+	if database == "" {
+		database = "(none)"
+	}
+	fmt.Fprintf(w, "database\t%s\n", database)
+	for _, v := range values {
+		value := v.value
+		if value == "" {
+			value = "-"
+		}
+		source := v.source
+		if v.ignored != "" {
+			source += ", " + v.ignored + " ignored for -db"
+		}
+		fmt.Fprintf(w, "%s\t%s\t(%s)\n", v.name, value, source)
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	fmt.Println()
+	fmt.Println("Flags override the PWSAFE_* environment variables, which " +
+		"override the\nsettings of the database, which override the top " +
+		"level of the file.\nPWSAFE_PATH and PWSAFE_KEYFILE do not apply to " +
+		"a database named with -db.")
+	return nil
+}
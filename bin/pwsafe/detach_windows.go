@@ -0,0 +1,8 @@
+package main
+
+import (
+	"os/exec"
+)
+
+// detach does nothing; child processes outlive their parent on windows
+func detach(cmd *exec.Cmd) {}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/v3"
+)
+
+type diffCommand struct {
+	commonParams
+	Unmask          bool
+	Format          string
+	ExitCode        bool
+	Textconv        bool
+	Git             bool
+	OtherPassphrase passphraseParams
+}
+
+func (c *diffCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+	flagset.BoolVar(&c.Unmask, "unmask", false, "if true, shows the changed passwords and TOTP secrets")
+	flagset.StringVar(&c.Format, "format", "text", "output format (text, json)")
+	flagset.BoolVar(&c.ExitCode, "exit-code", false, "if true, exits with status 1 if the databases differ")
+	flagset.BoolVar(&c.Textconv, "textconv", false, "if true, prints the entries of a single database as text, for use as a git textconv filter")
+	flagset.BoolVar(&c.Git, "git", false, "if true, takes the seven arguments of a git external diff command")
+	c.OtherPassphrase.AddFlags(flagset, "other-passphrase",
+		"passphrase of the second database (defaults to the passphrase of the first)")
+}
+
+func (c *diffCommand) Execute(args []string) (err error) {
+	if c.Format != "text" && c.Format != "json" {
+		return fmt.Errorf("unknown format %q; expected text or json",
+			c.Format)
+	}
+
+	var before, after *v3.Database
+	var passphrase string
+	switch {
+	case c.Textconv:
+		if len(args) != 1 {
+			return fmt.Errorf("expected the path of the database")
+		}
+		if before, err = v3.NewDatabase(); err != nil {
+			return err
+		}
+		after, err = c.openFile(args[0], &passphrase)
+	case c.Git:
+		// path old-file old-hex old-mode new-file new-hex new-mode
+		if len(args) != 7 {
+			return fmt.Errorf("expected the 7 arguments of a git " +
+				"external diff command")
+		}
+		if before, err = c.openFile(args[1], &passphrase); err != nil {
+			return err
+		}
+		after, err = c.openFile(args[4], &passphrase)
+	case len(args) == 1:
+		// compare with the database
+		if after, passphrase, err = c.open(); err != nil {
+			return err
+		}
+		before, err = c.openFile(args[0], &passphrase)
+	case len(args) == 2:
+		if before, err = c.openFile(args[0], &passphrase); err != nil {
+			return err
+		}
+		after, err = c.openFile(args[1], &passphrase)
+	default:
+		return fmt.Errorf("expected the databases to compare")
+	}
+	if err != nil {
+		return err
+	}
+
+	diff := pwsafe.Diff(before, after)
+	if c.Git {
+		fmt.Printf("pwsafe diff a/%s b/%s\n", args[0], args[0])
+	}
+	if c.Format == "json" {
+		err = c.printJSON(os.Stdout, diff)
+	} else {
+		c.printText(os.Stdout, diff)
+	}
+	if err != nil {
+		return err
+	}
+	if c.ExitCode && !diff.Empty() {
+		return exitStatus(1)
+	}
+	return nil
+}
+
+// openFile opens a database to compare. The null device, which git passes
+// for added and removed files, is an empty database. The passphrase of the
+// first database opened is kept for opening the second.
+func (c *diffCommand) openFile(path string, passphrase *string) (
+	*v3.Database, error) {
+
+	if path == os.DevNull || path == "/dev/null" {
+		return v3.NewDatabase()
+	}
+	if *passphrase != "" || c.OtherPassphrase.configured() {
+		return openOther(path, *passphrase, &c.OtherPassphrase, c.Pinentry)
+	}
+	return v3.Open(path, makePassphraseFn("Passphrase of "+path+": ",
+		&c.Passphrase, c.Pinentry, passphrase))
+}
+
+func (c *diffCommand) printText(w io.Writer, diff *pwsafe.DatabaseDiff) {
+	for _, change := range diff.Header {
+		fmt.Fprintf(w, "header %s: %s\n", change.Field,
+			c.formatChange(pwsafe.Modified, change))
+	}
+	for _, group := range diff.AddedGroups {
+		fmt.Fprintf(w, "empty group added: %s\n", groupPath(group))
+	}
+	for _, group := range diff.RemovedGroups {
+		fmt.Fprintf(w, "empty group removed: %s\n", groupPath(group))
+	}
+	for _, record := range diff.Records {
+		fmt.Fprintf(w, "%s: %s [%s]\n", record.Kind,
+			joinTitlePath(record.Group, record.Title), record.UUID)
+		for _, change := range record.Fields {
+			fmt.Fprintf(w, "    %s: %s\n", change.Field,
+				c.formatChange(record.Kind, change))
+		}
+	}
+}
+
+// formatChange returns the value of an added or removed field, or the old
+// and new value of a changed field. Secrets are masked unless unmasked.
+func (c *diffCommand) formatChange(kind pwsafe.ChangeKind,
+	change pwsafe.FieldChange) string {
+
+	if change.Secret && !c.Unmask {
+		if kind == pwsafe.Added || kind == pwsafe.Removed {
+			return "set"
+		}
+		return "changed"
+	}
+	switch kind {
+	case pwsafe.Added:
+		return strconv.Quote(change.New)
+	case pwsafe.Removed:
+		return strconv.Quote(change.Old)
+	}
+	return strconv.Quote(change.Old) + " -> " + strconv.Quote(change.New)
+}
+
+type diffJSON struct {
+	Header        []fieldChangeJSON `json:"header"`
+	AddedGroups   []string          `json:"added_groups"`
+	RemovedGroups []string          `json:"removed_groups"`
+	Records       []recordDiffJSON  `json:"records"`
+}
+
+type recordDiffJSON struct {
+	Change string            `json:"change"`
+	UUID   string            `json:"uuid"`
+	Title  string            `json:"title"`
+	Group  string            `json:"group"`
+	Fields []fieldChangeJSON `json:"fields"`
+}
+
+type fieldChangeJSON struct {
+	Field  string `json:"field"`
+	Old    string `json:"old"`
+	New    string `json:"new"`
+	Masked bool   `json:"masked,omitempty"`
+}
+
+// printJSON prints the differences as a JSON document. Groups are written
+// with their elements separated by "/". Masked secrets have empty values.
+func (c *diffCommand) printJSON(w io.Writer,
+	diff *pwsafe.DatabaseDiff) error {
+
+	fields := func(changes []pwsafe.FieldChange) []fieldChangeJSON {
+		out := []fieldChangeJSON{}
+		for _, change := range changes {
+			field := fieldChangeJSON{
+				Field: change.Field,
+				Old:   change.Old,
+				New:   change.New,
+			}
+			if change.Secret && !c.Unmask {
+				field.Old, field.New, field.Masked = "", "", true
+			}
+			out = append(out, field)
+		}
+		return out
+	}
+	groups := func(groups []string) []string {
+		out := []string{}
+		for _, group := range groups {
+			out = append(out, groupPath(group))
+		}
+		return out
+	}
+
+	doc := diffJSON{
+		Header:        fields(diff.Header),
+		AddedGroups:   groups(diff.AddedGroups),
+		RemovedGroups: groups(diff.RemovedGroups),
+		Records:       []recordDiffJSON{},
+	}
+	for _, record := range diff.Records {
+		doc.Records = append(doc.Records, recordDiffJSON{
+			Change: record.Kind.String(),
+			UUID:   record.UUID,
+			Title:  record.Title,
+			Group:  groupPath(record.Group),
+			Fields: fields(record.Fields),
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
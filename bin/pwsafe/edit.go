@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/utils"
+	"github.com/azdagron/pwsafe/v3"
+)
+
+const editHeader = `# Edit the entry, then save and quit. The group elements are separated by /.
+# Expiry is a date (2006-01-02) or an RFC 3339 time; empty means no expiry.
+`
+
+// editDocument is the entry as edited in the editor
+type editDocument struct {
+	Title     string            `toml:"title"`
+	Group     string            `toml:"group"`
+	Username  string            `toml:"username"`
+	Password  string            `toml:"password"`
+	URL       string            `toml:"url"`
+	Email     string            `toml:"email"`
+	TOTP      string            `toml:"totp"`
+	Expiry    string            `toml:"expiry"`
+	Protected bool              `toml:"protected"`
+	Notes     string            `toml:"notes,multiline"`
+	Custom    []editCustomField `toml:"custom"`
+}
+
+type editCustomField struct {
+	Name  string `toml:"name"`
+	Value string `toml:"value"`
+}
+
+type editCommand struct {
+	commonParams
+	Force bool
+}
+
+func (c *editCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+	flagset.BoolVar(&c.Force, "force", false, "if true, edits protected entries")
+}
+
+func (c *editCommand) Execute(args []string) (err error) {
+	db, passphrase, err := c.open()
+	if err != nil {
+		return err
+	}
+	record, err := selectRecord(db, args)
+	if err != nil {
+		return err
+	}
+	if record.Protected() && !c.Force {
+		return fmt.Errorf("%s is protected; use -force to edit it",
+			titlePath(record))
+	}
+
+	doc, err := editRecord(record)
+	if err != nil {
+		return err
+	}
+	if doc == nil || !applyDocument(record, doc) {
+		fmt.Fprintln(os.Stderr, "no changes")
+		return nil
+	}
+	return c.save(db, passphrase)
+}
+
+// editRecord opens the record in the editor and returns the edited document,
+// or nil if the edit was abandoned
+func editRecord(record *v3.Record) (doc *editDocument, err error) {
+	var buf bytes.Buffer
+	buf.WriteString(editHeader)
+	if err = toml.NewEncoder(&buf).Encode(newEditDocument(record)); err != nil {
+		return nil, err
+	}
+
+	f, err := ioutil.TempFile(secureTempDir(), "pwsafe-*.toml")
+	if err != nil {
+		return nil, err
+	}
+	defer scrubFile(f.Name())
+	_, err = f.Write(buf.Bytes())
+	utils.LogError(f.Close)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if err = runEditor(f.Name()); err != nil {
+			return nil, err
+		}
+		doc, err = readEditDocument(f.Name())
+		if err == nil {
+			return doc, nil
+		}
+		fmt.Fprintln(os.Stderr, err)
+		if !confirm("Edit again?") {
+			return nil, nil
+		}
+	}
+}
+
+func newEditDocument(record *v3.Record) *editDocument {
+	doc := &editDocument{
+		Title:     record.Title(),
+		Group:     groupPath(record.Group()),
+		Username:  record.Username(),
+		Password:  record.Password(),
+		URL:       record.URL(),
+		Email:     record.Email(),
+		TOTP:      record.TOTP(),
+		Protected: record.Protected(),
+		Notes:     record.Notes(),
+	}
+	if expiry := record.Expiry(); !expiry.IsZero() {
+		doc.Expiry = expiry.Format(time.RFC3339)
+	}
+	for _, field := range record.CustomFields() {
+		doc.Custom = append(doc.Custom, editCustomField(field))
+	}
+	return doc
+}
+
+// readEditDocument reads and validates the edited document
+func readEditDocument(path string) (*editDocument, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc editDocument
+	md, err := toml.Decode(string(data), &doc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid entry: %s", err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("invalid entry: unknown key %s", undecoded[0])
+	}
+	if doc.Title == "" {
+		return nil, fmt.Errorf("invalid entry: the title is required")
+	}
+	if _, err = parseExpiry(doc.Expiry); err != nil {
+		return nil, fmt.Errorf("invalid entry: %s", err)
+	}
+	return &doc, nil
+}
+
+// applyDocument applies the edited document to the record, returning false
+// if nothing changed
+func applyDocument(record *v3.Record, doc *editDocument) bool {
+	before := newEditDocument(record)
+	changed := false
+	set := func(old, new string, fn func(string)) {
+		if old != new {
+			fn(new)
+			changed = true
+		}
+	}
+	set(before.Title, doc.Title, record.SetTitle)
+	set(before.Group, doc.Group, func(group string) {
+		record.SetGroup(parseGroupPath(group))
+	})
+	set(before.Username, doc.Username, record.SetUsername)
+	set(before.URL, doc.URL, record.SetURL)
+	set(before.Email, doc.Email, record.SetEmail)
+	set(before.TOTP, doc.TOTP, record.SetTOTP)
+	set(before.Notes, doc.Notes, record.SetNotes)
+	set(before.Expiry, doc.Expiry, func(s string) {
+		expiry, _ := parseExpiry(s)
+		record.SetExpiry(expiry)
+	})
+	if before.Protected != doc.Protected {
+		record.SetProtected(doc.Protected)
+		changed = true
+	}
+
+	var custom []pwsafe.CustomField
+	for _, field := range doc.Custom {
+		custom = append(custom, pwsafe.CustomField(field))
+	}
+	if !equalCustomFields(before.Custom, doc.Custom) {
+		record.SetCustomFields(custom)
+		changed = true
+	}
+
+	if before.Password != doc.Password {
+		// also sets the modification time
+		record.ChangePassword(doc.Password)
+		return true
+	}
+	if changed {
+		record.SetMtime(time.Now())
+	}
+	return changed
+}
+
+func equalCustomFields(a, b []editCustomField) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseExpiry parses a date or RFC 3339 time. An empty value is no expiry.
+func parseExpiry(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// runEditor runs the editor from $VISUAL or $EDITOR on the file
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	// the editor may have arguments, so run it with the shell
+	cmd := exec.Command("/bin/sh", "-c", editor+` "$1"`, "sh", path)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor failed: %s", err)
+	}
+	return nil
+}
+
+// secureTempDir returns a directory on a memory backed file system for
+// temporary files holding secrets
+func secureTempDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return dir
+	}
+	if fi, err := os.Stat("/dev/shm"); err == nil && fi.IsDir() {
+		return "/dev/shm"
+	}
+	fmt.Fprintln(os.Stderr, "warning: no memory backed temporary directory; "+
+		"secrets are written to disk")
+	return os.TempDir()
+}
+
+// scrubFile overwrites the file with zeros before removing it
+func scrubFile(path string) {
+	if fi, err := os.Stat(path); err == nil {
+		if f, err := os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+			f.Write(make([]byte, fi.Size()))
+			f.Sync()
+			f.Close()
+		}
+	}
+	utils.LogError(func() error { return os.Remove(path) })
+}
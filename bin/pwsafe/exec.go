@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/azdagron/pwsafe/v3"
+)
+
+// defaultEnvFile lists the environment of exec for a project. It is read
+// from the current directory if it exists.
+const defaultEnvFile = ".pwsafe-env"
+
+// secretMask replaces secrets in the output of commands run with -mask
+const secretMask = "********"
+
+// exitStatus is returned by commands to exit with the status instead of
+// printing an error
+type exitStatus int
+
+func (e exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+// envMapping sets the environment variable Name to the field of the entry
+// selected by Entry
+type envMapping struct {
+	Name  string
+	Entry string
+	Field string
+}
+
+// parseEnvMapping parses "NAME=entry:field". The field follows the last
+// colon, so that entries can be selected by queries.
+func parseEnvMapping(s string) (envMapping, error) {
+	i := strings.IndexByte(s, '=')
+	j := strings.LastIndexByte(s, ':')
+	if i <= 0 || j < i {
+		return envMapping{}, fmt.Errorf("invalid mapping %q; expected "+
+			"NAME=entry:field", s)
+	}
+	m := envMapping{
+		Name:  strings.TrimSpace(s[:i]),
+		Entry: strings.TrimSpace(s[i+1 : j]),
+		Field: strings.TrimSpace(s[j+1:]),
+	}
+	if m.Entry == "" || m.Field == "" {
+		return envMapping{}, fmt.Errorf("invalid mapping %q; expected "+
+			"NAME=entry:field", s)
+	}
+	return m, nil
+}
+
+// envMappings is a flag collecting -env mappings
+type envMappings []envMapping
+
+func (m *envMappings) String() string {
+	var mappings []string
+	for _, mapping := range *m {
+		mappings = append(mappings, fmt.Sprintf("%s=%s:%s", mapping.Name,
+			mapping.Entry, mapping.Field))
+	}
+	return strings.Join(mappings, ",")
+}
+
+func (m *envMappings) Set(value string) error {
+	mapping, err := parseEnvMapping(value)
+	if err != nil {
+		return err
+	}
+	*m = append(*m, mapping)
+	return nil
+}
+
+// readEnvFile reads mappings from a file with one "NAME=entry:field" per
+// line. Empty lines and lines starting with # are ignored, and environment
+// variables in the references are expanded.
+func readEnvFile(path string) (mappings []envMapping, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		mapping, err := parseEnvMapping(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, n, err)
+		}
+		mapping.Entry = os.ExpandEnv(mapping.Entry)
+		mappings = append(mappings, mapping)
+	}
+	return mappings, scanner.Err()
+}
+
+type execCommand struct {
+	commonParams
+	Env     envMappings
+	EnvFile string
+	Mask    bool
+}
+
+func (c *execCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+	flagset.Var(&c.Env, "env", "NAME=entry:field setting an environment variable of the command; may be repeated")
+	flagset.StringVar(&c.EnvFile, "env-file", defaultEnvFile, "file with one NAME=entry:field per line, used if it exists; -env overrides it")
+	flagset.BoolVar(&c.Mask, "mask", false, "if true, replaces the secrets in the output of the command")
+}
+
+func (c *execCommand) Execute(args []string) (err error) {
+	if len(args) == 0 {
+		return fmt.Errorf("expected a command")
+	}
+
+	var mappings []envMapping
+	if c.EnvFile != "" {
+		mappings, err = readEnvFile(c.EnvFile)
+		if os.IsNotExist(err) && c.EnvFile == defaultEnvFile {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	mappings = append(mappings, c.Env...)
+	if len(mappings) == 0 {
+		return fmt.Errorf("no environment mappings; use -env or %s",
+			defaultEnvFile)
+	}
+
+	db, _, err := c.open()
+	if err != nil {
+		return err
+	}
+	env, secrets, err := resolveEnv(db, mappings)
+	db.Wipe()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	var stdout, stderr *maskWriter
+	if c.Mask {
+		stdout = newMaskWriter(os.Stdout, secrets)
+		stderr = newMaskWriter(os.Stderr, secrets)
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+	}
+
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	// the command gets the signals of the terminal itself, so only
+	// SIGTERM is passed on
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGTERM {
+				cmd.Process.Signal(sig)
+			}
+		}
+	}()
+	err = cmd.Wait()
+	signal.Stop(signals)
+	close(signals)
+	if stdout != nil {
+		stdout.Flush()
+		stderr.Flush()
+	}
+
+	var exit_err *exec.ExitError
+	if errors.As(err, &exit_err) {
+		return exitStatus(exit_err.ExitCode())
+	}
+	return err
+}
+
+// resolveEnv returns the environment variables of the mappings and the
+// secrets they hold. Every reference that cannot be resolved is reported.
+func resolveEnv(db *v3.Database, mappings []envMapping) (env,
+	secrets []string, err error) {
+
+	values := make(map[string]string)
+	var problems []string
+	for _, mapping := range mappings {
+		record, err := selectRecord(db, []string{mapping.Entry})
+		if err == nil {
+			var value string
+			value, err = getField(db, record, mapping.Field)
+			values[mapping.Name] = value
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", mapping.Name,
+				err))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, nil, fmt.Errorf("unable to resolve %s",
+			strings.Join(problems, "; "))
+	}
+
+	for name, value := range values {
+		env = append(env, name+"="+value)
+		if value != "" {
+			secrets = append(secrets, value)
+		}
+	}
+	sort.Strings(env)
+	return env, secrets, nil
+}
+
+// maskWriter replaces secrets in the data written to it. Data that may be
+// the beginning of a secret is held back until the next write or Flush.
+type maskWriter struct {
+	out     io.Writer
+	secrets [][]byte
+
+	mu      sync.Mutex
+	pending []byte
+}
+
+func newMaskWriter(out io.Writer, secrets []string) *maskWriter {
+	w := &maskWriter{out: out}
+	for _, secret := range secrets {
+		w.secrets = append(w.secrets, []byte(secret))
+	}
+	// longer secrets first, in case one contains another
+	sort.Slice(w.secrets, func(i, j int) bool {
+		return len(w.secrets[i]) > len(w.secrets[j])
+	})
+	return w
+}
+
+func (w *maskWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending = append(w.pending, p...)
+	for _, secret := range w.secrets {
+		w.pending = bytes.Replace(w.pending, secret, []byte(secretMask), -1)
+	}
+	n := len(w.pending) - w.partial()
+	if _, err := w.out.Write(w.pending[:n]); err != nil {
+		return 0, err
+	}
+	w.pending = append(w.pending[:0], w.pending[n:]...)
+	return len(p), nil
+}
+
+// partial returns the length of the longest end of the pending data that is
+// the beginning of a secret
+func (w *maskWriter) partial() int {
+	longest := 0
+	for _, secret := range w.secrets {
+		n := len(secret) - 1
+		if n > len(w.pending) {
+			n = len(w.pending)
+		}
+		for ; n > longest; n-- {
+			if bytes.HasSuffix(w.pending, secret[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
+
+// Flush writes the data held back
+func (w *maskWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := w.out.Write(w.pending)
+	w.pending = nil
+	return err
+}
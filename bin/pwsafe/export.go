@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/kdbx"
+)
+
+// exporter converts the database and saves it to the path
+type exporter func(path string, db pwsafe.Database, passphrase string) error
+
+var exporters = map[string]exporter{
+	"kdbx": kdbx.Save,
+}
+
+type exportCommand struct {
+	commonParams
+	Format        string
+	Out           string
+	OutPassphrase passphraseParams
+}
+
+func (c *exportCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+	flagset.StringVar(&c.Format, "format", "", "format of the exported file ("+
+		exportFormats()+")")
+	flagset.StringVar(&c.Out, "out", "", "path of the exported file")
+	c.OutPassphrase.AddFlags(flagset, "out-passphrase",
+		"passphrase of the exported file (defaults to the database passphrase)")
+}
+
+func (c *exportCommand) Execute(args []string) (err error) {
+	exp := exporters[c.Format]
+	if exp == nil {
+		return fmt.Errorf("unknown export format %q; expected one of %s",
+			c.Format, exportFormats())
+	}
+	if c.Out == "" {
+		return fmt.Errorf("missing -out path")
+	}
+
+	db, passphrase, err := c.open()
+	if err != nil {
+		return err
+	}
+	if c.OutPassphrase.configured() || passphrase == "" {
+		// without -out-passphrase, a database opened from the agent needs
+		// a new passphrase, as the agent keeps the passphrase to itself
+		passphrase, err = askNewPassphrase(&c.OutPassphrase, c.Pinentry)
+		if err != nil {
+			return err
+		}
+	}
+	return exp(c.Out, db, passphrase)
+}
+
+// exportFormats returns the names of the export formats
+func exportFormats() string {
+	var names []string
+	for name := range exporters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
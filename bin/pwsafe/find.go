@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/azdagron/pwsafe"
+)
+
+type findCommand struct {
+	commonParams
+	Sort   string
+	Fields string
+	Unmask bool
+}
+
+func (c *findCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+	flagset.StringVar(&c.Sort, "sort", "group,title", "comma separated fields to sort by; prefix a field with - to sort descending")
+	flagset.StringVar(&c.Fields, "fields", "group,title,username,url", "comma separated fields to show ("+strings.Join(pwsafe.FieldNames(), ", ")+", custom.<name>)")
+	flagset.BoolVar(&c.Unmask, "unmask", false, "if true, shows the passwords and TOTP secrets")
+}
+
+func (c *findCommand) Execute(args []string) (err error) {
+	query, err := pwsafe.ParseQuery(strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+	fields := splitList(c.Fields)
+	for _, field := range fields {
+		if err = pwsafe.CheckField(field); err != nil {
+			return err
+		}
+	}
+
+	db, _, err := c.open()
+	if err != nil {
+		return err
+	}
+
+	var records []pwsafe.Record
+	for _, record := range db.Records() {
+		if query.Match(record) {
+			records = append(records, record)
+		}
+	}
+	if err = pwsafe.SortRecords(records, splitList(c.Sort)); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, strings.ToUpper(strings.Join(fields, "\t")))
+	for _, record := range records {
+		var values []string
+		for _, field := range fields {
+			value, err := pwsafe.FieldValue(record, field)
+			if err != nil {
+				return err
+			}
+			s := formatValue(value)
+			if pwsafe.SecretField(field) && !c.Unmask {
+				s = strings.Repeat("*", len(s))
+			}
+			values = append(values, strings.Replace(s, "\n", " ", -1))
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+	return w.Flush()
+}
+
+// formatValue formats a record field value for display
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format("2006-01-02 15:04")
+	case int:
+		return strconv.Itoa(v)
+	case bool:
+		if v {
+			return "yes"
+		}
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// splitList splits a comma separated list, ignoring empty elements
+func splitList(s string) []string {
+	var elems []string
+	for _, elem := range strings.Split(s, ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/v3"
+)
+
+// historyFieldPrefix selects a previous password, most recent first
+const historyFieldPrefix = "history."
+
+type getCommand struct {
+	commonParams
+}
+
+func (c *getCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+}
+
+func (c *getCommand) Execute(args []string) (err error) {
+	if len(args) < 2 {
+		return fmt.Errorf("expected an entry and a field")
+	}
+	field := args[len(args)-1]
+
+	db, _, err := c.open()
+	if err != nil {
+		return err
+	}
+	record, err := selectRecord(db, args[:len(args)-1])
+	if err != nil {
+		return err
+	}
+	value, err := getField(db, record, field)
+	if err != nil {
+		return err
+	}
+	fmt.Print(value)
+	return nil
+}
+
+// getField returns the raw value of the field of the record. The password of
+// an alias and every field of a shortcut come from the base record. The
+// "history" field returns the previous passwords, most recent first, one per
+// line; "history.N" returns the Nth most recent one.
+func getField(db *v3.Database, record *v3.Record, field string) (
+	string, error) {
+
+	base, shortcut := db.Base(record)
+	switch {
+	case shortcut:
+		record = base
+	case base != nil && field == "password":
+		return base.Password(), nil
+	}
+
+	if field == "history" || strings.HasPrefix(field, historyFieldPrefix) {
+		history := record.History()
+		var passwords []string
+		for i := len(history) - 1; i >= 0; i-- {
+			passwords = append(passwords, history[i].Password)
+		}
+		if field == "history" {
+			return strings.Join(passwords, "\n"), nil
+		}
+		n, err := strconv.Atoi(field[len(historyFieldPrefix):])
+		if err != nil || n < 1 {
+			return "", fmt.Errorf("invalid history entry %q", field)
+		}
+		if n > len(passwords) {
+			return "", fmt.Errorf("%s has %d previous passwords",
+				titlePath(record), len(passwords))
+		}
+		return passwords[n-1], nil
+	}
+
+	value, err := pwsafe.FieldValue(record, field)
+	if err != nil {
+		return "", err
+	}
+	switch v := value.(type) {
+	case time.Time:
+		if v.IsZero() {
+			return "", nil
+		}
+		return v.Format(time.RFC3339), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return formatValue(value), nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/v3"
+)
+
+// gitCredentialName is the program name git runs for the credential helper
+// "pwsafe"; a link with this name runs the git-credential command
+const gitCredentialName = "git-credential-pwsafe"
+
+// gitCredentialCommand implements the git credential helper protocol. git
+// writes the credential attributes to the standard input and reads the
+// username and password of the matching entry from the standard output.
+type gitCredentialCommand struct {
+	commonParams
+	Group string
+}
+
+func (c *gitCredentialCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+	flagset.StringVar(&c.Group, "group", "git", "group of the credential entries, with elements separated by /; empty searches every group and stores at the top level")
+}
+
+func (c *gitCredentialCommand) Execute(args []string) (err error) {
+	if len(args) != 1 {
+		return fmt.Errorf("expected one of get, store or erase")
+	}
+	cred, err := readGitCredential(os.Stdin)
+	if err != nil {
+		return err
+	}
+	c.batch = true
+
+	switch args[0] {
+	case "get":
+		return c.get(cred)
+	case "store":
+		return c.store(cred)
+	case "erase":
+		return c.erase(cred)
+	}
+	// git may add operations; unknown ones are to be ignored
+	return nil
+}
+
+func (c *gitCredentialCommand) get(cred *gitCredential) error {
+	if cred.Host == "" {
+		return nil
+	}
+	db, _, err := c.open()
+	if err != nil {
+		return err
+	}
+	var best *v3.Record
+	best_score := 0
+	for _, record := range c.records(db) {
+		score := cred.match(record, false)
+		if score == 0 {
+			continue
+		}
+		if score > best_score || score == best_score &&
+			record.Mtime().After(best.Mtime()) {
+			best, best_score = record, score
+		}
+	}
+	if best == nil {
+		return nil
+	}
+
+	username, err := getField(db, best, "username")
+	if err != nil {
+		return err
+	}
+	password, err := getField(db, best, "password")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	if username != "" {
+		fmt.Fprintf(w, "username=%s\n", username)
+	}
+	fmt.Fprintf(w, "password=%s\n", password)
+	return w.Flush()
+}
+
+// store saves credentials git used successfully, updating the password of
+// an entry for the same URL and username or adding a new entry
+func (c *gitCredentialCommand) store(cred *gitCredential) error {
+	if cred.Host == "" || cred.Password == "" {
+		return nil
+	}
+	db, passphrase, err := c.open()
+	if err != nil {
+		return err
+	}
+	for _, record := range c.records(db) {
+		if cred.match(record, true) == 0 {
+			continue
+		}
+		if base, _ := db.Base(record); base != nil {
+			// the password belongs to the base entry
+			record = base
+		}
+		if record.Password() == cred.Password {
+			return nil
+		}
+		record.ChangePassword(cred.Password)
+		record.SetMtime(time.Now())
+		return c.save(db, passphrase)
+	}
+
+	record, err := v3.NewRecord()
+	if err != nil {
+		return err
+	}
+	record.SetTitle(cred.title())
+	record.SetGroup(parseGroupPath(c.Group))
+	record.SetUsername(cred.Username)
+	record.SetPassword(cred.Password)
+	record.SetPasswordMtime(time.Now())
+	record.SetURL(cred.url())
+	if err = db.AddRecord(record); err != nil {
+		return err
+	}
+	return c.save(db, passphrase)
+}
+
+// erase removes the entries holding credentials git found to be invalid.
+// Only entries with the rejected password are removed, so that an entry
+// updated meanwhile is kept, and protected entries are never removed.
+func (c *gitCredentialCommand) erase(cred *gitCredential) error {
+	if cred.Host == "" || cred.Password == "" {
+		return nil
+	}
+	db, passphrase, err := c.open()
+	if err != nil {
+		return err
+	}
+	removed := false
+	for _, record := range c.records(db) {
+		if cred.match(record, true) == 0 || record.Protected() ||
+			record.Password() != cred.Password ||
+			len(db.Dependents(record.UUID())) > 0 {
+			continue
+		}
+		db.RemoveRecord(record.UUID())
+		removed = true
+	}
+	if !removed {
+		return nil
+	}
+	return c.save(db, passphrase)
+}
+
+// records returns the entries in the credential group and its subgroups
+func (c *gitCredentialCommand) records(db *v3.Database) []*v3.Record {
+	group := pwsafe.SplitGroup(parseGroupPath(c.Group))
+	var records []*v3.Record
+	for _, record := range db.Records() {
+		if inGroup(record.Group(), group) {
+			records = append(records, record.(*v3.Record))
+		}
+	}
+	return records
+}
+
+// gitCredential holds the attributes of a credential in the git credential
+// protocol
+type gitCredential struct {
+	Protocol string
+	Host     string
+	Path     string
+	Username string
+	Password string
+}
+
+// readGitCredential reads "key=value" lines up to an empty line or the end
+// of the input. Unknown attributes are ignored.
+func readGitCredential(r io.Reader) (*gitCredential, error) {
+	var cred gitCredential
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid credential line %q", line)
+		}
+		key, value := line[:i], line[i+1:]
+		switch key {
+		case "protocol":
+			cred.Protocol = value
+		case "host":
+			cred.Host = value
+		case "path":
+			cred.Path = value
+		case "username":
+			cred.Username = value
+		case "password":
+			cred.Password = value
+		case "url":
+			u, err := url.Parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid credential url: %s", err)
+			}
+			cred.Protocol, cred.Host = u.Scheme, u.Host
+			cred.Path = strings.TrimPrefix(u.Path, "/")
+			if u.User != nil {
+				cred.Username = u.User.Username()
+				if password, ok := u.User.Password(); ok {
+					cred.Password = password
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &cred, nil
+}
+
+// match returns how well the URL field of the record matches the
+// credential, or 0 if it does not match. An entry without a scheme matches
+// every protocol and an entry without a path every repository on the host,
+// but entries that match more closely score higher. exact requires the same
+// protocol, path and username.
+func (cred *gitCredential) match(record *v3.Record, exact bool) int {
+	raw := record.URL()
+	if raw == "" {
+		return 0
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "//" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || !strings.EqualFold(u.Host, cred.Host) {
+		return 0
+	}
+
+	score := 1
+	switch {
+	case strings.EqualFold(u.Scheme, cred.Protocol):
+		score++
+	case u.Scheme != "" || exact:
+		return 0
+	}
+	path := trimRepoPath(u.Path)
+	switch {
+	case path != "" && path == trimRepoPath(cred.Path):
+		score += 2
+	case path != "" || exact && cred.Path != "":
+		return 0
+	}
+	switch {
+	case record.Username() == cred.Username:
+		score++
+	case cred.Username != "" || exact:
+		return 0
+	}
+	return score
+}
+
+// trimRepoPath removes the slashes and .git suffix git may or may not use
+// for the same repository
+func trimRepoPath(path string) string {
+	return strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+}
+
+// url returns the URL field for a new entry
+func (cred *gitCredential) url() string {
+	u := url.URL{Scheme: cred.Protocol, Host: cred.Host}
+	if cred.Path != "" {
+		u.Path = "/" + cred.Path
+	}
+	return u.String()
+}
+
+// title returns the title for a new entry; the repository path is only
+// kept in the URL field, as titles are selected by "/" separated paths
+func (cred *gitCredential) title() string {
+	if cred.Username != "" {
+		return cred.Username + "@" + cred.Host
+	}
+	return cred.Host
+}
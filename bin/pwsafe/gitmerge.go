@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/v3"
+)
+
+// gitMergeDriverName names the merge and diff drivers configured by
+// git-merge-driver -install
+const gitMergeDriverName = "pwsafe"
+
+// gitMergeDriverCommand is a git merge driver for databases. git runs it
+// with the common ancestor (%O), our version (%A) and their version (%B);
+// the merge is written to our version. Fields changed differently in both
+// are kept as a copy of their entry with the conflict suffix, and the merge
+// is reported as conflicted.
+type gitMergeDriverCommand struct {
+	commonParams
+	Strategy        string
+	Suffix          string
+	Install         bool
+	Global          bool
+	Pattern         string
+	OtherPassphrase passphraseParams
+}
+
+func (c *gitMergeDriverCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+	flagset.StringVar(&c.Strategy, "strategy", "keep-both", "resolves fields changed in both versions (prefer-newer, prefer-ours or keep-both); only keep-both reports conflicts to git")
+	flagset.StringVar(&c.Suffix, "suffix", pwsafe.DefaultConflictSuffix, "appended to the titles of their entries kept by -strategy keep-both")
+	flagset.BoolVar(&c.Install, "install", false, "if true, configures the merge and diff drivers in git and adds the -pattern to .gitattributes")
+	flagset.BoolVar(&c.Global, "global", false, "if true, -install configures the drivers for every repository of the user")
+	flagset.StringVar(&c.Pattern, "pattern", "*.psafe3", "pattern of the databases in .gitattributes for -install")
+	c.OtherPassphrase.AddFlags(flagset, "other-passphrase",
+		"passphrase of their version and the common ancestor (defaults to the passphrase of our version)")
+}
+
+func (c *gitMergeDriverCommand) Execute(args []string) (err error) {
+	if c.Install {
+		return c.install()
+	}
+	// ancestor ours theirs [path]
+	if len(args) != 3 && len(args) != 4 {
+		return fmt.Errorf("expected the common ancestor, our and their " +
+			"version (%%O %%A %%B) and optionally the path (%%P)")
+	}
+	name := args[1]
+	if len(args) == 4 {
+		name = args[3]
+	}
+	strategy, err := pwsafe.ParseMergeStrategy(c.Strategy)
+	if err != nil {
+		return err
+	}
+
+	var passphrase string
+	ours, err := v3.Open(args[1], makePassphraseFn("Passphrase of "+name+
+		": ", &c.Passphrase, c.Pinentry, &passphrase))
+	if err != nil {
+		return err
+	}
+	theirs, err := openOther(args[2], passphrase, &c.OtherPassphrase,
+		c.Pinentry)
+	if err != nil {
+		return err
+	}
+	// the ancestor is empty if the file was added in both branches
+	var base *v3.Database
+	if info, stat_err := os.Stat(args[0]); stat_err != nil ||
+		info.Size() > 0 {
+
+		if base, err = openOther(args[0], passphrase, &c.OtherPassphrase,
+			c.Pinentry); err != nil {
+			return err
+		}
+	}
+
+	merged, conflicts, err := v3.Merge(base, ours, theirs,
+		pwsafe.MergeOptions{
+			Strategy: strategy,
+			Suffix:   c.Suffix,
+		})
+	if err != nil {
+		return err
+	}
+	if err = merged.Save(args[1], passphrase); err != nil {
+		return err
+	}
+	for _, conflict := range conflicts {
+		fmt.Fprintf(os.Stderr, "%s: conflict: %s\n", name,
+			describeConflict(conflict))
+	}
+	if len(conflicts) > 0 && strategy == pwsafe.KeepBoth {
+		return exitStatus(1)
+	}
+	return nil
+}
+
+// install configures the merge driver and the diff textconv filter in git,
+// and assigns them to the pattern in the .gitattributes of the repository
+func (c *gitMergeDriverCommand) install() error {
+	config := func(key, value string) error {
+		args := []string{"config"}
+		if c.Global {
+			args = append(args, "--global")
+		}
+		cmd := exec.Command("git", append(args, key, value)...)
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("git config %s failed: %s", key, err)
+		}
+		return nil
+	}
+	prefix := "merge." + gitMergeDriverName
+	if err := config(prefix+".name", "password safe database"); err != nil {
+		return err
+	}
+	if err := config(prefix+".driver",
+		"pwsafe git-merge-driver %O %A %B %P"); err != nil {
+		return err
+	}
+	if err := config("diff."+gitMergeDriverName+".textconv",
+		"pwsafe diff -textconv"); err != nil {
+		return err
+	}
+
+	out, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		if c.Global {
+			fmt.Fprintf(os.Stderr, "not in a repository; add %q to the "+
+				".gitattributes of your repositories\n", c.attributes())
+			return nil
+		}
+		return fmt.Errorf("not in a git repository")
+	}
+	return c.addAttributes(filepath.Join(strings.TrimSpace(string(out)),
+		".gitattributes"))
+}
+
+// attributes returns the .gitattributes line assigning the drivers to the
+// pattern
+func (c *gitMergeDriverCommand) attributes() string {
+	return fmt.Sprintf("%s merge=%s diff=%s", c.Pattern, gitMergeDriverName,
+		gitMergeDriverName)
+}
+
+// addAttributes appends the attributes line to the file unless it is there
+func (c *gitMergeDriverCommand) addAttributes(path string) (err error) {
+	line := c.attributes()
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if close_err := f.Close(); err == nil {
+			err = close_err
+		}
+	}()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == line {
+			return nil
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > 0 && !endsWithNewline(f, info.Size()) {
+		line = "\n" + line
+	}
+	if _, err = f.WriteString(line + "\n"); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "added %q to %s\n", c.attributes(), path)
+	return nil
+}
+
+func endsWithNewline(f *os.File, size int64) bool {
+	last := make([]byte, 1)
+	_, err := f.ReadAt(last, size-1)
+	return err == nil && last[0] == '\n'
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/bitwarden"
+	"github.com/azdagron/pwsafe/kdbx"
+	"github.com/azdagron/pwsafe/onepux"
+	"github.com/azdagron/pwsafe/utils"
+	"github.com/azdagron/pwsafe/v3"
+)
+
+// importer converts the database at the path into a v3 database, returning
+// any items that could not be converted
+type importer func(path string, passphrase_fn v3.PassphraseFn) (
+	*v3.Database, []pwsafe.Unmapped, error)
+
+var importers = map[string]importer{
+	"kdbx":      openKDBX,
+	"bitwarden": bitwarden.Open,
+	"1pux":      onepux.Open,
+	"json":      openJSON,
+}
+
+func openKDBX(path string, passphrase_fn v3.PassphraseFn) (*v3.Database,
+	[]pwsafe.Unmapped, error) {
+
+	db, err := kdbx.Open(path, passphrase_fn)
+	return db, nil, err
+}
+
+func openJSON(path string, passphrase_fn v3.PassphraseFn) (*v3.Database,
+	[]pwsafe.Unmapped, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer utils.LogError(f.Close)
+
+	db, err := v3.ReadJSON(f)
+	return db, nil, err
+}
+
+type importCommand struct {
+	commonParams
+	Format           string
+	SourcePassphrase passphraseParams
+}
+
+func (c *importCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+	flagset.StringVar(&c.Format, "format", "", "format of the imported file ("+
+		importFormats()+")")
+	c.SourcePassphrase.AddFlags(flagset, "source-passphrase",
+		"passphrase of the imported file")
+}
+
+func (c *importCommand) Execute(args []string) (err error) {
+	if len(args) != 1 {
+		return fmt.Errorf("expected the path of the file to import")
+	}
+	imp := importers[c.Format]
+	if imp == nil {
+		return fmt.Errorf("unknown import format %q; expected one of %s",
+			c.Format, importFormats())
+	}
+
+	src, unmapped, err := imp(args[0], makePassphraseFn("Source passphrase: ",
+		&c.SourcePassphrase, c.Pinentry, nil))
+	if err != nil {
+		return err
+	}
+	for _, u := range unmapped {
+		fmt.Fprintf(os.Stderr, "not imported: %s: %s\n", u.Item, u.Reason)
+	}
+
+	if _, err := os.Stat(c.Path); os.IsNotExist(err) {
+		// importing into a new database keeps the imported header
+		passphrase, err := askNewPassphrase(&c.Passphrase, c.Pinentry)
+		if err != nil {
+			return err
+		}
+		if err = c.save(src, passphrase); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "imported %d records into %s\n",
+			len(src.Records()), c.Path)
+		return nil
+	}
+
+	db, passphrase, err := c.openOrCreate()
+	if err != nil {
+		return err
+	}
+	for _, record := range src.Records() {
+		if err = db.AddRecord(record.(*v3.Record)); err != nil {
+			return err
+		}
+	}
+	header := db.Header().(*v3.Header)
+	header.SetEmptyGroups(mergeGroups(header.EmptyGroups(),
+		src.Header().EmptyGroups()))
+
+	if err = c.save(db, passphrase); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "imported %d records into %s\n",
+		len(src.Records()), c.Path)
+	return nil
+}
+
+// mergeGroups returns the union of two lists of groups
+func mergeGroups(a, b []string) []string {
+	seen := make(map[string]bool)
+	var groups []string
+	for _, group := range append(a, b...) {
+		if !seen[group] {
+			seen[group] = true
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// importFormats returns the names of the import formats
+func importFormats() string {
+	var names []string
+	for name := range importers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
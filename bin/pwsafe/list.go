@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
@@ -8,38 +9,41 @@ import (
 	"strings"
 	"time"
 
-	"github.com/atotto/clipboard"
 	"github.com/azdagron/pwsafe/v3"
 )
 
 type listCommand struct {
 	commonParams
+	clipboardParams
 	Filter    string
 	Unmask    bool
 	Clipboard bool
+	Format    string
 }
 
 func (c *listCommand) ConfigureFlags(flagset *flag.FlagSet) {
 	c.commonParams.AddFlags(flagset)
 	flagset.StringVar(&c.Filter, "filter", "", "regex used to filter list entries by title or group")
 	flagset.BoolVar(&c.Unmask, "unmask", false, "if true, shows the passwords")
-	flagset.BoolVar(&c.Clipboard, "clipboard", false, "if true, copies password to clipboard")
+	flagset.BoolVar(&c.Clipboard, "clipboard", false, "if true, copies the password to the clipboard when exactly one entry matches")
+	c.clipboardParams.AddFlags(flagset)
+	flagset.StringVar(&c.Format, "format", "table", "output format (table, json, jsonl)")
 }
 
 func (c *listCommand) Execute(args []string) (err error) {
+	if c.Format != "table" && c.Format != "json" && c.Format != "jsonl" {
+		return fmt.Errorf("unknown format %q; expected table, json or jsonl",
+			c.Format)
+	}
+
 	masker := func(x string) string {
 		if c.Unmask {
 			return x
 		}
-		if c.Clipboard {
-			if err := clipboard.WriteAll(x); err != nil {
-				fmt.Fprintf(os.Stderr, "error copying to clipboard: %s", err)
-			}
-		}
 		return strings.Repeat("*", len(x))
 	}
 
-	db, err := v3.Open(c.Path, makePassphraseFn(c.Passphrase, nil))
+	db, _, err := c.open()
 	if err != nil {
 		return err
 	}
@@ -52,12 +56,33 @@ func (c *listCommand) Execute(args []string) (err error) {
 		}
 	}
 
+	var records []*v3.Record
 	for _, record := range db.Records() {
 		if re != nil &&
 			!re.MatchString(record.Group()) &&
 			!re.MatchString(record.Title()) {
+			db.RemoveRecord(record.UUID())
 			continue
 		}
+		records = append(records, record.(*v3.Record))
+	}
+
+	if c.Clipboard {
+		if len(records) == 1 {
+			if err = c.copySecret(records[0].Password()); err != nil {
+				return err
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "not copying to the clipboard: %d "+
+				"entries match\n", len(records))
+		}
+	}
+
+	if c.Format != "table" {
+		return c.printJSON(db, records)
+	}
+
+	for _, record := range records {
 		fmt.Println("[", record.UUID(), "]")
 		printFields([]fieldDescription{
 			{"Title", record.Title()},
@@ -75,6 +100,45 @@ func (c *listCommand) Execute(args []string) (err error) {
 	return nil
 }
 
+// printJSON prints the matching records using the lossless JSON schema,
+// either as a database document or as one record per line. Unless unmasked,
+// the secrets are masked and the output is marked so it cannot be imported.
+func (c *listCommand) printJSON(db *v3.Database,
+	records []*v3.Record) error {
+
+	enc := json.NewEncoder(os.Stdout)
+	if c.Format == "jsonl" {
+		for _, record := range records {
+			data, err := c.marshalJSON(record)
+			if err != nil {
+				return err
+			}
+			if err = enc.Encode(json.RawMessage(data)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	data, err := c.marshalJSON(db)
+	if err != nil {
+		return err
+	}
+	enc.SetIndent("", "  ")
+	return enc.Encode(json.RawMessage(data))
+}
+
+type maskedMarshaler interface {
+	json.Marshaler
+	MarshalMaskedJSON() ([]byte, error)
+}
+
+func (c *listCommand) marshalJSON(value maskedMarshaler) ([]byte, error) {
+	if c.Unmask {
+		return value.MarshalJSON()
+	}
+	return value.MarshalMaskedJSON()
+}
+
 type fieldDescription struct {
 	name  string
 	value interface{}
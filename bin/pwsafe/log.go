@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/azdagron/pwsafe/v3"
+)
+
+type logCommand struct {
+	commonParams
+	Enable    bool
+	Disable   bool
+	Operation string
+	User      string
+	Host      string
+	Since     string
+	Until     string
+	Format    string
+	Verify    bool
+}
+
+func (c *logCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+	flagset.BoolVar(&c.Enable, "enable", false, "if true, starts recording the changes to the database in its audit log")
+	flagset.BoolVar(&c.Disable, "disable", false, "if true, stops recording changes and removes the audit log")
+	flagset.StringVar(&c.Operation, "op", "", "shows only entries of the operation (enable, add, modify, remove, header, save or merge)")
+	flagset.StringVar(&c.User, "user", "", "shows only entries of the user")
+	flagset.StringVar(&c.Host, "host", "", "shows only entries of the host")
+	flagset.StringVar(&c.Since, "since", "", "shows only entries at or after the date (YYYY-MM-DD) or RFC 3339 time")
+	flagset.StringVar(&c.Until, "until", "", "shows only entries up to the date (YYYY-MM-DD, inclusive) or RFC 3339 time")
+	flagset.StringVar(&c.Format, "format", "text", "output format (text, json)")
+	flagset.BoolVar(&c.Verify, "verify", false, "if true, only checks the hash chain of the audit log and exits with status 1 if it is broken")
+}
+
+func (c *logCommand) Execute(args []string) (err error) {
+	if c.Enable && c.Disable {
+		return fmt.Errorf("-enable and -disable are exclusive")
+	}
+	if c.Format != "text" && c.Format != "json" {
+		return fmt.Errorf("unknown format %q; expected text or json",
+			c.Format)
+	}
+	since, err := parseLogTime(c.Since, false)
+	if err != nil {
+		return fmt.Errorf("invalid -since: %s", err)
+	}
+	until, err := parseLogTime(c.Until, true)
+	if err != nil {
+		return fmt.Errorf("invalid -until: %s", err)
+	}
+
+	db, passphrase, err := c.open()
+	if err != nil {
+		return err
+	}
+	switch {
+	case c.Enable:
+		if db.AuditLogEnabled() {
+			return fmt.Errorf("the audit log is already enabled")
+		}
+		if err = db.EnableAuditLog(); err != nil {
+			return err
+		}
+		return c.save(db, passphrase)
+	case c.Disable:
+		if !db.AuditLogEnabled() {
+			return fmt.Errorf("the audit log is not enabled")
+		}
+		if !confirm("Remove the audit log?") {
+			return nil
+		}
+		db.DisableAuditLog()
+		return c.save(db, passphrase)
+	}
+
+	if !db.AuditLogEnabled() {
+		return fmt.Errorf("the audit log is not enabled; enable it with " +
+			"-enable")
+	}
+	entries, err := db.AuditLog()
+	if err != nil {
+		return err
+	}
+	verify_err := v3.VerifyAuditLog(entries)
+	if c.Verify {
+		if verify_err != nil {
+			fmt.Fprintln(os.Stderr, verify_err)
+			return exitStatus(1)
+		}
+		fmt.Printf("%d entries verified; last hash %s\n", len(entries),
+			lastHash(entries))
+		return nil
+	}
+	if verify_err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", verify_err)
+	}
+
+	uuids, err := c.selectUUIDs(db, entries, args)
+	if err != nil {
+		return err
+	}
+	var selected []v3.AuditEntry
+	for _, entry := range entries {
+		switch {
+		case c.Operation != "" && entry.Operation != c.Operation,
+			c.User != "" && entry.User != c.User,
+			c.Host != "" && entry.Host != c.Host,
+			!since.IsZero() && entry.Time.Before(since),
+			!until.IsZero() && !entry.Time.Before(until),
+			uuids != nil && !uuids[entry.UUID]:
+			continue
+		}
+		selected = append(selected, entry)
+	}
+
+	if c.Format == "json" {
+		return printLogJSON(os.Stdout, db, selected)
+	}
+	printLogText(os.Stdout, db, selected)
+	return nil
+}
+
+// selectUUIDs returns the UUIDs of the records selected by the arguments, or
+// nil if there are none. A UUID in the log selects its record even if it was
+// removed.
+func (c *logCommand) selectUUIDs(db *v3.Database, entries []v3.AuditEntry,
+	args []string) (map[string]bool, error) {
+
+	if len(args) == 0 {
+		return nil, nil
+	}
+	selector := strings.ToLower(strings.TrimSpace(strings.Join(args, " ")))
+	for _, entry := range entries {
+		if entry.UUID != "" && entry.UUID == selector {
+			return map[string]bool{selector: true}, nil
+		}
+	}
+	records, err := selectRecords(db, args)
+	if err != nil {
+		return nil, err
+	}
+	uuids := make(map[string]bool)
+	for _, record := range records {
+		uuids[record.UUID()] = true
+	}
+	return uuids, nil
+}
+
+// parseLogTime parses a date or RFC 3339 time. A date given as the end of a
+// range includes the whole day.
+func parseLogTime(s string, end bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err == nil && end {
+		t = t.Add(time.Nanosecond)
+	}
+	return t, err
+}
+
+func lastHash(entries []v3.AuditEntry) string {
+	if len(entries) == 0 {
+		return ""
+	}
+	return hex.EncodeToString(entries[len(entries)-1].Hash)
+}
+
+// logTarget returns the title path of the record an entry changed, or its
+// UUID if the record is no longer in the database
+func logTarget(db *v3.Database, entry v3.AuditEntry) string {
+	if entry.UUID == "" {
+		return "-"
+	}
+	if record := db.Record(entry.UUID); record != nil {
+		return titlePath(record)
+	}
+	return entry.UUID
+}
+
+func printLogText(w io.Writer, db *v3.Database, entries []v3.AuditEntry) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, entry := range entries {
+		fields := strings.Join(entry.FieldNames(), ",")
+		if fields == "" {
+			fields = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s@%s\n",
+			entry.Time.Local().Format("2006-01-02 15:04:05"), entry.Operation,
+			logTarget(db, entry), fields, entry.User, entry.Host)
+	}
+	tw.Flush()
+}
+
+type logEntryJSON struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"op"`
+	UUID      string    `json:"uuid,omitempty"`
+	Title     string    `json:"title,omitempty"`
+	Fields    []string  `json:"fields"`
+	User      string    `json:"user"`
+	Host      string    `json:"host"`
+	Hash      string    `json:"hash"`
+}
+
+// printLogJSON prints the entries as a JSON array. The title path is set if
+// the record is still in the database.
+func printLogJSON(w io.Writer, db *v3.Database,
+	entries []v3.AuditEntry) error {
+
+	out := []logEntryJSON{}
+	for _, entry := range entries {
+		item := logEntryJSON{
+			Time:      entry.Time,
+			Operation: entry.Operation,
+			UUID:      entry.UUID,
+			Fields:    entry.FieldNames(),
+			User:      entry.User,
+			Host:      entry.Host,
+			Hash:      hex.EncodeToString(entry.Hash),
+		}
+		if record := db.Record(entry.UUID); entry.UUID != "" &&
+			record != nil {
+			item.Title = titlePath(record)
+		}
+		out = append(out, item)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
@@ -9,6 +9,9 @@ import (
 
 func main() {
 	err := maine()
+	if status, ok := err.(exitStatus); ok {
+		os.Exit(int(status))
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
@@ -17,12 +20,44 @@ func main() {
 
 func maine() (err error) {
 	commands := map[string]command{
-		"list": &listCommand{},
+		"list":   &listCommand{},
+		"import": &importCommand{},
+		"export": &exportCommand{},
+		"find":   &findCommand{},
+		"search": &searchCommand{},
+		"add":    &addCommand{},
+		"edit":   &editCommand{},
+		"rm":     &rmCommand{},
+		"mv":     &mvCommand{},
+		"merge":  &mergeCommand{},
+		"diff":   &diffCommand{},
+		"log":    &logCommand{},
+		"audit":  &auditCommand{},
+		"show":   &showCommand{},
+		"get":    &getCommand{},
+		"shell":  &shellCommand{},
+		"tui":    &tuiCommand{},
+		"agent":  &agentCommand{},
+		"config": &configCommand{},
+
+		"git-credential":   &gitCredentialCommand{},
+		"git-merge-driver": &gitMergeDriverCommand{},
+		"ssh-agent":        &sshAgentCommand{},
+		"exec":             &execCommand{},
+		"render":           &renderCommand{},
+		"serve":            &serveCommand{},
+		"mount":            &mountCommand{},
+		"secret-service":   &secretServiceCommand{},
+
+		clearClipboardName: &clearClipboardCommand{},
 	}
 
 	var cmdname string
-	if len(os.Args) > 1 {
-		cmdname = os.Args[1]
+	args := os.Args[1:]
+	if path.Base(os.Args[0]) == gitCredentialName {
+		cmdname = "git-credential"
+	} else if len(args) > 0 {
+		cmdname, args = args[0], args[1:]
 	} else {
 		usage("missing command")
 	}
@@ -35,7 +70,10 @@ func maine() (err error) {
 
 	flagset := flag.NewFlagSet(cmdname, flag.ExitOnError)
 	cmd.ConfigureFlags(flagset)
-	if err = flagset.Parse(os.Args[2:]); err != nil {
+	if err = flagset.Parse(args); err != nil {
+		return err
+	}
+	if err = applySettings(cmdname, flagset); err != nil {
 		return err
 	}
 
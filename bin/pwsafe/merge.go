@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/v3"
+)
+
+type mergeCommand struct {
+	commonParams
+	Base            string
+	Strategy        string
+	Suffix          string
+	Out             string
+	DryRun          bool
+	OtherPassphrase passphraseParams
+}
+
+func (c *mergeCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+	flagset.StringVar(&c.Base, "base", "", "path of the version both databases were changed from; without it, entries missing from one database are kept")
+	flagset.StringVar(&c.Strategy, "strategy", "prefer-newer", "resolves fields changed in both databases (prefer-newer, prefer-ours or keep-both)")
+	flagset.StringVar(&c.Suffix, "suffix", pwsafe.DefaultConflictSuffix, "appended to the titles of their entries kept by -strategy keep-both")
+	flagset.StringVar(&c.Out, "out", "", "path to save the merged database to instead of the database")
+	flagset.BoolVar(&c.DryRun, "dry-run", false, "if true, only prints the conflicts")
+	c.OtherPassphrase.AddFlags(flagset, "other-passphrase",
+		"passphrase of the merged and base databases (defaults to the database passphrase)")
+}
+
+func (c *mergeCommand) Execute(args []string) (err error) {
+	if len(args) != 1 {
+		return fmt.Errorf("expected the path of the database to merge")
+	}
+	strategy, err := pwsafe.ParseMergeStrategy(c.Strategy)
+	if err != nil {
+		return err
+	}
+
+	db, passphrase, err := c.open()
+	if err != nil {
+		return err
+	}
+	theirs, err := openOther(args[0], passphrase, &c.OtherPassphrase,
+		c.Pinentry)
+	if err != nil {
+		return err
+	}
+	var base *v3.Database
+	if c.Base != "" {
+		if base, err = openOther(c.Base, passphrase,
+			&c.OtherPassphrase, c.Pinentry); err != nil {
+			return err
+		}
+	}
+
+	merged, conflicts, err := v3.Merge(base, db, theirs, pwsafe.MergeOptions{
+		Strategy: strategy,
+		Suffix:   c.Suffix,
+	})
+	if err != nil {
+		return err
+	}
+	for _, conflict := range conflicts {
+		fmt.Fprintf(os.Stderr, "conflict: %s\n", describeConflict(conflict))
+	}
+	if c.DryRun {
+		return nil
+	}
+
+	if c.Out != "" {
+		if passphrase == "" {
+			// the agent keeps the passphrase to itself
+			passphrase, err = askNewPassphrase(&c.OtherPassphrase,
+				c.Pinentry)
+			if err != nil {
+				return err
+			}
+		}
+		if c.Iterations != 0 {
+			if err = merged.SetIterations(c.Iterations); err != nil {
+				return err
+			}
+		}
+		return merged.Save(c.Out, passphrase)
+	}
+	return c.save(merged, passphrase)
+}
+
+// describeConflict returns a one line description of a merge conflict
+func describeConflict(conflict pwsafe.Conflict) string {
+	what := "header"
+	if conflict.UUID != "" {
+		what = joinTitlePath(conflict.Group, conflict.Title)
+	}
+	if conflict.Field != "" {
+		what += ": " + conflict.Field
+	}
+
+	switch {
+	case conflict.Removed != 0 && conflict.Resolution == conflict.Removed:
+		return fmt.Sprintf("%s: changed in %s, removed in %s; removed", what,
+			otherSide(conflict.Removed), conflict.Removed)
+	case conflict.Removed != 0:
+		return fmt.Sprintf("%s: changed in %s, removed in %s; kept", what,
+			otherSide(conflict.Removed), conflict.Removed)
+	case conflict.Resolution == pwsafe.Both:
+		return fmt.Sprintf("%s: conflicting changes; kept both, theirs "+
+			"as %s", what, conflict.Copy)
+	}
+	return fmt.Sprintf("%s: conflicting changes; kept %s", what,
+		conflict.Resolution)
+}
+
+func otherSide(side pwsafe.MergeSide) pwsafe.MergeSide {
+	if side == pwsafe.Ours {
+		return pwsafe.Theirs
+	}
+	return pwsafe.Ours
+}
@@ -0,0 +1,88 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/azdagron/pwsafe/fusefs"
+	"github.com/azdagron/pwsafe/v3"
+)
+
+type mountCommand struct {
+	commonParams
+	Write      bool
+	Atime      bool
+	UID        int
+	AllowOther bool
+	Debug      bool
+}
+
+func (c *mountCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+	flagset.BoolVar(&c.Write, "write", false, "if true, writing a file changes the entry and saves the database")
+	flagset.BoolVar(&c.Atime, "atime", false, "if true, reading a file sets the access time of the entry (saved with the next change or on unmount with -write, without rotating backups)")
+	flagset.IntVar(&c.UID, "uid", os.Getuid(), "user allowed to access the files")
+	flagset.BoolVar(&c.AllowOther, "allow-other", false, "if true, lets the kernel pass requests of other users, needed when -uid is not the user mounting")
+	flagset.BoolVar(&c.Debug, "debug", false, "if true, logs the FUSE requests")
+}
+
+func (c *mountCommand) Execute(args []string) (err error) {
+	if len(args) != 1 {
+		return fmt.Errorf("expected a mountpoint")
+	}
+	mountpoint := args[0]
+
+	db, passphrase, err := c.open()
+	if err != nil {
+		return err
+	}
+	opts := fusefs.Options{
+		UID:         uint32(c.UID),
+		GID:         uint32(os.Getgid()),
+		UpdateAtime: c.Atime,
+		AllowOther:  c.AllowOther,
+		Debug:       c.Debug,
+	}
+	if c.Write {
+		opts.Save = func(db *v3.Database) error {
+			err := c.save(db, passphrase)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "unable to save: %s\n", err)
+			}
+			return err
+		}
+		opts.SaveAccess = func(db *v3.Database) error {
+			err := c.saveWithBackups(db, passphrase, 0)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "unable to save access times: %s\n",
+					err)
+			}
+			return err
+		}
+	}
+	fsys, err := fusefs.Mount(mountpoint, db, opts)
+	if err != nil {
+		db.Wipe()
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "mounted %s on %s; interrupt or unmount to "+
+		"stop\n", c.Path, mountpoint)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			if err := fsys.Unmount(); err != nil {
+				fmt.Fprintf(os.Stderr, "unable to unmount: %s\n", err)
+			}
+		}
+	}()
+	fsys.Wait()
+	return nil
+}
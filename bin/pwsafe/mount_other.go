@@ -0,0 +1,21 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+type mountCommand struct {
+	commonParams
+}
+
+func (c *mountCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+}
+
+func (c *mountCommand) Execute(args []string) error {
+	return fmt.Errorf("mount is not supported on this platform")
+}
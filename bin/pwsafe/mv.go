@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/azdagron/pwsafe/v3"
+)
+
+type mvCommand struct {
+	commonParams
+	Force bool
+}
+
+func (c *mvCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+	flagset.BoolVar(&c.Force, "force", false, "if true, moves protected entries")
+}
+
+func (c *mvCommand) Execute(args []string) (err error) {
+	if len(args) < 2 {
+		return fmt.Errorf("expected the entries to move and the group")
+	}
+	group := parseGroupPath(args[len(args)-1])
+
+	db, passphrase, err := c.open()
+	if err != nil {
+		return err
+	}
+	records, err := selectRecords(db, args[:len(args)-1])
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if record.Protected() && !c.Force {
+			return fmt.Errorf("%s is protected; use -force to move it",
+				titlePath(record))
+		}
+	}
+
+	now := time.Now()
+	for _, record := range records {
+		record.SetGroup(group)
+		record.SetMtime(now)
+	}
+
+	// the group is no longer empty
+	header := db.Header().(*v3.Header)
+	var empty []string
+	for _, g := range header.EmptyGroups() {
+		if g != group {
+			empty = append(empty, g)
+		}
+	}
+	header.SetEmptyGroups(empty)
+
+	return c.save(db, passphrase)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/bgentry/speakeasy"
+)
+
+// pinentryEnv names the environment variable holding the default pinentry
+// program
+const pinentryEnv = "PWSAFE_PINENTRY"
+
+// passphraseParams are the flags selecting where a passphrase comes from.
+// At most one source may be given; without one the passphrase is asked for.
+type passphraseParams struct {
+	Value string
+	File  string
+	Fd    int
+	Env   string
+	Cmd   string
+
+	name string
+
+	// once_value holds the passphrase read from the descriptor or the
+	// environment variable, which can only be read once
+	once_value *string
+}
+
+// AddFlags adds the -<name>, -<name>-file, -<name>-fd, -<name>-env and
+// -<name>-cmd flags
+func (p *passphraseParams) AddFlags(flagset *flag.FlagSet, name,
+	what string) {
+
+	p.name = name
+	flagset.StringVar(&p.Value, name, "", what+" (deprecated: visible to "+
+		"other users; use one of the other -"+name+" flags)")
+	flagset.StringVar(&p.File, name+"-file", "",
+		"file containing the "+what)
+	flagset.IntVar(&p.Fd, name+"-fd", -1,
+		"file descriptor to read the "+what+" from")
+	flagset.StringVar(&p.Env, name+"-env", "",
+		"environment variable holding the "+what)
+	flagset.StringVar(&p.Cmd, name+"-cmd", "",
+		"command printing the "+what)
+}
+
+// configured returns true if a passphrase source was given
+func (p *passphraseParams) configured() bool {
+	return p != nil && (p.Value != "" || p.File != "" || p.Fd >= 0 ||
+		p.Env != "" || p.Cmd != "")
+}
+
+// read returns the passphrase from the configured source. ok is false if no
+// source was given.
+func (p *passphraseParams) read() (passphrase string, ok bool, err error) {
+	if !p.configured() {
+		return "", false, nil
+	}
+	sources := 0
+	for _, given := range []bool{p.Value != "", p.File != "", p.Fd >= 0,
+		p.Env != "", p.Cmd != ""} {
+		if given {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return "", false, fmt.Errorf("only one -%s source may be given",
+			p.name)
+	}
+
+	switch {
+	case p.Value != "":
+		fmt.Fprintf(os.Stderr, "warning: -%s is visible to other users; "+
+			"use -%s-file, -%s-fd, -%s-env or -%s-cmd\n", p.name, p.name,
+			p.name, p.name, p.name)
+		passphrase = p.Value
+	case p.File != "":
+		passphrase, err = readPassphraseFile(p.File)
+	case p.once_value != nil:
+		passphrase = *p.once_value
+	case p.Fd >= 0:
+		f := os.NewFile(uintptr(p.Fd), fmt.Sprintf("fd %d", p.Fd))
+		if f == nil {
+			return "", false, fmt.Errorf("invalid -%s-fd %d", p.name,
+				p.Fd)
+		}
+		data, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return "", false, err
+		}
+		passphrase = trimNewline(string(data))
+		p.once_value = &passphrase
+	case p.Env != "":
+		passphrase = os.Getenv(p.Env)
+		if passphrase == "" {
+			return "", false, fmt.Errorf("environment variable %s is not "+
+				"set", p.Env)
+		}
+		// keep it from child processes like the editor
+		os.Unsetenv(p.Env)
+		p.once_value = &passphrase
+	case p.Cmd != "":
+		passphrase, err = runPassphraseCmd(p.Cmd)
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return passphrase, true, nil
+}
+
+// readPassphraseFile returns the first line of the file
+func readPassphraseFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		fmt.Fprintf(os.Stderr, "warning: %s is accessible by other users\n",
+			path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return trimNewline(string(data)), nil
+}
+
+// runPassphraseCmd runs the command with the shell and returns its output.
+// The command can use the terminal, for example to ask for a gpg passphrase.
+func runPassphraseCmd(command string) (string, error) {
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("passphrase command failed: %s", err)
+	}
+	return trimNewline(string(out)), nil
+}
+
+// trimNewline removes a single trailing line ending
+func trimNewline(s string) string {
+	s = strings.TrimSuffix(s, "\n")
+	return strings.TrimSuffix(s, "\r")
+}
+
+// askPassphrase asks for a passphrase with the pinentry program, or on the
+// terminal if there is none
+func askPassphrase(prompt, pinentry string) (string, error) {
+	if pinentry != "" {
+		return askPinentry(pinentry, prompt, "")
+	}
+	return speakeasy.Ask(prompt)
+}
+
+func makePassphraseFn(prompt string, source *passphraseParams,
+	pinentry string, out *string) func() (string, error) {
+
+	return func() (val string, err error) {
+		val, ok, err := source.read()
+		if err == nil && !ok {
+			val, err = askPassphrase(prompt, pinentry)
+		}
+		if out != nil {
+			*out = val
+		}
+		return val, err
+	}
+}
+
+// askNewPassphrase prompts for a passphrase for a new database, asking for
+// confirmation, unless the source provides one.
+func askNewPassphrase(source *passphraseParams, pinentry string) (string,
+	error) {
+
+	if val, ok, err := source.read(); err != nil || ok {
+		return val, err
+	}
+	val, err := askPassphrase("New passphrase: ", pinentry)
+	if err != nil {
+		return "", err
+	}
+	confirm, err := askPassphrase("Confirm passphrase: ", pinentry)
+	if err != nil {
+		return "", err
+	}
+	if val != confirm {
+		return "", errors.New("passphrases do not match")
+	}
+	return val, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// checkPeerCredentials returns an error if the peer of a Unix socket
+// connection cannot be identified on this platform
+func checkPeerCredentials() error {
+	return nil
+}
+
+// peerUID returns the user id of the process on the other end of a Unix
+// socket connection
+func peerUID(conn net.Conn) (int, error) {
+	unix_conn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0, errors.New("not a unix socket connection")
+	}
+	raw, err := unix_conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var cred *unix.Xucred
+	var cred_err error
+	err = raw.Control(func(fd uintptr) {
+		cred, cred_err = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL,
+			unix.LOCAL_PEERCRED)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if cred_err != nil {
+		return 0, cred_err
+	}
+	return int(cred.Uid), nil
+}
@@ -0,0 +1,24 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+var errNoPeerCredentials = errors.New("the agent is not supported on this " +
+	"platform")
+
+// checkPeerCredentials returns an error if the peer of a Unix socket
+// connection cannot be identified on this platform
+func checkPeerCredentials() error {
+	return errNoPeerCredentials
+}
+
+// peerUID returns the user id of the process on the other end of a Unix
+// socket connection
+func peerUID(conn net.Conn) (int, error) {
+	return 0, errNoPeerCredentials
+}
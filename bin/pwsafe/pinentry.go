@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// askPinentry asks for a passphrase with a pinentry program, which speaks
+// the Assuan protocol on its standard input and output. An empty
+// description shows a generic one.
+func askPinentry(program, prompt, description string) (pin string,
+	err error) {
+
+	cmd := exec.Command(program)
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err = cmd.Start(); err != nil {
+		return "", fmt.Errorf("unable to run pinentry: %s", err)
+	}
+	defer func() {
+		in.Close()
+		cmd.Wait()
+	}()
+
+	p := &pinentryConn{in: in, out: bufio.NewReader(out)}
+	if _, err = p.response(); err != nil {
+		return "", err
+	}
+
+	if description == "" {
+		description = "Enter the passphrase of the password safe"
+	}
+	commands := []string{
+		"SETTITLE pwsafe",
+		"SETDESC " + assuanEscape(description),
+		"SETPROMPT " + assuanEscape(strings.TrimSpace(prompt)),
+	}
+	// curses pinentry programs need the terminal
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		if tty, err := os.Readlink("/proc/self/fd/0"); err == nil {
+			commands = append(commands, "OPTION ttyname="+tty)
+		}
+		if t := os.Getenv("TERM"); t != "" {
+			commands = append(commands, "OPTION ttytype="+t)
+		}
+	}
+	for _, command := range commands {
+		if err = p.send(command); err != nil {
+			return "", err
+		}
+		if _, err = p.response(); err != nil && !strings.HasPrefix(command,
+			"OPTION") {
+			return "", err
+		}
+	}
+
+	if err = p.send("GETPIN"); err != nil {
+		return "", err
+	}
+	if pin, err = p.response(); err != nil {
+		return "", err
+	}
+	p.send("BYE")
+	return pin, nil
+}
+
+// pinentryConn is the client side of an Assuan connection
+type pinentryConn struct {
+	in  io.Writer
+	out *bufio.Reader
+}
+
+func (p *pinentryConn) send(command string) error {
+	_, err := io.WriteString(p.in, command+"\n")
+	return err
+}
+
+// response reads lines up to the final OK or ERR and returns the data of
+// any D lines
+func (p *pinentryConn) response() (data string, err error) {
+	for {
+		line, err := p.out.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("pinentry: %s", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "OK" || strings.HasPrefix(line, "OK "):
+			return data, nil
+		case strings.HasPrefix(line, "ERR "):
+			// ERR <code> <description>
+			fields := strings.SplitN(line, " ", 3)
+			if len(fields) == 3 {
+				return "", fmt.Errorf("pinentry: %s", fields[2])
+			}
+			return "", fmt.Errorf("pinentry: %s", line)
+		case strings.HasPrefix(line, "D "):
+			data += assuanUnescape(line[2:])
+		case strings.HasPrefix(line, "INQUIRE"):
+			if err = p.send("CAN"); err != nil {
+				return "", err
+			}
+		}
+		// status lines and comments are ignored
+	}
+}
+
+// assuanEscape percent-encodes the characters that would end an Assuan line
+func assuanEscape(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").
+		Replace(s)
+}
+
+// assuanUnescape decodes %XX escapes
+func assuanUnescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/utils"
+	"github.com/azdagron/pwsafe/v3"
+)
+
+type renderCommand struct {
+	commonParams
+	In  string
+	Out string
+}
+
+func (c *renderCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+	flagset.StringVar(&c.In, "in", "", "template to render")
+	flagset.StringVar(&c.Out, "out", "", "file to write, only readable by the owner; prints the output if empty")
+}
+
+func (c *renderCommand) Execute(args []string) (err error) {
+	if c.In == "" {
+		return fmt.Errorf("expected a template; use -in")
+	}
+	text, err := ioutil.ReadFile(c.In)
+	if err != nil {
+		return err
+	}
+
+	db, _, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer db.Wipe()
+
+	r := &renderer{db: db}
+	tmpl, err := template.New(filepath.Base(c.In)).
+		Option("missingkey=error").
+		Funcs(r.funcs()).
+		Parse(string(text))
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err = tmpl.Execute(&out, nil); err != nil {
+		return err
+	}
+	if len(r.problems) > 0 {
+		return fmt.Errorf("unable to render %s:\n  %s", c.In,
+			strings.Join(r.problems, "\n  "))
+	}
+
+	if c.Out == "" {
+		_, err = os.Stdout.Write(out.Bytes())
+		return err
+	}
+	return utils.WriteFileAtomicMode(c.Out, 0600, func(w io.Writer) error {
+		_, err := w.Write(out.Bytes())
+		return err
+	})
+}
+
+// renderer provides the template functions. Unresolved references are
+// collected, so that all of them are reported at once and nothing is
+// written.
+type renderer struct {
+	db       *v3.Database
+	problems []string
+}
+
+func (r *renderer) funcs() template.FuncMap {
+	return template.FuncMap{
+		"pwsafe":      r.field,
+		"pwsafeNotes": r.notes,
+		"generate":    r.generate,
+	}
+}
+
+// field returns a field of the entry, the password if no field is given
+func (r *renderer) field(entry string, field ...string) (string, error) {
+	if len(field) > 1 {
+		return "", fmt.Errorf("expected an entry and at most one field")
+	}
+	name := "password"
+	if len(field) == 1 {
+		name = field[0]
+	}
+	record, err := selectRecord(r.db, []string{entry})
+	if err == nil {
+		var value string
+		if value, err = getField(r.db, record, name); err == nil {
+			return value, nil
+		}
+	}
+	r.problems = append(r.problems, fmt.Sprintf("pwsafe %q %q: %s", entry,
+		name, err))
+	return "", nil
+}
+
+// notes returns the notes of the entry
+func (r *renderer) notes(entry string) (string, error) {
+	return r.field(entry, "notes")
+}
+
+// generate returns a new password following the named password policy of
+// the database, or the default policy if the name is empty
+func (r *renderer) generate(name ...string) (string, error) {
+	if len(name) > 1 {
+		return "", fmt.Errorf("expected at most one policy name")
+	}
+	policy := pwsafe.DefaultPasswordPolicy
+	if len(name) == 1 && name[0] != "" {
+		found := false
+		header := r.db.Header().(*v3.Header)
+		for _, named := range header.PasswordPolicies() {
+			if named.Name == name[0] {
+				policy, found = named, true
+				break
+			}
+		}
+		if !found {
+			r.problems = append(r.problems, fmt.Sprintf("generate %q: no "+
+				"such password policy", name[0]))
+			return "", nil
+		}
+	}
+	return policy.Generate()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/azdagron/pwsafe/v3"
+)
+
+type rmCommand struct {
+	commonParams
+	Yes   bool
+	Force bool
+}
+
+func (c *rmCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+	flagset.BoolVar(&c.Yes, "yes", false, "if true, removes without asking for confirmation")
+	flagset.BoolVar(&c.Force, "force", false, "if true, removes protected entries and entries with aliases or shortcuts")
+}
+
+func (c *rmCommand) Execute(args []string) (err error) {
+	db, passphrase, err := c.open()
+	if err != nil {
+		return err
+	}
+	records, err := selectRecords(db, args)
+	if err != nil {
+		return err
+	}
+
+	removed := make(map[string]bool)
+	for _, record := range records {
+		removed[record.UUID()] = true
+	}
+	for _, record := range records {
+		if record.Protected() && !c.Force {
+			return fmt.Errorf("%s is protected; use -force to remove it",
+				titlePath(record))
+		}
+		var dependents []*v3.Record
+		for _, dependent := range db.Dependents(record.UUID()) {
+			if !removed[dependent.UUID()] {
+				dependents = append(dependents, dependent)
+			}
+		}
+		if len(dependents) > 0 && !c.Force {
+			printRecords(os.Stderr, dependents)
+			return fmt.Errorf("%s is used by %d aliases or shortcuts; use "+
+				"-force to remove it", titlePath(record), len(dependents))
+		}
+	}
+
+	printRecords(os.Stderr, records)
+	if !c.Yes && !confirm(fmt.Sprintf("Remove %d entries?", len(records))) {
+		return nil
+	}
+	for _, record := range records {
+		db.RemoveRecord(record.UUID())
+	}
+	return c.save(db, passphrase)
+}
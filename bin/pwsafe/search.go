@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/v3"
+)
+
+type searchCommand struct {
+	commonParams
+	clipboardParams
+	Limit int
+	Pick  bool
+}
+
+func (c *searchCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+	flagset.IntVar(&c.Limit, "limit", 10, "maximum number of results; 0 shows all results")
+	flagset.BoolVar(&c.Pick, "pick", false, "if true, choose one of the results and copy its password to the clipboard")
+	c.clipboardParams.AddFlags(flagset)
+}
+
+func (c *searchCommand) Execute(args []string) (err error) {
+	if len(args) == 0 {
+		return fmt.Errorf("expected search terms")
+	}
+
+	db, _, err := c.open()
+	if err != nil {
+		return err
+	}
+
+	results := pwsafe.Search(db.Records(), strings.Join(args, " "))
+	if c.Limit > 0 && len(results) > c.Limit {
+		results = results[:c.Limit]
+	}
+	if len(results) == 0 {
+		return fmt.Errorf("no records match")
+	}
+
+	if !c.Pick {
+		printResults(os.Stdout, results, false)
+		return nil
+	}
+
+	record, err := pickResult(results)
+	if err != nil {
+		return err
+	}
+	password, err := getField(db, record.(*v3.Record), "password")
+	if err != nil {
+		return err
+	}
+	if err = c.copySecret(password); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "copied password of %s to the clipboard\n",
+		record.Title())
+	return nil
+}
+
+// printResults prints the search results, optionally numbered for picking
+func printResults(out *os.File, results []pwsafe.SearchResult,
+	numbered bool) {
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	for i, result := range results {
+		r := result.Record
+		group := groupPath(r.Group())
+		if numbered {
+			fmt.Fprintf(w, "%d)\t", i+1)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", group, r.Title(), r.Username(),
+			r.URL())
+	}
+	w.Flush()
+}
+
+// pickResult asks the user to choose one of the results. A single result is
+// chosen without asking.
+func pickResult(results []pwsafe.SearchResult) (pwsafe.Record, error) {
+	if len(results) == 1 {
+		return results[0].Record, nil
+	}
+	printResults(os.Stderr, results, true)
+
+	for {
+		line, err := prompt(fmt.Sprintf("Select [1-%d]", len(results)), "")
+		if err != nil {
+			return nil, fmt.Errorf("no record selected")
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || line == "q" {
+			return nil, fmt.Errorf("no record selected")
+		}
+		n, err := strconv.Atoi(line)
+		if err == nil && n >= 1 && n <= len(results) {
+			return results[n-1].Record, nil
+		}
+	}
+}
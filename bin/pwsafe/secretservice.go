@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/azdagron/pwsafe/secretservice"
+	"github.com/azdagron/pwsafe/v3"
+	"github.com/godbus/dbus/v5"
+)
+
+type secretServiceCommand struct {
+	commonParams
+	Group   string
+	Replace bool
+	Locked  bool
+}
+
+func (c *secretServiceCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+	flagset.StringVar(&c.Group, "group", "secret-service", "group of the default collection; its subgroups are the other collections")
+	flagset.BoolVar(&c.Replace, "replace", false, "if true, replaces the current provider of "+secretservice.BusName)
+	flagset.BoolVar(&c.Locked, "locked", false, "if true, starts locked until a client asks to unlock the database")
+}
+
+func (c *secretServiceCommand) Execute(args []string) (err error) {
+	var db *v3.Database
+	var passphrase string
+	if !c.Locked {
+		if db, passphrase, err = c.open(); err != nil {
+			return err
+		}
+	}
+	// later unlocks come from clients, so there is no terminal to ask on
+	c.batch = true
+
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		if db != nil {
+			db.Wipe()
+		}
+		return err
+	}
+	defer conn.Close()
+
+	service, err := secretservice.New(conn, db, secretservice.Options{
+		Group: c.Group,
+		Save: func(db *v3.Database) error {
+			return c.save(db, passphrase)
+		},
+		Unlock: func() (*v3.Database, error) {
+			db, p, err := c.open()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "unable to unlock: %s\n", err)
+				return nil, err
+			}
+			passphrase = p
+			return db, nil
+		},
+	})
+	if err != nil {
+		if db != nil {
+			db.Wipe()
+		}
+		return err
+	}
+	defer service.Lock()
+
+	flags := dbus.NameFlagDoNotQueue | dbus.NameFlagAllowReplacement
+	if c.Replace {
+		flags |= dbus.NameFlagReplaceExisting
+	}
+	reply, err := conn.RequestName(secretservice.BusName, flags)
+	if err != nil {
+		return err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return fmt.Errorf("%s is provided by another program; stop it or "+
+			"use -replace", secretservice.BusName)
+	}
+	fmt.Fprintf(os.Stderr, "providing %s from %s\n", secretservice.BusName,
+		c.Path)
+
+	// stop when interrupted, or when another provider replaces this one
+	lost := make(chan *dbus.Signal, 16)
+	conn.Signal(lost)
+	if err = conn.AddMatchSignal(dbus.WithMatchMember("NameLost"),
+		dbus.WithMatchInterface("org.freedesktop.DBus")); err != nil {
+		return err
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for {
+		select {
+		case <-signals:
+			return nil
+		case <-conn.Context().Done():
+			return fmt.Errorf("disconnected from the session bus")
+		case sig := <-lost:
+			if sig != nil && sig.Name == "org.freedesktop.DBus.NameLost" &&
+				len(sig.Body) > 0 && sig.Body[0] == secretservice.BusName {
+				return nil
+			}
+		}
+	}
+}
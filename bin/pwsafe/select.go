@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/v3"
+)
+
+// selectRecords returns the records selected by the arguments, which are
+// either a record UUID, a title path ("group/subgroup/title") or a query.
+func selectRecords(db *v3.Database, args []string) ([]*v3.Record, error) {
+	selector := strings.TrimSpace(strings.Join(args, " "))
+	if selector == "" {
+		return nil, fmt.Errorf("expected a record UUID, title path or query")
+	}
+
+	if record := db.Record(strings.ToLower(selector)); record != nil {
+		return []*v3.Record{record}, nil
+	}
+
+	var records []*v3.Record
+	for _, record := range db.Records() {
+		if titlePath(record) == selector {
+			records = append(records, record.(*v3.Record))
+		}
+	}
+	if len(records) > 0 {
+		return records, nil
+	}
+
+	query, err := pwsafe.ParseQuery(selector)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range db.Records() {
+		if query.Match(record) {
+			records = append(records, record.(*v3.Record))
+		}
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no records match %q", selector)
+	}
+	return records, nil
+}
+
+// selectRecord returns the single record selected by the arguments
+func selectRecord(db *v3.Database, args []string) (*v3.Record, error) {
+	records, err := selectRecords(db, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 1 {
+		printRecords(os.Stderr, records)
+		return nil, fmt.Errorf("%d records match; select one by UUID",
+			len(records))
+	}
+	return records[0], nil
+}
+
+// printRecords prints a one line summary of each record
+func printRecords(out *os.File, records []*v3.Record) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	for _, record := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", record.UUID(), titlePath(record),
+			record.Username())
+	}
+	w.Flush()
+}
+
+// titlePath returns the group path and title of the record separated by "/"
+func titlePath(record pwsafe.Record) string {
+	return joinTitlePath(record.Group(), record.Title())
+}
+
+// joinTitlePath returns the group path and title separated by "/"
+func joinTitlePath(group, title string) string {
+	return strings.Join(append(pwsafe.SplitGroup(group), title), "/")
+}
+
+// groupPath returns the group with its elements separated by "/"
+func groupPath(group string) string {
+	return strings.Join(pwsafe.SplitGroup(group), "/")
+}
+
+// parseGroupPath returns the group for a path with its elements separated by
+// "/"
+func parseGroupPath(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return ""
+	}
+	return pwsafe.JoinGroup(strings.Split(path, "/")...)
+}
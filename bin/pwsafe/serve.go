@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/azdagron/pwsafe/api"
+	"github.com/azdagron/pwsafe/v3"
+)
+
+type serveCommand struct {
+	commonParams
+	Listen   string
+	Socket   string
+	Tokens   string
+	Audit    string
+	Locked   bool
+	NewToken string
+
+	// size and mtime of the database when it was last read or written, to
+	// notice changes made by other programs
+	size  int64
+	mtime time.Time
+}
+
+// tokensFile is the file listing the API tokens
+type tokensFile struct {
+	Tokens []struct {
+		Name   string   `toml:"name"`
+		SHA256 string   `toml:"sha256"`
+		Scopes []string `toml:"scopes"`
+		Groups []string `toml:"groups"`
+	} `toml:"tokens"`
+}
+
+func (c *serveCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+	flagset.StringVar(&c.Listen, "listen", "127.0.0.1:8420", "loopback address to listen on")
+	flagset.StringVar(&c.Socket, "socket", "", "path of a Unix socket to listen on instead of -listen")
+	flagset.StringVar(&c.Tokens, "tokens", "", "TOML file listing the tokens clients authenticate with")
+	flagset.StringVar(&c.Audit, "audit", "", "file the audit log is appended to (defaults to standard error)")
+	flagset.BoolVar(&c.Locked, "locked", false, "if true, starts locked until a client unlocks the database")
+	flagset.StringVar(&c.NewToken, "new-token", "", "if set, prints a new token with this name and its entry for the -tokens file")
+}
+
+func (c *serveCommand) Execute(args []string) (err error) {
+	if c.NewToken != "" {
+		return printNewToken(c.NewToken)
+	}
+	if c.Tokens == "" {
+		return fmt.Errorf("no tokens; use -tokens (and -new-token to " +
+			"create one)")
+	}
+	tokens, err := loadTokens(c.Tokens)
+	if err != nil {
+		return err
+	}
+	if c.Path == "" {
+		return fmt.Errorf("no database; use -path or -db")
+	}
+	if c.Path, err = filepath.Abs(c.Path); err != nil {
+		return err
+	}
+
+	var audit io.Writer = os.Stderr
+	if c.Audit != "" {
+		file, err := os.OpenFile(c.Audit,
+			os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		audit = file
+	}
+
+	server := api.New(api.Options{
+		Open:    c.openWith,
+		Save:    c.saveWith,
+		Changed: c.changed,
+		Tokens:  tokens,
+		Audit:   audit,
+	})
+	if !c.Locked {
+		// ask for the passphrase, or take the database from the agent
+		db, passphrase, err := c.open()
+		if err != nil {
+			return err
+		}
+		db.Wipe()
+		if err = server.Unlock(passphrase); err != nil {
+			return err
+		}
+	}
+	defer server.Lock()
+
+	listener, err := c.listen()
+	if err != nil {
+		return err
+	}
+	if c.Socket != "" {
+		defer os.Remove(c.Socket)
+	}
+	fmt.Fprintf(os.Stderr, "serving %s on %s\n", c.Path, listener.Addr())
+
+	http_server := &http.Server{
+		Handler:           server,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		<-signals
+		ctx, cancel := context.WithTimeout(context.Background(),
+			10*time.Second)
+		defer cancel()
+		http_server.Shutdown(ctx)
+	}()
+	err = http_server.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// listen listens on the Unix socket, or on the loopback address. The API
+// is not meant to be reachable from other hosts.
+func (c *serveCommand) listen() (net.Listener, error) {
+	if c.Socket != "" {
+		socket, err := filepath.Abs(c.Socket)
+		if err != nil {
+			return nil, err
+		}
+		c.Socket = socket
+		return listenAgent(socket)
+	}
+	host, _, err := net.SplitHostPort(c.Listen)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" &&
+		(ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("%s is not a loopback address", c.Listen)
+	}
+	return net.Listen("tcp", c.Listen)
+}
+
+// openWith reads the database with the passphrase. An empty passphrase
+// takes the database from the agent it was first opened from.
+func (c *serveCommand) openWith(passphrase string) (*v3.Database, error) {
+	var db *v3.Database
+	var err error
+	if passphrase == "" && c.agent != nil {
+		db, err = c.agent.open(c.Path)
+	} else {
+		db, err = v3.Open(c.Path, func() (string, error) {
+			return passphrase, nil
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.stat()
+	return db, nil
+}
+
+func (c *serveCommand) saveWith(db *v3.Database, passphrase string) error {
+	if err := c.save(db, passphrase); err != nil {
+		return err
+	}
+	c.stat()
+	return nil
+}
+
+// stat records the size and mtime of the database
+func (c *serveCommand) stat() {
+	if info, err := os.Stat(c.Path); err == nil {
+		c.size, c.mtime = info.Size(), info.ModTime()
+	}
+}
+
+// changed returns true if the database changed since it was last read or
+// written
+func (c *serveCommand) changed() bool {
+	info, err := os.Stat(c.Path)
+	if err != nil {
+		return false
+	}
+	return info.Size() != c.size || !info.ModTime().Equal(c.mtime)
+}
+
+// loadTokens reads the tokens file:
+//
+//	[[tokens]]
+//	name = "deploy"
+//	sha256 = "<hex encoded SHA-256 of the token>"
+//	scopes = ["read"]
+//	groups = ["ops/deploy"]
+func loadTokens(path string) ([]api.Token, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file tokensFile
+	md, err := toml.Decode(string(data), &file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("%s: unknown key %s", path, undecoded[0])
+	}
+
+	var tokens []api.Token
+	for i, t := range file.Tokens {
+		token := api.Token{Name: t.Name, Scopes: t.Scopes, Groups: t.Groups}
+		if token.Name == "" {
+			return nil, fmt.Errorf("%s: token %d has no name", path, i+1)
+		}
+		hash, err := hex.DecodeString(t.SHA256)
+		if err != nil || len(hash) != len(token.Hash) {
+			return nil, fmt.Errorf("%s: token %s: sha256 must be %d hex "+
+				"encoded bytes", path, t.Name, len(token.Hash))
+		}
+		copy(token.Hash[:], hash)
+		for _, scope := range t.Scopes {
+			switch scope {
+			case api.ScopeRead, api.ScopeWrite, api.ScopeLock:
+			default:
+				return nil, fmt.Errorf("%s: token %s: unknown scope %q",
+					path, t.Name, scope)
+			}
+		}
+		tokens = append(tokens, token)
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%s: no tokens", path)
+	}
+	return tokens, nil
+}
+
+// printNewToken prints a random token and the entry of the tokens file for
+// it. Only its hash is stored.
+func printNewToken(name string) error {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(secret)
+	hash := api.HashToken(token)
+	fmt.Printf("token: %s\n\n", token)
+	fmt.Printf("[[tokens]]\nname = %q\nsha256 = %q\nscopes = [%q]\n", name,
+		hex.EncodeToString(hash[:]), api.ScopeRead)
+	return nil
+}
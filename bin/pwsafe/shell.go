@@ -0,0 +1,769 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/v3"
+	"github.com/bgentry/speakeasy"
+	"golang.org/x/term"
+)
+
+var shellHelp = []struct {
+	usage string
+	desc  string
+}{
+	{"ls [group]", "list the groups and entries of a group"},
+	{"cd [group]", "change the current group; / is the top"},
+	{"pwd", "print the current group"},
+	{"show [-u] <entry>", "show an entry; -u shows the passwords"},
+	{"cp <entry> [field]", "copy the password or another field to the clipboard"},
+	{"add <title>", "add an entry to the current group"},
+	{"edit <entry>", "edit an entry in $EDITOR"},
+	{"mv <entry> <group>", "move an entry to a group"},
+	{"rm <entry>", "remove an entry"},
+	{"save", "save the changes"},
+	{"lock", "lock the database until the next command"},
+	{"exit", "leave the shell, asking to save any changes"},
+}
+
+type shellCommand struct {
+	commonParams
+	clipboardParams
+	Lock time.Duration
+}
+
+func (c *shellCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+	c.clipboardParams.AddFlags(flagset)
+	flagset.DurationVar(&c.Lock, "lock", 5*time.Minute, "idle time after which the database is locked; 0 never locks")
+}
+
+func (c *shellCommand) Execute(args []string) (err error) {
+	s := &shell{params: c}
+	if err = s.unlock(); err != nil {
+		return err
+	}
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		s.fd = fd
+		s.term = term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout}, "")
+		s.term.AutoCompleteCallback = s.complete
+	}
+	if c.Lock > 0 {
+		s.timer = time.AfterFunc(c.Lock, s.idleLock)
+	}
+
+	for {
+		line, err := s.readLine()
+		if err == io.EOF {
+			if s.exit() {
+				return s.exitError()
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if s.timer != nil {
+			s.timer.Stop()
+			s.mu.Lock()
+			s.idle_warned = false
+			s.mu.Unlock()
+		}
+		if err = s.execute(line); err == errExit {
+			return s.exitError()
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if s.timer != nil {
+			s.timer.Reset(c.Lock)
+		}
+	}
+}
+
+var (
+	errExit    = errors.New("exit")
+	errUnsaved = errors.New("unsaved changes of a database from the " +
+		"agent; save them before locking")
+)
+
+// shell is the state of an interactive shell. The decrypted database is only
+// held while the shell is unlocked.
+type shell struct {
+	params *shellCommand
+
+	// mu protects the database from the idle lock timer
+	mu         sync.Mutex
+	db         *v3.Database
+	passphrase string
+	dirty      bool
+
+	// sealed holds the database with unsaved changes, encrypted with the
+	// passphrase, while the shell is locked
+	sealed []byte
+
+	// idle_warned is true once the idle lock warned that it will discard
+	// unsaved changes it cannot seal
+	idle_warned bool
+
+	// discarded is true if unsaved changes were discarded on exit without
+	// asking
+	discarded bool
+
+	cwd   []string
+	timer *time.Timer
+	fd    int
+	term  *term.Terminal
+}
+
+func (s *shell) prompt() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mark := ""
+	if s.dirty {
+		mark = "*"
+	}
+	if s.db == nil {
+		return "pwsafe (locked)" + mark + "> "
+	}
+	return "pwsafe:/" + strings.Join(s.cwd, "/") + mark + "> "
+}
+
+// readLine reads a command line, using the terminal line editor when the
+// input is a terminal
+func (s *shell) readLine() (string, error) {
+	if s.term == nil {
+		fmt.Fprint(os.Stderr, s.prompt())
+		line, err := stdin.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return line, nil
+	}
+
+	state, err := term.MakeRaw(s.fd)
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(s.fd, state)
+	s.term.SetPrompt(s.prompt())
+	return s.term.ReadLine()
+}
+
+func (s *shell) execute(line string) error {
+	args, err := splitArgs(line)
+	if err != nil || len(args) == 0 {
+		return err
+	}
+	switch args[0] {
+	case "exit", "quit":
+		if s.exit() {
+			return errExit
+		}
+		return nil
+	case "help":
+		for _, h := range shellHelp {
+			fmt.Printf("  %-20s %s\n", h.usage, h.desc)
+		}
+		return nil
+	case "lock":
+		return s.lock()
+	}
+
+	if s.locked() {
+		if err := s.unlock(); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch args[0] {
+	case "ls":
+		return s.ls(args[1:])
+	case "cd":
+		return s.cd(args[1:])
+	case "pwd":
+		fmt.Println("/" + strings.Join(s.cwd, "/"))
+		return nil
+	case "show":
+		return s.show(args[1:])
+	case "cp":
+		return s.cp(args[1:])
+	case "add":
+		return s.add(args[1:])
+	case "edit":
+		return s.edit(args[1:])
+	case "mv":
+		return s.mv(args[1:])
+	case "rm":
+		return s.rm(args[1:])
+	case "save":
+		return s.save()
+	}
+	return fmt.Errorf("unknown command %q; try help", args[0])
+}
+
+func (s *shell) locked() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db == nil
+}
+
+// unlock opens the database, or restores the database with unsaved changes
+// sealed by lock
+func (s *shell) unlock() (err error) {
+	s.mu.Lock()
+	sealed := s.sealed
+	s.mu.Unlock()
+	if sealed != nil {
+		passphrase, err := makePassphraseFn("Passphrase: ",
+			&s.params.Passphrase, s.params.Pinentry, nil)()
+		if err != nil {
+			return err
+		}
+		db, err := v3.Unseal(sealed, passphrase)
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.db, s.passphrase, s.dirty, s.sealed = db, passphrase, true, nil
+		return nil
+	}
+
+	db, passphrase, err := s.params.openOrCreate()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.db, s.passphrase, s.dirty = db, passphrase, false
+	return nil
+}
+
+// lock wipes the decrypted database. Unsaved changes are kept sealed with
+// the passphrase until the next unlock, so locking neither loses nor saves
+// them. A database from the agent has no passphrase to seal it with; lock
+// fails with errUnsaved then.
+func (s *shell) lock() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db == nil {
+		return nil
+	}
+	if s.dirty {
+		if s.passphrase == "" {
+			return errUnsaved
+		}
+		sealed, err := s.db.Seal(s.passphrase)
+		if err != nil {
+			return err
+		}
+		s.sealed = sealed
+	}
+	s.db.Wipe()
+	s.db, s.passphrase = nil, ""
+	return nil
+}
+
+// idleLock locks the database after inactivity. Unsaved changes that cannot
+// be sealed are discarded after a second period of inactivity, so that the
+// decrypted database is never kept indefinitely.
+func (s *shell) idleLock() {
+	err := s.lock()
+	msg := "locked after inactivity"
+	if err == errUnsaved {
+		s.mu.Lock()
+		warned := s.idle_warned
+		s.idle_warned = true
+		if warned {
+			s.dirty = false
+		}
+		s.mu.Unlock()
+		if warned {
+			err = s.lock()
+			msg = "discarded unsaved changes and locked after inactivity"
+		} else {
+			msg = fmt.Sprintf("unsaved changes will be discarded after "+
+				"another %s without input", s.params.Lock)
+			s.timer.Reset(s.params.Lock)
+		}
+	}
+	if err != nil && err != errUnsaved {
+		msg = "unable to lock: " + err.Error()
+	}
+	if s.term != nil {
+		s.term.SetPrompt(s.prompt())
+		fmt.Fprintln(s.term, msg)
+	} else {
+		fmt.Fprintln(os.Stderr, msg)
+	}
+}
+
+// exit returns true if the shell may exit, asking to save unsaved changes
+func (s *shell) exit() bool {
+	s.mu.Lock()
+	dirty, locked := s.dirty, s.db == nil
+	if dirty && s.term == nil {
+		fmt.Fprintln(os.Stderr, "discarding unsaved changes")
+		s.discarded = true
+	}
+	s.mu.Unlock()
+	if !dirty || s.term == nil || !confirm("Save changes?") {
+		return true
+	}
+	if locked {
+		if err := s.unlock(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return false
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.save(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+	return true
+}
+
+// exitError returns the error the shell exits with: exit status 1 if
+// changes were discarded
+func (s *shell) exitError() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.discarded {
+		return exitStatus(1)
+	}
+	return nil
+}
+
+func (s *shell) save() error {
+	if err := s.params.save(s.db, s.passphrase); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// path returns the group elements of a group path relative to the current
+// group
+func (s *shell) path(arg string) []string {
+	var elems []string
+	if !strings.HasPrefix(arg, "/") {
+		elems = append(elems, s.cwd...)
+	}
+	for _, elem := range strings.Split(arg, "/") {
+		switch elem {
+		case "", ".":
+		case "..":
+			if len(elems) > 0 {
+				elems = elems[:len(elems)-1]
+			}
+		default:
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
+
+// children returns the subgroups and entry titles of the group
+func (s *shell) children(group []string) (groups, titles []string) {
+	seen := make(map[string]bool)
+	addGroup := func(elems []string) {
+		if len(elems) > len(group) && hasPrefix(elems, group) &&
+			!seen[elems[len(group)]] {
+			seen[elems[len(group)]] = true
+			groups = append(groups, elems[len(group)])
+		}
+	}
+	for _, record := range s.db.Records() {
+		elems := pwsafe.SplitGroup(record.Group())
+		if len(elems) == len(group) && hasPrefix(elems, group) {
+			titles = append(titles, record.Title())
+		}
+		addGroup(elems)
+	}
+	for _, empty := range s.db.Header().EmptyGroups() {
+		addGroup(pwsafe.SplitGroup(empty))
+	}
+	sort.Strings(groups)
+	sort.Strings(titles)
+	return groups, titles
+}
+
+func hasPrefix(elems, prefix []string) bool {
+	if len(elems) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if elems[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// entry returns the entry with the UUID or title path relative to the
+// current group
+func (s *shell) entry(arg string) (*v3.Record, error) {
+	if record := s.db.Record(strings.ToLower(arg)); record != nil {
+		return record, nil
+	}
+	path := strings.Join(s.path(arg), "/")
+	var records []*v3.Record
+	for _, record := range s.db.Records() {
+		if titlePath(record) == path {
+			records = append(records, record.(*v3.Record))
+		}
+	}
+	switch len(records) {
+	case 0:
+		return nil, fmt.Errorf("no entry %q", arg)
+	case 1:
+		return records[0], nil
+	}
+	printRecords(os.Stderr, records)
+	return nil, fmt.Errorf("%d entries are named %q; use the UUID",
+		len(records), arg)
+}
+
+func (s *shell) ls(args []string) error {
+	group := s.cwd
+	if len(args) > 0 {
+		group = s.path(args[0])
+	}
+	groups, titles := s.children(group)
+	for _, g := range groups {
+		fmt.Println(g + "/")
+	}
+	for _, title := range titles {
+		fmt.Println(title)
+	}
+	return nil
+}
+
+func (s *shell) cd(args []string) error {
+	if len(args) == 0 {
+		s.cwd = nil
+		return nil
+	}
+	group := s.path(args[0])
+	if len(group) > 0 {
+		groups, _ := s.children(group[:len(group)-1])
+		found := false
+		for _, g := range groups {
+			found = found || g == group[len(group)-1]
+		}
+		if !found {
+			return fmt.Errorf("no group %q", args[0])
+		}
+	}
+	s.cwd = group
+	return nil
+}
+
+func (s *shell) show(args []string) error {
+	unmask := len(args) > 0 && args[0] == "-u"
+	if unmask {
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: show [-u] <entry>")
+	}
+	record, err := s.entry(args[0])
+	if err != nil {
+		return err
+	}
+	printRecord(s.db, record, unmask)
+	return nil
+}
+
+func (s *shell) cp(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: cp <entry> [field]")
+	}
+	record, err := s.entry(args[0])
+	if err != nil {
+		return err
+	}
+	field := "password"
+	if len(args) == 2 {
+		field = args[1]
+	}
+	value, err := getField(s.db, record, field)
+	if err != nil {
+		return err
+	}
+	if err = s.params.copySecret(value); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "copied %s of %s to the clipboard\n", field,
+		record.Title())
+	return nil
+}
+
+func (s *shell) add(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: add <title>")
+	}
+	record, err := v3.NewRecord()
+	if err != nil {
+		return err
+	}
+	record.SetTitle(args[0])
+	record.SetGroup(pwsafe.JoinGroup(s.cwd...))
+	for _, field := range []struct {
+		name string
+		set  func(string)
+	}{
+		{"Username", record.SetUsername},
+		{"URL", record.SetURL},
+		{"Email", record.SetEmail},
+	} {
+		value, err := prompt(field.name, "")
+		if err != nil {
+			return err
+		}
+		field.set(value)
+	}
+	password, err := speakeasy.Ask("Password (empty to generate): ")
+	if err != nil {
+		return err
+	}
+	if password == "" {
+		policy := s.db.PasswordPolicy(record)
+		if password, err = policy.Generate(); err != nil {
+			return err
+		}
+	}
+	record.SetPassword(password)
+	record.SetPasswordMtime(time.Now())
+	if err = s.db.AddRecord(record); err != nil {
+		return err
+	}
+	s.dirty = true
+	return nil
+}
+
+func (s *shell) edit(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: edit <entry>")
+	}
+	record, err := s.entry(args[0])
+	if err != nil {
+		return err
+	}
+	if record.Protected() {
+		return fmt.Errorf("%s is protected", titlePath(record))
+	}
+	doc, err := editRecord(record)
+	if err != nil {
+		return err
+	}
+	if doc != nil && applyDocument(record, doc) {
+		s.dirty = true
+	}
+	return nil
+}
+
+func (s *shell) mv(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: mv <entry> <group>")
+	}
+	record, err := s.entry(args[0])
+	if err != nil {
+		return err
+	}
+	if record.Protected() {
+		return fmt.Errorf("%s is protected", titlePath(record))
+	}
+	record.SetGroup(pwsafe.JoinGroup(s.path(args[1])...))
+	record.SetMtime(time.Now())
+	s.dirty = true
+	return nil
+}
+
+func (s *shell) rm(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: rm <entry>")
+	}
+	record, err := s.entry(args[0])
+	if err != nil {
+		return err
+	}
+	if record.Protected() {
+		return fmt.Errorf("%s is protected", titlePath(record))
+	}
+	if dependents := s.db.Dependents(record.UUID()); len(dependents) > 0 {
+		printRecords(os.Stderr, dependents)
+		return fmt.Errorf("%s is used by %d aliases or shortcuts",
+			titlePath(record), len(dependents))
+	}
+	if !confirm("Remove " + titlePath(record) + "?") {
+		return nil
+	}
+	s.db.RemoveRecord(record.UUID())
+	s.dirty = true
+	return nil
+}
+
+// shellCommands are completed as the first word of a line
+var shellCommands = []string{"add", "cd", "cp", "edit", "exit", "help",
+	"lock", "ls", "mv", "pwd", "rm", "save", "show"}
+
+// complete completes commands, group paths and titles when tab is pressed
+func (s *shell) complete(line string, pos int, key rune) (string, int,
+	bool) {
+
+	if key != '\t' {
+		return "", 0, false
+	}
+	start := wordStart(line[:pos])
+	word := unescapeWord(line[start:pos])
+
+	var candidates []string
+	if strings.TrimSpace(line[:start]) == "" {
+		for _, name := range shellCommands {
+			candidates = append(candidates, name+" ")
+		}
+	} else {
+		s.mu.Lock()
+		if s.db != nil {
+			dir := word[:strings.LastIndex(word, "/")+1]
+			groups, titles := s.children(s.path(dir))
+			for _, g := range groups {
+				candidates = append(candidates, dir+g+"/")
+			}
+			if !strings.HasPrefix(line, "cd ") {
+				for _, title := range titles {
+					candidates = append(candidates, dir+title+" ")
+				}
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, word) {
+			matches = append(matches, candidate)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+	completion := matches[0]
+	for _, match := range matches[1:] {
+		completion = commonPrefix(completion, match)
+	}
+	if len(completion) <= len(word) {
+		return "", 0, false
+	}
+	escaped := escapeWord(strings.TrimSuffix(completion, " "))
+	if strings.HasSuffix(completion, " ") {
+		escaped += " "
+	}
+	return line[:start] + escaped + line[pos:], start + len(escaped), true
+}
+
+func commonPrefix(a, b string) string {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return a[:n]
+}
+
+// wordStart returns the start of the last, possibly escaped, word
+func wordStart(s string) int {
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case ' ':
+			start = i + 1
+		}
+	}
+	return start
+}
+
+func escapeWord(word string) string {
+	var b strings.Builder
+	for _, c := range word {
+		if c == ' ' || c == '\\' || c == '"' || c == '\'' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func unescapeWord(word string) string {
+	args, err := splitArgs(word)
+	if err != nil || len(args) == 0 {
+		return word
+	}
+	return args[0]
+}
+
+// splitArgs splits a command line into words separated by spaces. Quotes and
+// backslashes escape spaces.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	in_word := false
+	var quote rune
+	escaped := false
+	for _, c := range line {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			in_word = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			in_word = true
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			if in_word {
+				args = append(args, word.String())
+				word.Reset()
+				in_word = false
+			}
+		default:
+			word.WriteRune(c)
+			in_word = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if in_word {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/azdagron/pwsafe/v3"
+)
+
+type showCommand struct {
+	commonParams
+	clipboardParams
+	Unmask    bool
+	Clipboard bool
+}
+
+func (c *showCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+	flagset.BoolVar(&c.Unmask, "unmask", false, "if true, shows the passwords")
+	flagset.BoolVar(&c.Clipboard, "clipboard", false, "if true, copies the password to the clipboard")
+	c.clipboardParams.AddFlags(flagset)
+}
+
+func (c *showCommand) Execute(args []string) (err error) {
+	db, _, err := c.open()
+	if err != nil {
+		return err
+	}
+	record, err := selectRecord(db, args)
+	if err != nil {
+		return err
+	}
+
+	if c.Clipboard {
+		password, err := getField(db, record, "password")
+		if err != nil {
+			return err
+		}
+		if err = c.copySecret(password); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "copied password of %s to the clipboard\n",
+			record.Title())
+	}
+
+	printRecord(db, record, c.Unmask)
+	return nil
+}
+
+// printRecord prints every field of the record
+func printRecord(db *v3.Database, record *v3.Record, unmask bool) {
+	mask := func(x string) string {
+		if unmask {
+			return x
+		}
+		return strings.Repeat("*", len(x))
+	}
+
+	fmt.Println("[", record.UUID(), "]")
+	fields := []fieldDescription{
+		{"Title", record.Title()},
+		{"Group", groupPath(record.Group())},
+		{"Username", record.Username()},
+	}
+	if base, shortcut := db.Base(record); base != nil {
+		kind := "Alias of"
+		if shortcut {
+			kind = "Shortcut to"
+		}
+		fields = append(fields, fieldDescription{kind, titlePath(base)})
+	} else {
+		fields = append(fields, fieldDescription{"Password",
+			mask(record.Password())})
+	}
+	fields = append(fields, []fieldDescription{
+		{"URL", record.URL()},
+		{"Email", record.Email()},
+		{"TOTP", mask(record.TOTP())},
+		{"Notes", record.Notes()},
+		{"Ctime", record.Ctime()},
+		{"Mtime", record.Mtime()},
+		{"Atime", record.Atime()},
+		{"Password mtime", record.PasswordMtime()},
+		{"Expiry", record.Expiry()},
+	}...)
+	if record.Protected() {
+		fields = append(fields, fieldDescription{"Protected", "yes"})
+	}
+	for _, field := range record.CustomFields() {
+		fields = append(fields, fieldDescription{field.Name, field.Value})
+	}
+	history := record.History()
+	for i := len(history) - 1; i >= 0; i-- {
+		fields = append(fields, fieldDescription{
+			"History " + strconv.Itoa(len(history)-i),
+			mask(history[i].Password) + " (" +
+				history[i].Time.Format("2006-01-02 15:04") + ")",
+		})
+	}
+	printFields(fields)
+}
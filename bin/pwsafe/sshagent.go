@@ -0,0 +1,438 @@
+package main
+
+import (
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/v3"
+	"golang.org/x/crypto/ssh"
+	sshagent "golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	sshAuthSockEnv = "SSH_AUTH_SOCK"
+	sshAgentPidEnv = "SSH_AGENT_PID"
+
+	// Custom fields of entries holding SSH keys. sshAgentField serves the
+	// key of an entry outside the SSH group; the others override the
+	// -confirm and -lifetime flags for the key.
+	sshAgentField    = "ssh-agent"
+	sshConfirmField  = "ssh-confirm"
+	sshLifetimeField = "ssh-lifetime"
+)
+
+type sshAgentCommand struct {
+	commonParams
+	Group      string
+	Socket     string
+	Foreground bool
+	Confirm    bool
+	Lifetime   time.Duration
+	Kill       bool
+
+	// passphrase unlocks the database again in the detached agent
+	passphrase string
+}
+
+func (c *sshAgentCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+	flagset.StringVar(&c.Group, "group", "ssh", "group of the entries holding SSH keys, with elements separated by /")
+	flagset.StringVar(&c.Socket, "socket", "", "path of the agent socket (defaults to a new private directory)")
+	flagset.BoolVar(&c.Foreground, "foreground", false, "if true, runs the agent in the foreground")
+	flagset.BoolVar(&c.Confirm, "confirm", false, "if true, every use of a key must be confirmed with $SSH_ASKPASS")
+	flagset.DurationVar(&c.Lifetime, "lifetime", 0, "time after which the keys are removed from the agent; 0 keeps them")
+	flagset.BoolVar(&c.Kill, "kill", false, "if true, stops the agent named by "+sshAgentPidEnv)
+}
+
+func (c *sshAgentCommand) Execute(args []string) (err error) {
+	if c.Kill {
+		pid, err := strconv.Atoi(os.Getenv(sshAgentPidEnv))
+		if err != nil {
+			return fmt.Errorf("%s is not set", sshAgentPidEnv)
+		}
+		process, err := os.FindProcess(pid)
+		if err != nil {
+			return err
+		}
+		if err = process.Signal(syscall.SIGTERM); err != nil {
+			return err
+		}
+		fmt.Printf("unset %s;\nunset %s;\necho Agent pid %d killed;\n",
+			sshAuthSockEnv, sshAgentPidEnv, pid)
+		return nil
+	}
+
+	if fd := os.Getenv(agentListenFdEnv); fd != "" {
+		// detached child; the passphrase comes from -passphrase-fd
+		os.Unsetenv(agentListenFdEnv)
+		n, err := strconv.Atoi(fd)
+		if err != nil {
+			return err
+		}
+		listener, err := net.FileListener(os.NewFile(uintptr(n), "agent"))
+		if err != nil {
+			return err
+		}
+		c.batch = true
+		keyring, err := c.loadKeys(false)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		return serveSSHAgent(listener, c.Socket, keyring)
+	}
+
+	if err = checkPeerCredentials(); err != nil {
+		return err
+	}
+	if c.Path, err = filepath.Abs(c.Path); err != nil {
+		return err
+	}
+	// unlock before listening, so that mistakes are reported right away
+	keyring, err := c.loadKeys(true)
+	if err != nil {
+		return err
+	}
+
+	socket := c.Socket
+	if socket == "" {
+		socket, err = defaultAgentSocket()
+	} else {
+		socket, err = filepath.Abs(socket)
+	}
+	if err != nil {
+		return err
+	}
+	listener, err := listenAgent(socket)
+	if err != nil {
+		return err
+	}
+
+	if c.Foreground {
+		printSSHAgentEnv(socket, os.Getpid())
+		return serveSSHAgent(listener, socket, keyring)
+	}
+
+	pid, err := c.start(listener.(*net.UnixListener), socket)
+	listener.Close()
+	if err != nil {
+		os.Remove(socket)
+		return err
+	}
+	printSSHAgentEnv(socket, pid)
+	return nil
+}
+
+// printSSHAgentEnv prints shell commands setting SSH_AUTH_SOCK and
+// SSH_AGENT_PID, like ssh-agent does
+func printSSHAgentEnv(socket string, pid int) {
+	fmt.Printf("%s=%s; export %s;\n", sshAuthSockEnv, shellQuote(socket),
+		sshAuthSockEnv)
+	fmt.Printf("%s=%d; export %s;\n", sshAgentPidEnv, pid, sshAgentPidEnv)
+	fmt.Printf("echo Agent pid %d;\n", pid)
+}
+
+// start starts a detached agent serving the listener and returns its process
+// id. The agent unlocks the database again with the passphrase, which it
+// reads from a pipe, or from the unlock agent the database came from.
+func (c *sshAgentCommand) start(listener *net.UnixListener,
+	socket string) (int, error) {
+
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	file, err := listener.File()
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	// the socket must survive closing the listener in this process
+	listener.SetUnlinkOnClose(false)
+
+	cmd := exec.Command(exe, "ssh-agent", "-path", c.Path, "-group", c.Group,
+		"-socket", socket, "-confirm="+strconv.FormatBool(c.Confirm),
+		"-lifetime", c.Lifetime.String())
+	cmd.Env = append(os.Environ(), agentListenFdEnv+"=3")
+	cmd.ExtraFiles = []*os.File{file}
+
+	var w *os.File
+	if c.agent == nil {
+		r, pw, err := os.Pipe()
+		if err != nil {
+			return 0, err
+		}
+		defer r.Close()
+		w = pw
+		cmd.Args = append(cmd.Args, "-passphrase-fd", "4")
+		cmd.ExtraFiles = append(cmd.ExtraFiles, r)
+	}
+	detach(cmd)
+	if err = cmd.Start(); err != nil {
+		if w != nil {
+			w.Close()
+		}
+		return 0, err
+	}
+	if w != nil {
+		_, err = w.WriteString(c.passphrase)
+		w.Close()
+		if err != nil {
+			cmd.Process.Kill()
+			return 0, err
+		}
+	}
+	pid := cmd.Process.Pid
+	return pid, cmd.Process.Release()
+}
+
+// loadKeys unlocks the database and returns a keyring holding the keys of
+// the entries in the SSH group or with the ssh-agent custom field. Entries
+// whose key cannot be read are skipped, which is reported if verbose.
+func (c *sshAgentCommand) loadKeys(verbose bool) (*sshKeyring, error) {
+	db, passphrase, err := c.open()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Wipe()
+	c.passphrase = passphrase
+
+	group := pwsafe.SplitGroup(parseGroupPath(c.Group))
+	keyring := newSSHKeyring()
+	for _, r := range db.Records() {
+		record := r.(*v3.Record)
+		fields := customFieldMap(record)
+		if _, ok := fields[sshAgentField]; !ok &&
+			!inGroup(record.Group(), group) {
+			continue
+		}
+		key, err := c.recordKey(db, record, fields)
+		if err != nil {
+			if verbose {
+				fmt.Fprintf(os.Stderr, "skipping %s: %s\n", titlePath(record),
+					err)
+			}
+			continue
+		}
+		if err = keyring.Add(*key); err != nil {
+			return nil, err
+		}
+		if verbose {
+			signer, err := ssh.NewSignerFromKey(key.PrivateKey)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(os.Stderr, "added %s (%s)\n", key.Comment,
+				ssh.FingerprintSHA256(signer.PublicKey()))
+		}
+	}
+	return keyring, nil
+}
+
+// recordKey returns the private key in the notes of the record, decrypted
+// with the password of the record if needed
+func (c *sshAgentCommand) recordKey(db *v3.Database, record *v3.Record,
+	fields map[string]string) (*sshagent.AddedKey, error) {
+
+	notes, err := getField(db, record, "notes")
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(notes))
+	if block == nil {
+		return nil, errors.New("no private key in the notes")
+	}
+	data := pem.EncodeToMemory(block)
+	key, err := ssh.ParseRawPrivateKey(data)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		password, err := getField(db, record, "password")
+		if err != nil {
+			return nil, err
+		}
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(data,
+			[]byte(password))
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	added := &sshagent.AddedKey{
+		PrivateKey:       key,
+		Comment:          titlePath(record),
+		ConfirmBeforeUse: c.Confirm,
+	}
+	lifetime := c.Lifetime
+	if value, ok := fields[sshConfirmField]; ok {
+		if added.ConfirmBeforeUse, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid %s %q", sshConfirmField, value)
+		}
+	}
+	if value, ok := fields[sshLifetimeField]; ok {
+		if lifetime, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("invalid %s %q", sshLifetimeField, value)
+		}
+	}
+	if lifetime > 0 {
+		added.LifetimeSecs = uint32((lifetime + time.Second - 1) /
+			time.Second)
+	}
+	return added, nil
+}
+
+// customFieldMap returns the custom fields of the record by name
+func customFieldMap(record *v3.Record) map[string]string {
+	fields := make(map[string]string)
+	for _, field := range record.CustomFields() {
+		fields[field.Name] = field.Value
+	}
+	return fields
+}
+
+// inGroup returns true if the group is the parent group or one of its
+// subgroups. Every group is in the empty parent group.
+func inGroup(group string, parent []string) bool {
+	elements := pwsafe.SplitGroup(group)
+	if len(elements) < len(parent) {
+		return false
+	}
+	for i := range parent {
+		if elements[i] != parent[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// serveSSHAgent serves the keyring on the listener until interrupted
+func serveSSHAgent(listener net.Listener, socket string,
+	keyring *sshKeyring) error {
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		<-signals
+		listener.Close()
+	}()
+	defer func() {
+		keyring.RemoveAll()
+		os.Remove(socket)
+		if dir := filepath.Dir(socket); strings.HasPrefix(
+			filepath.Base(dir), agentDirPrefix) {
+			os.Remove(dir)
+		}
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if uid, err := peerUID(conn); err != nil || uid != os.Getuid() {
+				return
+			}
+			sshagent.ServeAgent(keyring, conn)
+		}()
+	}
+}
+
+// errNotConfirmed is returned for signing requests the user declined
+var errNotConfirmed = errors.New("use of the key was not confirmed")
+
+// sshKeyring is an in-memory keyring that asks before using keys added with
+// the confirm constraint, which the keyring of x/crypto rejects
+type sshKeyring struct {
+	sshagent.ExtendedAgent
+
+	mu sync.Mutex
+	// confirm holds the comments of the keys needing confirmation by their
+	// public key
+	confirm map[string]string
+}
+
+func newSSHKeyring() *sshKeyring {
+	return &sshKeyring{
+		ExtendedAgent: sshagent.NewKeyring().(sshagent.ExtendedAgent),
+		confirm:       make(map[string]string),
+	}
+}
+
+func (k *sshKeyring) Add(key sshagent.AddedKey) error {
+	var public ssh.PublicKey
+	if key.Certificate != nil {
+		public = key.Certificate
+	} else {
+		signer, err := ssh.NewSignerFromKey(key.PrivateKey)
+		if err != nil {
+			return err
+		}
+		public = signer.PublicKey()
+	}
+	blob := string(public.Marshal())
+
+	// the confirm constraint is enforced by SignWithFlags
+	confirm := key.ConfirmBeforeUse
+	key.ConfirmBeforeUse = false
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.ExtendedAgent.Add(key); err != nil {
+		return err
+	}
+	if confirm {
+		k.confirm[blob] = key.Comment
+	} else {
+		delete(k.confirm, blob)
+	}
+	return nil
+}
+
+func (k *sshKeyring) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature,
+	error) {
+
+	return k.SignWithFlags(key, data, 0)
+}
+
+func (k *sshKeyring) SignWithFlags(key ssh.PublicKey, data []byte,
+	flags sshagent.SignatureFlags) (*ssh.Signature, error) {
+
+	k.mu.Lock()
+	comment, confirm := k.confirm[string(key.Marshal())]
+	k.mu.Unlock()
+	if confirm && !askConfirm(fmt.Sprintf("Allow use of key %s?\nKey "+
+		"fingerprint %s.", comment, ssh.FingerprintSHA256(key))) {
+		return nil, errNotConfirmed
+	}
+	return k.ExtendedAgent.SignWithFlags(key, data, flags)
+}
+
+// askConfirm asks a yes or no question with $SSH_ASKPASS, like ssh-agent
+// does for keys added with "ssh-add -c"
+func askConfirm(question string) bool {
+	program := os.Getenv("SSH_ASKPASS")
+	if program == "" {
+		program = "ssh-askpass"
+	}
+	cmd := exec.Command(program, question)
+	cmd.Env = append(os.Environ(), "SSH_ASKPASS_PROMPT=confirm")
+	return cmd.Run() == nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/azdagron/pwsafe/tui"
+	"github.com/azdagron/pwsafe/v3"
+	"github.com/gdamore/tcell/v2"
+)
+
+type tuiCommand struct {
+	commonParams
+	clipboardParams
+}
+
+func (c *tuiCommand) ConfigureFlags(flagset *flag.FlagSet) {
+	c.commonParams.AddFlags(flagset)
+	c.clipboardParams.AddFlags(flagset)
+}
+
+func (c *tuiCommand) Execute(args []string) (err error) {
+	db, passphrase, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer db.Wipe()
+
+	screen, err := tcell.NewScreen()
+	if err != nil {
+		return err
+	}
+	if err = screen.Init(); err != nil {
+		return err
+	}
+	defer screen.Fini()
+
+	app := tui.New(screen, db, tui.Options{
+		Copy: c.copySecret,
+		Save: func(db *v3.Database) error {
+			return c.save(db, passphrase)
+		},
+	})
+	return app.Run()
+}
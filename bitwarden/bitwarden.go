@@ -0,0 +1,299 @@
+// Package bitwarden imports Bitwarden JSON exports, both unencrypted and
+// password protected, into password safe databases.
+package bitwarden
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/utils"
+	"github.com/azdagron/pwsafe/v3"
+)
+
+var (
+	Error         = pwsafe.Error
+	IOError       = pwsafe.IOError
+	BadPassphrase = pwsafe.BadPassphrase
+	Corrupted     = pwsafe.Corrupted
+	Unsupported   = pwsafe.Unsupported
+)
+
+// Item types
+const (
+	loginType      = 1
+	secureNoteType = 2
+	cardType       = 3
+	identityType   = 4
+	sshKeyType     = 5
+)
+
+// Custom field types
+const (
+	textFieldType    = 0
+	hiddenFieldType  = 1
+	booleanFieldType = 2
+	linkedFieldType  = 3
+)
+
+type export struct {
+	Encrypted         bool     `json:"encrypted"`
+	PasswordProtected bool     `json:"passwordProtected"`
+	Folders           []folder `json:"folders"`
+	Collections       []folder `json:"collections"`
+	Items             []item   `json:"items"`
+	encryptedExport
+}
+
+type folder struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type item struct {
+	ID              string             `json:"id"`
+	FolderID        *string            `json:"folderId"`
+	CollectionIDs   []string           `json:"collectionIds"`
+	Type            int                `json:"type"`
+	Name            string             `json:"name"`
+	Notes           *string            `json:"notes"`
+	Fields          []field            `json:"fields"`
+	Login           *login             `json:"login"`
+	Card            map[string]*string `json:"card"`
+	Identity        map[string]*string `json:"identity"`
+	SSHKey          map[string]*string `json:"sshKey"`
+	PasswordHistory []passwordHistory  `json:"passwordHistory"`
+	CreationDate    string             `json:"creationDate"`
+	RevisionDate    string             `json:"revisionDate"`
+	DeletedDate     *string            `json:"deletedDate"`
+}
+
+type field struct {
+	Name  string  `json:"name"`
+	Value *string `json:"value"`
+	Type  int     `json:"type"`
+}
+
+type login struct {
+	URIs             []uri             `json:"uris"`
+	Username         *string           `json:"username"`
+	Password         *string           `json:"password"`
+	TOTP             *string           `json:"totp"`
+	FIDO2Credentials []json.RawMessage `json:"fido2Credentials"`
+}
+
+type uri struct {
+	URI *string `json:"uri"`
+}
+
+type passwordHistory struct {
+	LastUsedDate string `json:"lastUsedDate"`
+	Password     string `json:"password"`
+}
+
+// The keys of card, identity and SSH key items, in the order they are
+// converted into custom fields
+var (
+	cardKeys = []string{"cardholderName", "brand", "number", "expMonth",
+		"expYear", "code"}
+	identityKeys = []string{"title", "firstName", "middleName", "lastName",
+		"address1", "address2", "address3", "city", "state", "postalCode",
+		"country", "company", "phone", "ssn", "passportNumber",
+		"licenseNumber"}
+	sshKeyKeys = []string{"privateKey", "publicKey", "keyFingerprint"}
+)
+
+// Open opens a Bitwarden JSON export and converts it into a v3 password safe
+// database. The passphrase callback is only used for password protected
+// exports. Items or parts of items that could not be converted are returned
+// in the unmapped list.
+func Open(path string, passphrase_fn v3.PassphraseFn) (*v3.Database,
+	[]pwsafe.Unmapped, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, IOError.Wrap(err)
+	}
+	defer utils.LogError(f.Close)
+
+	return OpenReader(f, passphrase_fn)
+}
+
+// OpenReader loads a Bitwarden JSON export from the reader and converts it
+// into a v3 password safe database.
+func OpenReader(r io.Reader, passphrase_fn v3.PassphraseFn) (*v3.Database,
+	[]pwsafe.Unmapped, error) {
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, IOError.Wrap(err)
+	}
+
+	var exp export
+	if err = json.Unmarshal(data, &exp); err != nil {
+		return nil, nil, Corrupted.New("invalid export: %s", err)
+	}
+	if exp.Encrypted {
+		if !exp.PasswordProtected {
+			return nil, nil, Unsupported.New(
+				"account restricted encrypted exports; export with a " +
+					"password instead")
+		}
+		data, err = exp.decrypt(passphrase_fn)
+		if err != nil {
+			return nil, nil, err
+		}
+		exp = export{}
+		if err = json.Unmarshal(data, &exp); err != nil {
+			return nil, nil, Corrupted.New("invalid export: %s", err)
+		}
+	}
+	return convert(&exp)
+}
+
+func convert(exp *export) (*v3.Database, []pwsafe.Unmapped, error) {
+	db, err := v3.NewDatabase()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	groups := make(map[string]string)
+	for _, f := range append(exp.Folders, exp.Collections...) {
+		groups[f.ID] = pwsafe.JoinGroup(strings.Split(f.Name, "/")...)
+	}
+
+	var unmapped []pwsafe.Unmapped
+	report := func(it *item, reason string) {
+		unmapped = append(unmapped, pwsafe.Unmapped{
+			Item:   it.Name,
+			Reason: reason,
+		})
+	}
+
+	for i := range exp.Items {
+		it := &exp.Items[i]
+		if it.DeletedDate != nil {
+			report(it, "item is in the trash")
+			continue
+		}
+
+		record, err := v3.NewRecord()
+		if err != nil {
+			return nil, nil, err
+		}
+		record.SetTitle(it.Name)
+		record.SetNotes(str(it.Notes))
+		if it.FolderID != nil {
+			record.SetGroup(groups[*it.FolderID])
+		} else if len(it.CollectionIDs) > 0 {
+			record.SetGroup(groups[it.CollectionIDs[0]])
+		}
+		if t := parseTime(it.CreationDate); !t.IsZero() {
+			record.SetCtime(t)
+		}
+		if t := parseTime(it.RevisionDate); !t.IsZero() {
+			record.SetMtime(t)
+		}
+
+		var custom []pwsafe.CustomField
+		switch it.Type {
+		case loginType:
+			if it.Login == nil {
+				break
+			}
+			record.SetUsername(str(it.Login.Username))
+			record.SetPassword(str(it.Login.Password))
+			record.SetTOTP(str(it.Login.TOTP))
+			for j, u := range it.Login.URIs {
+				if j == 0 {
+					record.SetURL(str(u.URI))
+					continue
+				}
+				custom = append(custom, pwsafe.CustomField{
+					Name:  "URL " + strconv.Itoa(j+1),
+					Value: str(u.URI),
+				})
+			}
+			if len(it.Login.FIDO2Credentials) > 0 {
+				report(it, "passkeys cannot be converted")
+			}
+		case secureNoteType:
+		case cardType:
+			custom = appendObject(custom, it.Card, cardKeys)
+		case identityType:
+			record.SetUsername(str(it.Identity["username"]))
+			record.SetEmail(str(it.Identity["email"]))
+			custom = appendObject(custom, it.Identity, identityKeys)
+		case sshKeyType:
+			custom = appendObject(custom, it.SSHKey, sshKeyKeys)
+		default:
+			report(it, "unknown item type "+strconv.Itoa(it.Type))
+			continue
+		}
+
+		for _, f := range it.Fields {
+			switch f.Type {
+			case textFieldType, hiddenFieldType, booleanFieldType:
+				custom = append(custom, pwsafe.CustomField{
+					Name:  f.Name,
+					Value: str(f.Value),
+				})
+			default:
+				report(it, "linked field "+strconv.Quote(f.Name)+
+					" cannot be converted")
+			}
+		}
+		record.SetCustomFields(custom)
+
+		var history []pwsafe.HistoryEntry
+		for j := len(it.PasswordHistory) - 1; j >= 0; j-- {
+			// bitwarden lists the most recent password first
+			h := it.PasswordHistory[j]
+			history = append(history, pwsafe.HistoryEntry{
+				Time:     parseTime(h.LastUsedDate),
+				Password: h.Password,
+			})
+		}
+		record.SetHistory(history)
+
+		if err = db.AddRecord(record); err != nil {
+			return nil, nil, err
+		}
+	}
+	return db, unmapped, nil
+}
+
+// appendObject appends the non-empty values of the object as custom fields
+func appendObject(custom []pwsafe.CustomField, object map[string]*string,
+	keys []string) []pwsafe.CustomField {
+
+	for _, key := range keys {
+		if value := str(object[key]); value != "" {
+			custom = append(custom, pwsafe.CustomField{
+				Name:  key,
+				Value: value,
+			})
+		}
+	}
+	return custom
+}
+
+func str(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func parseTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,156 @@
+package bitwarden
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"strings"
+
+	"github.com/azdagron/pwsafe/v3"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Key derivation functions
+const (
+	pbkdf2KDF   = 0
+	argon2idKDF = 1
+)
+
+// Upper bounds of the key derivation parameters, those accepted by Bitwarden,
+// so that an export cannot make the import take unbounded time or memory
+const (
+	pbkdf2MaxIterations  = 2000000
+	argon2MaxIterations  = 10
+	argon2MaxMemory      = 1024 // MiB
+	argon2MaxParallelism = 16
+)
+
+// encryptedExport holds the fields of a password protected export
+type encryptedExport struct {
+	Salt           string `json:"salt"`
+	KDFType        int    `json:"kdfType"`
+	KDFIterations  int    `json:"kdfIterations"`
+	KDFMemory      int    `json:"kdfMemory"`
+	KDFParallelism int    `json:"kdfParallelism"`
+	KeyValidation  string `json:"encKeyValidation_DO_NOT_EDIT"`
+	Data           string `json:"data"`
+}
+
+// decrypt derives the key from the passphrase and decrypts the export data
+func (e *encryptedExport) decrypt(passphrase_fn v3.PassphraseFn) (
+	[]byte, error) {
+
+	passphrase, err := passphrase_fn()
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	var key []byte
+	switch e.KDFType {
+	case pbkdf2KDF:
+		if e.KDFIterations <= 0 {
+			return nil, Corrupted.New("invalid kdf iterations")
+		}
+		if e.KDFIterations > pbkdf2MaxIterations {
+			return nil, Unsupported.New("pbkdf2 iterations %d exceed %d",
+				e.KDFIterations, pbkdf2MaxIterations)
+		}
+		key = pbkdf2.Key([]byte(passphrase), []byte(e.Salt), e.KDFIterations,
+			32, sha256.New)
+	case argon2idKDF:
+		if e.KDFIterations <= 0 || e.KDFMemory <= 0 || e.KDFParallelism <= 0 {
+			return nil, Corrupted.New("invalid kdf parameters")
+		}
+		switch {
+		case e.KDFIterations > argon2MaxIterations:
+			return nil, Unsupported.New("argon2 iterations %d exceed %d",
+				e.KDFIterations, argon2MaxIterations)
+		case e.KDFMemory > argon2MaxMemory:
+			return nil, Unsupported.New("argon2 memory of %d MiB exceeds %d",
+				e.KDFMemory, argon2MaxMemory)
+		case e.KDFParallelism > argon2MaxParallelism:
+			return nil, Unsupported.New("argon2 parallelism %d exceeds %d",
+				e.KDFParallelism, argon2MaxParallelism)
+		}
+		salt := sha256.Sum256([]byte(e.Salt))
+		key = argon2.IDKey([]byte(passphrase), salt[:],
+			uint32(e.KDFIterations), uint32(e.KDFMemory)*1024,
+			uint8(e.KDFParallelism), 32)
+	default:
+		return nil, Unsupported.New("kdf type %d", e.KDFType)
+	}
+
+	enc_key, mac_key, err := stretchKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = decryptString(e.KeyValidation, enc_key, mac_key); err != nil {
+		return nil, err
+	}
+	return decryptString(e.Data, enc_key, mac_key)
+}
+
+// stretchKey expands the derived key into the encryption and MAC keys
+func stretchKey(key []byte) (enc_key, mac_key []byte, err error) {
+	enc_key = make([]byte, 32)
+	mac_key = make([]byte, 32)
+	_, err = io.ReadFull(hkdf.Expand(sha256.New, key, []byte("enc")), enc_key)
+	if err != nil {
+		return nil, nil, Error.Wrap(err)
+	}
+	_, err = io.ReadFull(hkdf.Expand(sha256.New, key, []byte("mac")), mac_key)
+	if err != nil {
+		return nil, nil, Error.Wrap(err)
+	}
+	return enc_key, mac_key, nil
+}
+
+// decryptString decrypts a type 2 (AES-256-CBC with HMAC-SHA256) encrypted
+// string of the form "2.iv|data|mac"
+func decryptString(s string, enc_key, mac_key []byte) ([]byte, error) {
+	if !strings.HasPrefix(s, "2.") {
+		return nil, Unsupported.New("encrypted string type")
+	}
+	parts := strings.Split(s[2:], "|")
+	if len(parts) != 3 {
+		return nil, Corrupted.New("invalid encrypted string")
+	}
+	var decoded [3][]byte
+	for i, part := range parts {
+		var err error
+		decoded[i], err = base64.StdEncoding.DecodeString(part)
+		if err != nil {
+			return nil, Corrupted.New("invalid encrypted string: %s", err)
+		}
+	}
+	iv, data, mac := decoded[0], decoded[1], decoded[2]
+
+	hm := hmac.New(sha256.New, mac_key)
+	hm.Write(iv)
+	hm.Write(data)
+	if !hmac.Equal(hm.Sum(nil), mac) {
+		return nil, BadPassphrase.New("passphrase is incorrect")
+	}
+
+	block, err := aes.NewCipher(enc_key)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	if len(iv) != block.BlockSize() || len(data) == 0 ||
+		len(data)%block.BlockSize() != 0 {
+		return nil, Corrupted.New("invalid encrypted string length")
+	}
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, data)
+
+	// remove the PKCS#7 padding
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > block.BlockSize() {
+		return nil, Corrupted.New("invalid padding")
+	}
+	return data[:len(data)-padding], nil
+}
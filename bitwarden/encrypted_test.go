@@ -0,0 +1,38 @@
+package bitwarden
+
+import (
+	"testing"
+)
+
+// TestKDFLimits checks that out of range key derivation parameters from an
+// export are rejected before the key is derived
+func TestKDFLimits(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		export encryptedExport
+	}{
+		{"too many pbkdf2 iterations", encryptedExport{
+			KDFType: pbkdf2KDF, KDFIterations: 1 << 30}},
+		{"too many argon2 iterations", encryptedExport{
+			KDFType: argon2idKDF, KDFIterations: 1 << 30, KDFMemory: 64,
+			KDFParallelism: 4}},
+		{"too much argon2 memory", encryptedExport{
+			KDFType: argon2idKDF, KDFIterations: 3, KDFMemory: 2048,
+			KDFParallelism: 4}},
+		{"overflowing argon2 memory", encryptedExport{
+			KDFType: argon2idKDF, KDFIterations: 3, KDFMemory: 1 << 22,
+			KDFParallelism: 4}},
+		{"too much argon2 parallelism", encryptedExport{
+			KDFType: argon2idKDF, KDFIterations: 3, KDFMemory: 64,
+			KDFParallelism: 255}},
+		{"unknown kdf", encryptedExport{KDFType: 2}},
+	} {
+		_, err := test.export.decrypt(func() (string, error) {
+			return "passphrase", nil
+		})
+		if !Unsupported.Contains(err) {
+			t.Errorf("%s: expected an unsupported error, got %v", test.name,
+				err)
+		}
+	}
+}
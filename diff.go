@@ -0,0 +1,286 @@
+package pwsafe
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChangeKind describes how a record differs between two databases.
+type ChangeKind int
+
+const (
+	// Added records are only in the database after the changes.
+	Added ChangeKind = iota + 1
+
+	// Removed records are only in the database before the changes.
+	Removed
+
+	// Moved records only changed their group.
+	Moved
+
+	// Modified records changed other fields, and possibly their group.
+	Modified
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Moved:
+		return "moved"
+	case Modified:
+		return "modified"
+	}
+	return ""
+}
+
+// FieldChange is a field whose value changed. Values are formatted as text:
+// times in RFC 3339, groups with their elements separated by "/", the
+// history as its number of entries.
+type FieldChange struct {
+	Field string
+	Old   string
+	New   string
+
+	// Secret is true for the password and the TOTP secret
+	Secret bool
+}
+
+// RecordDiff describes a record that differs between two databases.
+type RecordDiff struct {
+	Kind ChangeKind
+	UUID string
+
+	// Title and Group are of the record after the changes, or before if it
+	// was removed
+	Title string
+	Group string
+
+	// Fields are the changed fields. Added and removed records list every
+	// field that is set. The access and modification times are not
+	// compared.
+	Fields []FieldChange
+}
+
+// DatabaseDiff holds the differences between two databases.
+type DatabaseDiff struct {
+	// Header lists the changed name and description
+	Header []FieldChange
+
+	// AddedGroups and RemovedGroups are the changed empty groups
+	AddedGroups   []string
+	RemovedGroups []string
+
+	// Records are the changed records, sorted by group and title
+	Records []RecordDiff
+}
+
+// Empty returns true if the databases do not differ.
+func (d *DatabaseDiff) Empty() bool {
+	return len(d.Header) == 0 && len(d.AddedGroups) == 0 &&
+		len(d.RemovedGroups) == 0 && len(d.Records) == 0
+}
+
+// diffFields lists the compared record fields in the order they are
+// reported. Custom fields follow.
+var diffFields = []string{"title", "group", "username", "password", "url",
+	"email", "notes", "totp", "ctime", "password_mtime", "expiry",
+	"protected"}
+
+// Diff returns the differences between the database before and after
+// changes. Records are matched by UUID.
+func Diff(before, after Database) *DatabaseDiff {
+	diff := &DatabaseDiff{}
+	for _, field := range []struct {
+		name          string
+		before, after string
+	}{
+		{"name", before.Header().Name(), after.Header().Name()},
+		{"description", before.Header().Description(),
+			after.Header().Description()},
+	} {
+		if field.before != field.after {
+			diff.Header = append(diff.Header, FieldChange{
+				Field: field.name,
+				Old:   field.before,
+				New:   field.after,
+			})
+		}
+	}
+	diff.AddedGroups = groupsMissing(after.Header().EmptyGroups(),
+		before.Header().EmptyGroups())
+	diff.RemovedGroups = groupsMissing(before.Header().EmptyGroups(),
+		after.Header().EmptyGroups())
+
+	old_records := make(map[string]Record)
+	for _, record := range before.Records() {
+		old_records[record.UUID()] = record
+	}
+	seen := make(map[string]bool)
+	for _, record := range after.Records() {
+		seen[record.UUID()] = true
+		old_record := old_records[record.UUID()]
+		fields := diffRecord(old_record, record)
+		kind := Modified
+		switch {
+		case old_record == nil:
+			kind = Added
+		case len(fields) == 0:
+			continue
+		case len(fields) == 1 && fields[0].Field == "group":
+			kind = Moved
+		}
+		diff.Records = append(diff.Records, recordDiff(kind, record, fields))
+	}
+	for _, record := range before.Records() {
+		if !seen[record.UUID()] {
+			diff.Records = append(diff.Records, recordDiff(Removed, record,
+				diffRecord(record, nil)))
+		}
+	}
+
+	sort.SliceStable(diff.Records, func(i, j int) bool {
+		a, b := diff.Records[i], diff.Records[j]
+		a_group := strings.Join(SplitGroup(a.Group), "/")
+		b_group := strings.Join(SplitGroup(b.Group), "/")
+		if a_group != b_group {
+			return a_group < b_group
+		}
+		return a.Title < b.Title
+	})
+	return diff
+}
+
+func recordDiff(kind ChangeKind, record Record,
+	fields []FieldChange) RecordDiff {
+
+	return RecordDiff{
+		Kind:   kind,
+		UUID:   record.UUID(),
+		Title:  record.Title(),
+		Group:  record.Group(),
+		Fields: fields,
+	}
+}
+
+// diffRecord returns the changed fields of a record. A nil record has no
+// fields set.
+func diffRecord(before, after Record) []FieldChange {
+	var changes []FieldChange
+	for _, name := range diffFields {
+		old_value := diffValue(before, name)
+		new_value := diffValue(after, name)
+		if old_value != new_value {
+			changes = append(changes, FieldChange{
+				Field:  name,
+				Old:    old_value,
+				New:    new_value,
+				Secret: SecretField(name),
+			})
+		}
+	}
+
+	old_history, new_history := history(before), history(after)
+	if !equalHistory(old_history, new_history) {
+		changes = append(changes, FieldChange{
+			Field: "history",
+			Old:   strconv.Itoa(len(old_history)),
+			New:   strconv.Itoa(len(new_history)),
+		})
+	}
+
+	old_custom := customFieldMap(before)
+	new_custom := customFieldMap(after)
+	var names []string
+	for name := range old_custom {
+		names = append(names, name)
+	}
+	for name := range new_custom {
+		if _, ok := old_custom[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if old_custom[name] != new_custom[name] {
+			changes = append(changes, FieldChange{
+				Field: customFieldPrefix + name,
+				Old:   old_custom[name],
+				New:   new_custom[name],
+			})
+		}
+	}
+	return changes
+}
+
+// diffValue returns the named field of the record formatted as text
+func diffValue(record Record, name string) string {
+	if record == nil {
+		return ""
+	}
+	switch v := recordFields[name].get(record).(type) {
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.UTC().Format(time.RFC3339)
+	case bool:
+		if !v {
+			return ""
+		}
+		return "true"
+	case string:
+		return v
+	}
+	return ""
+}
+
+func history(record Record) []HistoryEntry {
+	if record == nil {
+		return nil
+	}
+	return record.History()
+}
+
+func equalHistory(a, b []HistoryEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Time.Equal(b[i].Time) || a[i].Password != b[i].Password {
+			return false
+		}
+	}
+	return true
+}
+
+func customFieldMap(record Record) map[string]string {
+	fields := make(map[string]string)
+	if record == nil {
+		return fields
+	}
+	for _, field := range record.CustomFields() {
+		fields[field.Name] = field.Value
+	}
+	return fields
+}
+
+// groupsMissing returns the groups that are not in other
+func groupsMissing(groups, other []string) []string {
+	in_other := make(map[string]bool)
+	for _, group := range other {
+		in_other[group] = true
+	}
+	var missing []string
+	for _, group := range groups {
+		if !in_other[group] {
+			missing = append(missing, group)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
@@ -0,0 +1,500 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+// Package fusefs exposes a v3 password safe database as a FUSE filesystem.
+//
+// Groups are directories and every entry is a directory holding the files
+// password, username, url and notes. Tools that can only read secrets from
+// files read them there. File contents are never cached by the kernel, and
+// Unmount wipes the database and open files from memory.
+package fusefs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/v3"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Options configure the filesystem
+type Options struct {
+	// Save saves the database after a change. The filesystem is read-only
+	// if nil.
+	Save func(db *v3.Database) error
+
+	// UID is the only user allowed to access the filesystem, which also
+	// owns every file
+	UID uint32
+
+	// GID is the group owning every file
+	GID uint32
+
+	// UpdateAtime sets the access time of an entry whenever one of its
+	// files is opened for reading. Access times are kept in memory and
+	// saved with the next change, or by SaveAccess on unmount.
+	UpdateAtime bool
+
+	// SaveAccess saves the database on unmount if only access times
+	// changed since the last save. Access times are not saved on their own
+	// if nil.
+	SaveAccess func(db *v3.Database) error
+
+	// AllowOther lets users other than the one mounting the filesystem
+	// reach it; UID still restricts access
+	AllowOther bool
+
+	// Debug logs the FUSE requests
+	Debug bool
+}
+
+// fields are the files of an entry directory
+var fields = []string{"password", "username", "url", "notes"}
+
+// FS is a mounted database
+type FS struct {
+	opts   Options
+	server *fuse.Server
+
+	mu      sync.Mutex
+	db      *v3.Database
+	handles map[*handle]bool
+
+	// accessed is true if access times changed since the last save
+	accessed bool
+}
+
+// Mount mounts the database at the mountpoint. The filesystem owns the
+// database from then on and wipes it on Unmount.
+func Mount(mountpoint string, db *v3.Database, opts Options) (*FS, error) {
+	f := &FS{opts: opts, db: db, handles: make(map[*handle]bool)}
+	root := &dir{fs: f}
+
+	timeout := time.Duration(0)
+	mount_opts := &fs.Options{
+		EntryTimeout: &timeout,
+		AttrTimeout:  &timeout,
+		UID:          opts.UID,
+		GID:          opts.GID,
+		MountOptions: fuse.MountOptions{
+			FsName:      "pwsafe",
+			Name:        "pwsafe",
+			AllowOther:  opts.AllowOther,
+			Options:     []string{"default_permissions"},
+			DirectMount: true,
+			Debug:       opts.Debug,
+		},
+		OnAdd: func(ctx context.Context) {
+			f.build(ctx, root)
+		},
+	}
+	if opts.Save == nil {
+		mount_opts.MountOptions.Options = append(
+			mount_opts.MountOptions.Options, "ro")
+	}
+	server, err := fs.Mount(mountpoint, root, mount_opts)
+	if err != nil {
+		return nil, err
+	}
+	f.server = server
+	return f, nil
+}
+
+// Wait returns when the filesystem is unmounted, also by fusermount -u or
+// umount. Changed access times are saved as by Unmount; SaveAccess reports
+// its errors itself.
+func (f *FS) Wait() {
+	f.server.Wait()
+	_ = f.wipe()
+}
+
+// Unmount unmounts the filesystem, saves changed access times with
+// SaveAccess and wipes the database and the contents of open files
+func (f *FS) Unmount() error {
+	err := f.server.Unmount()
+	if err == nil {
+		err = f.wipe()
+	}
+	return err
+}
+
+func (f *FS) wipe() (err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for h := range f.handles {
+		h.wipe()
+	}
+	f.handles = make(map[*handle]bool)
+	if f.db != nil {
+		if f.accessed && f.opts.SaveAccess != nil {
+			err = f.opts.SaveAccess(f.db)
+		}
+		f.accessed = false
+		f.db.Wipe()
+		f.db = nil
+	}
+	return err
+}
+
+// build adds the groups and entries of the database below the root
+func (f *FS) build(ctx context.Context, root *dir) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dirs := map[string]*fs.Inode{"": root.EmbeddedInode()}
+	var groupDir func(group string) *fs.Inode
+	groupDir = func(group string) *fs.Inode {
+		if inode, ok := dirs[group]; ok {
+			return inode
+		}
+		elements := pwsafe.SplitGroup(group)
+		parent := groupDir(pwsafe.JoinGroup(elements[:len(elements)-1]...))
+		inode := parent.NewPersistentInode(ctx, &dir{fs: f},
+			fs.StableAttr{Mode: syscall.S_IFDIR})
+		parent.AddChild(fileName(elements[len(elements)-1]), inode, false)
+		dirs[group] = inode
+		return inode
+	}
+	for _, group := range f.db.Header().EmptyGroups() {
+		groupDir(group)
+	}
+	for _, record := range f.db.Records() {
+		groupDir(record.Group())
+	}
+
+	for _, r := range f.db.Records() {
+		record := r.(*v3.Record)
+		parent := groupDir(record.Group())
+		name := fileName(record.Title())
+		if name == "" || parent.GetChild(name) != nil {
+			// titles are not unique; the UUID tells the entries apart
+			name = strings.TrimSpace(fmt.Sprintf("%s [%s]", name,
+				record.UUID()[:8]))
+		}
+		entry := parent.NewPersistentInode(ctx, &dir{fs: f},
+			fs.StableAttr{Mode: syscall.S_IFDIR})
+		parent.AddChild(name, entry, false)
+		for _, field := range fields {
+			inode := entry.NewPersistentInode(ctx,
+				&file{fs: f, uuid: record.UUID(), field: field},
+				fs.StableAttr{Mode: syscall.S_IFREG})
+			entry.AddChild(field, inode, false)
+		}
+	}
+}
+
+// fileName returns a group element or title usable as a file name
+func fileName(name string) string {
+	name = strings.Replace(name, "/", "_", -1)
+	if name == "." || name == ".." {
+		return strings.Replace(name, ".", "_", -1)
+	}
+	return name
+}
+
+// allowed returns true if the request comes from the user of the
+// filesystem
+func (f *FS) allowed(ctx context.Context) bool {
+	caller, ok := fuse.FromContext(ctx)
+	return ok && caller.Uid == f.opts.UID
+}
+
+// dir is a group or entry directory
+type dir struct {
+	fs.Inode
+	fs *FS
+}
+
+var _ = (fs.NodeLookuper)((*dir)(nil))
+var _ = (fs.NodeOpendirer)((*dir)(nil))
+var _ = (fs.NodeGetattrer)((*dir)(nil))
+
+func (d *dir) Lookup(ctx context.Context, name string,
+	out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+
+	if !d.fs.allowed(ctx) {
+		return nil, syscall.EACCES
+	}
+	child := d.GetChild(name)
+	if child == nil {
+		return nil, syscall.ENOENT
+	}
+	var attr fuse.AttrOut
+	if errno := child.Operations().(fs.NodeGetattrer).Getattr(ctx, nil,
+		&attr); errno != 0 {
+		return nil, errno
+	}
+	out.Attr = attr.Attr
+	return child, fs.OK
+}
+
+func (d *dir) Opendir(ctx context.Context) syscall.Errno {
+	if !d.fs.allowed(ctx) {
+		return syscall.EACCES
+	}
+	return fs.OK
+}
+
+func (d *dir) Getattr(ctx context.Context, f fs.FileHandle,
+	out *fuse.AttrOut) syscall.Errno {
+
+	// mounting stats the root as the user mounting the filesystem
+	if !d.fs.allowed(ctx) && !d.IsRoot() {
+		return syscall.EACCES
+	}
+	out.Mode = syscall.S_IFDIR | 0500
+	out.Uid, out.Gid = d.fs.opts.UID, d.fs.opts.GID
+	return fs.OK
+}
+
+// file is a field of an entry
+type file struct {
+	fs.Inode
+	fs    *FS
+	uuid  string
+	field string
+}
+
+var _ = (fs.NodeGetattrer)((*file)(nil))
+var _ = (fs.NodeSetattrer)((*file)(nil))
+var _ = (fs.NodeOpener)((*file)(nil))
+var _ = (fs.NodeReader)((*file)(nil))
+var _ = (fs.NodeWriter)((*file)(nil))
+var _ = (fs.NodeFlusher)((*file)(nil))
+var _ = (fs.NodeReleaser)((*file)(nil))
+
+// value returns the field of the record. The password of an alias is the
+// password of its base record. Must be called with the lock held.
+func (n *file) value() (string, *v3.Record, syscall.Errno) {
+	if n.fs.db == nil {
+		return "", nil, syscall.EIO
+	}
+	record := n.fs.db.Record(n.uuid)
+	if record == nil {
+		return "", nil, syscall.ENOENT
+	}
+	switch n.field {
+	case "password":
+		if base, _ := n.fs.db.Base(record); base != nil {
+			return base.Password(), record, fs.OK
+		}
+		return record.Password(), record, fs.OK
+	case "username":
+		return record.Username(), record, fs.OK
+	case "url":
+		return record.URL(), record, fs.OK
+	default:
+		return record.Notes(), record, fs.OK
+	}
+}
+
+// set changes the field of the record and saves the database. Must be
+// called with the lock held.
+func (n *file) set(value string) syscall.Errno {
+	_, record, errno := n.value()
+	if errno != 0 {
+		return errno
+	}
+	if record.Protected() {
+		return syscall.EPERM
+	}
+	switch n.field {
+	case "password":
+		if base, _ := n.fs.db.Base(record); base != nil {
+			// the password belongs to the base record
+			return syscall.EPERM
+		}
+		record.ChangePassword(value)
+	case "username":
+		record.SetUsername(value)
+	case "url":
+		record.SetURL(value)
+	default:
+		record.SetNotes(value)
+	}
+	record.SetMtime(time.Now())
+	if err := n.fs.opts.Save(n.fs.db); err != nil {
+		return syscall.EIO
+	}
+	n.fs.accessed = false
+	return fs.OK
+}
+
+func (n *file) Getattr(ctx context.Context, f fs.FileHandle,
+	out *fuse.AttrOut) syscall.Errno {
+
+	if !n.fs.allowed(ctx) {
+		return syscall.EACCES
+	}
+	n.fs.mu.Lock()
+	defer n.fs.mu.Unlock()
+	value, record, errno := n.value()
+	if errno != 0 {
+		return errno
+	}
+	out.Mode = syscall.S_IFREG | 0400
+	if n.fs.opts.Save != nil {
+		out.Mode |= 0200
+	}
+	out.Uid, out.Gid = n.fs.opts.UID, n.fs.opts.GID
+	out.Size = uint64(len(value))
+	if h, ok := f.(*handle); ok {
+		out.Size = uint64(len(h.data))
+	}
+	if mtime := record.Mtime(); !mtime.IsZero() {
+		out.SetTimes(nil, &mtime, &mtime)
+	}
+	return fs.OK
+}
+
+// Setattr only supports truncating, which editors and shell redirections
+// do before writing
+func (n *file) Setattr(ctx context.Context, f fs.FileHandle,
+	in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+
+	if !n.fs.allowed(ctx) {
+		return syscall.EACCES
+	}
+	if size, ok := in.GetSize(); ok {
+		if n.fs.opts.Save == nil {
+			return syscall.EROFS
+		}
+		n.fs.mu.Lock()
+		if h, ok := f.(*handle); ok {
+			h.truncate(int(size))
+		} else {
+			value, _, errno := n.value()
+			if errno == 0 && int(size) < len(value) {
+				errno = n.set(value[:size])
+			}
+			if errno != 0 {
+				n.fs.mu.Unlock()
+				return errno
+			}
+		}
+		n.fs.mu.Unlock()
+	}
+	return n.Getattr(ctx, f, out)
+}
+
+func (n *file) Open(ctx context.Context, flags uint32) (fs.FileHandle,
+	uint32, syscall.Errno) {
+
+	if !n.fs.allowed(ctx) {
+		return nil, 0, syscall.EACCES
+	}
+	writing := flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0
+	if writing && n.fs.opts.Save == nil {
+		return nil, 0, syscall.EROFS
+	}
+
+	n.fs.mu.Lock()
+	defer n.fs.mu.Unlock()
+	value, record, errno := n.value()
+	if errno != 0 {
+		return nil, 0, errno
+	}
+	h := &handle{data: []byte(value)}
+	if writing && flags&syscall.O_TRUNC != 0 {
+		h.truncate(0)
+	}
+	if flags&syscall.O_WRONLY == 0 && n.fs.opts.UpdateAtime {
+		record.SetAtime(time.Now())
+		n.fs.accessed = true
+	}
+	n.fs.handles[h] = true
+	// keep secrets out of the page cache
+	return h, fuse.FOPEN_DIRECT_IO, fs.OK
+}
+
+func (n *file) Read(ctx context.Context, f fs.FileHandle, dest []byte,
+	off int64) (fuse.ReadResult, syscall.Errno) {
+
+	n.fs.mu.Lock()
+	defer n.fs.mu.Unlock()
+	h := f.(*handle)
+	if off >= int64(len(h.data)) {
+		return fuse.ReadResultData(nil), fs.OK
+	}
+	end := off + int64(len(dest))
+	if end > int64(len(h.data)) {
+		end = int64(len(h.data))
+	}
+	return fuse.ReadResultData(append([]byte(nil), h.data[off:end]...)),
+		fs.OK
+}
+
+func (n *file) Write(ctx context.Context, f fs.FileHandle, data []byte,
+	off int64) (uint32, syscall.Errno) {
+
+	n.fs.mu.Lock()
+	defer n.fs.mu.Unlock()
+	h := f.(*handle)
+	if end := int(off) + len(data); end > len(h.data) {
+		h.truncate(end)
+	}
+	copy(h.data[off:], data)
+	h.dirty = true
+	return uint32(len(data)), fs.OK
+}
+
+// Flush saves the written contents of the file. A trailing newline, as
+// added by editors and echo, is not part of the value.
+func (n *file) Flush(ctx context.Context, f fs.FileHandle) syscall.Errno {
+	n.fs.mu.Lock()
+	defer n.fs.mu.Unlock()
+	h := f.(*handle)
+	if !h.dirty {
+		return fs.OK
+	}
+	h.dirty = false
+	value := string(h.data)
+	if n.field != "notes" {
+		value = strings.TrimRight(value, "\r\n")
+	}
+	return n.set(value)
+}
+
+func (n *file) Release(ctx context.Context, f fs.FileHandle) syscall.Errno {
+	n.fs.mu.Lock()
+	defer n.fs.mu.Unlock()
+	h := f.(*handle)
+	h.wipe()
+	delete(n.fs.handles, h)
+	return fs.OK
+}
+
+// handle is an open file, holding a copy of the field
+type handle struct {
+	data  []byte
+	dirty bool
+}
+
+// truncate sets the length of the data, wiping what is cut off
+func (h *handle) truncate(size int) {
+	h.dirty = true
+	if size <= len(h.data) {
+		for i := size; i < len(h.data); i++ {
+			h.data[i] = 0
+		}
+		h.data = h.data[:size]
+		return
+	}
+	data := make([]byte, size)
+	copy(data, h.data)
+	h.wipe()
+	h.data = data
+}
+
+func (h *handle) wipe() {
+	for i := range h.data {
+		h.data[i] = 0
+	}
+	h.data = nil
+}
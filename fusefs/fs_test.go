@@ -0,0 +1,153 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package fusefs
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/azdagron/pwsafe/v3"
+)
+
+// newTestDatabase returns a database with the entry ops/aws/AWS prod and the
+// alias Console of its password
+func newTestDatabase(t *testing.T) (*v3.Database, *v3.Record) {
+	db, err := v3.NewDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := v3.NewRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	base.SetTitle("AWS prod")
+	base.SetGroup("ops.aws")
+	base.SetUsername("admin")
+	base.SetPassword("secret")
+	alias, err := v3.NewRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alias.SetTitle("Console")
+	alias.SetPassword("[[" + base.UUID() + "]]")
+	for _, record := range []*v3.Record{base, alias} {
+		if err = db.AddRecord(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db, base
+}
+
+// mount mounts the database in a temporary directory. The test is skipped
+// where FUSE filesystems cannot be mounted.
+func mount(t *testing.T, db *v3.Database, opts Options) (*FS, string) {
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Skip("no /dev/fuse")
+	}
+	_, err := exec.LookPath("fusermount")
+	if err != nil && os.Geteuid() != 0 {
+		t.Skip("no fusermount")
+	}
+	mountpoint := t.TempDir()
+	f, err := Mount(mountpoint, db, opts)
+	if err != nil {
+		t.Skipf("unable to mount: %v", err)
+	}
+	t.Cleanup(func() { _ = f.Unmount() })
+	return f, mountpoint
+}
+
+func TestRead(t *testing.T) {
+	db, _ := newTestDatabase(t)
+	_, mountpoint := mount(t, db, Options{UID: uint32(os.Getuid())})
+
+	for path, expected := range map[string]string{
+		"ops/aws/AWS prod/password": "secret",
+		"ops/aws/AWS prod/username": "admin",
+		"Console/password":          "secret",
+	} {
+		data, err := os.ReadFile(filepath.Join(mountpoint, path))
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+		} else if string(data) != expected {
+			t.Errorf("%s: expected %q, got %q", path, expected, data)
+		}
+	}
+
+	err := os.WriteFile(filepath.Join(mountpoint, "Console/username"),
+		[]byte("root"), 0600)
+	if !errors.Is(err, syscall.EROFS) {
+		t.Errorf("expected a read-only filesystem, got %v", err)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	db, base := newTestDatabase(t)
+	var saved int
+	f, mountpoint := mount(t, db, Options{
+		UID: uint32(os.Getuid()),
+		Save: func(db *v3.Database) error {
+			saved++
+			return nil
+		},
+	})
+
+	path := filepath.Join(mountpoint, "ops/aws/AWS prod/password")
+	if err := os.WriteFile(path, []byte("changed\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	if base.Password() != "changed" || saved == 0 {
+		t.Errorf("expected the password to be saved, got %q after %d saves",
+			base.Password(), saved)
+	}
+	f.mu.Unlock()
+	data, err := os.ReadFile(filepath.Join(mountpoint, "Console/password"))
+	if err != nil || string(data) != "changed" {
+		t.Errorf("expected the alias to follow, got %q, %v", data, err)
+	}
+
+	// the password of an alias belongs to its base
+	err = os.WriteFile(filepath.Join(mountpoint, "Console/password"),
+		[]byte("other"), 0600)
+	f.mu.Lock()
+	if err == nil || base.Password() != "changed" {
+		t.Errorf("expected writing the alias password to fail, got %v", err)
+	}
+	f.mu.Unlock()
+}
+
+func TestUID(t *testing.T) {
+	db, _ := newTestDatabase(t)
+	_, mountpoint := mount(t, db, Options{UID: uint32(os.Getuid()) + 1})
+
+	_, err := os.ReadFile(filepath.Join(mountpoint, "Console/password"))
+	if !errors.Is(err, syscall.EACCES) {
+		t.Errorf("expected access to be denied, got %v", err)
+	}
+}
+
+func TestUnmount(t *testing.T) {
+	db, _ := newTestDatabase(t)
+	f, mountpoint := mount(t, db, Options{UID: uint32(os.Getuid())})
+
+	path := filepath.Join(mountpoint, "Console/password")
+	if _, err := os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Unmount(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.ReadFile(path); err == nil {
+		t.Error("expected the filesystem to be unmounted")
+	}
+	if len(db.Records()) != 0 || f.db != nil {
+		t.Errorf("expected the database to be wiped, got %d entries",
+			len(db.Records()))
+	}
+}
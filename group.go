@@ -0,0 +1,37 @@
+package pwsafe
+
+import "strings"
+
+// SplitGroup splits a group path into its elements. Group elements are
+// separated by a period. Periods inside of an element are escaped with a
+// backslash.
+func SplitGroup(group string) []string {
+	if group == "" {
+		return nil
+	}
+	var elems []string
+	var elem []byte
+	for i := 0; i < len(group); i++ {
+		switch {
+		case group[i] == '\\' && i+1 < len(group) && group[i+1] == '.':
+			elem = append(elem, '.')
+			i++
+		case group[i] == '.':
+			elems = append(elems, string(elem))
+			elem = elem[:0]
+		default:
+			elem = append(elem, group[i])
+		}
+	}
+	return append(elems, string(elem))
+}
+
+// JoinGroup joins group elements into a group path, escaping any periods
+// inside of the elements.
+func JoinGroup(elems ...string) string {
+	escaped := make([]string, 0, len(elems))
+	for _, elem := range elems {
+		escaped = append(escaped, strings.Replace(elem, ".", "\\.", -1))
+	}
+	return strings.Join(escaped, ".")
+}
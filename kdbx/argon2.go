@@ -0,0 +1,335 @@
+package kdbx
+
+import (
+	"encoding/binary"
+	"hash"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// golang.org/x/crypto/argon2 only provides Argon2i and Argon2id, but KeePass
+// databases commonly use Argon2d. This is a straightforward implementation of
+// RFC 9106 supporting both Argon2d and Argon2id.
+
+const (
+	argon2d  = 0
+	argon2id = 2
+
+	argon2BlockLength = 128 // in uint64 words (1024 bytes)
+	argon2SyncPoints  = 4
+)
+
+type argon2Block [argon2BlockLength]uint64
+
+// argon2Key derives a key of key_len bytes.
+func argon2Key(mode int, password, salt, secret, data []byte, time,
+	memory, threads, version, key_len uint32) []byte {
+
+	if time < 1 {
+		time = 1
+	}
+	if threads < 1 {
+		threads = 1
+	}
+	// memory is in KiB; each lane needs at least two blocks per segment
+	if memory < 2*argon2SyncPoints*threads {
+		memory = 2 * argon2SyncPoints * threads
+	}
+	// the initial hash uses the requested memory, not the rounded amount
+	h0 := argon2InitHash(mode, password, salt, secret, data, time, memory,
+		threads, version, key_len)
+	memory = memory / (argon2SyncPoints * threads) * (argon2SyncPoints * threads)
+
+	blocks := argon2InitBlocks(&h0, memory, threads)
+	argon2ProcessBlocks(mode, blocks, time, memory, threads, version)
+	return argon2ExtractKey(blocks, memory, threads, key_len)
+}
+
+func argon2InitHash(mode int, password, salt, secret, data []byte, time,
+	memory, threads, version, key_len uint32) [blake2b.Size + 8]byte {
+
+	var h0 [blake2b.Size + 8]byte
+	h, _ := blake2b.New512(nil)
+	write_uint32 := func(v uint32) {
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], v)
+		h.Write(b[:])
+	}
+	write_bytes := func(b []byte) {
+		write_uint32(uint32(len(b)))
+		h.Write(b)
+	}
+	write_uint32(threads)
+	write_uint32(key_len)
+	write_uint32(memory)
+	write_uint32(time)
+	write_uint32(version)
+	write_uint32(uint32(mode))
+	write_bytes(password)
+	write_bytes(salt)
+	write_bytes(secret)
+	write_bytes(data)
+	h.Sum(h0[:0])
+	return h0
+}
+
+func argon2InitBlocks(h0 *[blake2b.Size + 8]byte, memory,
+	threads uint32) []argon2Block {
+
+	var block0 [1024]byte
+	blocks := make([]argon2Block, memory)
+	for lane := uint32(0); lane < threads; lane++ {
+		j := lane * (memory / threads)
+		binary.LittleEndian.PutUint32(h0[blake2b.Size+4:], lane)
+
+		binary.LittleEndian.PutUint32(h0[blake2b.Size:], 0)
+		argon2Hash(block0[:], h0[:])
+		for i := range blocks[j] {
+			blocks[j][i] = binary.LittleEndian.Uint64(block0[i*8:])
+		}
+
+		binary.LittleEndian.PutUint32(h0[blake2b.Size:], 1)
+		argon2Hash(block0[:], h0[:])
+		for i := range blocks[j+1] {
+			blocks[j+1][i] = binary.LittleEndian.Uint64(block0[i*8:])
+		}
+	}
+	return blocks
+}
+
+func argon2ProcessBlocks(mode int, blocks []argon2Block, time, memory,
+	threads, version uint32) {
+
+	lanes := memory / threads
+	segments := lanes / argon2SyncPoints
+
+	for n := uint32(0); n < time; n++ {
+		for slice := uint32(0); slice < argon2SyncPoints; slice++ {
+			// lanes are processed sequentially; the result is the same as
+			// processing them in parallel within a slice
+			for lane := uint32(0); lane < threads; lane++ {
+				argon2ProcessSegment(mode, blocks, n, slice, lane, time,
+					memory, threads, lanes, segments, version)
+			}
+		}
+	}
+}
+
+func argon2ProcessSegment(mode int, blocks []argon2Block, n, slice, lane,
+	time, memory, threads, lanes, segments, version uint32) {
+
+	var addresses, in, zero argon2Block
+	data_independent := mode == argon2id && n == 0 &&
+		slice < argon2SyncPoints/2
+	if data_independent {
+		in[0] = uint64(n)
+		in[1] = uint64(lane)
+		in[2] = uint64(slice)
+		in[3] = uint64(memory)
+		in[4] = uint64(time)
+		in[5] = uint64(mode)
+	}
+
+	index := uint32(0)
+	if n == 0 && slice == 0 {
+		index = 2 // the first two blocks were initialized
+		if data_independent {
+			in[6]++
+			argon2ProcessBlock(&addresses, &in, &zero)
+			argon2ProcessBlock(&addresses, &addresses, &zero)
+		}
+	}
+
+	offset := lane*lanes + slice*segments + index
+	for index < segments {
+		prev := offset - 1
+		if index == 0 && slice == 0 {
+			prev += lanes // last block of the lane
+		}
+
+		var random uint64
+		if data_independent {
+			if index%argon2BlockLength == 0 {
+				in[6]++
+				argon2ProcessBlock(&addresses, &in, &zero)
+				argon2ProcessBlock(&addresses, &addresses, &zero)
+			}
+			random = addresses[index%argon2BlockLength]
+		} else {
+			random = blocks[prev][0]
+		}
+
+		new_offset := argon2IndexAlpha(random, lanes, segments, threads, n,
+			slice, lane, index)
+		if n == 0 || version == 0x10 {
+			argon2ProcessBlock(&blocks[offset], &blocks[prev],
+				&blocks[new_offset])
+		} else {
+			argon2ProcessBlockXOR(&blocks[offset], &blocks[prev],
+				&blocks[new_offset])
+		}
+		index, offset = index+1, offset+1
+	}
+}
+
+func argon2IndexAlpha(rand uint64, lanes, segments, threads, n, slice, lane,
+	index uint32) uint32 {
+
+	ref_lane := uint32(rand>>32) % threads
+	if n == 0 && slice == 0 {
+		ref_lane = lane
+	}
+	m, s := 3*segments, ((slice+1)%argon2SyncPoints)*segments
+	if lane == ref_lane {
+		m += index
+	}
+	if n == 0 {
+		m, s = slice*segments, 0
+		if slice == 0 || lane == ref_lane {
+			m += index
+		}
+	}
+	if index == 0 || lane == ref_lane {
+		m--
+	}
+	return argon2Phi(rand, uint64(m), uint64(s), ref_lane, lanes)
+}
+
+func argon2Phi(rand, m, s uint64, lane, lanes uint32) uint32 {
+	p := rand & 0xFFFFFFFF
+	p = (p * p) >> 32
+	p = (p * m) >> 32
+	return lane*lanes + uint32((s+m-(p+1))%uint64(lanes))
+}
+
+func argon2ExtractKey(blocks []argon2Block, memory, threads,
+	key_len uint32) []byte {
+
+	lanes := memory / threads
+	for lane := uint32(0); lane < threads-1; lane++ {
+		for i, v := range blocks[(lane*lanes)+lanes-1] {
+			blocks[memory-1][i] ^= v
+		}
+	}
+
+	var block [1024]byte
+	for i, v := range blocks[memory-1] {
+		binary.LittleEndian.PutUint64(block[i*8:], v)
+	}
+	key := make([]byte, key_len)
+	argon2Hash(key, block[:])
+	return key
+}
+
+// argon2Hash is the variable length hash function H' from the RFC
+func argon2Hash(out []byte, in []byte) {
+	var b [4]byte
+	var h hash.Hash
+	binary.LittleEndian.PutUint32(b[:], uint32(len(out)))
+
+	if len(out) <= blake2b.Size {
+		h, _ = blake2b.New(len(out), nil)
+		h.Write(b[:])
+		h.Write(in)
+		h.Sum(out[:0])
+		return
+	}
+
+	h, _ = blake2b.New512(nil)
+	h.Write(b[:])
+	h.Write(in)
+	var v [blake2b.Size]byte
+	h.Sum(v[:0])
+	copy(out, v[:32])
+	out = out[32:]
+	for len(out) > blake2b.Size {
+		h.Reset()
+		h.Write(v[:])
+		h.Sum(v[:0])
+		copy(out, v[:32])
+		out = out[32:]
+	}
+	h, _ = blake2b.New(len(out), nil)
+	h.Write(v[:])
+	h.Sum(out[:0])
+}
+
+func argon2ProcessBlock(out, in1, in2 *argon2Block) {
+	argon2ProcessBlockGeneric(out, in1, in2, false)
+}
+
+func argon2ProcessBlockXOR(out, in1, in2 *argon2Block) {
+	argon2ProcessBlockGeneric(out, in1, in2, true)
+}
+
+func argon2ProcessBlockGeneric(out, in1, in2 *argon2Block, xor bool) {
+	var t argon2Block
+	for i := range t {
+		t[i] = in1[i] ^ in2[i]
+	}
+	for i := 0; i < argon2BlockLength; i += 16 {
+		argon2BlamkaGeneric(
+			&t[i+0], &t[i+1], &t[i+2], &t[i+3],
+			&t[i+4], &t[i+5], &t[i+6], &t[i+7],
+			&t[i+8], &t[i+9], &t[i+10], &t[i+11],
+			&t[i+12], &t[i+13], &t[i+14], &t[i+15],
+		)
+	}
+	for i := 0; i < argon2BlockLength/8; i += 2 {
+		argon2BlamkaGeneric(
+			&t[i], &t[i+1], &t[16+i], &t[16+i+1],
+			&t[32+i], &t[32+i+1], &t[48+i], &t[48+i+1],
+			&t[64+i], &t[64+i+1], &t[80+i], &t[80+i+1],
+			&t[96+i], &t[96+i+1], &t[112+i], &t[112+i+1],
+		)
+	}
+	if xor {
+		for i := range t {
+			out[i] ^= in1[i] ^ in2[i] ^ t[i]
+		}
+	} else {
+		for i := range t {
+			out[i] = in1[i] ^ in2[i] ^ t[i]
+		}
+	}
+}
+
+func argon2BlamkaGeneric(t00, t01, t02, t03, t04, t05, t06, t07, t08, t09,
+	t10, t11, t12, t13, t14, t15 *uint64) {
+
+	v00, v01, v02, v03 := *t00, *t01, *t02, *t03
+	v04, v05, v06, v07 := *t04, *t05, *t06, *t07
+	v08, v09, v10, v11 := *t08, *t09, *t10, *t11
+	v12, v13, v14, v15 := *t12, *t13, *t14, *t15
+
+	v00, v04, v08, v12 = argon2G(v00, v04, v08, v12)
+	v01, v05, v09, v13 = argon2G(v01, v05, v09, v13)
+	v02, v06, v10, v14 = argon2G(v02, v06, v10, v14)
+	v03, v07, v11, v15 = argon2G(v03, v07, v11, v15)
+
+	v00, v05, v10, v15 = argon2G(v00, v05, v10, v15)
+	v01, v06, v11, v12 = argon2G(v01, v06, v11, v12)
+	v02, v07, v08, v13 = argon2G(v02, v07, v08, v13)
+	v03, v04, v09, v14 = argon2G(v03, v04, v09, v14)
+
+	*t00, *t01, *t02, *t03 = v00, v01, v02, v03
+	*t04, *t05, *t06, *t07 = v04, v05, v06, v07
+	*t08, *t09, *t10, *t11 = v08, v09, v10, v11
+	*t12, *t13, *t14, *t15 = v12, v13, v14, v15
+}
+
+func argon2G(a, b, c, d uint64) (uint64, uint64, uint64, uint64) {
+	a += b + 2*uint64(uint32(a))*uint64(uint32(b))
+	d = rotr64(d^a, 32)
+	c += d + 2*uint64(uint32(c))*uint64(uint32(d))
+	b = rotr64(b^c, 24)
+	a += b + 2*uint64(uint32(a))*uint64(uint32(b))
+	d = rotr64(d^a, 16)
+	c += d + 2*uint64(uint32(c))*uint64(uint32(d))
+	b = rotr64(b^c, 63)
+	return a, b, c, d
+}
+
+func rotr64(v uint64, n uint) uint64 {
+	return v>>n | v<<(64-n)
+}
@@ -0,0 +1,90 @@
+package kdbx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// TestArgon2RFC9106 checks the test vectors of RFC 9106, section 5
+func TestArgon2RFC9106(t *testing.T) {
+	password := bytes.Repeat([]byte{0x01}, 32)
+	salt := bytes.Repeat([]byte{0x02}, 16)
+	secret := bytes.Repeat([]byte{0x03}, 8)
+	data := bytes.Repeat([]byte{0x04}, 12)
+
+	for _, test := range []struct {
+		name string
+		mode int
+		tag  string
+	}{
+		{"argon2d", argon2d, "512b391b6f1162975371d30919734294" +
+			"f868e3be3984f3c1a13a4db9fabe4acb"},
+		{"argon2id", argon2id, "0d640df58d78766c08c037a34a8b53c9" +
+			"d01ef0452d75b65eb52520e96b01e659"},
+	} {
+		key := argon2Key(test.mode, password, salt, secret, data, 3, 32, 4,
+			argon2Version, 32)
+		if got := hex.EncodeToString(key); got != test.tag {
+			t.Errorf("%s: expected %s, got %s", test.name, test.tag, got)
+		}
+	}
+}
+
+// TestArgon2IDKey compares Argon2id without secret and associated data with
+// golang.org/x/crypto/argon2
+func TestArgon2IDKey(t *testing.T) {
+	password, salt := []byte("password"), []byte("somesalt")
+	for _, test := range []struct {
+		time, memory uint32
+		threads      uint8
+		key_len      uint32
+	}{
+		{1, 8, 1, 32},
+		{2, 64, 1, 32},
+		{3, 256, 2, 64},
+		{1, 1024, 4, 16},
+		{2, 100, 3, 100},
+	} {
+		expected := argon2.IDKey(password, salt, test.time, test.memory,
+			test.threads, test.key_len)
+		key := argon2Key(argon2id, password, salt, nil, nil, test.time,
+			test.memory, uint32(test.threads), argon2Version, test.key_len)
+		if !bytes.Equal(key, expected) {
+			t.Errorf("%+v: expected %x, got %x", test, expected, key)
+		}
+	}
+}
+
+// TestArgon2Limits checks that out of range parameters from a header are
+// rejected before any memory is allocated
+func TestArgon2Limits(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		parallelism uint32
+		memory      uint64
+		version     uint32
+	}{
+		{"no parallelism", 0, 64 * 1024, argon2Version},
+		{"overflowing parallelism", 1 << 30, 64 * 1024, argon2Version},
+		{"too much parallelism for the memory cap", 1<<24 - 1, 1024,
+			argon2Version},
+		{"unknown version", 1, 64 * 1024, 0x12},
+		{"too much memory", 1, 1 << 42, argon2Version},
+	} {
+		header := &outerHeader{major: version4}
+		header.kdf.setBytes(kdfUUIDKey, kdfArgon2d)
+		header.kdf.setBytes(kdfSeedKey, make([]byte, 32))
+		header.kdf.setUint32(kdfParallelismKey, test.parallelism)
+		header.kdf.setUint64(kdfMemoryKey, test.memory)
+		header.kdf.setUint64(kdfIterationsKey, 1)
+		header.kdf.setUint32(kdfVersionKey, test.version)
+		_, err := header.transformKey(make([]byte, 32))
+		if !Unsupported.Contains(err) {
+			t.Errorf("%s: expected an unsupported error, got %v", test.name,
+				err)
+		}
+	}
+}
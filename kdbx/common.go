@@ -0,0 +1,143 @@
+// Package kdbx converts between KeePass KDBX databases and password safe
+// databases. KDBX 3.1 and 4.x databases protected by a passphrase can be read,
+// and KDBX 4.0 databases are written.
+package kdbx
+
+import (
+	"crypto/aes"
+	"crypto/sha256"
+	"time"
+
+	"github.com/azdagron/pwsafe"
+)
+
+var (
+	Error         = pwsafe.Error
+	IOError       = pwsafe.IOError
+	BadPassphrase = pwsafe.BadPassphrase
+	BadTag        = pwsafe.BadTag
+	Corrupted     = pwsafe.Corrupted
+	Unsupported   = pwsafe.Unsupported
+)
+
+const (
+	signature1 uint32 = 0x9aa2d903
+	signature2 uint32 = 0xb54bfb67
+
+	version3 uint16 = 3
+	version4 uint16 = 4
+
+	// Outer header fields
+	endOfHeader         byte = 0x00
+	cipherIDHeader      byte = 0x02
+	compressionHeader   byte = 0x03
+	masterSeedHeader    byte = 0x04
+	transformSeedHeader byte = 0x05
+	transformRounds     byte = 0x06
+	encryptionIVHeader  byte = 0x07
+	protectedStreamKey  byte = 0x08
+	streamStartBytes    byte = 0x09
+	innerRandomStreamID byte = 0x0a
+	kdfParametersHeader byte = 0x0b
+	publicCustomData    byte = 0x0c
+
+	// Inner header fields (KDBX 4)
+	innerEndOfHeader       byte = 0x00
+	innerRandomStreamIDHdr byte = 0x01
+	innerRandomStreamKey   byte = 0x02
+	innerBinaryHeader      byte = 0x03
+
+	// Compression algorithms
+	compressionNone uint32 = 0
+	compressionGzip uint32 = 1
+
+	// Inner random stream algorithms
+	streamNone     uint32 = 0
+	streamSalsa20  uint32 = 2
+	streamChaCha20 uint32 = 3
+
+	// Variant dictionary value types
+	vdVersion   uint16 = 0x0100
+	vdEnd       byte   = 0x00
+	vdUint32    byte   = 0x04
+	vdUint64    byte   = 0x05
+	vdBool      byte   = 0x08
+	vdInt32     byte   = 0x0c
+	vdInt64     byte   = 0x0d
+	vdString    byte   = 0x18
+	vdByteArray byte   = 0x42
+
+	// Variant dictionary keys for the KDF parameters
+	kdfUUIDKey        = "$UUID"
+	kdfRoundsKey      = "R"
+	kdfSeedKey        = "S"
+	kdfParallelismKey = "P"
+	kdfMemoryKey      = "M"
+	kdfIterationsKey  = "I"
+	kdfVersionKey     = "V"
+	kdfSecretKey      = "K"
+	kdfAssocDataKey   = "A"
+
+	// Defaults used when writing
+	defaultArgon2Iterations  uint64 = 2
+	defaultArgon2Memory      uint64 = 64 * 1024 * 1024
+	defaultArgon2Parallelism uint32 = 2
+	argon2Version            uint32 = 0x13
+
+	// Limits of the Argon2 parameters accepted when reading, so a crafted
+	// header cannot exhaust memory
+	argon2Version10      uint32 = 0x10
+	argon2MaxParallelism uint32 = 1<<24 - 1
+	argon2MaxMemory      uint64 = 4 * 1024 * 1024 * 1024
+
+	blockSize = 1024 * 1024
+
+	// KDBX 4 times are seconds since 0001-01-01
+	kdbxEpochOffset int64 = 62135596800
+)
+
+var (
+	cipherAES256   = mustUUID("31c1f2e6bf714350be5805216afc5aff")
+	cipherChaCha20 = mustUUID("d6038a2b8b6f4cb5a524339a31dbb59a")
+	cipherTwofish  = mustUUID("ad68f29f576f4bb9a36ad47af965346c")
+
+	kdfAES3    = mustUUID("c9d9f39a628a4460bf740d08c18a4fea")
+	kdfAES     = mustUUID("7c02bb8279a74ac0927d114a00648238")
+	kdfArgon2d = mustUUID("ef636ddf8c29444b91f7a9a403e30a0c")
+	kdfArgon2  = mustUUID("9e298b1956db4773b23dfc3ec6f0a1e6")
+
+	// salsa20Nonce is the fixed nonce of the KDBX 3 Salsa20 inner stream
+	salsa20Nonce = []byte{0xe8, 0x30, 0x09, 0x4b, 0x97, 0x20, 0x5d, 0x2a}
+)
+
+// compositeKey returns the composite key for a passphrase only database
+func compositeKey(passphrase string) []byte {
+	h := sha256.Sum256([]byte(passphrase))
+	h = sha256.Sum256(h[:])
+	return h[:]
+}
+
+// transformAESKDF applies the AES key derivation function
+func transformAESKDF(key, seed []byte, rounds uint64) ([]byte, error) {
+	c, err := aes.NewCipher(seed)
+	if err != nil {
+		return nil, Error.New("unable to create kdf cipher: %s", err)
+	}
+	transformed := append([]byte{}, key...)
+	for i := uint64(0); i < rounds; i++ {
+		c.Encrypt(transformed[:16], transformed[:16])
+		c.Encrypt(transformed[16:], transformed[16:])
+	}
+	h := sha256.Sum256(transformed)
+	return h[:], nil
+}
+
+// decodeTime decodes a KDBX 4 time, the number of seconds since 0001-01-01
+func decodeTime(seconds int64) time.Time {
+	return time.Unix(seconds-kdbxEpochOffset, 0)
+}
+
+// encodeTime encodes a KDBX 4 time
+func encodeTime(t time.Time) int64 {
+	return t.Unix() + kdbxEpochOffset
+}
@@ -0,0 +1,321 @@
+package kdbx
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/utils"
+	"github.com/azdagron/pwsafe/v3"
+)
+
+// Standard KeePass entry string keys
+const (
+	titleKey    = "Title"
+	usernameKey = "UserName"
+	passwordKey = "Password"
+	urlKey      = "URL"
+	notesKey    = "Notes"
+
+	// emailKey is not standard, but is the common name for the field and
+	// is mapped to the email field.
+	emailKey = "Email"
+
+	// otpKey holds the TOTP otpauth URI in KeePassXC. KeePass uses
+	// totpSecretKey for the base32 secret.
+	otpKey        = "otp"
+	totpSecretKey = "TimeOtp-Secret-Base32"
+)
+
+// toDatabase converts the XML document into a password safe database
+func toDatabase(doc *xmlFile) (*v3.Database, error) {
+	db, err := v3.NewDatabase()
+	if err != nil {
+		return nil, err
+	}
+	header := db.Header().(*v3.Header)
+	header.SetName(doc.Meta.DatabaseName)
+	header.SetDescription(doc.Meta.DatabaseDescription)
+
+	recycle_bin := ""
+	if doc.Meta.RecycleBinEnabled != xmlFalse {
+		recycle_bin = doc.Meta.RecycleBinUUID
+	}
+
+	var empty_groups []string
+	var convert_group func(group *xmlGroup, path []string) error
+	convert_group = func(group *xmlGroup, path []string) error {
+		if len(path) > 0 && len(group.Entries) == 0 &&
+			len(group.Groups) == 0 {
+			empty_groups = append(empty_groups, pwsafe.JoinGroup(path...))
+		}
+		for i := range group.Entries {
+			record, err := toRecord(&group.Entries[i])
+			if err != nil {
+				return err
+			}
+			record.SetGroup(pwsafe.JoinGroup(path...))
+			if err := db.AddRecord(record); err != nil {
+				return err
+			}
+		}
+		for i := range group.Groups {
+			child := &group.Groups[i]
+			if recycle_bin != "" && child.UUID == recycle_bin {
+				continue
+			}
+			child_path := append(append([]string(nil), path...), child.Name)
+			if err := convert_group(child, child_path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// the root group is not part of the group path
+	if err := convert_group(&doc.Root.Group, nil); err != nil {
+		return nil, err
+	}
+	header.SetEmptyGroups(empty_groups)
+	return db, nil
+}
+
+// toRecord converts a KeePass entry into a record
+func toRecord(entry *xmlEntry) (*v3.Record, error) {
+	record, err := v3.NewRecord()
+	if err != nil {
+		return nil, err
+	}
+	if uuid, err := base64.StdEncoding.DecodeString(entry.UUID); err == nil &&
+		len(uuid) == 16 {
+		record.SetUUID(hex.EncodeToString(uuid))
+	}
+
+	var custom []pwsafe.CustomField
+	for _, s := range entry.Strings {
+		switch s.Key {
+		case titleKey:
+			record.SetTitle(s.Value.Text)
+		case usernameKey:
+			record.SetUsername(s.Value.Text)
+		case passwordKey:
+			record.SetPassword(s.Value.Text)
+		case urlKey:
+			record.SetURL(s.Value.Text)
+		case notesKey:
+			record.SetNotes(s.Value.Text)
+		case otpKey, totpSecretKey:
+			record.SetTOTP(s.Value.Text)
+		default:
+			if isEmailKey(s.Key) && record.Email() == "" {
+				record.SetEmail(s.Value.Text)
+				continue
+			}
+			custom = append(custom, pwsafe.CustomField{
+				Name:  s.Key,
+				Value: s.Value.Text,
+			})
+		}
+	}
+	record.SetCustomFields(custom)
+
+	record.SetCtime(parseXMLTime(entry.Times.CreationTime))
+	record.SetMtime(parseXMLTime(entry.Times.LastModificationTime))
+	record.SetAtime(parseXMLTime(entry.Times.LastAccessTime))
+	if entry.Times.Expires == xmlTrue {
+		record.SetExpiry(parseXMLTime(entry.Times.ExpiryTime))
+	}
+
+	// KeePass keeps whole previous versions of an entry; only the
+	// passwords that differ from the next version are kept.
+	if entry.History != nil {
+		var history []pwsafe.HistoryEntry
+		password := record.Password()
+		for i := len(entry.History.Entries) - 1; i >= 0; i-- {
+			old := &entry.History.Entries[i]
+			old_password := old.get(passwordKey)
+			if old_password == password {
+				continue
+			}
+			password = old_password
+			history = append([]pwsafe.HistoryEntry{{
+				Time:     parseXMLTime(old.Times.LastModificationTime),
+				Password: old_password,
+			}}, history...)
+		}
+		record.SetHistory(history)
+	}
+	return record, nil
+}
+
+func isEmailKey(key string) bool {
+	switch strings.ToLower(key) {
+	case "email", "e-mail", "mail":
+		return true
+	}
+	return false
+}
+
+// fromDatabase converts a password safe database into the XML document
+func fromDatabase(db pwsafe.Database) (*xmlFile, error) {
+	header := db.Header()
+	name := header.Name()
+	if name == "" {
+		name = "Root"
+	}
+
+	now := time.Now()
+	root, err := newXMLGroup(name, now)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &xmlFile{
+		Meta: xmlMeta{
+			Generator:           "pwsafe",
+			DatabaseName:        header.Name(),
+			DatabaseDescription: header.Description(),
+			MemoryProtection: &xmlMemoryProtection{
+				ProtectTitle:    xmlFalse,
+				ProtectUserName: xmlFalse,
+				ProtectPassword: xmlTrue,
+				ProtectURL:      xmlFalse,
+				ProtectNotes:    xmlFalse,
+			},
+			RecycleBinEnabled: xmlFalse,
+		},
+	}
+
+	// find_group returns the group at the path, creating it if needed
+	find_group := func(path []string) (*xmlGroup, error) {
+		group := root
+		for _, name := range path {
+			var child *xmlGroup
+			for i := range group.Groups {
+				if group.Groups[i].Name == name {
+					child = &group.Groups[i]
+					break
+				}
+			}
+			if child == nil {
+				new_group, err := newXMLGroup(name, now)
+				if err != nil {
+					return nil, err
+				}
+				group.Groups = append(group.Groups, *new_group)
+				child = &group.Groups[len(group.Groups)-1]
+			}
+			group = child
+		}
+		return group, nil
+	}
+
+	for _, group := range header.EmptyGroups() {
+		if _, err := find_group(pwsafe.SplitGroup(group)); err != nil {
+			return nil, err
+		}
+	}
+	for _, record := range db.Records() {
+		group, err := find_group(pwsafe.SplitGroup(record.Group()))
+		if err != nil {
+			return nil, err
+		}
+		entry, err := fromRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		group.Entries = append(group.Entries, *entry)
+	}
+
+	doc.Root.Group = *root
+	return doc, nil
+}
+
+func newXMLGroup(name string, now time.Time) (*xmlGroup, error) {
+	uuid, err := utils.SecureRandBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	return &xmlGroup{
+		UUID:  base64.StdEncoding.EncodeToString(uuid),
+		Name:  name,
+		Times: newXMLTimes(now, now, now, time.Time{}),
+	}, nil
+}
+
+func newXMLTimes(ctime, mtime, atime, expiry time.Time) xmlTimes {
+	times := xmlTimes{
+		CreationTime:         formatXMLTime(ctime),
+		LastModificationTime: formatXMLTime(mtime),
+		LastAccessTime:       formatXMLTime(atime),
+		ExpiryTime:           formatXMLTime(expiry),
+		Expires:              xmlFalse,
+	}
+	if !expiry.IsZero() {
+		times.Expires = xmlTrue
+	}
+	return times
+}
+
+// fromRecord converts a record into a KeePass entry
+func fromRecord(record pwsafe.Record) (*xmlEntry, error) {
+	uuid, err := hex.DecodeString(record.UUID())
+	if err != nil || len(uuid) != 16 {
+		if uuid, err = utils.SecureRandBytes(16); err != nil {
+			return nil, err
+		}
+	}
+
+	ctime, mtime, atime := record.Ctime(), record.Mtime(), record.Atime()
+	if ctime.IsZero() {
+		ctime = time.Now()
+	}
+	if mtime.IsZero() {
+		mtime = ctime
+	}
+	if atime.IsZero() {
+		atime = mtime
+	}
+
+	entry := &xmlEntry{
+		UUID:  base64.StdEncoding.EncodeToString(uuid),
+		Times: newXMLTimes(ctime, mtime, atime, record.Expiry()),
+	}
+	entry.set(titleKey, record.Title(), false)
+	entry.set(usernameKey, record.Username(), false)
+	entry.set(passwordKey, record.Password(), true)
+	entry.set(urlKey, record.URL(), false)
+	entry.set(notesKey, record.Notes(), false)
+	if email := record.Email(); email != "" {
+		entry.set(emailKey, email, false)
+	}
+	if totp := record.TOTP(); totp != "" {
+		entry.set(otpKey, totp, true)
+	}
+	for _, field := range record.CustomFields() {
+		entry.set(field.Name, field.Value, false)
+	}
+
+	// each previous password becomes a previous version of the entry
+	history := record.History()
+	if len(history) > 0 {
+		sort.SliceStable(history, func(i, j int) bool {
+			return history[i].Time.Before(history[j].Time)
+		})
+		entry.History = &xmlHistory{}
+		for _, old := range history {
+			old_entry := &xmlEntry{
+				UUID:    entry.UUID,
+				Times:   newXMLTimes(ctime, old.Time, old.Time, time.Time{}),
+				Strings: append([]xmlString(nil), entry.Strings...),
+			}
+			old_entry.set(passwordKey, old.Password, true)
+			entry.History.Entries = append(entry.History.Entries,
+				*old_entry)
+		}
+	}
+	return entry, nil
+}
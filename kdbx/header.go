@@ -0,0 +1,258 @@
+package kdbx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+
+	"github.com/azdagron/pwsafe/utils"
+)
+
+// outerHeader is the unencrypted header at the beginning of a KDBX file
+type outerHeader struct {
+	major  uint16
+	minor  uint16
+	fields map[byte][]byte
+
+	// kdf holds the KDF parameters of a KDBX 4 database
+	kdf variantDictionary
+
+	// raw holds the serialized header, which is covered by the header hash
+	raw []byte
+}
+
+func mustUUID(s string) []byte {
+	uuid, err := hex.DecodeString(s)
+	if err != nil || len(uuid) != 16 {
+		panic("invalid uuid " + s)
+	}
+	return uuid
+}
+
+// readHeader reads the outer header, verifying the signature and version
+func readHeader(r io.Reader) (header *outerHeader, err error) {
+	var raw bytes.Buffer
+	r = io.TeeReader(r, &raw)
+
+	var preamble struct {
+		Signature1 uint32
+		Signature2 uint32
+		Minor      uint16
+		Major      uint16
+	}
+	err = binary.Read(r, binary.LittleEndian, &preamble)
+	if err != nil {
+		return nil, IOError.Wrap(err)
+	}
+	if preamble.Signature1 != signature1 || preamble.Signature2 != signature2 {
+		return nil, BadTag.New("not a kdbx database")
+	}
+	if preamble.Major != version3 && preamble.Major != version4 {
+		return nil, Unsupported.New("kdbx version %d.%d", preamble.Major,
+			preamble.Minor)
+	}
+
+	header = &outerHeader{
+		major:  preamble.Major,
+		minor:  preamble.Minor,
+		fields: make(map[byte][]byte),
+	}
+	for {
+		field_type, err := utils.ReadBytes(r, 1)
+		if err != nil {
+			return nil, err
+		}
+		var field_len uint32
+		if header.major == version3 {
+			var len16 uint16
+			err = binary.Read(r, binary.LittleEndian, &len16)
+			field_len = uint32(len16)
+		} else {
+			err = binary.Read(r, binary.LittleEndian, &field_len)
+		}
+		if err != nil {
+			return nil, IOError.Wrap(err)
+		}
+		data, err := utils.ReadBytes(r, int(field_len))
+		if err != nil {
+			return nil, err
+		}
+		if field_type[0] == endOfHeader {
+			break
+		}
+		header.fields[field_type[0]] = data
+	}
+
+	if header.major == version4 {
+		header.kdf, err = readVariantDictionary(
+			header.fields[kdfParametersHeader])
+		if err != nil {
+			return nil, err
+		}
+	}
+	header.raw = raw.Bytes()
+	return header, nil
+}
+
+// uint32Field returns the little-endian uint32 value of a header field
+func (h *outerHeader) uint32Field(field_type byte) (uint32, error) {
+	data := h.fields[field_type]
+	if len(data) != 4 {
+		return 0, Corrupted.New("invalid header field %d", field_type)
+	}
+	return binary.LittleEndian.Uint32(data), nil
+}
+
+// bytesField returns the value of a header field that must be present
+func (h *outerHeader) bytesField(field_type byte) ([]byte, error) {
+	data := h.fields[field_type]
+	if len(data) == 0 {
+		return nil, Corrupted.New("missing header field %d", field_type)
+	}
+	return data, nil
+}
+
+// serialize serializes a KDBX 4 header. Fields are written in field type
+// order.
+func (h *outerHeader) serialize() []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, signature1)
+	binary.Write(&b, binary.LittleEndian, signature2)
+	binary.Write(&b, binary.LittleEndian, h.minor)
+	binary.Write(&b, binary.LittleEndian, h.major)
+
+	if h.kdf != nil {
+		h.fields[kdfParametersHeader] = h.kdf.serialize()
+	}
+	for field_type := byte(1); field_type != 0; field_type++ {
+		if data, ok := h.fields[field_type]; ok {
+			writeField(&b, field_type, data)
+		}
+	}
+	writeField(&b, endOfHeader, []byte("\r\n\r\n"))
+	return b.Bytes()
+}
+
+// writeField writes a KDBX 4 outer or inner header field
+func writeField(b *bytes.Buffer, field_type byte, data []byte) {
+	b.WriteByte(field_type)
+	binary.Write(b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+}
+
+type variantItem struct {
+	value_type byte
+	key        string
+	value      []byte
+}
+
+// variantDictionary is the KDBX 4 key/value serialization format
+type variantDictionary []variantItem
+
+func readVariantDictionary(data []byte) (variantDictionary, error) {
+	r := bytes.NewReader(data)
+	var version uint16
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return nil, Corrupted.New("invalid variant dictionary: %s", err)
+	}
+	if version&0xff00 != vdVersion&0xff00 {
+		return nil, Unsupported.New("variant dictionary version %x", version)
+	}
+
+	var d variantDictionary
+	readLenPrefixed := func() ([]byte, error) {
+		var n int32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return nil, err
+		}
+		if n < 0 || int(n) > r.Len() {
+			return nil, io.ErrUnexpectedEOF
+		}
+		b := make([]byte, n)
+		_, err := io.ReadFull(r, b)
+		return b, err
+	}
+	for {
+		value_type, err := r.ReadByte()
+		if err != nil {
+			return nil, Corrupted.New("invalid variant dictionary: %s", err)
+		}
+		if value_type == vdEnd {
+			return d, nil
+		}
+		key, err := readLenPrefixed()
+		if err != nil {
+			return nil, Corrupted.New("invalid variant dictionary: %s", err)
+		}
+		value, err := readLenPrefixed()
+		if err != nil {
+			return nil, Corrupted.New("invalid variant dictionary: %s", err)
+		}
+		d = append(d, variantItem{
+			value_type: value_type,
+			key:        string(key),
+			value:      value,
+		})
+	}
+}
+
+func (d variantDictionary) serialize() []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, vdVersion)
+	for _, item := range d {
+		b.WriteByte(item.value_type)
+		binary.Write(&b, binary.LittleEndian, int32(len(item.key)))
+		b.WriteString(item.key)
+		binary.Write(&b, binary.LittleEndian, int32(len(item.value)))
+		b.Write(item.value)
+	}
+	b.WriteByte(vdEnd)
+	return b.Bytes()
+}
+
+func (d variantDictionary) get(key string, value_type byte) []byte {
+	for _, item := range d {
+		if item.key == key && item.value_type == value_type {
+			return item.value
+		}
+	}
+	return nil
+}
+
+func (d variantDictionary) bytes(key string) []byte {
+	return d.get(key, vdByteArray)
+}
+
+func (d variantDictionary) uint32(key string) (uint32, error) {
+	value := d.get(key, vdUint32)
+	if len(value) != 4 {
+		return 0, Corrupted.New("missing kdf parameter %q", key)
+	}
+	return binary.LittleEndian.Uint32(value), nil
+}
+
+func (d variantDictionary) uint64(key string) (uint64, error) {
+	value := d.get(key, vdUint64)
+	if len(value) != 8 {
+		return 0, Corrupted.New("missing kdf parameter %q", key)
+	}
+	return binary.LittleEndian.Uint64(value), nil
+}
+
+func (d *variantDictionary) setBytes(key string, value []byte) {
+	*d = append(*d, variantItem{value_type: vdByteArray, key: key,
+		value: value})
+}
+
+func (d *variantDictionary) setUint32(key string, value uint32) {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, value)
+	*d = append(*d, variantItem{value_type: vdUint32, key: key, value: b})
+}
+
+func (d *variantDictionary) setUint64(key string, value uint64) {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, value)
+	*d = append(*d, variantItem{value_type: vdUint64, key: key, value: b})
+}
@@ -0,0 +1,303 @@
+package kdbx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/azdagron/pwsafe/utils"
+	"github.com/azdagron/pwsafe/v3"
+)
+
+// Open opens a KDBX database and converts it into a v3 password safe database
+func Open(path string, passphrase_fn v3.PassphraseFn) (*v3.Database, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, IOError.Wrap(err)
+	}
+	defer utils.LogError(f.Close)
+
+	return OpenReader(f, passphrase_fn)
+}
+
+// OpenReader loads a KDBX database from the reader and converts it into a v3
+// password safe database
+func OpenReader(r io.Reader, passphrase_fn v3.PassphraseFn) (
+	*v3.Database, error) {
+
+	header, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	passphrase, err := passphrase_fn()
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	transformed_key, err := header.transformKey(compositeKey(passphrase))
+	if err != nil {
+		return nil, err
+	}
+	master_seed, err := header.bytesField(masterSeedHeader)
+	if err != nil {
+		return nil, err
+	}
+	cipher_key := sha256.Sum256(append(append([]byte{}, master_seed...),
+		transformed_key...))
+
+	var payload []byte
+	var stream_id uint32
+	var stream_key []byte
+	if header.major == version3 {
+		payload, err = readPayload3(r, header, cipher_key[:])
+		if err != nil {
+			return nil, err
+		}
+		if stream_id, err = header.uint32Field(innerRandomStreamID); err != nil {
+			return nil, err
+		}
+		stream_key = header.fields[protectedStreamKey]
+	} else {
+		payload, err = readPayload4(r, header, master_seed, transformed_key,
+			cipher_key[:])
+		if err != nil {
+			return nil, err
+		}
+		payload, stream_id, stream_key, err = readInnerHeader(payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stream, err := newInnerStream(stream_id, stream_key)
+	if err != nil {
+		return nil, err
+	}
+	// strip any byte order mark, which the xml decoder does not handle
+	payload = bytes.TrimPrefix(payload, []byte("\xef\xbb\xbf"))
+	payload, err = transformProtected(payload, stream, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc xmlFile
+	if err = xml.Unmarshal(payload, &doc); err != nil {
+		return nil, Corrupted.New("invalid xml: %s", err)
+	}
+	return toDatabase(&doc)
+}
+
+// transformKey derives the transformed key from the composite key using the
+// key derivation function of the database
+func (h *outerHeader) transformKey(key []byte) ([]byte, error) {
+	if h.major == version3 {
+		seed, err := h.bytesField(transformSeedHeader)
+		if err != nil {
+			return nil, err
+		}
+		rounds := h.fields[transformRounds]
+		if len(rounds) != 8 {
+			return nil, Corrupted.New("invalid transform rounds")
+		}
+		return transformAESKDF(key, seed,
+			binary.LittleEndian.Uint64(rounds))
+	}
+
+	uuid := h.kdf.bytes(kdfUUIDKey)
+	switch {
+	case bytes.Equal(uuid, kdfAES), bytes.Equal(uuid, kdfAES3):
+		rounds, err := h.kdf.uint64(kdfRoundsKey)
+		if err != nil {
+			return nil, err
+		}
+		return transformAESKDF(key, h.kdf.bytes(kdfSeedKey), rounds)
+	case bytes.Equal(uuid, kdfArgon2d), bytes.Equal(uuid, kdfArgon2):
+		mode := argon2d
+		if bytes.Equal(uuid, kdfArgon2) {
+			mode = argon2id
+		}
+		parallelism, err := h.kdf.uint32(kdfParallelismKey)
+		if err != nil {
+			return nil, err
+		}
+		memory, err := h.kdf.uint64(kdfMemoryKey)
+		if err != nil {
+			return nil, err
+		}
+		iterations, err := h.kdf.uint64(kdfIterationsKey)
+		if err != nil {
+			return nil, err
+		}
+		version, err := h.kdf.uint32(kdfVersionKey)
+		if err != nil {
+			return nil, err
+		}
+		// argon2Key raises the memory to at least 8 KiB per lane
+		used_memory := memory
+		if min_memory := 8 * 1024 * uint64(parallelism); used_memory <
+			min_memory {
+			used_memory = min_memory
+		}
+		switch {
+		case parallelism < 1 || parallelism > argon2MaxParallelism:
+			return nil, Unsupported.New("argon2 parallelism %d", parallelism)
+		case version != argon2Version10 && version != argon2Version:
+			return nil, Unsupported.New("argon2 version %#x", version)
+		case used_memory > argon2MaxMemory:
+			return nil, Unsupported.New("argon2 memory of %d bytes exceeds "+
+				"%d", used_memory, argon2MaxMemory)
+		case iterations > 1<<32-1:
+			return nil, Unsupported.New("argon2 iterations %d", iterations)
+		}
+		return argon2Key(mode, key, h.kdf.bytes(kdfSeedKey),
+			h.kdf.bytes(kdfSecretKey), h.kdf.bytes(kdfAssocDataKey),
+			uint32(iterations), uint32(memory/1024), parallelism, version,
+			32), nil
+	}
+	return nil, Unsupported.New("key derivation function %x", uuid)
+}
+
+// readPayload3 reads, decrypts and decompresses the KDBX 3 payload
+func readPayload3(r io.Reader, header *outerHeader, cipher_key []byte) (
+	[]byte, error) {
+
+	iv, err := header.bytesField(encryptionIVHeader)
+	if err != nil {
+		return nil, err
+	}
+	start_bytes, err := header.bytesField(streamStartBytes)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, IOError.New("unable to read payload: %s", err)
+	}
+	payload, err := decryptPayload(header.fields[cipherIDHeader], cipher_key,
+		iv, encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(payload, start_bytes) {
+		return nil, BadPassphrase.New("passphrase is incorrect")
+	}
+	payload, err = readHashedBlocks(payload[len(start_bytes):])
+	if err != nil {
+		return nil, err
+	}
+	return decompress(header, payload)
+}
+
+// readPayload4 verifies the header and reads, decrypts and decompresses the
+// KDBX 4 payload
+func readPayload4(r io.Reader, header *outerHeader, master_seed,
+	transformed_key, cipher_key []byte) ([]byte, error) {
+
+	expected_hash, err := utils.ReadBytes(r, sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	header_hash := sha256.Sum256(header.raw)
+	if !hmac.Equal(header_hash[:], expected_hash) {
+		return nil, Corrupted.New("header hash mismatch")
+	}
+
+	hmac_key := hmacKey(master_seed, transformed_key)
+	expected_hmac, err := utils.ReadBytes(r, sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	hm := hmac.New(sha256.New, blockHMACKey(hmac_key, 1<<64-1))
+	hm.Write(header.raw)
+	if !hmac.Equal(hm.Sum(nil), expected_hmac) {
+		return nil, BadPassphrase.New("passphrase is incorrect")
+	}
+
+	iv, err := header.bytesField(encryptionIVHeader)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := readHMACBlocks(r, hmac_key)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := decryptPayload(header.fields[cipherIDHeader], cipher_key,
+		iv, encrypted)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(header, payload)
+}
+
+// hmacKey returns the key used to derive the KDBX 4 HMAC block keys
+func hmacKey(master_seed, transformed_key []byte) []byte {
+	h := sha512.New()
+	h.Write(master_seed)
+	h.Write(transformed_key)
+	h.Write([]byte{0x01})
+	return h.Sum(nil)
+}
+
+func decompress(header *outerHeader, payload []byte) ([]byte, error) {
+	compression, err := header.uint32Field(compressionHeader)
+	if err != nil {
+		return nil, err
+	}
+	switch compression {
+	case compressionNone:
+		return payload, nil
+	case compressionGzip:
+		gz, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, Corrupted.New("invalid compressed payload: %s", err)
+		}
+		payload, err = ioutil.ReadAll(gz)
+		if err != nil {
+			return nil, Corrupted.New("invalid compressed payload: %s", err)
+		}
+		return payload, nil
+	}
+	return nil, Unsupported.New("compression %d", compression)
+}
+
+// readInnerHeader reads the KDBX 4 inner header, returning the remaining
+// payload and the inner random stream parameters. Attachments are skipped.
+func readInnerHeader(payload []byte) (rest []byte, stream_id uint32,
+	stream_key []byte, err error) {
+
+	r := bytes.NewReader(payload)
+	for {
+		var field struct {
+			Type byte
+			Size uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &field); err != nil {
+			return nil, 0, nil, Corrupted.New("invalid inner header: %s", err)
+		}
+		if int64(field.Size) > int64(r.Len()) {
+			return nil, 0, nil, Corrupted.New("truncated inner header")
+		}
+		data := make([]byte, field.Size)
+		io.ReadFull(r, data)
+
+		switch field.Type {
+		case innerEndOfHeader:
+			return payload[len(payload)-r.Len():], stream_id, stream_key,
+				nil
+		case innerRandomStreamIDHdr:
+			if len(data) != 4 {
+				return nil, 0, nil, Corrupted.New("invalid inner stream id")
+			}
+			stream_id = binary.LittleEndian.Uint32(data)
+		case innerRandomStreamKey:
+			stream_key = data
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package kdbx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/xml"
+	"io"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/utils"
+)
+
+// Save converts the database to KDBX and saves it to the path. The file is
+// replaced atomically.
+func Save(path string, db pwsafe.Database, passphrase string) error {
+	return utils.WriteFileAtomic(path, func(w io.Writer) error {
+		return SaveWriter(w, db, passphrase)
+	})
+}
+
+// SaveWriter converts the database to a KDBX 4.0 database written to an
+// io.Writer. The database is encrypted with AES-256 using Argon2id for key
+// derivation.
+func SaveWriter(w io.Writer, db pwsafe.Database, passphrase string) error {
+	doc, err := fromDatabase(db)
+	if err != nil {
+		return err
+	}
+
+	// new random values
+	master_seed, err := utils.SecureRandBytes(32)
+	if err != nil {
+		return err
+	}
+	iv, err := utils.SecureRandBytes(16)
+	if err != nil {
+		return err
+	}
+	kdf_seed, err := utils.SecureRandBytes(32)
+	if err != nil {
+		return err
+	}
+	stream_key, err := utils.SecureRandBytes(64)
+	if err != nil {
+		return err
+	}
+
+	header := &outerHeader{
+		major:  version4,
+		minor:  0,
+		fields: make(map[byte][]byte),
+	}
+	header.fields[cipherIDHeader] = cipherAES256
+	header.fields[compressionHeader] = uint32Bytes(compressionGzip)
+	header.fields[masterSeedHeader] = master_seed
+	header.fields[encryptionIVHeader] = iv
+	header.kdf.setBytes(kdfUUIDKey, kdfArgon2)
+	header.kdf.setBytes(kdfSeedKey, kdf_seed)
+	header.kdf.setUint32(kdfParallelismKey, defaultArgon2Parallelism)
+	header.kdf.setUint64(kdfMemoryKey, defaultArgon2Memory)
+	header.kdf.setUint64(kdfIterationsKey, defaultArgon2Iterations)
+	header.kdf.setUint32(kdfVersionKey, argon2Version)
+	header.raw = header.serialize()
+
+	transformed_key, err := header.transformKey(compositeKey(passphrase))
+	if err != nil {
+		return err
+	}
+	cipher_key := sha256.Sum256(append(append([]byte{}, master_seed...),
+		transformed_key...))
+	hmac_key := hmacKey(master_seed, transformed_key)
+
+	// the inner header followed by the xml document
+	var payload bytes.Buffer
+	writeField(&payload, innerRandomStreamIDHdr,
+		uint32Bytes(streamChaCha20))
+	writeField(&payload, innerRandomStreamKey, stream_key)
+	writeField(&payload, innerEndOfHeader, nil)
+
+	stream, err := newInnerStream(streamChaCha20, stream_key)
+	if err != nil {
+		return err
+	}
+	xml_data, err := xml.Marshal(doc)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	xml_data, err = transformProtected(xml_data, stream, false)
+	if err != nil {
+		return err
+	}
+	payload.WriteString(xml.Header)
+	payload.Write(xml_data)
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err = gz.Write(payload.Bytes()); err != nil {
+		return Error.Wrap(err)
+	}
+	if err = gz.Close(); err != nil {
+		return Error.Wrap(err)
+	}
+
+	encrypted, err := encryptPayload(cipherAES256, cipher_key[:], iv,
+		compressed.Bytes())
+	if err != nil {
+		return err
+	}
+
+	// write it all out
+	header_hash := sha256.Sum256(header.raw)
+	hm := hmac.New(sha256.New, blockHMACKey(hmac_key, 1<<64-1))
+	hm.Write(header.raw)
+
+	if _, err = w.Write(header.raw); err != nil {
+		return IOError.Wrap(err)
+	}
+	if _, err = w.Write(header_hash[:]); err != nil {
+		return IOError.Wrap(err)
+	}
+	if _, err = w.Write(hm.Sum(nil)); err != nil {
+		return IOError.Wrap(err)
+	}
+	return writeHMACBlocks(w, hmac_key, encrypted)
+}
+
+func uint32Bytes(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
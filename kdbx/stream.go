@@ -0,0 +1,247 @@
+package kdbx
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"io"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/salsa20/salsa"
+	"golang.org/x/crypto/twofish"
+)
+
+// newBlockCipher returns the block cipher for a CBC mode cipher id, or nil if
+// the cipher is a stream cipher.
+func newBlockCipher(cipher_id, key []byte) (cipher.Block, error) {
+	switch {
+	case bytes.Equal(cipher_id, cipherAES256):
+		return aes.NewCipher(key)
+	case bytes.Equal(cipher_id, cipherTwofish):
+		return twofish.NewCipher(key)
+	case bytes.Equal(cipher_id, cipherChaCha20):
+		return nil, nil
+	}
+	return nil, Unsupported.New("cipher %x", cipher_id)
+}
+
+// decryptPayload decrypts the encrypted payload in place
+func decryptPayload(cipher_id, key, iv, data []byte) ([]byte, error) {
+	block, err := newBlockCipher(cipher_id, key)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		stream, err := chacha20.NewUnauthenticatedCipher(key, iv)
+		if err != nil {
+			return nil, Corrupted.New("invalid chacha20 iv: %s", err)
+		}
+		stream.XORKeyStream(data, data)
+		return data, nil
+	}
+
+	if len(iv) != block.BlockSize() || len(data)%block.BlockSize() != 0 ||
+		len(data) == 0 {
+		return nil, Corrupted.New("invalid encrypted payload length")
+	}
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, data)
+
+	// remove the PKCS#7 padding
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > block.BlockSize() {
+		return nil, BadPassphrase.New("invalid payload padding")
+	}
+	for _, b := range data[len(data)-padding:] {
+		if int(b) != padding {
+			return nil, BadPassphrase.New("invalid payload padding")
+		}
+	}
+	return data[:len(data)-padding], nil
+}
+
+// encryptPayload encrypts the payload, returning the encrypted data
+func encryptPayload(cipher_id, key, iv, data []byte) ([]byte, error) {
+	block, err := newBlockCipher(cipher_id, key)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		stream, err := chacha20.NewUnauthenticatedCipher(key, iv)
+		if err != nil {
+			return nil, Error.New("invalid chacha20 iv: %s", err)
+		}
+		out := make([]byte, len(data))
+		stream.XORKeyStream(out, data)
+		return out, nil
+	}
+
+	// add PKCS#7 padding
+	padding := block.BlockSize() - len(data)%block.BlockSize()
+	out := make([]byte, len(data), len(data)+padding)
+	copy(out, data)
+	out = append(out, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, out)
+	return out, nil
+}
+
+// readHashedBlocks reads the KDBX 3 hashed block stream
+func readHashedBlocks(data []byte) ([]byte, error) {
+	r := bytes.NewReader(data)
+	var out bytes.Buffer
+	for index := uint32(0); ; index++ {
+		var block struct {
+			Index uint32
+			Hash  [sha256.Size]byte
+			Size  uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &block); err != nil {
+			return nil, Corrupted.New("unable to read block: %s", err)
+		}
+		if block.Index != index {
+			return nil, Corrupted.New("unexpected block index %d",
+				block.Index)
+		}
+		if block.Size == 0 {
+			return out.Bytes(), nil
+		}
+		if int64(block.Size) > int64(r.Len()) {
+			return nil, Corrupted.New("truncated block %d", index)
+		}
+		block_data := make([]byte, block.Size)
+		io.ReadFull(r, block_data)
+		if sha256.Sum256(block_data) != block.Hash {
+			return nil, Corrupted.New("block %d hash mismatch", index)
+		}
+		out.Write(block_data)
+	}
+}
+
+// blockHMACKey returns the HMAC key for the block at index
+func blockHMACKey(hmac_key []byte, index uint64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], index)
+	h := sha512.New()
+	h.Write(b[:])
+	h.Write(hmac_key)
+	return h.Sum(nil)
+}
+
+// blockHMAC computes the HMAC for a KDBX 4 block
+func blockHMAC(hmac_key []byte, index uint64, data []byte) []byte {
+	var b [12]byte
+	binary.LittleEndian.PutUint64(b[:8], index)
+	binary.LittleEndian.PutUint32(b[8:], uint32(len(data)))
+	hm := hmac.New(sha256.New, blockHMACKey(hmac_key, index))
+	hm.Write(b[:])
+	hm.Write(data)
+	return hm.Sum(nil)
+}
+
+// readHMACBlocks reads the KDBX 4 HMAC block stream
+func readHMACBlocks(r io.Reader, hmac_key []byte) ([]byte, error) {
+	var out bytes.Buffer
+	for index := uint64(0); ; index++ {
+		var block struct {
+			HMAC [sha256.Size]byte
+			Size int32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &block); err != nil {
+			return nil, Corrupted.New("unable to read block: %s", err)
+		}
+		if block.Size < 0 {
+			return nil, Corrupted.New("invalid block size %d", block.Size)
+		}
+		block_data := make([]byte, block.Size)
+		if _, err := io.ReadFull(r, block_data); err != nil {
+			return nil, Corrupted.New("truncated block %d", index)
+		}
+		if !hmac.Equal(block.HMAC[:],
+			blockHMAC(hmac_key, index, block_data)) {
+			return nil, Corrupted.New("block %d hmac mismatch", index)
+		}
+		if block.Size == 0 {
+			return out.Bytes(), nil
+		}
+		out.Write(block_data)
+	}
+}
+
+// writeHMACBlocks writes the KDBX 4 HMAC block stream
+func writeHMACBlocks(w io.Writer, hmac_key, data []byte) error {
+	for index := uint64(0); ; index++ {
+		n := len(data)
+		if n > blockSize {
+			n = blockSize
+		}
+		block_data := data[:n]
+		data = data[n:]
+		if _, err := w.Write(blockHMAC(hmac_key, index, block_data)); err != nil {
+			return IOError.Wrap(err)
+		}
+		err := binary.Write(w, binary.LittleEndian, int32(len(block_data)))
+		if err != nil {
+			return IOError.Wrap(err)
+		}
+		if _, err := w.Write(block_data); err != nil {
+			return IOError.Wrap(err)
+		}
+		if n == 0 {
+			return nil
+		}
+	}
+}
+
+// newInnerStream returns the stream cipher used to protect values inside of
+// the XML document
+func newInnerStream(id uint32, key []byte) (cipher.Stream, error) {
+	switch id {
+	case streamSalsa20:
+		return newSalsa20Stream(key), nil
+	case streamChaCha20:
+		h := sha512.Sum512(key)
+		stream, err := chacha20.NewUnauthenticatedCipher(h[:32], h[32:44])
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+		return stream, nil
+	case streamNone:
+		return nil, nil
+	}
+	return nil, Unsupported.New("inner random stream %d", id)
+}
+
+// salsa20Stream is a Salsa20 key stream that can be consumed in arbitrary
+// increments
+type salsa20Stream struct {
+	key     [32]byte
+	counter [16]byte
+	block   [64]byte
+	used    int
+}
+
+func newSalsa20Stream(key []byte) *salsa20Stream {
+	s := &salsa20Stream{
+		key:  sha256.Sum256(key),
+		used: 64,
+	}
+	copy(s.counter[:8], salsa20Nonce)
+	return s
+}
+
+func (s *salsa20Stream) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if s.used == len(s.block) {
+			var zero [64]byte
+			salsa.XORKeyStream(s.block[:], zero[:], &s.counter, &s.key)
+			binary.LittleEndian.PutUint64(s.counter[8:],
+				binary.LittleEndian.Uint64(s.counter[8:])+1)
+			s.used = 0
+		}
+		dst[i] = src[i] ^ s.block[s.used]
+		s.used++
+	}
+}
@@ -0,0 +1,208 @@
+package kdbx
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+// xmlFile is the XML document stored inside of a KDBX database. Only the
+// elements that are converted are represented.
+type xmlFile struct {
+	XMLName xml.Name `xml:"KeePassFile"`
+	Meta    xmlMeta  `xml:"Meta"`
+	Root    xmlRoot  `xml:"Root"`
+}
+
+type xmlMeta struct {
+	Generator           string               `xml:"Generator"`
+	DatabaseName        string               `xml:"DatabaseName"`
+	DatabaseDescription string               `xml:"DatabaseDescription"`
+	MemoryProtection    *xmlMemoryProtection `xml:"MemoryProtection"`
+	RecycleBinEnabled   string               `xml:"RecycleBinEnabled"`
+	RecycleBinUUID      string               `xml:"RecycleBinUUID"`
+}
+
+type xmlMemoryProtection struct {
+	ProtectTitle    string `xml:"ProtectTitle"`
+	ProtectUserName string `xml:"ProtectUserName"`
+	ProtectPassword string `xml:"ProtectPassword"`
+	ProtectURL      string `xml:"ProtectURL"`
+	ProtectNotes    string `xml:"ProtectNotes"`
+}
+
+type xmlRoot struct {
+	Group xmlGroup `xml:"Group"`
+}
+
+type xmlGroup struct {
+	UUID    string     `xml:"UUID"`
+	Name    string     `xml:"Name"`
+	Notes   string     `xml:"Notes,omitempty"`
+	Times   xmlTimes   `xml:"Times"`
+	Entries []xmlEntry `xml:"Entry"`
+	Groups  []xmlGroup `xml:"Group"`
+}
+
+type xmlEntry struct {
+	UUID    string      `xml:"UUID"`
+	Times   xmlTimes    `xml:"Times"`
+	Strings []xmlString `xml:"String"`
+	History *xmlHistory `xml:"History"`
+}
+
+type xmlHistory struct {
+	Entries []xmlEntry `xml:"Entry"`
+}
+
+type xmlTimes struct {
+	CreationTime         string `xml:"CreationTime"`
+	LastModificationTime string `xml:"LastModificationTime"`
+	LastAccessTime       string `xml:"LastAccessTime"`
+	ExpiryTime           string `xml:"ExpiryTime"`
+	Expires              string `xml:"Expires"`
+}
+
+type xmlString struct {
+	Key   string   `xml:"Key"`
+	Value xmlValue `xml:"Value"`
+}
+
+type xmlValue struct {
+	Protected string `xml:"Protected,attr,omitempty"`
+	Text      string `xml:",chardata"`
+}
+
+// get returns the value of the string with the key
+func (e *xmlEntry) get(key string) string {
+	for _, s := range e.Strings {
+		if s.Key == key {
+			return s.Value.Text
+		}
+	}
+	return ""
+}
+
+// set sets the value of the string with the key, appending it if not present
+func (e *xmlEntry) set(key, value string, protected bool) {
+	v := xmlValue{Text: value}
+	if protected {
+		v.Protected = xmlTrue
+	}
+	for i := range e.Strings {
+		if e.Strings[i].Key == key {
+			e.Strings[i].Value = v
+			return
+		}
+	}
+	e.Strings = append(e.Strings, xmlString{Key: key, Value: v})
+}
+
+const (
+	xmlTrue  = "True"
+	xmlFalse = "False"
+)
+
+// parseXMLTime parses a time, which is ISO 8601 in KDBX 3 and the base64
+// encoding of the number of seconds since 0001-01-01 in KDBX 4.
+func parseXMLTime(s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t
+	}
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(data) != 8 {
+		return time.Time{}
+	}
+	return decodeTime(int64(binary.LittleEndian.Uint64(data)))
+}
+
+// formatXMLTime formats a time for KDBX 4
+func formatXMLTime(t time.Time) string {
+	var data [8]byte
+	binary.LittleEndian.PutUint64(data[:], uint64(encodeTime(t)))
+	return base64.StdEncoding.EncodeToString(data[:])
+}
+
+// transformProtected rewrites the XML document, applying the inner random
+// stream to the contents of every protected element. The stream must be
+// applied in document order, which is why this operates on the token stream
+// and not on the unmarshalled document. When decrypting, protected values
+// are base64 decoded before the stream is applied; when encrypting they are
+// base64 encoded afterwards.
+func transformProtected(doc []byte, stream cipher.Stream, decrypt bool) (
+	[]byte, error) {
+
+	if stream == nil {
+		return doc, nil
+	}
+
+	var out bytes.Buffer
+	d := xml.NewDecoder(bytes.NewReader(doc))
+	e := xml.NewEncoder(&out)
+	protected := false
+	var text []byte
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, Corrupted.New("invalid xml: %s", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			for _, attr := range t.Attr {
+				if attr.Name.Local == "Protected" && attr.Value == xmlTrue {
+					protected = true
+					text = text[:0]
+				}
+			}
+		case xml.CharData:
+			if protected {
+				text = append(text, t...)
+				continue
+			}
+		case xml.EndElement:
+			if protected {
+				value, err := applyStream(stream, text, decrypt)
+				if err != nil {
+					return nil, err
+				}
+				if err := e.EncodeToken(xml.CharData(value)); err != nil {
+					return nil, Error.Wrap(err)
+				}
+				protected = false
+			}
+		}
+		if err := e.EncodeToken(token); err != nil {
+			return nil, Error.Wrap(err)
+		}
+	}
+	if err := e.Flush(); err != nil {
+		return nil, Error.Wrap(err)
+	}
+	return out.Bytes(), nil
+}
+
+func applyStream(stream cipher.Stream, text []byte, decrypt bool) (
+	[]byte, error) {
+
+	if !decrypt {
+		value := make([]byte, len(text))
+		stream.XORKeyStream(value, text)
+		return []byte(base64.StdEncoding.EncodeToString(value)), nil
+	}
+	value, err := base64.StdEncoding.DecodeString(string(text))
+	if err != nil {
+		return nil, Corrupted.New("invalid protected value: %s", err)
+	}
+	stream.XORKeyStream(value, value)
+	return value, nil
+}
@@ -0,0 +1,127 @@
+package pwsafe
+
+import "strings"
+
+// MergeStrategy decides how a field changed differently in both databases of
+// a merge is resolved.
+type MergeStrategy int
+
+const (
+	// PreferNewer keeps the value that was modified last. Passwords compare
+	// their password modification times, other fields the record
+	// modification times. Ties keep our value.
+	PreferNewer MergeStrategy = iota
+
+	// PreferOurs keeps our value.
+	PreferOurs
+
+	// KeepBoth keeps our record and adds a copy of their record, with a new
+	// UUID and the conflict suffix appended to its title.
+	KeepBoth
+)
+
+var mergeStrategyNames = []string{"prefer-newer", "prefer-ours", "keep-both"}
+
+func (s MergeStrategy) String() string {
+	if s < 0 || int(s) >= len(mergeStrategyNames) {
+		return "unknown"
+	}
+	return mergeStrategyNames[s]
+}
+
+// ParseMergeStrategy returns the strategy with the name: prefer-newer,
+// prefer-ours or keep-both.
+func ParseMergeStrategy(name string) (MergeStrategy, error) {
+	for i, strategy_name := range mergeStrategyNames {
+		if name == strategy_name {
+			return MergeStrategy(i), nil
+		}
+	}
+	return 0, Error.New("unknown merge strategy %q; expected one of %s",
+		name, strings.Join(mergeStrategyNames, ", "))
+}
+
+// DefaultConflictSuffix is appended to the titles of the copies KeepBoth
+// makes if MergeOptions has no suffix.
+const DefaultConflictSuffix = " [conflict]"
+
+// MergeOptions configures a merge.
+type MergeOptions struct {
+	Strategy MergeStrategy
+
+	// Suffix is appended to the titles of copies made by KeepBoth
+	Suffix string
+}
+
+// MergeSide names the database a merged value was taken from.
+type MergeSide int
+
+const (
+	Ours MergeSide = iota + 1
+	Theirs
+	Both
+)
+
+func (s MergeSide) String() string {
+	switch s {
+	case Ours:
+		return "ours"
+	case Theirs:
+		return "theirs"
+	case Both:
+		return "both"
+	}
+	return ""
+}
+
+// Conflict describes a change made in both databases of a merge that could
+// not be combined.
+type Conflict struct {
+	// UUID is the record UUID, or empty for a conflict in the header
+	UUID string
+
+	// Title and Group identify the record in the merged database
+	Title string
+	Group string
+
+	// Field names the field changed differently in both databases. It is
+	// empty if the record was removed from one database and changed in
+	// the other.
+	Field string
+
+	// Removed is the database the record was removed from, if any
+	Removed MergeSide
+
+	// Resolution is the database whose value was kept. Both means their
+	// record was added as Copy.
+	Resolution MergeSide
+
+	// Copy is the UUID of the copy of their record made by KeepBoth
+	Copy string
+}
+
+// Merger is implemented by databases that can be merged.
+type Merger interface {
+	// Merge merges the changes made in theirs since the common base into a
+	// copy of the database. base may be nil if there is no common version;
+	// records then are only matched, never considered removed.
+	Merge(base, theirs Database, options MergeOptions) (Database,
+		[]Conflict, error)
+}
+
+// Merge performs a three-way merge of the changes made in ours and theirs
+// since base. Records are matched by UUID. Changes made in only one database
+// are taken, as are removals of records unchanged in the other database.
+// Password histories and empty groups are combined. Fields changed
+// differently in both are resolved with the strategy and returned as
+// conflicts. None of the databases are modified.
+func Merge(base, ours, theirs Database, options MergeOptions) (Database,
+	[]Conflict, error) {
+
+	merger, ok := ours.(Merger)
+	if !ok {
+		return nil, nil, Unsupported.New("%s databases cannot be merged",
+			ours.Version())
+	}
+	return merger.Merge(base, theirs, options)
+}
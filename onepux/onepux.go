@@ -0,0 +1,352 @@
+// Package onepux imports 1Password 1PUX exports into password safe
+// databases.
+package onepux
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/utils"
+	"github.com/azdagron/pwsafe/v3"
+)
+
+var (
+	Error     = pwsafe.Error
+	IOError   = pwsafe.IOError
+	Corrupted = pwsafe.Corrupted
+)
+
+const (
+	// exportDataPath is the path of the export data inside of the archive
+	exportDataPath = "export.data"
+
+	activeState = "active"
+
+	documentCategory = "006"
+)
+
+type exportData struct {
+	Accounts []account `json:"accounts"`
+}
+
+type account struct {
+	Attrs struct {
+		AccountName string `json:"accountName"`
+		Name        string `json:"name"`
+	} `json:"attrs"`
+	Vaults []vault `json:"vaults"`
+}
+
+type vault struct {
+	Attrs struct {
+		Name string `json:"name"`
+	} `json:"attrs"`
+	Items []item `json:"items"`
+}
+
+type item struct {
+	UUID         string   `json:"uuid"`
+	CreatedAt    int64    `json:"createdAt"`
+	UpdatedAt    int64    `json:"updatedAt"`
+	State        string   `json:"state"`
+	CategoryUUID string   `json:"categoryUuid"`
+	Details      details  `json:"details"`
+	Overview     overview `json:"overview"`
+}
+
+type details struct {
+	LoginFields     []loginField      `json:"loginFields"`
+	NotesPlain      string            `json:"notesPlain"`
+	Sections        []section         `json:"sections"`
+	PasswordHistory []passwordHistory `json:"passwordHistory"`
+	Password        string            `json:"password"`
+	Passkey         json.RawMessage   `json:"passkey"`
+}
+
+type loginField struct {
+	Value       string `json:"value"`
+	Name        string `json:"name"`
+	Designation string `json:"designation"`
+}
+
+type section struct {
+	Title  string         `json:"title"`
+	Fields []sectionField `json:"fields"`
+}
+
+type sectionField struct {
+	Title string                     `json:"title"`
+	ID    string                     `json:"id"`
+	Value map[string]json.RawMessage `json:"value"`
+}
+
+type passwordHistory struct {
+	Value string `json:"value"`
+	Time  int64  `json:"time"`
+}
+
+type overview struct {
+	Title string    `json:"title"`
+	URL   string    `json:"url"`
+	URLs  []itemURL `json:"urls"`
+	Tags  []string  `json:"tags"`
+}
+
+type itemURL struct {
+	Label string `json:"label"`
+	URL   string `json:"url"`
+}
+
+// Open opens a 1PUX export and converts it into a v3 password safe database.
+// 1PUX exports are not encrypted, so the passphrase callback is not used.
+// Items or parts of items that could not be converted are returned in the
+// unmapped list.
+func Open(path string, passphrase_fn v3.PassphraseFn) (*v3.Database,
+	[]pwsafe.Unmapped, error) {
+
+	z, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, nil, IOError.Wrap(err)
+	}
+	defer utils.LogError(z.Close)
+
+	for _, f := range z.File {
+		if f.Name != exportDataPath {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, nil, IOError.Wrap(err)
+		}
+		defer utils.LogError(r.Close)
+
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, nil, IOError.Wrap(err)
+		}
+		var export exportData
+		if err = json.Unmarshal(data, &export); err != nil {
+			return nil, nil, Corrupted.New("invalid export data: %s", err)
+		}
+		return convert(&export)
+	}
+	return nil, nil, Corrupted.New("missing %s", exportDataPath)
+}
+
+func convert(export *exportData) (*v3.Database, []pwsafe.Unmapped, error) {
+	db, err := v3.NewDatabase()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var unmapped []pwsafe.Unmapped
+	for _, acct := range export.Accounts {
+		for _, v := range acct.Vaults {
+			// vaults become groups; the account is only part of the
+			// group when there is more than one
+			group := []string{v.Attrs.Name}
+			if len(export.Accounts) > 1 {
+				group = append([]string{acct.Attrs.AccountName}, group...)
+			}
+			for i := range v.Items {
+				record, item_unmapped, err := convertItem(&v.Items[i])
+				if err != nil {
+					return nil, nil, err
+				}
+				unmapped = append(unmapped, item_unmapped...)
+				if record == nil {
+					continue
+				}
+				record.SetGroup(pwsafe.JoinGroup(group...))
+				if err = db.AddRecord(record); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+	}
+	return db, unmapped, nil
+}
+
+func convertItem(it *item) (*v3.Record, []pwsafe.Unmapped, error) {
+	var unmapped []pwsafe.Unmapped
+	report := func(reason string) {
+		unmapped = append(unmapped, pwsafe.Unmapped{
+			Item:   it.Overview.Title,
+			Reason: reason,
+		})
+	}
+
+	if it.State != "" && it.State != activeState {
+		report(it.State + " item was not imported")
+		return nil, unmapped, nil
+	}
+	if it.CategoryUUID == documentCategory {
+		report("documents cannot be converted")
+		return nil, unmapped, nil
+	}
+
+	record, err := v3.NewRecord()
+	if err != nil {
+		return nil, nil, err
+	}
+	record.SetTitle(it.Overview.Title)
+	record.SetURL(it.Overview.URL)
+	record.SetNotes(it.Details.NotesPlain)
+	record.SetPassword(it.Details.Password)
+	if it.CreatedAt != 0 {
+		record.SetCtime(time.Unix(it.CreatedAt, 0))
+	}
+	if it.UpdatedAt != 0 {
+		record.SetMtime(time.Unix(it.UpdatedAt, 0))
+	}
+
+	var custom []pwsafe.CustomField
+	for _, f := range it.Details.LoginFields {
+		switch f.Designation {
+		case "username":
+			record.SetUsername(f.Value)
+		case "password":
+			record.SetPassword(f.Value)
+		default:
+			if f.Value != "" {
+				custom = append(custom, pwsafe.CustomField{
+					Name:  f.Name,
+					Value: f.Value,
+				})
+			}
+		}
+	}
+
+	for _, u := range it.Overview.URLs {
+		if u.URL == record.URL() {
+			continue
+		}
+		name := u.Label
+		if name == "" {
+			name = "URL"
+		}
+		custom = append(custom, pwsafe.CustomField{Name: name, Value: u.URL})
+	}
+
+	for _, s := range it.Details.Sections {
+		for _, f := range s.Fields {
+			name := f.Title
+			if name == "" {
+				name = f.ID
+			}
+			if s.Title != "" {
+				name = s.Title + ": " + name
+			}
+			kind, value, ok := fieldValue(f.Value)
+			switch {
+			case !ok:
+				report(fmt.Sprintf("%s field %q cannot be converted", kind,
+					name))
+			case value == "":
+			case kind == "totp" && record.TOTP() == "":
+				record.SetTOTP(value)
+			case kind == "email" && record.Email() == "":
+				record.SetEmail(value)
+			default:
+				custom = append(custom, pwsafe.CustomField{
+					Name:  name,
+					Value: value,
+				})
+			}
+		}
+	}
+
+	if len(it.Overview.Tags) > 0 {
+		custom = append(custom, pwsafe.CustomField{
+			Name:  "Tags",
+			Value: strings.Join(it.Overview.Tags, ", "),
+		})
+	}
+	record.SetCustomFields(custom)
+
+	if len(it.Details.Passkey) > 0 && string(it.Details.Passkey) != "null" {
+		report("passkeys cannot be converted")
+	}
+
+	history := make([]pwsafe.HistoryEntry, 0, len(it.Details.PasswordHistory))
+	for _, h := range it.Details.PasswordHistory {
+		history = append(history, pwsafe.HistoryEntry{
+			Time:     time.Unix(h.Time, 0),
+			Password: h.Value,
+		})
+	}
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Time.Before(history[j].Time)
+	})
+	record.SetHistory(history)
+
+	return record, unmapped, nil
+}
+
+// fieldValue returns the kind and string representation of a section field
+// value. ok is false if the value cannot be represented as a string.
+func fieldValue(value map[string]json.RawMessage) (kind, s string,
+	ok bool) {
+
+	for kind, raw := range value {
+		switch kind {
+		case "string", "concealed", "totp", "url", "phone", "menu",
+			"gender", "creditCardType", "creditCardNumber":
+			var v string
+			if json.Unmarshal(raw, &v) != nil {
+				return kind, "", false
+			}
+			return kind, v, true
+		case "email":
+			var v struct {
+				EmailAddress string `json:"email_address"`
+			}
+			if json.Unmarshal(raw, &v) != nil {
+				return kind, "", false
+			}
+			return kind, v.EmailAddress, true
+		case "date":
+			var v int64
+			if json.Unmarshal(raw, &v) != nil {
+				return kind, "", false
+			}
+			return kind, time.Unix(v, 0).UTC().Format("2006-01-02"), true
+		case "monthYear":
+			var v int
+			if json.Unmarshal(raw, &v) != nil {
+				return kind, "", false
+			}
+			return kind, fmt.Sprintf("%04d-%02d", v/100, v%100), true
+		case "address":
+			var v map[string]string
+			if json.Unmarshal(raw, &v) != nil {
+				return kind, "", false
+			}
+			var lines []string
+			for _, key := range []string{"street", "city", "state", "zip",
+				"country"} {
+				if v[key] != "" {
+					lines = append(lines, v[key])
+				}
+			}
+			return kind, strings.Join(lines, "\n"), true
+		case "sshKey":
+			var v struct {
+				PrivateKey string `json:"privateKey"`
+			}
+			if json.Unmarshal(raw, &v) != nil {
+				return kind, "", false
+			}
+			return kind, v.PrivateKey, true
+		default:
+			return kind, "", false
+		}
+	}
+	return "empty", "", true
+}
@@ -0,0 +1,233 @@
+package pwsafe
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Character sets used for password generation. The easy vision sets leave
+// out characters that are easily confused, like 1, l and I.
+const (
+	lowercaseChars = "abcdefghijklmnopqrstuvwxyz"
+	uppercaseChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digitChars     = "0123456789"
+	hexDigitChars  = "0123456789abcdef"
+
+	easyLowercaseChars = "abcdefghijkmnopqrstuvwxyz"
+	easyUppercaseChars = "ABCDEFGHJKLMNPQRTUVWXY"
+	easyDigitChars     = "346789"
+	easySymbolChars    = "+-=_@#$%^&<>/~\\?"
+
+	// DefaultSymbols are the symbols used when a policy does not specify
+	// its own.
+	DefaultSymbols = "+-=_@#$%^&;:,.<>/~\\[](){}?!|*"
+)
+
+// PasswordPolicy describes how passwords are generated.
+type PasswordPolicy struct {
+	// Name is the name of a named policy, or empty.
+	Name string
+
+	UseLowercase  bool
+	UseUppercase  bool
+	UseDigits     bool
+	UseSymbols    bool
+	UseHexDigits  bool
+	UseEasyVision bool
+
+	// MakePronounceable is recorded but not supported for generation.
+	MakePronounceable bool
+
+	Length       int
+	MinLowercase int
+	MinUppercase int
+	MinDigits    int
+	MinSymbols   int
+
+	// Symbols are the allowed symbols. Empty uses DefaultSymbols.
+	Symbols string
+}
+
+// DefaultPasswordPolicy is the policy used when neither the record nor the
+// database specify one.
+var DefaultPasswordPolicy = PasswordPolicy{
+	UseLowercase: true,
+	UseUppercase: true,
+	UseDigits:    true,
+	UseSymbols:   true,
+	Length:       20,
+	MinLowercase: 1,
+	MinUppercase: 1,
+	MinDigits:    1,
+	MinSymbols:   1,
+}
+
+type charClass struct {
+	name  string
+	chars string
+	min   int
+}
+
+// classes returns the character classes the policy draws from
+func (p PasswordPolicy) classes() []charClass {
+	if p.UseHexDigits {
+		return []charClass{{"hex digits", hexDigitChars, 0}}
+	}
+	lower, upper, digits := lowercaseChars, uppercaseChars, digitChars
+	symbols := p.Symbols
+	if symbols == "" {
+		symbols = DefaultSymbols
+	}
+	if p.UseEasyVision {
+		lower, upper, digits = easyLowercaseChars, easyUppercaseChars,
+			easyDigitChars
+		if p.Symbols == "" {
+			symbols = easySymbolChars
+		}
+	}
+
+	var classes []charClass
+	if p.UseLowercase {
+		classes = append(classes, charClass{"lowercase letters", lower, p.MinLowercase})
+	}
+	if p.UseUppercase {
+		classes = append(classes, charClass{"uppercase letters", upper, p.MinUppercase})
+	}
+	if p.UseDigits {
+		classes = append(classes, charClass{"digits", digits, p.MinDigits})
+	}
+	if p.UseSymbols {
+		classes = append(classes, charClass{"symbols", symbols, p.MinSymbols})
+	}
+	return classes
+}
+
+// Generate returns a random password following the policy.
+func (p PasswordPolicy) Generate() (string, error) {
+	classes := p.classes()
+	if len(classes) == 0 {
+		return "", Error.New("password policy allows no characters")
+	}
+	var alphabet string
+	required := 0
+	for _, class := range classes {
+		alphabet += class.chars
+		required += class.min
+	}
+	if p.Length <= 0 || p.Length < required {
+		return "", Error.New("password length %d is too short for the "+
+			"policy", p.Length)
+	}
+
+	// start with the required characters of each class, fill up from all
+	// classes and shuffle
+	var password []rune
+	for _, class := range classes {
+		for i := 0; i < class.min; i++ {
+			c, err := randomChar(class.chars)
+			if err != nil {
+				return "", err
+			}
+			password = append(password, c)
+		}
+	}
+	for len(password) < p.Length {
+		c, err := randomChar(alphabet)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := randomInt(i + 1)
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j] = password[j], password[i]
+	}
+	return string(password), nil
+}
+
+// Violations returns how the password does not follow the policy: too
+// short, characters outside of the allowed classes or too few characters of
+// a class.
+func (p PasswordPolicy) Violations(password string) []string {
+	var violations []string
+	if length := len([]rune(password)); length < p.Length {
+		violations = append(violations, "shorter than "+
+			strconv.Itoa(p.Length)+" characters")
+	}
+	classes := p.classes()
+	var alphabet string
+	for _, class := range classes {
+		alphabet += class.chars
+	}
+	for _, c := range password {
+		if !strings.ContainsRune(alphabet, c) {
+			violations = append(violations, "contains characters the "+
+				"policy does not allow")
+			break
+		}
+	}
+	for _, class := range classes {
+		count := 0
+		for _, c := range password {
+			if strings.ContainsRune(class.chars, c) {
+				count++
+			}
+		}
+		switch {
+		case count == 0 && class.min > 0:
+			violations = append(violations, "no "+class.name)
+		case count < class.min:
+			violations = append(violations, "fewer than "+
+				strconv.Itoa(class.min)+" "+class.name)
+		}
+	}
+	return violations
+}
+
+func randomChar(chars string) (rune, error) {
+	runes := []rune(chars)
+	i, err := randomInt(len(runes))
+	if err != nil {
+		return 0, err
+	}
+	return runes[i], nil
+}
+
+func randomInt(n int) (int, error) {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, IOError.Wrap(err)
+	}
+	return int(v.Int64()), nil
+}
+
+// Describe returns a short human readable description of the policy
+func (p PasswordPolicy) Describe() string {
+	var parts []string
+	add := func(use bool, name string, min int) {
+		if !use {
+			return
+		}
+		if min > 0 {
+			name += " (min " + strconv.Itoa(min) + ")"
+		}
+		parts = append(parts, name)
+	}
+	if p.UseHexDigits {
+		parts = append(parts, "hex digits")
+	} else {
+		add(p.UseLowercase, "lowercase", p.MinLowercase)
+		add(p.UseUppercase, "uppercase", p.MinUppercase)
+		add(p.UseDigits, "digits", p.MinDigits)
+		add(p.UseSymbols, "symbols", p.MinSymbols)
+		if p.UseEasyVision {
+			parts = append(parts, "easy vision")
+		}
+	}
+	return strconv.Itoa(p.Length) + " characters: " + strings.Join(parts, ", ")
+}
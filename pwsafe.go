@@ -21,6 +21,12 @@ var (
 
 	// Corrupted indicates that the database has been corrupted.
 	Corrupted = Error.NewClass("corrupted", errors.NoCaptureStack())
+
+	// Unsupported indicates that a feature of a file is not supported.
+	Unsupported = Error.NewClass("unsupported", errors.NoCaptureStack())
+
+	// BadQuery indicates that a record query could not be parsed.
+	BadQuery = Error.NewClass("bad query", errors.NoCaptureStack())
 )
 
 // Database represents a pwsafe database.
@@ -48,12 +54,48 @@ type Record interface {
 	Notes() string
 	Group() string
 	URL() string
+	Email() string
 	Ctime() time.Time
 	Mtime() time.Time
 	Atime() time.Time
+	PasswordMtime() time.Time
+	Expiry() time.Time
+	TOTP() string
+	Protected() bool
+	History() []HistoryEntry
+	CustomFields() []CustomField
 }
 
 // Header represents a database header.
 type Header interface {
 	Mtime() time.Time
+	Name() string
+	Description() string
+	EmptyGroups() []string
+}
+
+// HistoryEntry is a previous password of a record.
+type HistoryEntry struct {
+	// Time is when the password was set.
+	Time time.Time
+
+	// Password is the previous password.
+	Password string
+}
+
+// CustomField is a named field that has no dedicated field type in the
+// password safe format. They are stored in an application-specific field.
+type CustomField struct {
+	Name  string
+	Value string
+}
+
+// Unmapped describes an item of an imported database that could not be
+// completely converted into a record.
+type Unmapped struct {
+	// Item names the item that was not converted
+	Item string
+
+	// Reason describes what could not be converted
+	Reason string
 }
@@ -0,0 +1,753 @@
+package pwsafe
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query is a parsed record query. A query is made of terms combined with AND,
+// OR, NOT and parentheses. Adjacent terms are combined with AND.
+//
+// A term is either a bare word, which matches the title, group, username, URL
+// or notes of a record, or a field comparison:
+//
+//	field:value   case insensitive glob ("*" and "?") match, or substring
+//	              match if the value has no wildcards. An empty value
+//	              matches records where the field is not set.
+//	field:~value  regular expression match
+//	field=value   equal
+//	field!=value  not equal
+//	field<value   also <=, > and >=
+//
+// Values containing spaces or parentheses are quoted with double quotes. Time
+// values are dates (2006-01-02), RFC 3339 times or "now"; a date compares
+// equal to any time on that day. Groups are written with "/" separating the
+// group elements. See FieldNames for the fields.
+//
+// For example:
+//
+//	group:ops/* AND user:svc-* AND mtime<2025-01-01 AND NOT url:~internal
+type Query struct {
+	root queryNode
+}
+
+type fieldKind int
+
+const (
+	stringKind fieldKind = iota
+	timeKind
+	intKind
+	boolKind
+)
+
+type recordField struct {
+	kind fieldKind
+	get  func(r Record) interface{}
+}
+
+// customFieldPrefix prefixes the name of a custom field in a query
+const customFieldPrefix = "custom."
+
+var recordFields = map[string]recordField{
+	"uuid":  {stringKind, func(r Record) interface{} { return r.UUID() }},
+	"title": {stringKind, func(r Record) interface{} { return r.Title() }},
+	"group": {stringKind, func(r Record) interface{} {
+		return strings.Join(SplitGroup(r.Group()), "/")
+	}},
+	"username": {stringKind, func(r Record) interface{} {
+		return r.Username()
+	}},
+	"password": {stringKind, func(r Record) interface{} {
+		return r.Password()
+	}},
+	"notes": {stringKind, func(r Record) interface{} { return r.Notes() }},
+	"url":   {stringKind, func(r Record) interface{} { return r.URL() }},
+	"email": {stringKind, func(r Record) interface{} { return r.Email() }},
+	"totp":  {stringKind, func(r Record) interface{} { return r.TOTP() }},
+	"ctime": {timeKind, func(r Record) interface{} { return r.Ctime() }},
+	"mtime": {timeKind, func(r Record) interface{} { return r.Mtime() }},
+	"atime": {timeKind, func(r Record) interface{} { return r.Atime() }},
+	"password_mtime": {timeKind, func(r Record) interface{} {
+		return r.PasswordMtime()
+	}},
+	"expiry": {timeKind, func(r Record) interface{} { return r.Expiry() }},
+	"protected": {boolKind, func(r Record) interface{} {
+		return r.Protected()
+	}},
+	"history": {intKind, func(r Record) interface{} {
+		return len(r.History())
+	}},
+}
+
+// fieldAliases maps alternative field names to the field names
+var fieldAliases = map[string]string{
+	"user":     "username",
+	"created":  "ctime",
+	"modified": "mtime",
+	"accessed": "atime",
+	"pmtime":   "password_mtime",
+	"expires":  "expiry",
+}
+
+// FieldNames returns the names of the record fields that can be used in
+// queries, sorting and field selection. Custom fields are named
+// "custom.<name>".
+func FieldNames() []string {
+	var names []string
+	for name := range recordFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SecretField returns true for the fields holding secrets, the password and
+// the TOTP secret, which are masked when shown
+func SecretField(name string) bool {
+	if alias, ok := fieldAliases[name]; ok {
+		name = alias
+	}
+	return name == "password" || name == "totp"
+}
+
+func lookupField(name string) (recordField, bool) {
+	if strings.HasPrefix(name, customFieldPrefix) {
+		custom := name[len(customFieldPrefix):]
+		return recordField{stringKind, func(r Record) interface{} {
+			for _, field := range r.CustomFields() {
+				if field.Name == custom {
+					return field.Value
+				}
+			}
+			return ""
+		}}, true
+	}
+	if alias, ok := fieldAliases[name]; ok {
+		name = alias
+	}
+	field, ok := recordFields[name]
+	return field, ok
+}
+
+// CheckField returns an error if there is no record field with the name
+func CheckField(name string) error {
+	if _, ok := lookupField(name); !ok {
+		return BadQuery.New("unknown field %q", name)
+	}
+	return nil
+}
+
+// FieldValue returns the value of the named field of the record. The value is
+// a string, time.Time, int or bool.
+func FieldValue(r Record, name string) (interface{}, error) {
+	field, ok := lookupField(name)
+	if !ok {
+		return nil, BadQuery.New("unknown field %q", name)
+	}
+	return field.get(r), nil
+}
+
+// SortRecords sorts the records by the named fields. A field name prefixed
+// with "-" sorts in descending order.
+func SortRecords(records []Record, keys []string) error {
+	type sortKey struct {
+		field      recordField
+		descending bool
+	}
+	var sort_keys []sortKey
+	for _, key := range keys {
+		descending := strings.HasPrefix(key, "-")
+		name := strings.TrimPrefix(key, "-")
+		field, ok := lookupField(name)
+		if !ok {
+			return BadQuery.New("unknown sort field %q", name)
+		}
+		sort_keys = append(sort_keys, sortKey{field, descending})
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		for _, key := range sort_keys {
+			c := compareValues(key.field.get(records[i]),
+				key.field.get(records[j]))
+			if c == 0 {
+				continue
+			}
+			if key.descending {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+	return nil
+}
+
+// compareValues compares two field values of the same kind. Strings compare
+// case insensitively.
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(strings.ToLower(a),
+			strings.ToLower(b.(string)))
+	case time.Time:
+		b := b.(time.Time)
+		switch {
+		case a.Before(b):
+			return -1
+		case a.After(b):
+			return 1
+		}
+	case int:
+		b := b.(int)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	case bool:
+		b := b.(bool)
+		switch {
+		case !a && b:
+			return -1
+		case a && !b:
+			return 1
+		}
+	}
+	return 0
+}
+
+// ParseQuery parses a query. An empty query matches every record.
+func ParseQuery(query string) (*Query, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens, now: time.Now()}
+	var root queryNode
+	if len(tokens) > 0 {
+		root, err = p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok := p.peek(); tok.kind != endToken {
+			return nil, BadQuery.New("unexpected %s", tok)
+		}
+	}
+	return &Query{root: root}, nil
+}
+
+// Match returns true if the record matches the query
+func (q *Query) Match(r Record) bool {
+	if q.root == nil {
+		return true
+	}
+	return q.root.match(r)
+}
+
+// String returns the query with explicit operators and parentheses
+func (q *Query) String() string {
+	if q.root == nil {
+		return ""
+	}
+	return q.root.String()
+}
+
+type queryNode interface {
+	match(r Record) bool
+	String() string
+}
+
+type andNode struct {
+	left, right queryNode
+}
+
+func (n *andNode) match(r Record) bool {
+	return n.left.match(r) && n.right.match(r)
+}
+
+func (n *andNode) String() string {
+	return "(" + n.left.String() + " AND " + n.right.String() + ")"
+}
+
+type orNode struct {
+	left, right queryNode
+}
+
+func (n *orNode) match(r Record) bool {
+	return n.left.match(r) || n.right.match(r)
+}
+
+func (n *orNode) String() string {
+	return "(" + n.left.String() + " OR " + n.right.String() + ")"
+}
+
+type notNode struct {
+	node queryNode
+}
+
+func (n *notNode) match(r Record) bool {
+	return !n.node.match(r)
+}
+
+func (n *notNode) String() string {
+	return "NOT " + n.node.String()
+}
+
+// textFields are the fields matched by bare words
+var textFields = []string{"title", "group", "username", "url", "notes"}
+
+type textNode struct {
+	value string
+	glob  *regexp.Regexp
+}
+
+func (n *textNode) match(r Record) bool {
+	for _, name := range textFields {
+		if matchText(recordFields[name].get(r).(string), n.value, n.glob) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *textNode) String() string {
+	return quoteValue(n.value)
+}
+
+type compareNode struct {
+	name  string
+	field recordField
+	op    string
+	value string
+
+	glob *regexp.Regexp
+	re   *regexp.Regexp
+
+	// start and end are the time range [start, end) for time values
+	start, end time.Time
+	n          int
+	b          bool
+}
+
+func (n *compareNode) match(r Record) bool {
+	value := n.field.get(r)
+	switch n.field.kind {
+	case stringKind:
+		s := value.(string)
+		switch n.op {
+		case ":":
+			if n.value == "" {
+				return s == ""
+			}
+			return matchText(s, n.value, n.glob)
+		case ":~":
+			return n.re.MatchString(s)
+		}
+		return compareOp(n.op, strings.Compare(s, n.value))
+	case timeKind:
+		t := value.(time.Time)
+		if n.op == ":" && n.value == "" {
+			return t.IsZero()
+		}
+		if t.IsZero() {
+			// unset times only differ from everything
+			return n.op == "!="
+		}
+		switch n.op {
+		case ":", "=":
+			return !t.Before(n.start) && t.Before(n.end)
+		case "!=":
+			return t.Before(n.start) || !t.Before(n.end)
+		case "<":
+			return t.Before(n.start)
+		case "<=":
+			return t.Before(n.end)
+		case ">":
+			return !t.Before(n.end)
+		case ">=":
+			return !t.Before(n.start)
+		}
+	case intKind:
+		return compareOp(n.op, compareValues(value, n.n))
+	case boolKind:
+		return compareOp(n.op, compareValues(value, n.b))
+	}
+	return false
+}
+
+func (n *compareNode) String() string {
+	return n.name + n.op + quoteValue(n.value)
+}
+
+// compareOp returns the result of the comparison operator given the result
+// of comparing the two values
+func compareOp(op string, c int) bool {
+	switch op {
+	case ":", "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// matchText matches the value against a glob or, without wildcards,
+// a case insensitive substring
+func matchText(s, value string, glob *regexp.Regexp) bool {
+	if glob != nil {
+		return glob.MatchString(s)
+	}
+	return strings.Contains(strings.ToLower(s), strings.ToLower(value))
+}
+
+// compileGlob returns a regular expression for the glob, or nil if the value
+// has no wildcards
+func compileGlob(value string) *regexp.Regexp {
+	if !strings.ContainsAny(value, "*?") {
+		return nil
+	}
+	expr := "(?is)^"
+	for _, c := range value {
+		switch c {
+		case '*':
+			expr += ".*"
+		case '?':
+			expr += "."
+		default:
+			expr += regexp.QuoteMeta(string(c))
+		}
+	}
+	return regexp.MustCompile(expr + "$")
+}
+
+func quoteValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\r\n()\"\\") ||
+		isKeyword(value) {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+func isKeyword(word string) bool {
+	return word == "AND" || word == "OR" || word == "NOT"
+}
+
+type tokenKind int
+
+const (
+	endToken tokenKind = iota
+	termToken
+	andToken
+	orToken
+	notToken
+	openToken
+	closeToken
+)
+
+type token struct {
+	kind  tokenKind
+	field string
+	op    string
+	value string
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case endToken:
+		return "end of query"
+	case andToken:
+		return "AND"
+	case orToken:
+		return "OR"
+	case notToken:
+		return "NOT"
+	case openToken:
+		return `"("`
+	case closeToken:
+		return `")"`
+	}
+	return strconv.Quote(t.field + t.op + t.value)
+}
+
+// queryOps lists the comparison operators, longest first
+var queryOps = []string{":~", "!=", "<=", ">=", ":", "=", "<", ">"}
+
+func tokenize(query string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(query); {
+		switch query[i] {
+		case ' ', '\t', '\r', '\n':
+			i++
+		case '(':
+			tokens = append(tokens, token{kind: openToken})
+			i++
+		case ')':
+			tokens = append(tokens, token{kind: closeToken})
+			i++
+		default:
+			tok, n, err := scanTerm(query[i:])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, tok)
+			i += n
+		}
+	}
+	return tokens, nil
+}
+
+// scanTerm scans a keyword, bare word or field comparison
+func scanTerm(s string) (tok token, n int, err error) {
+	if s[0] == '"' {
+		value, n, err := scanQuoted(s)
+		if err != nil {
+			return token{}, 0, err
+		}
+		return token{kind: termToken, value: value}, n, nil
+	}
+
+	name := 0
+	for name < len(s) && isFieldChar(s[name]) {
+		name++
+	}
+	if name > 0 {
+		for _, op := range queryOps {
+			if !strings.HasPrefix(s[name:], op) {
+				continue
+			}
+			start := name + len(op)
+			if start < len(s) && s[start] == '"' {
+				value, n, err := scanQuoted(s[start:])
+				if err != nil {
+					return token{}, 0, err
+				}
+				return token{kind: termToken, field: s[:name], op: op,
+					value: value}, start + n, nil
+			}
+			end := start + scanWord(s[start:])
+			return token{kind: termToken, field: s[:name], op: op,
+				value: s[start:end]}, end, nil
+		}
+	}
+
+	n = scanWord(s)
+	switch s[:n] {
+	case "AND":
+		return token{kind: andToken}, n, nil
+	case "OR":
+		return token{kind: orToken}, n, nil
+	case "NOT":
+		return token{kind: notToken}, n, nil
+	}
+	return token{kind: termToken, value: s[:n]}, n, nil
+}
+
+func isFieldChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		c >= '0' && c <= '9' || c == '_' || c == '.'
+}
+
+// scanWord returns the length of the word at the start of s
+func scanWord(s string) int {
+	n := strings.IndexAny(s, " \t\r\n()")
+	if n < 0 {
+		return len(s)
+	}
+	return n
+}
+
+// scanQuoted scans a double quoted string with backslash escapes
+func scanQuoted(s string) (value string, n int, err error) {
+	var b []byte
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b = append(b, s[i])
+			}
+		case '"':
+			return string(b), i + 1, nil
+		default:
+			b = append(b, s[i])
+		}
+	}
+	return "", 0, BadQuery.New("unterminated quoted string")
+}
+
+type queryParser struct {
+	tokens []token
+	pos    int
+	now    time.Time
+}
+
+func (p *queryParser) peek() token {
+	if p.pos >= len(p.tokens) {
+		return token{kind: endToken}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() token {
+	tok := p.peek()
+	if tok.kind != endToken {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == orToken {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek().kind {
+		case andToken:
+			p.next()
+		case termToken, notToken, openToken:
+		default:
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left: left, right: right}
+	}
+}
+
+func (p *queryParser) parseNot() (queryNode, error) {
+	if p.peek().kind == notToken {
+		p.next()
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{node: node}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (queryNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case openToken:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok := p.next(); tok.kind != closeToken {
+			return nil, BadQuery.New("expected \")\", got %s", tok)
+		}
+		return node, nil
+	case termToken:
+		if tok.field == "" {
+			return &textNode{value: tok.value, glob: compileGlob(tok.value)},
+				nil
+		}
+		return p.parseComparison(tok)
+	}
+	return nil, BadQuery.New("unexpected %s", tok)
+}
+
+func (p *queryParser) parseComparison(tok token) (queryNode, error) {
+	field, ok := lookupField(tok.field)
+	if !ok {
+		return nil, BadQuery.New("unknown field %q", tok.field)
+	}
+	n := &compareNode{
+		name:  tok.field,
+		field: field,
+		op:    tok.op,
+		value: tok.value,
+	}
+	if n.op == ":~" && field.kind != stringKind {
+		return nil, BadQuery.New("%s cannot be matched with a regular "+
+			"expression", n.name)
+	}
+	if n.value == "" && n.op == ":" && field.kind != intKind &&
+		field.kind != boolKind {
+		return n, nil
+	}
+
+	var err error
+	switch field.kind {
+	case stringKind:
+		switch n.op {
+		case ":":
+			n.glob = compileGlob(n.value)
+		case ":~":
+			n.re, err = regexp.Compile(n.value)
+		}
+	case timeKind:
+		var ok bool
+		n.start, n.end, ok = parseTimeValue(n.value, p.now)
+		if !ok {
+			return nil, BadQuery.New("invalid value for %s: expected a "+
+				"date, RFC 3339 time or now", n.name)
+		}
+	case intKind:
+		n.n, err = strconv.Atoi(n.value)
+	case boolKind:
+		switch strings.ToLower(n.value) {
+		case "true", "yes", "1":
+			n.b = true
+		case "false", "no", "0":
+			n.b = false
+		default:
+			return nil, BadQuery.New("invalid value for %s: expected true "+
+				"or false", n.name)
+		}
+	}
+	if err != nil {
+		return nil, BadQuery.New("invalid value for %s: %s", n.name, err)
+	}
+	return n, nil
+}
+
+// parseTimeValue returns the time range [start, end) of a time value
+func parseTimeValue(value string, now time.Time) (start, end time.Time,
+	ok bool) {
+
+	if value == "now" {
+		return now, now.Add(time.Second), true
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value,
+		time.Local); err == nil {
+		return t, t.AddDate(0, 0, 1), true
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	return t, t.Add(time.Second), true
+}
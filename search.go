@@ -0,0 +1,175 @@
+package pwsafe
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Fuzzy match scoring. Every matched character scores scoreMatch, with
+// bonuses for matching at the start of a word and for consecutive matches.
+// Characters skipped between matches cost scoreGap each.
+const (
+	scoreMatch            = 16
+	scoreGap              = -1
+	bonusBoundary         = 8
+	bonusCamelCase        = 6
+	bonusConsecutive      = 8
+	bonusFirstCharPattern = 4
+)
+
+// searchFields are the fields searched and their weights
+var searchFields = []struct {
+	name   string
+	weight float64
+}{
+	{"title", 4},
+	{"group", 2},
+	{"username", 2},
+	{"url", 2},
+	{"notes", 1},
+}
+
+// recencyHalfLife is the time after which the recency boost of a recently
+// accessed record is halved
+const recencyHalfLife = 30 * 24 * time.Hour
+
+// SearchResult is a record matched by Search
+type SearchResult struct {
+	Record Record
+	Score  float64
+}
+
+// Search returns the records fuzzily matching the whitespace separated
+// terms, best match first. Every term must match, as a subsequence, at least
+// one of the title, group, username, URL or notes of a record. Matches at
+// word boundaries and consecutive matches score higher, as do recently
+// accessed records.
+func Search(records []Record, terms string) []SearchResult {
+	patterns := strings.Fields(strings.ToLower(terms))
+	now := time.Now()
+
+	var results []SearchResult
+	for _, record := range records {
+		score, ok := scoreRecord(record, patterns)
+		if !ok {
+			continue
+		}
+		if atime := record.Atime(); !atime.IsZero() && atime.Before(now) {
+			age := float64(now.Sub(atime)) / float64(recencyHalfLife)
+			score *= 1 + 0.5*math.Pow(2, -age)
+		}
+		results = append(results, SearchResult{Record: record, Score: score})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return compareValues(results[i].Record.Title(),
+			results[j].Record.Title()) < 0
+	})
+	return results
+}
+
+// scoreRecord returns the sum of the best weighted field score of each
+// pattern. ok is false if a pattern does not match any field.
+func scoreRecord(record Record, patterns []string) (score float64, ok bool) {
+	for _, pattern := range patterns {
+		best := 0.0
+		for _, field := range searchFields {
+			text := recordFields[field.name].get(record).(string)
+			if s, ok := fuzzyScore(pattern, text); ok {
+				best = math.Max(best, float64(s)*field.weight)
+			}
+		}
+		if best == 0 {
+			return 0, false
+		}
+		score += best
+	}
+	return score, true
+}
+
+// fuzzyScore returns the score of the best alignment of the lower case
+// pattern as a subsequence of the text. ok is false if the pattern is not a
+// subsequence of the text.
+func fuzzyScore(pattern, text string) (score int, ok bool) {
+	p := []rune(pattern)
+	t := []rune(text)
+	if len(p) == 0 || len(p) > len(t) {
+		return 0, false
+	}
+
+	bonus := make([]int, len(t))
+	lower := make([]rune, len(t))
+	for j, c := range t {
+		lower[j] = unicode.ToLower(c)
+		switch {
+		case j == 0 || !isWordChar(t[j-1]) && isWordChar(c):
+			bonus[j] = bonusBoundary
+		case unicode.IsLower(t[j-1]) && unicode.IsUpper(c):
+			bonus[j] = bonusCamelCase
+		}
+	}
+
+	// prev[j] and cur[j] are the best scores of matching the pattern up to
+	// the previous and current pattern character with that character at
+	// text position j
+	const none = math.MinInt32
+	prev := make([]int, len(t))
+	cur := make([]int, len(t))
+	for j := range t {
+		prev[j] = none
+		if lower[j] == p[0] {
+			prev[j] = scoreMatch + bonus[j]*2 + bonusFirstCharPattern
+		}
+	}
+	for i := 1; i < len(p); i++ {
+		// run is the best score ending before j-1, including the gap
+		run := none
+		for j := range t {
+			cur[j] = none
+			if j >= 2 && prev[j-2] != none && prev[j-2]+scoreGap > run {
+				run = prev[j-2] + scoreGap
+			}
+			if lower[j] == p[i] && j > 0 {
+				best := none
+				if prev[j-1] != none {
+					best = prev[j-1] + bonusConsecutive
+				}
+				if run != none && run > best {
+					best = run
+				}
+				if best != none {
+					cur[j] = best + scoreMatch + bonus[j]
+				}
+			}
+			if run != none {
+				run += scoreGap
+			}
+		}
+		prev, cur = cur, prev
+	}
+
+	score = none
+	for _, s := range prev {
+		if s > score {
+			score = s
+		}
+	}
+	if score == none {
+		return 0, false
+	}
+	if score < 1 {
+		// long gaps still match
+		score = 1
+	}
+	return score, true
+}
+
+func isWordChar(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c)
+}
@@ -0,0 +1,400 @@
+package secretservice
+
+import (
+	"time"
+
+	"github.com/azdagron/pwsafe/v3"
+	"github.com/godbus/dbus/v5"
+)
+
+// messagePath returns the object path a method was called on
+func messagePath(msg dbus.Message) dbus.ObjectPath {
+	path, _ := msg.Headers[dbus.FieldPath].Value().(dbus.ObjectPath)
+	return path
+}
+
+// collectionObject implements org.freedesktop.Secret.Collection for every
+// collection path
+type collectionObject struct {
+	s *Service
+}
+
+// collection returns the group of the collection the method was called on.
+// Must be called with the lock held.
+func (o collectionObject) collection(msg dbus.Message) (string,
+	*dbus.Error) {
+
+	path := messagePath(msg)
+	group, record, err := o.s.lookup(path)
+	if err != nil {
+		return "", err
+	}
+	if record != nil {
+		return "", errNoSuchObject(path)
+	}
+	return group, nil
+}
+
+// Delete removes the items of a collection. The default collection cannot be
+// deleted.
+func (o collectionObject) Delete(msg dbus.Message) (dbus.ObjectPath,
+	*dbus.Error) {
+
+	s := o.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, err := o.collection(msg)
+	if err != nil {
+		return noPrompt, err
+	}
+	if err = s.writable(); err != nil {
+		return noPrompt, err
+	}
+	if group == s.group {
+		return noPrompt, dbus.NewError(
+			"org.freedesktop.DBus.Error.AccessDenied",
+			[]interface{}{"the default collection cannot be deleted"})
+	}
+	records := s.items(group, nil)
+	for _, record := range records {
+		if record.Protected() {
+			return noPrompt, errProtected
+		}
+	}
+	path := s.collectionPath(group)
+	for _, record := range records {
+		s.db.RemoveRecord(record.UUID())
+	}
+	header := s.db.Header().(*v3.Header)
+	var empty []string
+	for _, g := range header.EmptyGroups() {
+		if g != group {
+			empty = append(empty, g)
+		}
+	}
+	header.SetEmptyGroups(empty)
+	if err = s.save(); err != nil {
+		return noPrompt, err
+	}
+	for alias, g := range s.aliases {
+		if g == group {
+			delete(s.aliases, alias)
+		}
+	}
+	s.emit(servicePath, serviceIface+".CollectionDeleted", path)
+	return noPrompt, nil
+}
+
+func (o collectionObject) SearchItems(msg dbus.Message,
+	attributes map[string]string) ([]dbus.ObjectPath, *dbus.Error) {
+
+	s := o.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, err := o.collection(msg)
+	if err != nil {
+		return nil, err
+	}
+	paths := []dbus.ObjectPath{}
+	for _, record := range s.items(group, attributes) {
+		paths = append(paths, s.itemPath(record))
+	}
+	return paths, nil
+}
+
+// CreateItem adds an entry to the collection. With replace, an item with
+// the same attributes is updated instead.
+func (o collectionObject) CreateItem(msg dbus.Message,
+	properties map[string]dbus.Variant, secret Secret, replace bool) (
+	dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+
+	s := o.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, err := o.collection(msg)
+	if err != nil {
+		return noPrompt, noPrompt, err
+	}
+	if err = s.writable(); err != nil {
+		return noPrompt, noPrompt, err
+	}
+	label, _ := properties[itemIface+".Label"].Value().(string)
+	attribute_value := properties[itemIface+".Attributes"]
+	attributes, _ := attribute_value.Value().(map[string]string)
+
+	var record *v3.Record
+	if replace {
+		for _, r := range s.items(group, attributes) {
+			if len(itemAttributes(r)) == len(attributes) {
+				record = r
+				break
+			}
+		}
+	}
+	created := record == nil
+	if created {
+		var new_err error
+		if record, new_err = v3.NewRecord(); new_err != nil {
+			return noPrompt, noPrompt, dbus.MakeFailedError(new_err)
+		}
+		record.SetGroup(group)
+	} else if record.Protected() {
+		return noPrompt, noPrompt, errProtected
+	}
+	if err = s.setSecret(record, secret); err != nil {
+		return noPrompt, noPrompt, err
+	}
+	record.SetTitle(label)
+	setItemAttributes(record, attributes)
+	record.SetMtime(time.Now())
+	if created {
+		if add_err := s.db.AddRecord(record); add_err != nil {
+			return noPrompt, noPrompt, dbus.MakeFailedError(add_err)
+		}
+	}
+	if err = s.save(); err != nil {
+		return noPrompt, noPrompt, err
+	}
+
+	path := s.itemPath(record)
+	if created {
+		s.emit(s.collectionPath(group), collectionIface+".ItemCreated", path)
+	} else {
+		s.emit(s.collectionPath(group), collectionIface+".ItemChanged", path)
+	}
+	return path, noPrompt, nil
+}
+
+// itemObject implements org.freedesktop.Secret.Item for every item path
+type itemObject struct {
+	s *Service
+}
+
+// item returns the entry of the item the method was called on. Must be
+// called with the lock held.
+func (o itemObject) item(msg dbus.Message) (*v3.Record, *dbus.Error) {
+	path := messagePath(msg)
+	_, record, err := o.s.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, errNoSuchObject(path)
+	}
+	return record, nil
+}
+
+func (o itemObject) Delete(msg dbus.Message) (dbus.ObjectPath, *dbus.Error) {
+	s := o.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, err := o.item(msg)
+	if err != nil {
+		return noPrompt, err
+	}
+	if err = s.writable(); err != nil {
+		return noPrompt, err
+	}
+	if record.Protected() || len(s.db.Dependents(record.UUID())) > 0 {
+		return noPrompt, errProtected
+	}
+	path := s.itemPath(record)
+	s.db.RemoveRecord(record.UUID())
+	if err = s.save(); err != nil {
+		return noPrompt, err
+	}
+	s.emit(s.collectionPath(record.Group()), collectionIface+".ItemDeleted",
+		path)
+	return noPrompt, nil
+}
+
+func (o itemObject) GetSecret(msg dbus.Message,
+	session_path dbus.ObjectPath) (Secret, *dbus.Error) {
+
+	s := o.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, err := o.item(msg)
+	if err != nil {
+		return Secret{}, err
+	}
+	session := s.sessions[session_path]
+	if session == nil {
+		return Secret{}, errNoSession
+	}
+	return s.secret(session, session_path, record)
+}
+
+func (o itemObject) SetSecret(msg dbus.Message, secret Secret) *dbus.Error {
+	s := o.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, err := o.item(msg)
+	if err != nil {
+		return err
+	}
+	if err = s.writable(); err != nil {
+		return err
+	}
+	if record.Protected() {
+		return errProtected
+	}
+	if err = s.setSecret(record, secret); err != nil {
+		return err
+	}
+	return s.changed(record)
+}
+
+// changed saves a changed item. Must be called with the lock held.
+func (s *Service) changed(record *v3.Record) *dbus.Error {
+	record.SetMtime(time.Now())
+	if err := s.save(); err != nil {
+		return err
+	}
+	s.emit(s.collectionPath(record.Group()), collectionIface+".ItemChanged",
+		s.itemPath(record))
+	return nil
+}
+
+// propertiesObject implements org.freedesktop.DBus.Properties for the
+// service, collections and items
+type propertiesObject struct {
+	s *Service
+}
+
+// properties returns the properties of the interface of an object. Must be
+// called with the lock held.
+func (o propertiesObject) properties(path dbus.ObjectPath, iface string) (
+	map[string]dbus.Variant, *dbus.Error) {
+
+	s := o.s
+	if path == servicePath {
+		if iface != serviceIface && iface != "" {
+			return nil, errUnknownInterface(iface)
+		}
+		paths := []dbus.ObjectPath{}
+		for _, group := range s.collections() {
+			paths = append(paths, s.collectionPath(group))
+		}
+		return map[string]dbus.Variant{
+			"Collections": dbus.MakeVariant(paths),
+		}, nil
+	}
+
+	group, record, err := s.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		if iface != collectionIface && iface != "" {
+			return nil, errUnknownInterface(iface)
+		}
+		items := []dbus.ObjectPath{}
+		var created, modified time.Time
+		for _, record := range s.items(group, nil) {
+			items = append(items, s.itemPath(record))
+			if created.IsZero() || record.Ctime().Before(created) {
+				created = record.Ctime()
+			}
+			if record.Mtime().After(modified) {
+				modified = record.Mtime()
+			}
+		}
+		return map[string]dbus.Variant{
+			"Items":    dbus.MakeVariant(items),
+			"Label":    dbus.MakeVariant(s.collectionLabel(group)),
+			"Locked":   dbus.MakeVariant(s.db == nil),
+			"Created":  dbus.MakeVariant(unixTime(created)),
+			"Modified": dbus.MakeVariant(unixTime(modified)),
+		}, nil
+	}
+
+	if iface != itemIface && iface != "" {
+		return nil, errUnknownInterface(iface)
+	}
+	return map[string]dbus.Variant{
+		"Locked":     dbus.MakeVariant(false),
+		"Attributes": dbus.MakeVariant(itemAttributes(record)),
+		"Label":      dbus.MakeVariant(record.Title()),
+		"Created":    dbus.MakeVariant(unixTime(record.Ctime())),
+		"Modified":   dbus.MakeVariant(unixTime(record.Mtime())),
+	}, nil
+}
+
+func unixTime(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.Unix())
+}
+
+func errUnknownInterface(iface string) *dbus.Error {
+	return dbus.NewError("org.freedesktop.DBus.Error.UnknownInterface",
+		[]interface{}{"unknown interface " + iface})
+}
+
+func (o propertiesObject) Get(msg dbus.Message, iface, name string) (
+	dbus.Variant, *dbus.Error) {
+
+	o.s.mu.Lock()
+	defer o.s.mu.Unlock()
+	properties, err := o.properties(messagePath(msg), iface)
+	if err != nil {
+		return dbus.Variant{}, err
+	}
+	value, ok := properties[name]
+	if !ok {
+		return dbus.Variant{}, dbus.NewError(
+			"org.freedesktop.DBus.Error.UnknownProperty",
+			[]interface{}{"unknown property " + name})
+	}
+	return value, nil
+}
+
+func (o propertiesObject) GetAll(msg dbus.Message, iface string) (
+	map[string]dbus.Variant, *dbus.Error) {
+
+	o.s.mu.Lock()
+	defer o.s.mu.Unlock()
+	return o.properties(messagePath(msg), iface)
+}
+
+// Set changes the label or attributes of an item
+func (o propertiesObject) Set(msg dbus.Message, iface, name string,
+	value dbus.Variant) *dbus.Error {
+
+	s := o.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := messagePath(msg)
+	_, record, err := s.lookup(path)
+	if err != nil {
+		return err
+	}
+	if record == nil || iface != itemIface ||
+		name != "Label" && name != "Attributes" {
+		return dbus.NewError("org.freedesktop.DBus.Error.PropertyReadOnly",
+			[]interface{}{"property " + name + " is read-only"})
+	}
+	if err = s.writable(); err != nil {
+		return err
+	}
+	if record.Protected() {
+		return errProtected
+	}
+	switch name {
+	case "Label":
+		label, ok := value.Value().(string)
+		if !ok {
+			return errInvalidArgs("Label must be a string")
+		}
+		record.SetTitle(label)
+	case "Attributes":
+		attributes, ok := value.Value().(map[string]string)
+		if !ok {
+			return errInvalidArgs("Attributes must be a{ss}")
+		}
+		setItemAttributes(record, attributes)
+	}
+	return s.changed(record)
+}
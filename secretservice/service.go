@@ -0,0 +1,666 @@
+// Package secretservice implements the freedesktop Secret Service D-Bus API
+// (org.freedesktop.secrets) on top of a v3 password safe database, so that
+// applications using libsecret store their secrets in it.
+//
+// Collections are groups: the default collection ("login") is a dedicated
+// group, and every direct subgroup of it is another collection. Items are
+// the entries directly in those groups. The label of an item is the title of
+// the entry, the secret its password, and every attribute a custom field
+// named with the prefix "attr:".
+package secretservice
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/v3"
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	// BusName is the well-known name the Service is reached at
+	BusName = "org.freedesktop.secrets"
+
+	servicePath      = "/org/freedesktop/secrets"
+	collectionPrefix = servicePath + "/collection/"
+	aliasPrefix      = servicePath + "/aliases/"
+	sessionPrefix    = servicePath + "/session/"
+	promptPrefix     = servicePath + "/prompt/"
+
+	serviceIface    = "org.freedesktop.Secret.Service"
+	collectionIface = "org.freedesktop.Secret.Collection"
+	itemIface       = "org.freedesktop.Secret.Item"
+	sessionIface    = "org.freedesktop.Secret.Session"
+	promptIface     = "org.freedesktop.Secret.Prompt"
+	propertiesIface = "org.freedesktop.DBus.Properties"
+
+	// noPrompt is returned when no prompt is necessary
+	noPrompt = dbus.ObjectPath("/")
+
+	// defaultName is the name of the default collection in object paths
+	defaultName  = "login"
+	defaultLabel = "Login"
+
+	// attributePrefix starts the names of the custom fields holding item
+	// attributes
+	attributePrefix = "attr:"
+
+	// contentTypeField holds the content type of secrets other than
+	// text/plain
+	contentTypeField   = "secret-content-type"
+	defaultContentType = "text/plain"
+)
+
+// Options configure the Service
+type Options struct {
+	// Group is the group of the default collection, with elements
+	// separated by "/"
+	Group string
+
+	// Save saves the database after a change. The Service is read-only if
+	// nil.
+	Save func(db *v3.Database) error
+
+	// Unlock opens the database when a client asks to unlock it, typically
+	// asking the user for the passphrase. Unlocking is impossible if nil.
+	Unlock func() (*v3.Database, error)
+}
+
+// Secret is a secret as transferred over D-Bus, (oayays)
+type Secret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// Service serves the Secret Service API for a database
+type Service struct {
+	conn  *dbus.Conn
+	opts  Options
+	group string
+
+	mu sync.Mutex
+	// db is nil while locked
+	db *v3.Database
+	// known are the collections seen when the database was last unlocked
+	known    []string
+	aliases  map[string]string
+	sessions map[dbus.ObjectPath]*session
+	prompts  map[dbus.ObjectPath]*prompt
+	serial   uint64
+}
+
+// New exports the Service on the connection. The database may be nil to
+// start locked. Requesting BusName is up to the caller.
+func New(conn *dbus.Conn, db *v3.Database, opts Options) (*Service, error) {
+	path := strings.Trim(opts.Group, "/")
+	if path == "" {
+		return nil, pwsafe.Error.New("a group is required")
+	}
+	s := &Service{
+		conn:     conn,
+		opts:     opts,
+		group:    pwsafe.JoinGroup(strings.Split(path, "/")...),
+		db:       db,
+		aliases:  make(map[string]string),
+		sessions: make(map[dbus.ObjectPath]*session),
+		prompts:  make(map[dbus.ObjectPath]*prompt),
+	}
+	s.aliases["default"] = s.group
+	s.known = []string{s.group}
+
+	exports := []struct {
+		value   interface{}
+		path    dbus.ObjectPath
+		iface   string
+		subtree bool
+	}{
+		{serviceObject{s}, servicePath, serviceIface, false},
+		// more specific exports hide the interfaces of their parents
+		{propertiesObject{s}, servicePath, propertiesIface, false},
+		{propertiesObject{s}, servicePath + "/collection", propertiesIface, true},
+		{propertiesObject{s}, servicePath + "/aliases", propertiesIface, true},
+		{collectionObject{s}, servicePath + "/collection", collectionIface, true},
+		{collectionObject{s}, servicePath + "/aliases", collectionIface, true},
+		{itemObject{s}, servicePath + "/collection", itemIface, true},
+		{itemObject{s}, servicePath + "/aliases", itemIface, true},
+		{sessionObject{s}, servicePath + "/session", sessionIface, true},
+		{promptObject{s}, servicePath + "/prompt", promptIface, true},
+	}
+	for _, export := range exports {
+		var err error
+		if export.subtree {
+			err = conn.ExportSubtree(export.value, export.path, export.iface)
+		} else {
+			err = conn.Export(export.value, export.path, export.iface)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Lock wipes the database from memory
+func (s *Service) Lock() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lock()
+}
+
+func (s *Service) lock() {
+	if s.db == nil {
+		return
+	}
+	s.known = s.collections()
+	s.db.Wipe()
+	s.db = nil
+}
+
+// save saves the database after a change. Must be called with the lock
+// held.
+func (s *Service) save() *dbus.Error {
+	if err := s.opts.Save(s.db); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+// writable returns an error if the database cannot be changed. Must be
+// called with the lock held.
+func (s *Service) writable() *dbus.Error {
+	if s.db == nil {
+		return errLocked
+	}
+	if s.opts.Save == nil {
+		return errReadOnly
+	}
+	return nil
+}
+
+var (
+	errLocked = dbus.NewError("org.freedesktop.Secret.Error.IsLocked",
+		[]interface{}{"the database is locked"})
+	errNoSession = dbus.NewError("org.freedesktop.Secret.Error.NoSession",
+		[]interface{}{"no such session"})
+	errReadOnly = dbus.NewError("org.freedesktop.DBus.Error.AccessDenied",
+		[]interface{}{"the database is read-only"})
+	errProtected = dbus.NewError("org.freedesktop.DBus.Error.AccessDenied",
+		[]interface{}{"the entry is protected"})
+)
+
+func errNoSuchObject(path dbus.ObjectPath) *dbus.Error {
+	return dbus.NewError("org.freedesktop.Secret.Error.NoSuchObject",
+		[]interface{}{"no such object " + string(path)})
+}
+
+func errInvalidArgs(message string) *dbus.Error {
+	return dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs",
+		[]interface{}{message})
+}
+
+// nextPath returns a new object path for a session or prompt. Must be
+// called with the lock held.
+func (s *Service) nextPath(prefix string) dbus.ObjectPath {
+	s.serial++
+	return dbus.ObjectPath(prefix + strconv.FormatUint(s.serial, 10))
+}
+
+// emit sends a signal, ignoring errors as clients may be gone
+func (s *Service) emit(path dbus.ObjectPath, name string,
+	values ...interface{}) {
+
+	s.conn.Emit(path, name, values...)
+}
+
+// collections returns the groups of the collections. Must be called with
+// the lock held.
+func (s *Service) collections() []string {
+	if s.db == nil {
+		return s.known
+	}
+	found := map[string]bool{s.group: true}
+	add := func(group string) {
+		if sub := s.subgroup(group); sub != "" {
+			found[sub] = true
+		}
+	}
+	for _, record := range s.db.Records() {
+		add(record.Group())
+	}
+	for _, group := range s.db.Header().EmptyGroups() {
+		add(group)
+	}
+	var groups []string
+	for group := range found {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// subgroup returns the direct subgroup of the default collection group the
+// group is in, or an empty string
+func (s *Service) subgroup(group string) string {
+	parent := pwsafe.SplitGroup(s.group)
+	elements := pwsafe.SplitGroup(group)
+	if len(elements) <= len(parent) {
+		return ""
+	}
+	for i := range parent {
+		if elements[i] != parent[i] {
+			return ""
+		}
+	}
+	return pwsafe.JoinGroup(elements[:len(parent)+1]...)
+}
+
+// hasCollection returns true if the group is a collection. Must be called
+// with the lock held.
+func (s *Service) hasCollection(group string) bool {
+	for _, g := range s.collections() {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// collectionLabel returns the label of a collection
+func (s *Service) collectionLabel(group string) string {
+	if group == s.group {
+		return defaultLabel
+	}
+	elements := pwsafe.SplitGroup(group)
+	return elements[len(elements)-1]
+}
+
+// collectionPath returns the object path of a collection
+func (s *Service) collectionPath(group string) dbus.ObjectPath {
+	if group == s.group {
+		return collectionPrefix + defaultName
+	}
+	return dbus.ObjectPath(collectionPrefix +
+		encodePathElement(s.collectionLabel(group)))
+}
+
+// itemPath returns the object path of an item
+func (s *Service) itemPath(record *v3.Record) dbus.ObjectPath {
+	return s.collectionPath(record.Group()) + "/" +
+		dbus.ObjectPath(record.UUID())
+}
+
+// lookup returns the collection group and the item record of an object
+// path. The record is nil for collection paths. Must be called with the
+// lock held.
+func (s *Service) lookup(path dbus.ObjectPath) (string, *v3.Record,
+	*dbus.Error) {
+
+	var rest string
+	var group string
+	switch p := string(path); {
+	case strings.HasPrefix(p, collectionPrefix):
+		rest = p[len(collectionPrefix):]
+		name := strings.SplitN(rest, "/", 2)[0]
+		if name == defaultName {
+			group = s.group
+		} else if label, ok := decodePathElement(name); ok {
+			group = pwsafe.JoinGroup(append(pwsafe.SplitGroup(s.group),
+				label)...)
+		}
+	case strings.HasPrefix(p, aliasPrefix):
+		rest = p[len(aliasPrefix):]
+		group = s.aliases[strings.SplitN(rest, "/", 2)[0]]
+	}
+	if group == "" || !s.hasCollection(group) {
+		return "", nil, errNoSuchObject(path)
+	}
+
+	parts := strings.SplitN(rest, "/", 2)
+	if len(parts) == 1 {
+		return group, nil, nil
+	}
+	if s.db == nil {
+		return "", nil, errLocked
+	}
+	record := s.db.Record(parts[1])
+	if record == nil || record.Group() != group {
+		return "", nil, errNoSuchObject(path)
+	}
+	return group, record, nil
+}
+
+// encodePathElement encodes a label for an object path, which may only
+// hold letters, digits and underscores
+func encodePathElement(label string) string {
+	const hex = "0123456789abcdef"
+	var buf []byte
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+			c >= '0' && c <= '9' {
+			buf = append(buf, c)
+		} else {
+			buf = append(buf, '_', hex[c>>4], hex[c&0xf])
+		}
+	}
+	return string(buf)
+}
+
+func decodePathElement(element string) (string, bool) {
+	var buf []byte
+	for i := 0; i < len(element); i++ {
+		if element[i] != '_' {
+			buf = append(buf, element[i])
+			continue
+		}
+		if i+2 >= len(element) {
+			return "", false
+		}
+		var c byte
+		for _, h := range element[i+1 : i+3] {
+			switch {
+			case h >= '0' && h <= '9':
+				c = c<<4 | byte(h-'0')
+			case h >= 'a' && h <= 'f':
+				c = c<<4 | byte(h-'a'+10)
+			default:
+				return "", false
+			}
+		}
+		buf = append(buf, c)
+		i += 2
+	}
+	return string(buf), true
+}
+
+// items returns the entries of a collection matching the attributes. Must
+// be called with the lock held.
+func (s *Service) items(group string,
+	attributes map[string]string) []*v3.Record {
+
+	if s.db == nil {
+		return nil
+	}
+	var records []*v3.Record
+	for _, r := range s.db.Records() {
+		record := r.(*v3.Record)
+		if record.Group() != group {
+			continue
+		}
+		if matchAttributes(record, attributes) {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// itemAttributes returns the attributes of an item
+func itemAttributes(record *v3.Record) map[string]string {
+	attributes := make(map[string]string)
+	for _, field := range record.CustomFields() {
+		if strings.HasPrefix(field.Name, attributePrefix) {
+			attributes[field.Name[len(attributePrefix):]] = field.Value
+		}
+	}
+	return attributes
+}
+
+// setItemAttributes replaces the attributes of an item, keeping other
+// custom fields
+func setItemAttributes(record *v3.Record, attributes map[string]string) {
+	var fields []pwsafe.CustomField
+	for _, field := range record.CustomFields() {
+		if !strings.HasPrefix(field.Name, attributePrefix) {
+			fields = append(fields, field)
+		}
+	}
+	var names []string
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fields = append(fields, pwsafe.CustomField{
+			Name:  attributePrefix + name,
+			Value: attributes[name],
+		})
+	}
+	record.SetCustomFields(fields)
+}
+
+func matchAttributes(record *v3.Record, attributes map[string]string) bool {
+	have := itemAttributes(record)
+	for name, value := range attributes {
+		if v, ok := have[name]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// serviceObject implements org.freedesktop.Secret.Service
+type serviceObject struct {
+	s *Service
+}
+
+func (o serviceObject) OpenSession(algorithm string, input dbus.Variant) (
+	dbus.Variant, dbus.ObjectPath, *dbus.Error) {
+
+	session, output, err := openSession(algorithm, input)
+	if err != nil {
+		return dbus.MakeVariant(""), noPrompt, err
+	}
+	o.s.mu.Lock()
+	defer o.s.mu.Unlock()
+	path := o.s.nextPath(sessionPrefix)
+	o.s.sessions[path] = session
+	return output, path, nil
+}
+
+func (o serviceObject) CreateCollection(properties map[string]dbus.Variant,
+	alias string) (dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+
+	s := o.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if group, ok := s.aliases[alias]; ok && alias != "" &&
+		s.hasCollection(group) {
+		return s.collectionPath(group), noPrompt, nil
+	}
+	if err := s.writable(); err != nil {
+		return noPrompt, noPrompt, err
+	}
+	label, _ := properties[collectionIface+".Label"].Value().(string)
+	if label == "" || label == defaultName || label == defaultLabel {
+		return noPrompt, noPrompt, errInvalidArgs("invalid label " +
+			label)
+	}
+	group := pwsafe.JoinGroup(append(pwsafe.SplitGroup(s.group),
+		label)...)
+	if !s.hasCollection(group) {
+		header := s.db.Header().(*v3.Header)
+		header.SetEmptyGroups(append(header.EmptyGroups(), group))
+		if err := s.save(); err != nil {
+			return noPrompt, noPrompt, err
+		}
+		s.emit(servicePath, serviceIface+".CollectionCreated",
+			s.collectionPath(group))
+	}
+	if alias != "" {
+		s.aliases[alias] = group
+	}
+	return s.collectionPath(group), noPrompt, nil
+}
+
+func (o serviceObject) SearchItems(attributes map[string]string) (
+	[]dbus.ObjectPath, []dbus.ObjectPath, *dbus.Error) {
+
+	s := o.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlocked := []dbus.ObjectPath{}
+	for _, group := range s.collections() {
+		for _, record := range s.items(group, attributes) {
+			unlocked = append(unlocked, s.itemPath(record))
+		}
+	}
+	// the items of a locked database are unknown
+	return unlocked, []dbus.ObjectPath{}, nil
+}
+
+func (o serviceObject) Unlock(objects []dbus.ObjectPath) (
+	[]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+
+	s := o.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db != nil {
+		return objects, noPrompt, nil
+	}
+	if s.opts.Unlock == nil {
+		return []dbus.ObjectPath{}, noPrompt, nil
+	}
+	path := s.nextPath(promptPrefix)
+	s.prompts[path] = &prompt{action: func() (dbus.Variant, bool) {
+		db, err := s.opts.Unlock()
+		if err != nil {
+			return dbus.MakeVariant([]dbus.ObjectPath{}), true
+		}
+		s.mu.Lock()
+		if s.db != nil {
+			db.Wipe()
+		} else {
+			s.db = db
+		}
+		groups := s.collections()
+		s.mu.Unlock()
+		for _, group := range groups {
+			s.emit(servicePath, serviceIface+".CollectionChanged",
+				s.collectionPath(group))
+		}
+		return dbus.MakeVariant(objects), false
+	}}
+	return []dbus.ObjectPath{}, path, nil
+}
+
+func (o serviceObject) Lock(objects []dbus.ObjectPath) (
+	[]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+
+	// there is one database, so locking anything locks everything
+	o.s.Lock()
+	return objects, noPrompt, nil
+}
+
+func (o serviceObject) GetSecrets(items []dbus.ObjectPath,
+	session_path dbus.ObjectPath) (map[dbus.ObjectPath]Secret,
+	*dbus.Error) {
+
+	s := o.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session := s.sessions[session_path]
+	if session == nil {
+		return nil, errNoSession
+	}
+	secrets := make(map[dbus.ObjectPath]Secret)
+	for _, path := range items {
+		_, record, err := s.lookup(path)
+		if err != nil || record == nil {
+			// unknown and locked items are left out
+			continue
+		}
+		secret, err := s.secret(session, session_path, record)
+		if err != nil {
+			return nil, err
+		}
+		secrets[path] = secret
+	}
+	return secrets, nil
+}
+
+func (o serviceObject) ReadAlias(name string) (dbus.ObjectPath,
+	*dbus.Error) {
+
+	s := o.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, ok := s.aliases[name]
+	if !ok || !s.hasCollection(group) {
+		return noPrompt, nil
+	}
+	return s.collectionPath(group), nil
+}
+
+func (o serviceObject) SetAlias(name string,
+	collection dbus.ObjectPath) *dbus.Error {
+
+	s := o.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if collection == noPrompt {
+		delete(s.aliases, name)
+		return nil
+	}
+	group, record, err := s.lookup(collection)
+	if err != nil {
+		return err
+	}
+	if record != nil {
+		return errInvalidArgs("not a collection")
+	}
+	s.aliases[name] = group
+	return nil
+}
+
+// secret returns the secret of an item encoded for the session. Must be
+// called with the lock held.
+func (s *Service) secret(session *session, path dbus.ObjectPath,
+	record *v3.Record) (Secret, *dbus.Error) {
+
+	password := record.Password()
+	if base, _ := s.db.Base(record); base != nil {
+		password = base.Password()
+	}
+	content_type := defaultContentType
+	for _, field := range record.CustomFields() {
+		if field.Name == contentTypeField {
+			content_type = field.Value
+		}
+	}
+	secret, err := session.encode(path, []byte(password), content_type)
+	if err != nil {
+		return Secret{}, dbus.MakeFailedError(err)
+	}
+	return secret, nil
+}
+
+// setSecret decodes a secret and stores it in the item. Must be called
+// with the lock held.
+func (s *Service) setSecret(record *v3.Record, secret Secret) *dbus.Error {
+	session := s.sessions[secret.Session]
+	if session == nil {
+		return errNoSession
+	}
+	value, err := session.decode(secret)
+	if err != nil {
+		return errInvalidArgs(err.Error())
+	}
+	record.ChangePassword(string(value))
+	var fields []pwsafe.CustomField
+	for _, field := range record.CustomFields() {
+		if field.Name != contentTypeField {
+			fields = append(fields, field)
+		}
+	}
+	if secret.ContentType != "" && secret.ContentType != defaultContentType {
+		fields = append(fields, pwsafe.CustomField{Name: contentTypeField,
+			Value: secret.ContentType})
+	}
+	record.SetCustomFields(fields)
+	return nil
+}
@@ -0,0 +1,196 @@
+package secretservice
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"math/big"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/azdagron/pwsafe/v3"
+	"github.com/godbus/dbus/v5"
+	"golang.org/x/crypto/hkdf"
+)
+
+// startBus starts a private session bus and returns its address. The test
+// is skipped without dbus-daemon.
+func startBus(t *testing.T) string {
+	if _, err := exec.LookPath("dbus-daemon"); err != nil {
+		t.Skip("no dbus-daemon")
+	}
+	cmd := exec.Command("dbus-daemon", "--session", "--nofork",
+		"--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(address)
+}
+
+// connect opens a connection to the bus
+func connect(t *testing.T, address string) *dbus.Conn {
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// newTestService serves an empty database with the default collection in
+// the group apps/secrets, and returns the database and a client connection
+func newTestService(t *testing.T) (*Service, *v3.Database, *dbus.Conn) {
+	address := startBus(t)
+	db, err := v3.NewDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := connect(t, address)
+	s, err := New(conn, db, Options{
+		Group: "apps/secrets",
+		Save:  func(db *v3.Database) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := conn.RequestName(BusName, dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("unable to own %s: %v", BusName, err)
+	}
+	return s, db, connect(t, address)
+}
+
+// openClientSession opens a session with the algorithm and returns its path
+// and the client side of it
+func openClientSession(t *testing.T, service dbus.BusObject,
+	algorithm string) (dbus.ObjectPath, *session) {
+
+	input := dbus.MakeVariant("")
+	var private *big.Int
+	if algorithm == algorithmDH {
+		var err error
+		private, err = rand.Int(rand.Reader, dhPrime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		input = dbus.MakeVariant(
+			new(big.Int).Exp(big.NewInt(2), private, dhPrime).Bytes())
+	}
+	var output dbus.Variant
+	var path dbus.ObjectPath
+	err := service.Call(serviceIface+".OpenSession", 0, algorithm,
+		input).Store(&output, &path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if algorithm == algorithmPlain {
+		return path, &session{}
+	}
+
+	peer, ok := output.Value().([]byte)
+	if !ok {
+		t.Fatalf("expected the public key of the service, got %v", output)
+	}
+	shared := new(big.Int).Exp(new(big.Int).SetBytes(peer), private, dhPrime)
+	ikm := make([]byte, (dhPrime.BitLen()+7)/8)
+	shared.FillBytes(ikm)
+	key := make([]byte, 16)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, ikm, nil, nil),
+		key); err != nil {
+		t.Fatal(err)
+	}
+	return path, &session{key: key}
+}
+
+func TestItems(t *testing.T) {
+	s, db, client := newTestService(t)
+	service := client.Object(BusName, servicePath)
+	collection := client.Object(BusName, aliasPrefix+"default")
+
+	for _, algorithm := range []string{algorithmPlain, algorithmDH} {
+		path, session := openClientSession(t, service, algorithm)
+		value := "secret over " + algorithm
+		secret, err := session.encode(path, []byte(value), "text/plain")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if algorithm == algorithmDH && strings.Contains(
+			string(secret.Value), value) {
+			t.Fatalf("%s: expected the secret to be encrypted", algorithm)
+		}
+		attributes := map[string]string{"service": algorithm, "user": "me"}
+		properties := map[string]dbus.Variant{
+			itemIface + ".Label":      dbus.MakeVariant("Item " + algorithm),
+			itemIface + ".Attributes": dbus.MakeVariant(attributes),
+		}
+		var item, prompt dbus.ObjectPath
+		err = collection.Call(collectionIface+".CreateItem", 0, properties,
+			secret, false).Store(&item, &prompt)
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+
+		var unlocked, locked []dbus.ObjectPath
+		err = service.Call(serviceIface+".SearchItems", 0,
+			map[string]string{"service": algorithm}).Store(&unlocked,
+			&locked)
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		if len(unlocked) != 1 || unlocked[0] != item {
+			t.Fatalf("%s: expected to find %s, got %v", algorithm, item,
+				unlocked)
+		}
+
+		var secrets map[dbus.ObjectPath]Secret
+		err = service.Call(serviceIface+".GetSecrets", 0, unlocked,
+			path).Store(&secrets)
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		got, err := session.decode(secrets[item])
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		if string(got) != value {
+			t.Errorf("%s: expected secret %q, got %q", algorithm, value, got)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := db.Records()
+	if len(records) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(records))
+	}
+	for _, record := range records {
+		if record.Group() != "apps.secrets" {
+			t.Errorf("%s: expected the group apps.secrets, got %q",
+				record.Title(), record.Group())
+		}
+		fields := record.CustomFields()
+		if len(fields) != 2 || fields[0].Name != "attr:service" ||
+			fields[1].Name != "attr:user" || fields[1].Value != "me" {
+			t.Errorf("%s: expected the attributes as custom fields, got %v",
+				record.Title(), fields)
+		}
+		if !strings.HasPrefix(record.Password(), "secret over ") {
+			t.Errorf("%s: expected the secret as password, got %q",
+				record.Title(), record.Password())
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package secretservice
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"math/big"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/godbus/dbus/v5"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	algorithmPlain = "plain"
+	algorithmDH    = "dh-ietf1024-sha256-aes128-cbc-pkcs7"
+)
+
+// dhPrime is the 1024 bit MODP group of RFC 2409 (second Oakley group),
+// with generator 2
+var dhPrime, _ = new(big.Int).SetString(
+	"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD1"+
+		"29024E088A67CC74020BBEA63B139B22514A08798E3404DD"+
+		"EF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245"+
+		"E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED"+
+		"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE65381"+
+		"FFFFFFFFFFFFFFFF", 16)
+
+// session transfers secrets between the Service and a client
+type session struct {
+	// key is the AES key agreed on, nil for plain sessions
+	key []byte
+}
+
+// openSession negotiates a session with the algorithm and returns the
+// output for the client
+func openSession(algorithm string, input dbus.Variant) (*session,
+	dbus.Variant, *dbus.Error) {
+
+	switch algorithm {
+	case algorithmPlain:
+		return &session{}, dbus.MakeVariant(""), nil
+	case algorithmDH:
+		peer_bytes, ok := input.Value().([]byte)
+		if !ok {
+			return nil, dbus.Variant{}, errInvalidArgs("expected the " +
+				"public key as ay")
+		}
+		peer := new(big.Int).SetBytes(peer_bytes)
+		max := new(big.Int).Sub(dhPrime, big.NewInt(1))
+		if peer.Cmp(big.NewInt(1)) <= 0 || peer.Cmp(max) >= 0 {
+			return nil, dbus.Variant{}, errInvalidArgs("invalid public key")
+		}
+
+		private, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return nil, dbus.Variant{}, dbus.MakeFailedError(err)
+		}
+		public := new(big.Int).Exp(big.NewInt(2), private, dhPrime)
+		shared := new(big.Int).Exp(peer, private, dhPrime)
+
+		// the shared secret is padded to the size of the prime, as
+		// libsecret does
+		ikm := make([]byte, (dhPrime.BitLen()+7)/8)
+		shared.FillBytes(ikm)
+		key := make([]byte, 16)
+		if _, err = io.ReadFull(hkdf.New(sha256.New, ikm, nil, nil),
+			key); err != nil {
+			return nil, dbus.Variant{}, dbus.MakeFailedError(err)
+		}
+		for i := range ikm {
+			ikm[i] = 0
+		}
+		return &session{key: key}, dbus.MakeVariant(public.Bytes()), nil
+	default:
+		return nil, dbus.Variant{}, dbus.NewError(
+			"org.freedesktop.DBus.Error.NotSupported",
+			[]interface{}{"unsupported algorithm " + algorithm})
+	}
+}
+
+// encode returns the secret for transfer over the session
+func (s *session) encode(path dbus.ObjectPath, value []byte,
+	content_type string) (Secret, error) {
+
+	secret := Secret{Session: path, ContentType: content_type}
+	if s.key == nil {
+		secret.Value = value
+		return secret, nil
+	}
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return Secret{}, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err = rand.Read(iv); err != nil {
+		return Secret{}, err
+	}
+	padding := aes.BlockSize - len(value)%aes.BlockSize
+	data := make([]byte, len(value)+padding)
+	copy(data, value)
+	copy(data[len(value):], bytes.Repeat([]byte{byte(padding)}, padding))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	secret.Parameters, secret.Value = iv, data
+	return secret, nil
+}
+
+// decode returns the value of a secret transferred over the session
+func (s *session) decode(secret Secret) ([]byte, error) {
+	if s.key == nil {
+		return secret.Value, nil
+	}
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(secret.Parameters) != aes.BlockSize || len(secret.Value) == 0 ||
+		len(secret.Value)%aes.BlockSize != 0 {
+		return nil, pwsafe.Error.New("invalid encrypted secret")
+	}
+	data := make([]byte, len(secret.Value))
+	cipher.NewCBCDecrypter(block, secret.Parameters).CryptBlocks(data,
+		secret.Value)
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, pwsafe.Error.New("invalid padding")
+	}
+	for _, b := range data[len(data)-padding:] {
+		if int(b) != padding {
+			return nil, pwsafe.Error.New("invalid padding")
+		}
+	}
+	return data[:len(data)-padding], nil
+}
+
+func (s *session) close() {
+	for i := range s.key {
+		s.key[i] = 0
+	}
+}
+
+// sessionObject implements org.freedesktop.Secret.Session
+type sessionObject struct {
+	s *Service
+}
+
+func (o sessionObject) Close(msg dbus.Message) *dbus.Error {
+	o.s.mu.Lock()
+	defer o.s.mu.Unlock()
+	path := messagePath(msg)
+	if session := o.s.sessions[path]; session != nil {
+		session.close()
+		delete(o.s.sessions, path)
+	}
+	return nil
+}
+
+// prompt is an action needing the user, which runs when the client calls
+// Prompt
+type prompt struct {
+	// action returns the result, and true if the user dismissed it
+	action func() (dbus.Variant, bool)
+}
+
+// promptObject implements org.freedesktop.Secret.Prompt
+type promptObject struct {
+	s *Service
+}
+
+// take removes and returns the prompt the method was called on
+func (o promptObject) take(msg dbus.Message) (dbus.ObjectPath, *prompt,
+	*dbus.Error) {
+
+	o.s.mu.Lock()
+	defer o.s.mu.Unlock()
+	path := messagePath(msg)
+	p := o.s.prompts[path]
+	if p == nil {
+		return path, nil, errNoSuchObject(path)
+	}
+	delete(o.s.prompts, path)
+	return path, p, nil
+}
+
+// Prompt runs the action in the background; the result is sent with the
+// Completed signal
+func (o promptObject) Prompt(msg dbus.Message, window_id string) *dbus.Error {
+	path, p, err := o.take(msg)
+	if err != nil {
+		return err
+	}
+	go func() {
+		result, dismissed := p.action()
+		o.s.emit(path, promptIface+".Completed", dismissed, result)
+	}()
+	return nil
+}
+
+func (o promptObject) Dismiss(msg dbus.Message) *dbus.Error {
+	path, _, err := o.take(msg)
+	if err != nil {
+		return err
+	}
+	o.s.emit(path, promptIface+".Completed", true, dbus.MakeVariant(""))
+	return nil
+}
@@ -0,0 +1,391 @@
+// Package tui implements a full screen terminal user interface for v3
+// password safe databases.
+//
+// The interface draws on any tcell.Screen. Terminals use tcell.NewScreen;
+// tests use a simulation screen through Headless.
+package tui
+
+import (
+	"time"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/v3"
+	"github.com/gdamore/tcell/v2"
+)
+
+// Options are the callbacks the App uses for actions outside of the
+// database.
+type Options struct {
+	// Copy copies a secret to the clipboard. Copying is disabled if nil.
+	Copy func(secret string) error
+
+	// Save saves the database. Saving is disabled if nil.
+	Save func(db *v3.Database) error
+}
+
+// focus is the part of the interface keys are sent to
+type focus int
+
+const (
+	focusTree focus = iota
+	focusFilter
+	focusEdit
+	focusGenerator
+	focusQuit
+)
+
+// App is the state of the user interface
+type App struct {
+	screen tcell.Screen
+	db     *v3.Database
+	opts   Options
+
+	focus     focus
+	filter    []rune
+	collapsed map[string]bool
+	rows      []row
+	cursor    int
+	offset    int
+	unmask    bool
+	dirty     bool
+	status    string
+	form      *form
+	generator *generator
+	done      bool
+}
+
+// New returns an App showing the database on the screen. The screen must
+// be initialized.
+func New(screen tcell.Screen, db *v3.Database, opts Options) *App {
+	a := &App{
+		screen:    screen,
+		db:        db,
+		opts:      opts,
+		collapsed: make(map[string]bool),
+	}
+	a.rebuild()
+	return a
+}
+
+// Run draws the interface and handles events until the user quits.
+func (a *App) Run() error {
+	a.Draw()
+	for !a.done {
+		ev := a.screen.PollEvent()
+		if ev == nil {
+			return nil
+		}
+		a.HandleEvent(ev)
+		a.Draw()
+	}
+	return nil
+}
+
+// Done returns true once the user quit
+func (a *App) Done() bool {
+	return a.done
+}
+
+// Dirty returns true if the database has unsaved changes
+func (a *App) Dirty() bool {
+	return a.dirty
+}
+
+// HandleEvent updates the state for a screen event. It does not redraw the
+// screen.
+func (a *App) HandleEvent(ev tcell.Event) {
+	switch ev := ev.(type) {
+	case *tcell.EventResize:
+		a.screen.Sync()
+	case *tcell.EventKey:
+		a.status = ""
+		switch a.focus {
+		case focusTree:
+			a.treeKey(ev)
+		case focusFilter:
+			a.filterKey(ev)
+		case focusEdit:
+			a.formKey(ev)
+		case focusGenerator:
+			a.generatorKey(ev)
+		case focusQuit:
+			a.quitKey(ev)
+		}
+	}
+}
+
+func (a *App) treeKey(ev *tcell.EventKey) {
+	switch ev.Key() {
+	case tcell.KeyUp:
+		a.move(-1)
+	case tcell.KeyDown:
+		a.move(1)
+	case tcell.KeyPgUp:
+		a.move(-a.treeHeight())
+	case tcell.KeyPgDn:
+		a.move(a.treeHeight())
+	case tcell.KeyHome:
+		a.move(-len(a.rows))
+	case tcell.KeyEnd:
+		a.move(len(a.rows))
+	case tcell.KeyLeft:
+		a.collapse()
+	case tcell.KeyRight:
+		a.expand()
+	case tcell.KeyEnter:
+		a.toggle()
+	case tcell.KeyEscape:
+		a.setFilter(nil)
+	case tcell.KeyCtrlC:
+		a.quit()
+	case tcell.KeyRune:
+		switch ev.Rune() {
+		case 'k':
+			a.move(-1)
+		case 'j':
+			a.move(1)
+		case 'h':
+			a.collapse()
+		case 'l':
+			a.expand()
+		case ' ':
+			a.toggle()
+		case '/':
+			a.focus = focusFilter
+		case 'm':
+			a.unmask = !a.unmask
+		case 'c':
+			a.copyField("password")
+		case 'u':
+			a.copyField("username")
+		case 'w':
+			a.copyField("url")
+		case 'e':
+			a.edit()
+		case 'a':
+			a.add()
+		case 'g':
+			a.generate(nil)
+		case 's':
+			a.save()
+		case 'q':
+			a.quit()
+		}
+	}
+}
+
+func (a *App) filterKey(ev *tcell.EventKey) {
+	switch ev.Key() {
+	case tcell.KeyEscape:
+		a.setFilter(nil)
+		a.focus = focusTree
+	case tcell.KeyEnter, tcell.KeyTab:
+		a.focus = focusTree
+	case tcell.KeyUp:
+		a.move(-1)
+	case tcell.KeyDown:
+		a.move(1)
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if len(a.filter) > 0 {
+			a.setFilter(a.filter[:len(a.filter)-1])
+		}
+	case tcell.KeyCtrlU:
+		a.setFilter(nil)
+	case tcell.KeyCtrlC:
+		a.quit()
+	case tcell.KeyRune:
+		a.setFilter(append(a.filter, ev.Rune()))
+	}
+}
+
+func (a *App) quitKey(ev *tcell.EventKey) {
+	switch {
+	case ev.Key() == tcell.KeyEscape:
+		a.focus = focusTree
+	case ev.Key() == tcell.KeyRune && (ev.Rune() == 'y' || ev.Rune() == 'Y'):
+		a.focus = focusTree
+		if a.save() {
+			a.done = true
+		}
+	case ev.Key() == tcell.KeyRune && (ev.Rune() == 'n' || ev.Rune() == 'N'):
+		a.done = true
+	}
+}
+
+// setFilter updates the filter and selects the best match
+func (a *App) setFilter(filter []rune) {
+	a.filter = filter
+	a.rebuild()
+	if len(filter) == 0 {
+		return
+	}
+	results := pwsafe.Search(a.db.Records(), string(filter))
+	if len(results) > 0 {
+		a.selectRecord(results[0].Record.(*v3.Record))
+	}
+}
+
+// selected returns the selected row, or nil if there are no rows
+func (a *App) selected() *row {
+	if a.cursor < 0 || a.cursor >= len(a.rows) {
+		return nil
+	}
+	return &a.rows[a.cursor]
+}
+
+// selectedRecord returns the selected record, or nil if a group is selected
+func (a *App) selectedRecord() *v3.Record {
+	if r := a.selected(); r != nil {
+		return r.record
+	}
+	return nil
+}
+
+func (a *App) move(delta int) {
+	a.cursor += delta
+	if a.cursor >= len(a.rows) {
+		a.cursor = len(a.rows) - 1
+	}
+	if a.cursor < 0 {
+		a.cursor = 0
+	}
+}
+
+// collapse collapses the selected group, or selects the parent group
+func (a *App) collapse() {
+	r := a.selected()
+	if r == nil {
+		return
+	}
+	if r.record == nil && r.expanded && !a.filtering() {
+		a.collapsed[r.group] = true
+		a.rebuild()
+		return
+	}
+	for i := a.cursor - 1; i >= 0; i-- {
+		if a.rows[i].depth < r.depth {
+			a.cursor = i
+			return
+		}
+	}
+}
+
+// expand expands the selected group
+func (a *App) expand() {
+	r := a.selected()
+	if r == nil || r.record != nil {
+		return
+	}
+	delete(a.collapsed, r.group)
+	a.rebuild()
+}
+
+func (a *App) toggle() {
+	r := a.selected()
+	if r == nil || r.record != nil {
+		return
+	}
+	if r.expanded {
+		a.collapse()
+	} else {
+		a.expand()
+	}
+}
+
+// currentGroup returns the selected group, or the group of the selected
+// record
+func (a *App) currentGroup() string {
+	r := a.selected()
+	switch {
+	case r == nil:
+		return ""
+	case r.record != nil:
+		return r.record.Group()
+	default:
+		return r.group
+	}
+}
+
+// value returns a field of the record, taken from the base record for the
+// password of an alias and every field of a shortcut
+func (a *App) value(record *v3.Record, field string) string {
+	base, shortcut := a.db.Base(record)
+	switch {
+	case shortcut:
+		record = base
+	case base != nil && field == "password":
+		record = base
+	}
+	switch field {
+	case "password":
+		return record.Password()
+	case "username":
+		return record.Username()
+	case "url":
+		return record.URL()
+	}
+	return ""
+}
+
+func (a *App) copyField(field string) {
+	record := a.selectedRecord()
+	if record == nil {
+		return
+	}
+	if a.opts.Copy == nil {
+		a.status = "copying is not available"
+		return
+	}
+	value := a.value(record, field)
+	if value == "" {
+		a.status = "the " + field + " is empty"
+		return
+	}
+	if err := a.opts.Copy(value); err != nil {
+		a.status = err.Error()
+		return
+	}
+	a.status = "copied the " + field + " of " + record.Title()
+}
+
+// save saves the database and returns true on success
+func (a *App) save() bool {
+	if a.opts.Save == nil {
+		a.status = "saving is not available"
+		return false
+	}
+	if err := a.opts.Save(a.db); err != nil {
+		a.status = err.Error()
+		return false
+	}
+	a.dirty = false
+	a.status = "saved"
+	return true
+}
+
+func (a *App) quit() {
+	if a.dirty {
+		a.focus = focusQuit
+		return
+	}
+	a.done = true
+}
+
+// modified marks the record and the database as changed
+func (a *App) modified(record *v3.Record) {
+	record.SetMtime(time.Now())
+	a.dirty = true
+
+	// the group of the record is no longer empty
+	header := a.db.Header().(*v3.Header)
+	var empty []string
+	for _, g := range header.EmptyGroups() {
+		if g != record.Group() {
+			empty = append(empty, g)
+		}
+	}
+	header.SetEmptyGroups(empty)
+
+	a.rebuild()
+	a.selectRecord(record)
+}
@@ -0,0 +1,217 @@
+package tui
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/v3"
+	"github.com/gdamore/tcell/v2"
+)
+
+// newTestHeadless returns a headless App showing a database with three
+// entries. Bank uses the named policy "pin".
+func newTestHeadless(t *testing.T) (*Headless, *v3.Database) {
+	db, err := v3.NewDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Header().(*v3.Header).SetPasswordPolicies(
+		[]pwsafe.PasswordPolicy{{Name: "pin", UseDigits: true, Length: 6}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []struct {
+		title, group, username, password, policy string
+	}{
+		{"AWS prod", "ops.aws", "admin", "secret-one", ""},
+		{"Mail", "personal", "me", "hunter22", ""},
+		{"Bank", "personal", "me", "1234", "pin"},
+	} {
+		record, err := v3.NewRecord()
+		if err != nil {
+			t.Fatal(err)
+		}
+		record.SetTitle(entry.title)
+		record.SetGroup(entry.group)
+		record.SetUsername(entry.username)
+		record.SetPassword(entry.password)
+		record.SetPasswordPolicyName(entry.policy)
+		if err = db.AddRecord(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, err := NewHeadless(db, Options{}, 100, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, db
+}
+
+// selectEntry replaces the filter and selects its best match
+func selectEntry(h *Headless, filter string) {
+	h.Type("/")
+	h.Key(tcell.KeyCtrlU)
+	h.Type(filter)
+	h.Key(tcell.KeyEnter)
+}
+
+func findRecord(t *testing.T, db *v3.Database, title string) *v3.Record {
+	for _, record := range db.Records() {
+		if record.Title() == title {
+			return record.(*v3.Record)
+		}
+	}
+	t.Fatalf("no entry %q", title)
+	return nil
+}
+
+func TestFilter(t *testing.T) {
+	h, _ := newTestHeadless(t)
+	if !h.Contains("AWS prod") || !h.Contains("Mail") {
+		t.Fatalf("expected all entries:\n%s", h.Text())
+	}
+
+	h.Type("/mail")
+	if !h.Contains("Filter: mail") {
+		t.Errorf("expected the filter to be shown:\n%s", h.Text())
+	}
+	if !h.Contains("Mail") || h.Contains("AWS prod") {
+		t.Errorf("expected only Mail:\n%s", h.Text())
+	}
+	if record := h.App.selectedRecord(); record == nil ||
+		record.Title() != "Mail" {
+		t.Errorf("expected Mail to be selected, got %v", record)
+	}
+
+	h.Type("zzz")
+	if !h.Contains("no matching entries") {
+		t.Errorf("expected no matches:\n%s", h.Text())
+	}
+	for i := 0; i < 3; i++ {
+		h.Key(tcell.KeyBackspace2)
+	}
+	if !h.Contains("Mail") {
+		t.Errorf("expected Mail after deleting the filter:\n%s", h.Text())
+	}
+
+	h.Key(tcell.KeyEscape)
+	if !h.Contains("AWS prod") || !h.Contains("Mail") {
+		t.Errorf("expected all entries after clearing:\n%s", h.Text())
+	}
+}
+
+func TestMask(t *testing.T) {
+	h, _ := newTestHeadless(t)
+	selectEntry(h, "mail")
+	if h.Contains("hunter22") || !h.Contains(mask) {
+		t.Fatalf("expected the password to be masked:\n%s", h.Text())
+	}
+	h.Type("m")
+	if !h.Contains("hunter22") {
+		t.Errorf("expected the password to be shown:\n%s", h.Text())
+	}
+	h.Type("m")
+	if h.Contains("hunter22") {
+		t.Errorf("expected the password to be masked again:\n%s", h.Text())
+	}
+}
+
+func TestEdit(t *testing.T) {
+	h, db := newTestHeadless(t)
+	selectEntry(h, "mail")
+
+	h.Type("e")
+	if !h.Contains("Edit entry") {
+		t.Fatalf("expected the form:\n%s", h.Text())
+	}
+	h.Type("x")
+	h.Key(tcell.KeyEscape)
+	if !h.Contains("canceled") || h.App.Dirty() {
+		t.Errorf("expected canceling to keep the entry:\n%s", h.Text())
+	}
+
+	h.Type("e")
+	h.Key(tcell.KeyCtrlU)
+	h.Type("Webmail")
+	h.Key(tcell.KeyTab)
+	h.Key(tcell.KeyTab)
+	h.Key(tcell.KeyCtrlU)
+	h.Type("you")
+	h.Key(tcell.KeyTab)
+	if h.Contains("hunter22") {
+		t.Errorf("expected the password to be masked:\n%s", h.Text())
+	}
+	h.Key(tcell.KeyCtrlT)
+	if !h.Contains("hunter22") {
+		t.Errorf("expected ctrl-t to show the password:\n%s", h.Text())
+	}
+	h.Key(tcell.KeyEnter)
+
+	record := findRecord(t, db, "Webmail")
+	if record.Username() != "you" || record.Password() != "hunter22" {
+		t.Errorf("unexpected username %q and password %q",
+			record.Username(), record.Password())
+	}
+	if !h.App.Dirty() || !h.Contains("[modified]") ||
+		!h.Contains("updated Webmail") {
+		t.Errorf("expected the database to be modified:\n%s", h.Text())
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	h, db := newTestHeadless(t)
+
+	selectEntry(h, "bank")
+	h.Type("g")
+	if !h.Contains("Generate password") || !h.Contains("policy pin") {
+		t.Fatalf("expected the generator with the named policy:\n%s",
+			h.Text())
+	}
+	password := h.App.generator.password
+	if !regexp.MustCompile(`^[0-9]{6}$`).MatchString(password) {
+		t.Fatalf("expected 6 digits, got %q", password)
+	}
+	if !h.Contains("Password: " + password) {
+		t.Errorf("expected the password in the dialog:\n%s", h.Text())
+	}
+	h.Type("+")
+	password = h.App.generator.password
+	if len(password) != 7 {
+		t.Errorf("expected 7 digits, got %q", password)
+	}
+	h.Key(tcell.KeyEnter)
+	if record := findRecord(t, db, "Bank"); record.Password() != password {
+		t.Errorf("expected password %q, got %q", password,
+			record.Password())
+	}
+	if !h.App.Dirty() {
+		t.Error("expected the database to be modified")
+	}
+
+	// from the form, the password goes to the password field
+	selectEntry(h, "aws")
+	h.Type("e")
+	h.Key(tcell.KeyCtrlG)
+	if !h.Contains("default policy") {
+		t.Fatalf("expected the default policy:\n%s", h.Text())
+	}
+	password = h.App.generator.password
+	if len(password) != pwsafe.DefaultPasswordPolicy.Length {
+		t.Errorf("expected %d characters, got %q",
+			pwsafe.DefaultPasswordPolicy.Length, password)
+	}
+	h.Key(tcell.KeyEnter)
+	if h.App.generator != nil || !h.Contains("Edit entry") {
+		t.Fatalf("expected to return to the form:\n%s", h.Text())
+	}
+	if !h.Contains(strings.Repeat("*", len(password))) {
+		t.Errorf("expected the masked password in the form:\n%s", h.Text())
+	}
+	h.Key(tcell.KeyEnter)
+	if record := findRecord(t, db, "AWS prod"); record.Password() != password {
+		t.Errorf("expected password %q, got %q", password,
+			record.Password())
+	}
+}
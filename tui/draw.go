@@ -0,0 +1,418 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/v3"
+	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
+)
+
+const (
+	// labelWidth is the width of the field labels of the detail pane
+	labelWidth = 11
+
+	// mask replaces hidden secrets
+	mask = "********"
+
+	timeFormat = "2006-01-02 15:04"
+)
+
+var (
+	styleDefault  = tcell.StyleDefault
+	styleBar      = tcell.StyleDefault.Reverse(true)
+	styleSelected = tcell.StyleDefault.Reverse(true)
+	styleLabel    = tcell.StyleDefault.Bold(true)
+	styleInput    = tcell.StyleDefault.Underline(true)
+)
+
+var focusHelp = map[focus]string{
+	focusTree: "/ filter  m mask  c copy password  u copy username  " +
+		"w copy url  e edit  a add  g generate  s save  q quit",
+	focusFilter: "type to filter  enter done  esc clear",
+	focusEdit: "tab next field  enter save  esc cancel  ctrl-g generate  " +
+		"ctrl-t show password",
+	focusGenerator: "r new password  +/- length  enter use  esc cancel",
+	focusQuit:      "y save and quit  n quit without saving  esc cancel",
+}
+
+// Draw draws the interface on the screen and shows it
+func (a *App) Draw() {
+	s := a.screen
+	s.Clear()
+	s.HideCursor()
+	width, height := s.Size()
+	if width < 30 || height < 8 {
+		drawText(s, 0, 0, width, styleDefault, "terminal too small")
+		s.Show()
+		return
+	}
+
+	a.drawTitle(width)
+	a.drawFilter(width)
+
+	tree_width := width * 2 / 5
+	a.drawTree(0, 2, tree_width, height-3)
+	for y := 2; y < height-1; y++ {
+		s.SetContent(tree_width, y, tcell.RuneVLine, nil, styleDefault)
+	}
+	detail_x := tree_width + 2
+	if a.form != nil {
+		a.drawForm(detail_x, 2, width-detail_x, height-3)
+	} else {
+		a.drawDetail(detail_x, 2, width-detail_x, height-3)
+	}
+
+	status := a.status
+	if status == "" {
+		status = focusHelp[a.focus]
+	}
+	fillLine(s, 0, height-1, width, styleBar)
+	drawText(s, 1, height-1, width-1, styleBar, status)
+
+	switch a.focus {
+	case focusGenerator:
+		a.drawGenerator(width, height)
+	case focusQuit:
+		a.drawDialog(width, height, "Quit", []string{
+			"Save the changes before quitting?",
+			"",
+			"[y] save and quit  [n] quit  [esc] cancel",
+		})
+	}
+	s.Show()
+}
+
+// treeHeight is the number of visible tree rows
+func (a *App) treeHeight() int {
+	_, height := a.screen.Size()
+	if height < 4 {
+		return 1
+	}
+	return height - 3
+}
+
+func (a *App) drawTitle(width int) {
+	s := a.screen
+	title := "pwsafe"
+	if name := a.db.Header().Name(); name != "" {
+		title += ": " + name
+	}
+	if a.dirty {
+		title += " [modified]"
+	}
+	fillLine(s, 0, 0, width, styleBar)
+	drawText(s, 1, 0, width-1, styleBar, title)
+}
+
+func (a *App) drawFilter(width int) {
+	s := a.screen
+	x := drawText(s, 1, 1, width-1, styleLabel, "Filter: ")
+	x += drawText(s, 1+x, 1, width-1-x, styleDefault, string(a.filter))
+	if a.focus == focusFilter {
+		s.ShowCursor(1+x, 1)
+	}
+}
+
+func (a *App) drawTree(x, y, width, height int) {
+	s := a.screen
+	if len(a.rows) == 0 {
+		text := "no entries"
+		if a.filtering() {
+			text = "no matching entries"
+		}
+		drawText(s, x+1, y, width-1, styleDefault, text)
+		return
+	}
+
+	// keep the cursor visible
+	if a.cursor < a.offset {
+		a.offset = a.cursor
+	}
+	if a.cursor >= a.offset+height {
+		a.offset = a.cursor - height + 1
+	}
+	if a.offset > len(a.rows)-height {
+		a.offset = len(a.rows) - height
+	}
+	if a.offset < 0 {
+		a.offset = 0
+	}
+
+	for i := 0; i < height && a.offset+i < len(a.rows); i++ {
+		r := a.rows[a.offset+i]
+		style := styleDefault
+		if a.offset+i == a.cursor {
+			style = styleSelected
+			fillLine(s, x, y+i, width, style)
+		}
+		text := strings.Repeat("  ", r.depth)
+		switch {
+		case r.record != nil:
+			text += "  " + r.name
+		case r.expanded:
+			text += "- " + r.name + "/"
+		default:
+			text += fmt.Sprintf("+ %s/ (%d)", r.name, r.count)
+		}
+		drawText(s, x+1, y+i, width-1, style, text)
+	}
+}
+
+// field is a line of the detail pane
+type field struct {
+	label string
+	value string
+}
+
+func (a *App) drawDetail(x, y, width, height int) {
+	s := a.screen
+	r := a.selected()
+	if r == nil {
+		return
+	}
+	var fields []field
+	var notes string
+	if r.record == nil {
+		fields = []field{
+			{"Group", groupPath(r.group)},
+			{"Entries", fmt.Sprint(r.count)},
+		}
+	} else {
+		fields, notes = a.recordFields(r.record)
+	}
+
+	line := 0
+	for _, f := range fields {
+		if line >= height {
+			return
+		}
+		drawText(s, x, y+line, width, styleLabel, f.label)
+		drawText(s, x+labelWidth, y+line, width-labelWidth, styleDefault,
+			f.value)
+		line++
+	}
+	if notes == "" {
+		return
+	}
+	line++
+	if line < height {
+		drawText(s, x, y+line, width, styleLabel, "Notes")
+		line++
+	}
+	for _, text := range wrap(notes, width) {
+		if line >= height {
+			return
+		}
+		drawText(s, x, y+line, width, styleDefault, text)
+		line++
+	}
+}
+
+// recordFields returns the fields shown for a record, and its notes
+func (a *App) recordFields(record *v3.Record) (fields []field,
+	notes string) {
+
+	add := func(label, value string) {
+		if value != "" {
+			fields = append(fields, field{label, value})
+		}
+	}
+	addTime := func(label string, t time.Time) {
+		if !t.IsZero() {
+			add(label, t.Local().Format(timeFormat))
+		}
+	}
+	secret := func(value string) string {
+		if value == "" || a.unmask {
+			return value
+		}
+		return mask
+	}
+
+	shown := record
+	base, shortcut := a.db.Base(record)
+	if shortcut {
+		shown = base
+	}
+	add("Title", record.Title())
+	add("Group", groupPath(record.Group()))
+	switch {
+	case shortcut:
+		add("Shortcut", titlePath(base))
+	case base != nil:
+		add("Alias of", titlePath(base))
+	}
+	add("Username", shown.Username())
+	add("Password", secret(a.value(record, "password")))
+	add("URL", shown.URL())
+	add("Email", shown.Email())
+	add("TOTP", secret(shown.TOTP()))
+	addTime("Expires", shown.Expiry())
+	addTime("Modified", record.Mtime())
+	addTime("Created", record.Ctime())
+	addTime("Pw changed", shown.PasswordMtime())
+	if shown.Protected() {
+		add("Protected", "yes")
+	}
+	for _, custom := range shown.CustomFields() {
+		add(custom.Name, custom.Value)
+	}
+	switch n := len(shown.History()); n {
+	case 0:
+	case 1:
+		add("History", "1 previous password")
+	default:
+		add("History", fmt.Sprintf("%d previous passwords", n))
+	}
+	return fields, shown.Notes()
+}
+
+func (a *App) drawForm(x, y, width, height int) {
+	s := a.screen
+	f := a.form
+	title := "Edit entry"
+	if f.added {
+		title = "New entry"
+	}
+	drawText(s, x, y, width, styleLabel, title)
+	for i := 0; i < numFields && i+2 < height; i++ {
+		line := y + 2 + i
+		drawText(s, x, line, width, styleLabel, fieldLabels[i])
+		value := string(f.values[i])
+		if i == fieldPassword && !a.unmask {
+			value = strings.Repeat("*", len(f.values[i]))
+		}
+		style := styleDefault
+		if i == f.field {
+			style = styleInput
+			fillLine(s, x+labelWidth, line, width-labelWidth, style)
+		}
+		drawText(s, x+labelWidth, line, width-labelWidth, style, value)
+		if i == f.field && a.focus == focusEdit {
+			pos := runewidth.StringWidth(string(f.values[i][:f.pos]))
+			if i == fieldPassword && !a.unmask {
+				pos = f.pos
+			}
+			s.ShowCursor(x+labelWidth+pos, line)
+		}
+	}
+}
+
+func (a *App) drawGenerator(width, height int) {
+	g := a.generator
+	lines := []string{
+		"Policy:   " + g.source,
+		"          " + g.policy.Describe(),
+		"",
+	}
+	if g.err != nil {
+		lines = append(lines, "Error:    "+g.err.Error())
+	} else {
+		lines = append(lines, "Password: "+g.password)
+	}
+	lines = append(lines, "",
+		"[r] new  [+/-] length  [enter] use  [esc] cancel")
+	a.drawDialog(width, height, "Generate password", lines)
+}
+
+// drawDialog draws a box with the lines in the middle of the screen
+func (a *App) drawDialog(width, height int, title string, lines []string) {
+	s := a.screen
+	w := runewidth.StringWidth(title) + 4
+	for _, line := range lines {
+		if lw := runewidth.StringWidth(line) + 4; lw > w {
+			w = lw
+		}
+	}
+	if w > width {
+		w = width
+	}
+	h := len(lines) + 2
+	x := (width - w) / 2
+	y := (height - h) / 2
+	if y < 0 {
+		y = 0
+	}
+
+	for i := 0; i < h; i++ {
+		fillLine(s, x, y+i, w, styleDefault)
+	}
+	for i := 1; i < w-1; i++ {
+		s.SetContent(x+i, y, tcell.RuneHLine, nil, styleDefault)
+		s.SetContent(x+i, y+h-1, tcell.RuneHLine, nil, styleDefault)
+	}
+	for i := 1; i < h-1; i++ {
+		s.SetContent(x, y+i, tcell.RuneVLine, nil, styleDefault)
+		s.SetContent(x+w-1, y+i, tcell.RuneVLine, nil, styleDefault)
+	}
+	s.SetContent(x, y, tcell.RuneULCorner, nil, styleDefault)
+	s.SetContent(x+w-1, y, tcell.RuneURCorner, nil, styleDefault)
+	s.SetContent(x, y+h-1, tcell.RuneLLCorner, nil, styleDefault)
+	s.SetContent(x+w-1, y+h-1, tcell.RuneLRCorner, nil, styleDefault)
+	drawText(s, x+2, y, w-4, styleLabel, " "+title+" ")
+	for i, line := range lines {
+		drawText(s, x+2, y+1+i, w-4, styleDefault, line)
+	}
+}
+
+// drawText draws the text up to the width and returns the number of columns
+// used
+func drawText(s tcell.Screen, x, y, width int, style tcell.Style,
+	text string) int {
+
+	used := 0
+	for _, c := range text {
+		w := runewidth.RuneWidth(c)
+		if c < ' ' || c == 0x7f {
+			c, w = '?', 1
+		}
+		if w == 0 {
+			continue
+		}
+		if used+w > width {
+			break
+		}
+		s.SetContent(x+used, y, c, nil, style)
+		used += w
+	}
+	return used
+}
+
+func fillLine(s tcell.Screen, x, y, width int, style tcell.Style) {
+	for i := 0; i < width; i++ {
+		s.SetContent(x+i, y, ' ', nil, style)
+	}
+}
+
+// wrap splits the text into lines of at most width columns
+func wrap(text string, width int) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		paragraph = strings.TrimRight(paragraph, "\r")
+		for runewidth.StringWidth(paragraph) > width && width > 0 {
+			cut := runewidth.Truncate(paragraph, width, "")
+			if i := strings.LastIndex(cut, " "); i > 0 {
+				cut = cut[:i]
+			}
+			if cut == "" {
+				_, size := utf8.DecodeRuneInString(paragraph)
+				cut = paragraph[:size]
+			}
+			lines = append(lines, cut)
+			paragraph = strings.TrimLeft(paragraph[len(cut):], " ")
+		}
+		lines = append(lines, paragraph)
+	}
+	return lines
+}
+
+// titlePath returns the group path and title of the record separated by "/"
+func titlePath(record pwsafe.Record) string {
+	return strings.Join(append(pwsafe.SplitGroup(record.Group()),
+		record.Title()), "/")
+}
@@ -0,0 +1,195 @@
+package tui
+
+import (
+	"strings"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/v3"
+	"github.com/gdamore/tcell/v2"
+)
+
+// Inline editing fields, in form order
+const (
+	fieldTitle = iota
+	fieldGroup
+	fieldUsername
+	fieldPassword
+	fieldURL
+	fieldEmail
+	numFields
+)
+
+var fieldLabels = [numFields]string{
+	fieldTitle:    "Title",
+	fieldGroup:    "Group",
+	fieldUsername: "Username",
+	fieldPassword: "Password",
+	fieldURL:      "URL",
+	fieldEmail:    "Email",
+}
+
+// form is the inline editor of a record
+type form struct {
+	record *v3.Record
+
+	// added is true for a new record, which is removed again if editing is
+	// canceled
+	added bool
+
+	values [numFields][]rune
+	field  int
+	pos    int
+}
+
+func newForm(record *v3.Record) *form {
+	f := &form{record: record}
+	f.values[fieldTitle] = []rune(record.Title())
+	f.values[fieldGroup] = []rune(groupPath(record.Group()))
+	f.values[fieldUsername] = []rune(record.Username())
+	f.values[fieldPassword] = []rune(record.Password())
+	f.values[fieldURL] = []rune(record.URL())
+	f.values[fieldEmail] = []rune(record.Email())
+	f.pos = len(f.values[fieldTitle])
+	return f
+}
+
+// setField moves to the field, with the cursor at its end
+func (f *form) setField(field int) {
+	f.field = (field + numFields) % numFields
+	f.pos = len(f.values[f.field])
+}
+
+// edit starts editing the selected record
+func (a *App) edit() {
+	record := a.selectedRecord()
+	if record == nil {
+		return
+	}
+	if record.Protected() {
+		a.status = record.Title() + " is protected"
+		return
+	}
+	a.form = newForm(record)
+	a.focus = focusEdit
+}
+
+// add adds a record to the current group and starts editing it
+func (a *App) add() {
+	record, err := v3.NewRecord()
+	if err != nil {
+		a.status = err.Error()
+		return
+	}
+	record.SetGroup(a.currentGroup())
+	a.form = newForm(record)
+	a.form.added = true
+	a.focus = focusEdit
+}
+
+func (a *App) formKey(ev *tcell.EventKey) {
+	f := a.form
+	value := f.values[f.field]
+	switch ev.Key() {
+	case tcell.KeyEscape:
+		a.form = nil
+		a.focus = focusTree
+		a.status = "canceled"
+	case tcell.KeyEnter:
+		a.apply()
+	case tcell.KeyTab, tcell.KeyDown:
+		f.setField(f.field + 1)
+	case tcell.KeyBacktab, tcell.KeyUp:
+		f.setField(f.field - 1)
+	case tcell.KeyLeft:
+		if f.pos > 0 {
+			f.pos--
+		}
+	case tcell.KeyRight:
+		if f.pos < len(value) {
+			f.pos++
+		}
+	case tcell.KeyHome, tcell.KeyCtrlA:
+		f.pos = 0
+	case tcell.KeyEnd, tcell.KeyCtrlE:
+		f.pos = len(value)
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if f.pos > 0 {
+			f.values[f.field] = append(value[:f.pos-1], value[f.pos:]...)
+			f.pos--
+		}
+	case tcell.KeyDelete:
+		if f.pos < len(value) {
+			f.values[f.field] = append(value[:f.pos], value[f.pos+1:]...)
+		}
+	case tcell.KeyCtrlU:
+		f.values[f.field] = nil
+		f.pos = 0
+	case tcell.KeyCtrlT:
+		a.unmask = !a.unmask
+	case tcell.KeyCtrlG:
+		a.generate(f)
+	case tcell.KeyRune:
+		inserted := make([]rune, 0, len(value)+1)
+		inserted = append(inserted, value[:f.pos]...)
+		inserted = append(inserted, ev.Rune())
+		f.values[f.field] = append(inserted, value[f.pos:]...)
+		f.pos++
+	}
+}
+
+// apply stores the form values in the record
+func (a *App) apply() {
+	f := a.form
+	title := strings.TrimSpace(string(f.values[fieldTitle]))
+	if title == "" {
+		a.status = "the title must not be empty"
+		f.setField(fieldTitle)
+		return
+	}
+
+	record := f.record
+	changed := f.added
+	set := func(current string, value string, setter func(string)) {
+		if current != value {
+			setter(value)
+			changed = true
+		}
+	}
+	set(record.Title(), title, record.SetTitle)
+	set(record.Group(), parseGroupPath(string(f.values[fieldGroup])),
+		record.SetGroup)
+	set(record.Username(), string(f.values[fieldUsername]), record.SetUsername)
+	set(record.URL(), string(f.values[fieldURL]), record.SetURL)
+	set(record.Email(), string(f.values[fieldEmail]), record.SetEmail)
+	set(record.Password(), string(f.values[fieldPassword]),
+		record.ChangePassword)
+
+	a.form = nil
+	a.focus = focusTree
+	if !changed {
+		return
+	}
+	if f.added {
+		if err := a.db.AddRecord(record); err != nil {
+			a.status = err.Error()
+			return
+		}
+	}
+	a.modified(record)
+	a.status = "updated " + record.Title()
+}
+
+// groupPath returns the group with its elements separated by "/"
+func groupPath(group string) string {
+	return strings.Join(pwsafe.SplitGroup(group), "/")
+}
+
+// parseGroupPath returns the group for a path with its elements separated by
+// "/"
+func parseGroupPath(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return ""
+	}
+	return pwsafe.JoinGroup(strings.Split(path, "/")...)
+}
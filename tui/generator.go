@@ -0,0 +1,103 @@
+package tui
+
+import (
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/v3"
+	"github.com/gdamore/tcell/v2"
+)
+
+// generator is the password generator dialog
+type generator struct {
+	record *v3.Record
+
+	// form receives the password if the dialog was opened while editing
+	form *form
+
+	policy   pwsafe.PasswordPolicy
+	source   string
+	password string
+	err      error
+}
+
+// generate opens the password generator for the record being edited, or
+// for the selected record if f is nil
+func (a *App) generate(f *form) {
+	record := a.selectedRecord()
+	if f != nil {
+		record = f.record
+	}
+	if record == nil {
+		return
+	}
+	if record.Protected() {
+		a.status = record.Title() + " is protected"
+		return
+	}
+	if base, _ := a.db.Base(record); base != nil && f == nil {
+		a.status = record.Title() + " uses the password of " + base.Title()
+		return
+	}
+
+	g := &generator{record: record, form: f}
+	g.policy = a.db.PasswordPolicy(record)
+	_, own := record.PasswordPolicy()
+	switch {
+	case own:
+		g.source = "entry policy"
+	case g.policy.Name != "":
+		g.source = "policy " + g.policy.Name
+	default:
+		g.source = "default policy"
+	}
+	g.regenerate()
+	a.generator = g
+	a.focus = focusGenerator
+}
+
+func (g *generator) regenerate() {
+	g.password, g.err = g.policy.Generate()
+}
+
+func (a *App) generatorKey(ev *tcell.EventKey) {
+	g := a.generator
+	switch ev.Key() {
+	case tcell.KeyEscape:
+		a.closeGenerator()
+	case tcell.KeyEnter:
+		if g.err != nil {
+			return
+		}
+		a.closeGenerator()
+		if g.form != nil {
+			g.form.values[fieldPassword] = []rune(g.password)
+			g.form.setField(fieldPassword)
+			return
+		}
+		g.record.ChangePassword(g.password)
+		a.modified(g.record)
+		a.status = "changed the password of " + g.record.Title()
+	case tcell.KeyRune:
+		switch ev.Rune() {
+		case 'r', ' ':
+			g.regenerate()
+		case '+':
+			g.policy.Length++
+			g.regenerate()
+		case '-':
+			if g.policy.Length > 1 {
+				g.policy.Length--
+				g.regenerate()
+			}
+		}
+	}
+}
+
+// closeGenerator returns to the form or tree the generator was opened from
+func (a *App) closeGenerator() {
+	if a.generator.form != nil {
+		a.focus = focusEdit
+	} else {
+		a.focus = focusTree
+	}
+	a.generator = nil
+}
@@ -0,0 +1,93 @@
+package tui
+
+import (
+	"strings"
+
+	"github.com/azdagron/pwsafe/v3"
+	"github.com/gdamore/tcell/v2"
+)
+
+// Headless drives an App on a simulated screen, so the interface can be
+// tested without a terminal. Every key is handled and the screen redrawn
+// before the call returns.
+type Headless struct {
+	App    *App
+	Screen tcell.SimulationScreen
+}
+
+// NewHeadless returns an App on a simulated screen of the size
+func NewHeadless(db *v3.Database, opts Options, width, height int) (
+	*Headless, error) {
+
+	screen := tcell.NewSimulationScreen("UTF-8")
+	if err := screen.Init(); err != nil {
+		return nil, err
+	}
+	screen.SetSize(width, height)
+	h := &Headless{App: New(screen, db, opts), Screen: screen}
+	h.App.Draw()
+	return h, nil
+}
+
+// Key sends a special key, like tcell.KeyEnter or tcell.KeyCtrlG
+func (h *Headless) Key(key tcell.Key) {
+	h.send(tcell.NewEventKey(key, 0, tcell.ModNone))
+}
+
+// Type sends the runes of the text as key presses
+func (h *Headless) Type(text string) {
+	for _, c := range text {
+		h.send(tcell.NewEventKey(tcell.KeyRune, c, tcell.ModNone))
+	}
+}
+
+// Resize changes the size of the simulated screen
+func (h *Headless) Resize(width, height int) {
+	h.Screen.SetSize(width, height)
+	h.send(tcell.NewEventResize(width, height))
+}
+
+func (h *Headless) send(ev tcell.Event) {
+	h.App.HandleEvent(ev)
+	h.App.Draw()
+}
+
+// Lines returns the text on the screen, one string per line without
+// trailing spaces
+func (h *Headless) Lines() []string {
+	cells, width, height := h.Screen.GetContents()
+	lines := make([]string, height)
+	for y := 0; y < height; y++ {
+		var line strings.Builder
+		for x := 0; x < width; x++ {
+			cell := cells[y*width+x]
+			if len(cell.Runes) == 0 {
+				// the second column of a wide character
+				continue
+			}
+			line.WriteString(string(cell.Runes))
+		}
+		lines[y] = strings.TrimRight(line.String(), " ")
+	}
+	return lines
+}
+
+// Text returns the text on the screen, lines separated by newlines
+func (h *Headless) Text() string {
+	return strings.Join(h.Lines(), "\n")
+}
+
+// Contains returns true if the text appears on a line of the screen
+func (h *Headless) Contains(text string) bool {
+	for _, line := range h.Lines() {
+		if strings.Contains(line, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// Cursor returns the position of the cursor, or false if it is hidden
+func (h *Headless) Cursor() (x, y int, visible bool) {
+	return h.Screen.GetCursor()
+}
@@ -0,0 +1,191 @@
+package tui
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/v3"
+)
+
+// row is a visible line of the group tree
+type row struct {
+	depth int
+
+	// group is the group of a group row, or the group of the record
+	group string
+	name  string
+
+	// record is nil for group rows
+	record *v3.Record
+
+	// expanded is true for group rows showing their contents
+	expanded bool
+
+	// count is the number of records below a group row
+	count int
+}
+
+// node is a group of the tree
+type node struct {
+	name     string
+	group    string
+	children map[string]*node
+	records  []*v3.Record
+}
+
+func newNode(name, group string) *node {
+	return &node{name: name, group: group, children: make(map[string]*node)}
+}
+
+// child returns the child group, adding it if needed
+func (n *node) child(name string) *node {
+	c := n.children[name]
+	if c == nil {
+		var group string
+		if n.group == "" {
+			group = pwsafe.JoinGroup(name)
+		} else {
+			group = n.group + "." + pwsafe.JoinGroup(name)
+		}
+		c = newNode(name, group)
+		n.children[name] = c
+	}
+	return c
+}
+
+// find returns the node for the group, adding it and its parents if needed
+func (n *node) find(group string) *node {
+	for _, elem := range pwsafe.SplitGroup(group) {
+		n = n.child(elem)
+	}
+	return n
+}
+
+// count returns the number of records in the group and its children
+func (n *node) count() int {
+	total := len(n.records)
+	for _, c := range n.children {
+		total += c.count()
+	}
+	return total
+}
+
+// filtering returns true if the tree only shows records matching the filter
+func (a *App) filtering() bool {
+	return strings.TrimSpace(string(a.filter)) != ""
+}
+
+// rebuild recomputes the visible rows, keeping the selection
+func (a *App) rebuild() {
+	var selected_record *v3.Record
+	var selected_group string
+	if r := a.selected(); r != nil {
+		selected_record, selected_group = r.record, r.group
+	}
+
+	root := newNode("", "")
+	if a.filtering() {
+		for _, result := range pwsafe.Search(a.db.Records(), string(a.filter)) {
+			record := result.Record.(*v3.Record)
+			n := root.find(record.Group())
+			n.records = append(n.records, record)
+		}
+	} else {
+		for _, group := range a.db.Header().EmptyGroups() {
+			root.find(group)
+		}
+		for _, record := range a.db.Records() {
+			record := record.(*v3.Record)
+			n := root.find(record.Group())
+			n.records = append(n.records, record)
+		}
+	}
+
+	a.rows = a.rows[:0]
+	a.appendRows(root, 0)
+
+	a.cursor = 0
+	if selected_record != nil {
+		a.selectRecord(selected_record)
+	} else {
+		a.selectGroup(selected_group)
+	}
+}
+
+// appendRows appends the rows of the contents of the group, groups first
+func (a *App) appendRows(n *node, depth int) {
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return strings.ToLower(names[i]) < strings.ToLower(names[j])
+	})
+	for _, name := range names {
+		c := n.children[name]
+		expanded := a.filtering() || !a.collapsed[c.group]
+		a.rows = append(a.rows, row{
+			depth:    depth,
+			group:    c.group,
+			name:     c.name,
+			expanded: expanded,
+			count:    c.count(),
+		})
+		if expanded {
+			a.appendRows(c, depth+1)
+		}
+	}
+
+	sort.SliceStable(n.records, func(i, j int) bool {
+		return strings.ToLower(n.records[i].Title()) <
+			strings.ToLower(n.records[j].Title())
+	})
+	for _, record := range n.records {
+		a.rows = append(a.rows, row{
+			depth:  depth,
+			group:  record.Group(),
+			name:   record.Title(),
+			record: record,
+		})
+	}
+}
+
+// selectRecord moves the cursor to the record, expanding its groups. The
+// cursor does not move if the record is not visible.
+func (a *App) selectRecord(record *v3.Record) {
+	if a.expandGroup(record.Group()) {
+		a.rebuild()
+	}
+	for i, r := range a.rows {
+		if r.record == record {
+			a.cursor = i
+			return
+		}
+	}
+}
+
+// selectGroup moves the cursor to the group row
+func (a *App) selectGroup(group string) {
+	for i, r := range a.rows {
+		if r.record == nil && r.group == group {
+			a.cursor = i
+			return
+		}
+	}
+}
+
+// expandGroup expands the group and its parents. It returns true if any of
+// them were collapsed.
+func (a *App) expandGroup(group string) bool {
+	changed := false
+	elems := pwsafe.SplitGroup(group)
+	for i := range elems {
+		g := pwsafe.JoinGroup(elems[:i+1]...)
+		if a.collapsed[g] {
+			delete(a.collapsed, g)
+			changed = true
+		}
+	}
+	return changed
+}
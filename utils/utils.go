@@ -4,7 +4,9 @@ import (
 	"crypto/rand"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 
 	"github.com/azdagron/pwsafe"
 )
@@ -51,3 +53,99 @@ func ReadBytes(r io.Reader, n int) ([]byte, error) {
 func SecureRandBytes(n int) ([]byte, error) {
 	return ReadBytes(rand.Reader, n)
 }
+
+// WriteFileAtomic writes a file by calling fn with a temporary file in the
+// same directory, which is then renamed over the path. Readers see either the
+// old or the new contents, never a partially written file. The permissions of
+// an existing file are kept; new files are only readable by the owner.
+func WriteFileAtomic(path string, fn func(w io.Writer) error) (err error) {
+	mode := os.FileMode(0600)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	return WriteFileAtomicMode(path, mode, fn)
+}
+
+// WriteFileAtomicMode is WriteFileAtomic, but the file gets the mode even if
+// it exists already.
+func WriteFileAtomicMode(path string, mode os.FileMode,
+	fn func(w io.Writer) error) (err error) {
+
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, "."+base+".tmp")
+	if err != nil {
+		return pwsafe.IOError.Wrap(err)
+	}
+	closed := false
+	defer func() {
+		if err == nil {
+			return
+		}
+		if !closed {
+			LogError(f.Close)
+		}
+		LogError(func() error { return os.Remove(f.Name()) })
+	}()
+
+	if err = fn(f); err != nil {
+		return err
+	}
+	if err = f.Chmod(mode); err != nil {
+		return pwsafe.IOError.Wrap(err)
+	}
+	if err = f.Sync(); err != nil {
+		return pwsafe.IOError.Wrap(err)
+	}
+	closed = true
+	if err = f.Close(); err != nil {
+		return pwsafe.IOError.Wrap(err)
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return pwsafe.IOError.Wrap(err)
+	}
+
+	// make the rename durable; not all platforms can sync directories
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// BackupPath returns the path of the nth most recent backup of a file
+func BackupPath(path string, n int) string {
+	return fmt.Sprintf("%s.bak%d", path, n)
+}
+
+// RotateBackups keeps up to count copies of the file, the most recent in
+// BackupPath(path, 1). Older copies move up one number and the oldest is
+// removed. Nothing happens if the file does not exist.
+func RotateBackups(path string, count int) error {
+	if count <= 0 {
+		return nil
+	}
+	src, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return pwsafe.IOError.Wrap(err)
+	}
+	defer LogError(src.Close)
+
+	for n := count; n > 1; n-- {
+		err := os.Rename(BackupPath(path, n-1), BackupPath(path, n))
+		if err != nil && !os.IsNotExist(err) {
+			return pwsafe.IOError.Wrap(err)
+		}
+	}
+	return WriteFileAtomic(BackupPath(path, 1), func(w io.Writer) error {
+		if _, err := io.Copy(w, src); err != nil {
+			return pwsafe.IOError.Wrap(err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,422 @@
+package v3
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/azdagron/pwsafe"
+)
+
+// The audit log is kept in an application-specific header field, encrypted
+// with the rest of the database. It holds one JSON object per line. Every
+// entry carries the SHA-256 hash of the previous entry's hash and its own
+// encoding, so entries changed or removed after they were written break the
+// chain. Entries removed from the end, or a log rewritten as a whole, are
+// not detected; keep the hash of the last entry elsewhere to detect those.
+
+// Audit log operations
+const (
+	AuditEnable = "enable"
+	AuditAdd    = "add"
+	AuditModify = "modify"
+	AuditRemove = "remove"
+	AuditHeader = "header"
+	AuditSave   = "save"
+	AuditMerge  = "merge"
+)
+
+// AuditEntry is an entry of the audit log
+type AuditEntry struct {
+	Time      time.Time
+	Operation string
+
+	// UUID is the record the operation changed, empty for the operations
+	// on the database
+	UUID string
+
+	// Fields are the changed field types, of the record or of the header
+	Fields []byte
+
+	User string
+	Host string
+
+	// Hash chains the entry to the entries before it
+	Hash []byte
+}
+
+// FieldNames returns the names of the changed fields, as used in the JSON
+// representation
+func (e AuditEntry) FieldNames() []string {
+	names := make([]string, 0, len(e.Fields))
+	for _, field_type := range e.Fields {
+		switch {
+		case e.Operation != AuditHeader:
+			names = append(names, fieldName(field_type))
+		case field_type == emptyGroupsHeader:
+			names = append(names, "empty_groups")
+		default:
+			names = append(names, headerFieldName(field_type))
+		}
+	}
+	return names
+}
+
+type jsonAuditEntry struct {
+	Time      string `json:"time"`
+	Operation string `json:"op"`
+	UUID      string `json:"uuid,omitempty"`
+	Fields    []int  `json:"fields,omitempty"`
+	User      string `json:"user"`
+	Host      string `json:"host"`
+	Hash      string `json:"hash,omitempty"`
+}
+
+// encode returns the JSON encoding of the entry, without the hash if
+// with_hash is false
+func (e AuditEntry) encode(with_hash bool) ([]byte, error) {
+	entry := jsonAuditEntry{
+		Time:      e.Time.UTC().Format(time.RFC3339Nano),
+		Operation: e.Operation,
+		UUID:      e.UUID,
+		User:      e.User,
+		Host:      e.Host,
+	}
+	for _, field_type := range e.Fields {
+		entry.Fields = append(entry.Fields, int(field_type))
+	}
+	if with_hash {
+		entry.Hash = hex.EncodeToString(e.Hash)
+	}
+	return json.Marshal(entry)
+}
+
+// chainHash returns the hash of the entry following the previous hash
+func (e AuditEntry) chainHash(previous []byte) ([]byte, error) {
+	data, err := e.encode(false)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	h.Write(previous)
+	h.Write(data)
+	return h.Sum(nil), nil
+}
+
+// AuditLogEnabled returns true if changes are recorded in the audit log
+func (db *Database) AuditLogEnabled() bool {
+	return db.header.fields[auditLogHeader] != nil
+}
+
+// EnableAuditLog starts recording changes in the audit log when saving.
+// Changes made since the database was opened are recorded with the next
+// save.
+func (db *Database) EnableAuditLog() error {
+	if db.AuditLogEnabled() {
+		return nil
+	}
+	user, host := db.auditIdentity()
+	return db.appendAuditEntries(nil, []AuditEntry{{
+		Time:      time.Now(),
+		Operation: AuditEnable,
+		User:      user,
+		Host:      host,
+	}})
+}
+
+// DisableAuditLog stops recording changes and removes the audit log
+func (db *Database) DisableAuditLog() {
+	db.header.SetField(auditLogHeader, nil)
+}
+
+// SetAuditIdentity sets the user and host recorded in the audit log. They
+// default to the current user and the host name.
+func (db *Database) SetAuditIdentity(user, host string) {
+	db.auditUser, db.auditHost = user, host
+}
+
+// SetAuditBaseline records the changes since the previous database was
+// opened or saved in the audit log, instead of the changes since this
+// database was opened. It is used when a database is replaced by a changed
+// copy, such as one decoded from JSON.
+func (db *Database) SetAuditBaseline(previous *Database) {
+	db.snapshot = previous.snapshot
+}
+
+// AuditLog returns the entries of the audit log, oldest first
+func (db *Database) AuditLog() ([]AuditEntry, error) {
+	var entries []AuditEntry
+	for _, line := range bytes.Split(db.header.fields[auditLogHeader],
+		[]byte("\n")) {
+
+		if len(line) == 0 {
+			continue
+		}
+		var entry jsonAuditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, Corrupted.New("invalid audit log entry: %s", err)
+		}
+		t, err := time.Parse(time.RFC3339Nano, entry.Time)
+		if err != nil {
+			return nil, Corrupted.New("invalid audit log time: %s", err)
+		}
+		hash, err := hex.DecodeString(entry.Hash)
+		if err != nil {
+			return nil, Corrupted.New("invalid audit log hash: %s", err)
+		}
+		fields := make([]byte, 0, len(entry.Fields))
+		for _, field_type := range entry.Fields {
+			fields = append(fields, byte(field_type))
+		}
+		entries = append(entries, AuditEntry{
+			Time:      t,
+			Operation: entry.Operation,
+			UUID:      entry.UUID,
+			Fields:    fields,
+			User:      entry.User,
+			Host:      entry.Host,
+			Hash:      hash,
+		})
+	}
+	return entries, nil
+}
+
+// VerifyAuditLog checks the hash chain of the audit log entries. It returns
+// a Corrupted error naming the first entry that does not match.
+func VerifyAuditLog(entries []AuditEntry) error {
+	var previous []byte
+	for i, entry := range entries {
+		hash, err := entry.chainHash(previous)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(hash, entry.Hash) {
+			return Corrupted.New("audit log entry %d (%s %s) was changed, "+
+				"or entries before it were removed", i+1,
+				entry.Time.UTC().Format(time.RFC3339), entry.Operation)
+		}
+		previous = hash
+	}
+	return nil
+}
+
+// appendAuditEntries chains the entries to the log and stores it in the
+// header. entries are the current log entries, or nil if the log is empty.
+func (db *Database) appendAuditEntries(entries []AuditEntry,
+	added []AuditEntry) error {
+
+	var previous []byte
+	if len(entries) > 0 {
+		previous = entries[len(entries)-1].Hash
+	}
+	data := append([]byte{}, db.header.fields[auditLogHeader]...)
+	for _, entry := range added {
+		hash, err := entry.chainHash(previous)
+		if err != nil {
+			return err
+		}
+		entry.Hash = hash
+		line, err := entry.encode(true)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+		previous = hash
+	}
+	db.header.SetField(auditLogHeader, data)
+	return nil
+}
+
+// mergeAuditLog sets the audit log of the merged database to the entries of
+// ours followed by the entries theirs added since the entries both share,
+// chained again, and an entry for the merge. A log of theirs whose chain
+// does not verify is left out and reported as a conflict, as chaining its
+// entries again would hide the tampering.
+func (m *merger) mergeAuditLog(ours, theirs *Database) error {
+	if !ours.AuditLogEnabled() && !theirs.AuditLogEnabled() {
+		return nil
+	}
+	our_entries, err := ours.AuditLog()
+	if err != nil {
+		return err
+	}
+	their_entries, err := theirs.AuditLog()
+	if err == nil {
+		err = VerifyAuditLog(their_entries)
+	}
+	if err != nil {
+		if !Corrupted.Contains(err) {
+			return err
+		}
+		their_entries = nil
+		m.conflicts = append(m.conflicts, pwsafe.Conflict{
+			Field:      headerFieldName(auditLogHeader),
+			Resolution: pwsafe.Ours,
+		})
+	}
+
+	shared := 0
+	for shared < len(our_entries) && shared < len(their_entries) &&
+		bytes.Equal(our_entries[shared].Hash, their_entries[shared].Hash) {
+		shared++
+	}
+	added := append([]AuditEntry{}, their_entries[shared:]...)
+	if len(added) == 0 {
+		return nil
+	}
+	user, host := ours.auditIdentity()
+	added = append(added, AuditEntry{
+		Time:      time.Now(),
+		Operation: AuditMerge,
+		User:      user,
+		Host:      host,
+	})
+	m.result.header.SetField(auditLogHeader,
+		ours.header.fields[auditLogHeader])
+	return m.result.appendAuditEntries(our_entries, added)
+}
+
+// auditIdentity returns the user and host to record
+func (db *Database) auditIdentity() (string, string) {
+	name, host := db.auditUser, db.auditHost
+	if name == "" {
+		if current, err := user.Current(); err == nil {
+			name = current.Username
+		}
+	}
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	return name, host
+}
+
+// audited runs the save, recording the changes since the last open or save
+// in the audit log if it is enabled. The log is restored if the save fails.
+func (db *Database) audited(save func() error) error {
+	previous := db.header.fields[auditLogHeader]
+	if db.AuditLogEnabled() {
+		entries, err := db.AuditLog()
+		if err != nil {
+			return err
+		}
+		if err = db.appendAuditEntries(entries, db.changes()); err != nil {
+			return err
+		}
+	}
+	if err := save(); err != nil {
+		if previous != nil {
+			db.header.fields[auditLogHeader] = previous
+		}
+		return err
+	}
+	db.snapshot = db.takeSnapshot()
+	return nil
+}
+
+// changes returns the audit log entries for the changes since the snapshot,
+// followed by the save
+func (db *Database) changes() []AuditEntry {
+	user, host := db.auditIdentity()
+	now := time.Now()
+	entry := func(operation, uuid string, fields []byte) AuditEntry {
+		return AuditEntry{
+			Time:      now,
+			Operation: operation,
+			UUID:      uuid,
+			Fields:    fields,
+			User:      user,
+			Host:      host,
+		}
+	}
+
+	current := db.takeSnapshot()
+	previous := db.snapshot
+	var entries []AuditEntry
+	if previous != nil {
+		if fields := changedFields(previous.header,
+			current.header); len(fields) > 0 {
+			entries = append(entries, entry(AuditHeader, "", fields))
+		}
+	} else {
+		previous = &auditSnapshot{}
+	}
+	for _, record := range db.records {
+		uuid := record.UUID()
+		before, ok := previous.records[uuid]
+		if !ok {
+			entries = append(entries, entry(AuditAdd, uuid,
+				changedFields(nil, current.records[uuid])))
+			continue
+		}
+		if fields := changedFields(before,
+			current.records[uuid]); len(fields) > 0 {
+			entries = append(entries, entry(AuditModify, uuid, fields))
+		}
+	}
+	var removed []string
+	for uuid := range previous.records {
+		if _, ok := current.records[uuid]; !ok {
+			removed = append(removed, uuid)
+		}
+	}
+	sort.Strings(removed)
+	for _, uuid := range removed {
+		entries = append(entries, entry(AuditRemove, uuid, nil))
+	}
+	return append(entries, entry(AuditSave, "", nil))
+}
+
+// auditSnapshot holds digests of the header and record fields at the last
+// open or save, to find the changes to record in the audit log
+type auditSnapshot struct {
+	header  map[byte][sha256.Size]byte
+	records map[string]map[byte][sha256.Size]byte
+}
+
+func (db *Database) takeSnapshot() *auditSnapshot {
+	snapshot := &auditSnapshot{
+		header:  digestFields(db.header.fields),
+		records: make(map[string]map[byte][sha256.Size]byte),
+	}
+	delete(snapshot.header, auditLogHeader)
+	if len(db.header.emptyGroups) > 0 {
+		snapshot.header[emptyGroupsHeader] = sha256.Sum256([]byte(
+			strings.Join(db.header.emptyGroups, "\x00")))
+	}
+	for _, record := range db.records {
+		snapshot.records[record.UUID()] = digestFields(record.fields)
+	}
+	return snapshot
+}
+
+func digestFields(fields map[byte][]byte) map[byte][sha256.Size]byte {
+	digests := make(map[byte][sha256.Size]byte, len(fields))
+	for field_type, data := range fields {
+		digests[field_type] = sha256.Sum256(data)
+	}
+	return digests
+}
+
+// changedFields returns the field types that differ, in order
+func changedFields(before,
+	after map[byte][sha256.Size]byte) []byte {
+
+	set := make(map[byte]bool)
+	for field_type, digest := range after {
+		if previous, ok := before[field_type]; !ok || previous != digest {
+			set[field_type] = true
+		}
+	}
+	for field_type := range before {
+		if _, ok := after[field_type]; !ok {
+			set[field_type] = true
+		}
+	}
+	return sortedFieldTypes(set)
+}
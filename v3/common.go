@@ -3,11 +3,13 @@ package v3
 import (
 	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
 	"time"
 
 	"golang.org/x/crypto/twofish"
 
 	"github.com/azdagron/pwsafe"
+	"github.com/azdagron/pwsafe/utils"
 )
 
 var (
@@ -16,10 +18,15 @@ var (
 	BadPassphrase = pwsafe.BadPassphrase
 	BadTag        = pwsafe.BadTag
 	Corrupted     = pwsafe.Corrupted
+	Unsupported   = pwsafe.Unsupported
 )
 
+// minHashIterations is the least number of iterations the format allows
+const minHashIterations uint32 = 2048
+
 const (
 	hashIterations uint32 = 4096
+	formatVersion  uint16 = 0x030d
 	v3Tag                 = "PWS3"
 	v3EOF                 = "PWS3-EOFPWS3-EOF"
 
@@ -44,6 +51,9 @@ const (
 	emptyGroupsHeader           byte = 0x11
 	yubicoHeader                byte = 0x12
 
+	// Application-specific header fields
+	auditLogHeader byte = 0xe0
+
 	// Record fields
 	uuidField             byte = 0x01
 	groupField            byte = 0x02
@@ -71,6 +81,10 @@ const (
 	policyNameField       byte = 0x18
 	keyboardShortcutField byte = 0x19
 
+	// Application-specific record fields
+	customFieldsField byte = 0xe0
+	totpField         byte = 0xe1
+
 	fieldEnd byte = 0xff
 
 	saltLen = 32
@@ -89,6 +103,35 @@ func decodeTimeField(data []byte) time.Time {
 	return time.Unix(int64(time_t), 0)
 }
 
+func encodeTimeField(t time.Time) []byte {
+	if t.IsZero() {
+		return nil
+	}
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, uint32(t.Unix()))
+	return data
+}
+
+// newUUID returns a new random (version 4) UUID
+func newUUID() ([]byte, error) {
+	uuid, err := utils.SecureRandBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return uuid, nil
+}
+
+// decodeUUID decodes the 32 hex character representation of a UUID
+func decodeUUID(s string) ([]byte, error) {
+	uuid, err := hex.DecodeString(s)
+	if err != nil || len(uuid) != 16 {
+		return nil, Error.New("invalid uuid %q", s)
+	}
+	return uuid, nil
+}
+
 func makeKey(passphrase string, salt []byte, iter uint32) ([]byte, []byte) {
 	h := sha256.Sum256(append([]byte(passphrase), salt...))
 	for i := uint32(0); i < iter; i++ {
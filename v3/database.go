@@ -1,7 +1,12 @@
 package v3
 
 import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
 	"os"
+	"strings"
 
 	"github.com/azdagron/pwsafe"
 	"github.com/azdagron/pwsafe/utils"
@@ -11,15 +16,36 @@ import (
 type Database struct {
 	header  *Header
 	records []*Record
+
+	// iterations is the number of hash iterations of the passphrase
+	iterations uint32
+
+	// snapshot holds the state at the last open or save for the audit log
+	snapshot             *auditSnapshot
+	auditUser, auditHost string
 }
 
 // newDatabase returns a new database object with the specified header and
 // records.
 func newDatabase(header *Header, records []*Record) *Database {
 	return &Database{
-		header:  header,
-		records: records,
+		header:     header,
+		records:    records,
+		iterations: hashIterations,
+	}
+}
+
+// NewDatabase returns a new empty database
+func NewDatabase() (*Database, error) {
+	uuid, err := newUUID()
+	if err != nil {
+		return nil, err
 	}
+	header := newHeader(nil)
+	header.fields[versionHeader] = make([]byte, 2)
+	binary.LittleEndian.PutUint16(header.fields[versionHeader], formatVersion)
+	header.fields[uuidHeader] = uuid
+	return newDatabase(header, nil), nil
 }
 
 // PassphraseFn is a callback to retrieve the password when opening a database.
@@ -27,7 +53,7 @@ type PassphraseFn func() (string, error)
 
 // Open opens a v3 password safe database
 func Open(path string, passphrase_fn PassphraseFn) (
-	database pwsafe.Database, err error) {
+	database *Database, err error) {
 
 	f, err := os.Open(path)
 	if err != nil {
@@ -38,16 +64,33 @@ func Open(path string, passphrase_fn PassphraseFn) (
 	return OpenReader(f, passphrase_fn)
 }
 
-// Save saves the database to the path
+// Save saves the database to the path. The file is replaced atomically.
 func (db *Database) Save(path, passphrase string) (err error) {
-	f, err := os.Create(path)
-	if err != nil {
-		return nil
-	}
-	defer utils.LogError(f.Close)
-
 	// always save as the latest
-	return db.SaveWriter(f, passphrase)
+	return db.audited(func() error {
+		return utils.WriteFileAtomic(path, func(w io.Writer) error {
+			return db.saveWriter(w, passphrase)
+		})
+	})
+}
+
+// Iterations returns the number of hash iterations protecting the
+// passphrase
+func (db *Database) Iterations() int {
+	return int(db.iterations)
+}
+
+// SetIterations sets the number of hash iterations protecting the passphrase
+// of the saved database. More iterations make guessing the passphrase
+// slower; the format requires at least 2048.
+func (db *Database) SetIterations(iterations int) error {
+	if iterations < int(minHashIterations) ||
+		int64(iterations) > math.MaxUint32 {
+		return Error.New("iterations must be between %d and %d",
+			minHashIterations, uint32(math.MaxUint32))
+	}
+	db.iterations = uint32(iterations)
+	return nil
 }
 
 // Version returns the database version
@@ -70,3 +113,143 @@ func (db *Database) Records() []pwsafe.Record {
 	}
 	return records
 }
+
+// Record returns the record with the UUID, or nil if there is none
+func (db *Database) Record(uuid string) *Record {
+	for _, record := range db.records {
+		if record.UUID() == uuid {
+			return record
+		}
+	}
+	return nil
+}
+
+// AddRecord adds a record to the database. Record UUIDs must be unique, so a
+// record with the same UUID as an existing record is given a new UUID.
+func (db *Database) AddRecord(record *Record) error {
+	if db.Record(record.UUID()) != nil {
+		uuid, err := newUUID()
+		if err != nil {
+			return err
+		}
+		record.fields[uuidField] = uuid
+	}
+	db.records = append(db.records, record)
+	return nil
+}
+
+// RemoveRecord removes the record with the UUID from the database. It returns
+// false if there is no such record.
+func (db *Database) RemoveRecord(uuid string) bool {
+	for i, record := range db.records {
+		if record.UUID() == uuid {
+			db.records = append(db.records[:i], db.records[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Dependents returns the aliases and shortcuts of the record with the UUID.
+// An alias uses the password of its base record, a shortcut all of its
+// fields.
+func (db *Database) Dependents(uuid string) []*Record {
+	alias := "[[" + uuid + "]]"
+	shortcut := "[~" + uuid + "~]"
+	var dependents []*Record
+	for _, record := range db.records {
+		password := strings.ToLower(record.Password())
+		if password == alias || password == shortcut {
+			dependents = append(dependents, record)
+		}
+	}
+	return dependents
+}
+
+// Base returns the base record of an alias or shortcut, or nil if the record
+// is neither or its base does not exist. shortcut is true for shortcuts, which
+// use all fields of the base record; aliases only use its password.
+func (db *Database) Base(record *Record) (base *Record, shortcut bool) {
+	base, shortcut = db.Referenced(record.Password())
+	if base == record {
+		return nil, false
+	}
+	return base, shortcut
+}
+
+// Referenced returns the record an alias or shortcut password refers to, or
+// nil if the password is neither or the record does not exist.
+func (db *Database) Referenced(password string) (base *Record,
+	shortcut bool) {
+
+	password = strings.ToLower(password)
+	if len(password) != 36 {
+		return nil, false
+	}
+	switch {
+	case strings.HasPrefix(password, "[[") && strings.HasSuffix(password, "]]"):
+	case strings.HasPrefix(password, "[~") && strings.HasSuffix(password, "~]"):
+		shortcut = true
+	default:
+		return nil, false
+	}
+	base = db.Record(password[2:34])
+	if base == nil {
+		return nil, false
+	}
+	return base, shortcut
+}
+
+// Wipe overwrites the decrypted field data of the header and all records with
+// zeros and removes the records. The database is empty afterwards.
+func (db *Database) Wipe() {
+	wipe := func(fields map[byte][]byte) {
+		for field_type, data := range fields {
+			for i := range data {
+				data[i] = 0
+			}
+			delete(fields, field_type)
+		}
+	}
+	wipe(db.header.fields)
+	db.header.emptyGroups = nil
+	for _, record := range db.records {
+		wipe(record.fields)
+	}
+	db.records = nil
+	db.snapshot = nil
+}
+
+// Seal returns the database encrypted with the passphrase in the file format,
+// so that unsaved changes can be kept while the decrypted database is wiped.
+// Nothing is saved: the changes since the last open or save are added to the
+// audit log of the sealed copy only, and are recorded with the next save of
+// the database returned by Unseal.
+func (db *Database) Seal(passphrase string) ([]byte, error) {
+	previous := db.header.fields[auditLogHeader]
+	defer db.header.SetField(auditLogHeader, previous)
+	if db.AuditLogEnabled() {
+		entries, err := db.AuditLog()
+		if err != nil {
+			return nil, err
+		}
+		changes := db.changes()
+		// the trailing entry is the save, which does not happen here
+		err = db.appendAuditEntries(entries, changes[:len(changes)-1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	var sealed bytes.Buffer
+	if err := db.saveWriter(&sealed, passphrase); err != nil {
+		return nil, err
+	}
+	return sealed.Bytes(), nil
+}
+
+// Unseal decrypts a database sealed by Seal
+func Unseal(sealed []byte, passphrase string) (*Database, error) {
+	return OpenReader(bytes.NewReader(sealed), func() (string, error) {
+		return passphrase, nil
+	})
+}
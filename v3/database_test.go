@@ -0,0 +1,38 @@
+package v3
+
+import (
+	"testing"
+)
+
+// TestSeal checks that a sealed database keeps its unsaved changes
+func TestSeal(t *testing.T) {
+	db, err := NewDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := NewRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record.SetTitle("entry")
+	record.SetPassword("secret")
+	if err = db.AddRecord(record); err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := db.Seal("passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Unseal(sealed, "wrong"); err == nil {
+		t.Error("expected unsealing with a wrong passphrase to fail")
+	}
+	unsealed, err := Unseal(sealed, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unsealed.Records()) != 1 ||
+		unsealed.Records()[0].Password() != "secret" {
+		t.Errorf("expected the unsaved entry, got %v", unsealed.Records())
+	}
+}
@@ -5,6 +5,10 @@ import "time"
 // Header is a v3 password safe header
 type Header struct {
 	fields map[byte][]byte
+
+	// emptyGroups holds the empty groups field, which unlike other fields
+	// can appear multiple times.
+	emptyGroups []string
 }
 
 // newHeader constructs an empty Header object
@@ -15,7 +19,57 @@ func newHeader(fields map[byte][]byte) *Header {
 	return &Header{fields: fields}
 }
 
+// Field returns the raw data for the field type
+func (h *Header) Field(field_type byte) []byte {
+	return h.fields[field_type]
+}
+
+// SetField sets the raw data for the field type. An empty value removes the
+// field.
+func (h *Header) SetField(field_type byte, data []byte) {
+	if len(data) == 0 {
+		delete(h.fields, field_type)
+		return
+	}
+	h.fields[field_type] = append([]byte{}, data...)
+}
+
 // Mtime returns the timestamp of the last save on the database
 func (h *Header) Mtime() time.Time {
 	return decodeTimeField(h.fields[saveTimestampHeader])
 }
+
+// SetMtime sets the timestamp of the last save on the database
+func (h *Header) SetMtime(t time.Time) {
+	h.SetField(saveTimestampHeader, encodeTimeField(t))
+}
+
+// Name returns the database name
+func (h *Header) Name() string {
+	return string(h.fields[databaseNameHeader])
+}
+
+// SetName sets the database name
+func (h *Header) SetName(name string) {
+	h.SetField(databaseNameHeader, []byte(name))
+}
+
+// Description returns the database description
+func (h *Header) Description() string {
+	return string(h.fields[databaseDescHeader])
+}
+
+// SetDescription sets the database description
+func (h *Header) SetDescription(desc string) {
+	h.SetField(databaseDescHeader, []byte(desc))
+}
+
+// EmptyGroups returns the groups that have no records
+func (h *Header) EmptyGroups() []string {
+	return append([]string(nil), h.emptyGroups...)
+}
+
+// SetEmptyGroups sets the groups that have no records
+func (h *Header) SetEmptyGroups(groups []string) {
+	h.emptyGroups = append([]string(nil), groups...)
+}
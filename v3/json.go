@@ -0,0 +1,613 @@
+package v3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/azdagron/pwsafe"
+)
+
+// The JSON representation of a database is lossless: every field that is not
+// known, or whose data cannot be represented exactly by its JSON type, is
+// stored as a raw field with base64 encoded data.
+//
+// The schema is versioned. Readers reject documents with a different major
+// schema version, and documents or records marked as masked.
+const (
+	JSONSchema = "pwsafe-v3"
+
+	JSONSchemaVersion = 1
+)
+
+type jsonKind int
+
+const (
+	jsonText jsonKind = iota
+	jsonTime
+	jsonUUID
+	jsonUint16
+	jsonUint32
+	jsonBool
+	jsonHistory
+	jsonCustomFields
+)
+
+type jsonField struct {
+	field_type byte
+	name       string
+	kind       jsonKind
+}
+
+// headerJSONFields lists the typed header fields in the order they appear in
+// the JSON object
+var headerJSONFields = []jsonField{
+	{versionHeader, "format_version", jsonUint16},
+	{uuidHeader, "uuid", jsonUUID},
+	{databaseNameHeader, "name", jsonText},
+	{databaseDescHeader, "description", jsonText},
+	{saveTimestampHeader, "mtime", jsonTime},
+	{whoSavedHeader, "who_saved", jsonText},
+	{whatSavedHeader, "what_saved", jsonText},
+	{lastSavedByUserHeader, "last_saved_by_user", jsonText},
+	{lastSavedOnHostHeader, "last_saved_on_host", jsonText},
+	{prefsHeader, "preferences", jsonText},
+	{treeStatusHeader, "tree_status", jsonText},
+	{databaseFilterHeader, "filters", jsonText},
+	{recentlyUsedEntriesHeader, "recently_used_entries", jsonText},
+	{namedPasswordPoliciesHeader, "named_password_policies", jsonText},
+	{yubicoHeader, "yubico", jsonText},
+	{auditLogHeader, "audit_log", jsonText},
+}
+
+// recordJSONFields lists the typed record fields in the order they appear in
+// the JSON object
+var recordJSONFields = []jsonField{
+	{uuidField, "uuid", jsonUUID},
+	{groupField, "group", jsonText},
+	{titleField, "title", jsonText},
+	{usernameField, "username", jsonText},
+	{passwordField, "password", jsonText},
+	{notesField, "notes", jsonText},
+	{urlField, "url", jsonText},
+	{emailField, "email", jsonText},
+	{ctimeField, "ctime", jsonTime},
+	{mtimeField, "mtime", jsonTime},
+	{atimeField, "atime", jsonTime},
+	{passwordMtimeField, "password_mtime", jsonTime},
+	{expiryField, "expiry", jsonTime},
+	{expiryIntervalField, "expiry_interval", jsonUint32},
+	{historyField, "history", jsonHistory},
+	{autotypeField, "autotype", jsonText},
+	{runCommandField, "run_command", jsonText},
+	{policyField, "policy", jsonText},
+	{policyNameField, "policy_name", jsonText},
+	{passwordSymField, "own_symbols", jsonText},
+	{dblClickField, "double_click_action", jsonUint16},
+	{shiftDblclickField, "shift_double_click_action", jsonUint16},
+	{protectedEntryField, "protected", jsonBool},
+	{keyboardShortcutField, "keyboard_shortcut", jsonUint32},
+	{totpField, "totp", jsonText},
+	{customFieldsField, "custom_fields", jsonCustomFields},
+}
+
+type jsonRawField struct {
+	Type byte   `json:"type"`
+	Data []byte `json:"data"`
+}
+
+type jsonPasswordHistory struct {
+	Enabled bool               `json:"enabled"`
+	Max     int                `json:"max"`
+	Entries []jsonHistoryEntry `json:"entries"`
+}
+
+type jsonHistoryEntry struct {
+	Time     string `json:"time"`
+	Password string `json:"password"`
+}
+
+type jsonCustomField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type jsonDatabase struct {
+	Schema        string            `json:"schema"`
+	SchemaVersion int               `json:"schema_version"`
+	Masked        bool              `json:"masked,omitempty"`
+	Header        json.RawMessage   `json:"header"`
+	Records       []json.RawMessage `json:"records"`
+}
+
+// ReadJSON reads a database encoded by MarshalJSON
+func ReadJSON(r io.Reader) (*Database, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, IOError.Wrap(err)
+	}
+	// JSON does not carry the key stretching of the database
+	db := newDatabase(nil, nil)
+	if err = db.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// MarshalJSON encodes the database, header and all records as JSON
+func (db *Database) MarshalJSON() ([]byte, error) {
+	return db.marshalJSON(false)
+}
+
+// MarshalMaskedJSON encodes the database like MarshalJSON, with the records
+// masked like by Record.MarshalMaskedJSON. The document is marked as masked
+// and cannot be read back.
+func (db *Database) MarshalMaskedJSON() ([]byte, error) {
+	return db.marshalJSON(true)
+}
+
+func (db *Database) marshalJSON(masked bool) ([]byte, error) {
+	header, err := db.header.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	records := []json.RawMessage{}
+	for _, record := range db.records {
+		data, err := record.marshalJSON(masked)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, data)
+	}
+	return json.Marshal(jsonDatabase{
+		Schema:        JSONSchema,
+		SchemaVersion: JSONSchemaVersion,
+		Masked:        masked,
+		Header:        header,
+		Records:       records,
+	})
+}
+
+// UnmarshalJSON decodes a database encoded by MarshalJSON
+func (db *Database) UnmarshalJSON(data []byte) error {
+	var doc jsonDatabase
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return Corrupted.New("invalid json: %s", err)
+	}
+	if doc.Schema != JSONSchema {
+		return BadTag.New("expected schema %q, got %q", JSONSchema,
+			doc.Schema)
+	}
+	if doc.SchemaVersion != JSONSchemaVersion {
+		return Unsupported.New("schema version %d", doc.SchemaVersion)
+	}
+	if doc.Masked {
+		return Unsupported.New("masked document")
+	}
+	header := newHeader(nil)
+	if err := header.UnmarshalJSON(doc.Header); err != nil {
+		return err
+	}
+	var records []*Record
+	for _, data := range doc.Records {
+		if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+			continue
+		}
+		record := new(Record)
+		if err := record.UnmarshalJSON(data); err != nil {
+			return err
+		}
+		records = append(records, record)
+	}
+	db.header = header
+	db.records = records
+	return nil
+}
+
+// MarshalJSON encodes the header fields as JSON
+func (h *Header) MarshalJSON() ([]byte, error) {
+	var b jsonObjectBuilder
+	raw := marshalFields(&b, headerJSONFields, h.fields)
+
+	var groups []string
+	for _, group := range h.emptyGroups {
+		if utf8.ValidString(group) {
+			groups = append(groups, group)
+		} else {
+			raw = append(raw, jsonRawField{
+				Type: emptyGroupsHeader,
+				Data: []byte(group),
+			})
+		}
+	}
+	if len(groups) > 0 {
+		b.add("empty_groups", groups)
+	}
+	if len(raw) > 0 {
+		b.add("raw_fields", raw)
+	}
+	return b.bytes()
+}
+
+// UnmarshalJSON decodes a header encoded by MarshalJSON
+func (h *Header) UnmarshalJSON(data []byte) error {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return Corrupted.New("invalid json header: %s", err)
+	}
+	fields, raw, err := unmarshalFields(headerJSONFields, object)
+	if err != nil {
+		return err
+	}
+	var groups []string
+	if value, ok := object["empty_groups"]; ok {
+		if err = json.Unmarshal(value, &groups); err != nil {
+			return Corrupted.New("invalid empty_groups: %s", err)
+		}
+	}
+	for _, field := range raw {
+		if field.Type == emptyGroupsHeader {
+			groups = append(groups, string(field.Data))
+			continue
+		}
+		if _, ok := fields[field.Type]; ok {
+			return Corrupted.New("duplicate header field %d", field.Type)
+		}
+		fields[field.Type] = field.Data
+	}
+	h.fields = fields
+	h.emptyGroups = groups
+	return nil
+}
+
+// MarshalJSON encodes the record fields as JSON
+func (r *Record) MarshalJSON() ([]byte, error) {
+	return r.marshalJSON(false)
+}
+
+// MarshalMaskedJSON encodes the record like MarshalJSON, with the password,
+// the password history and the TOTP secret replaced by asterisks. The record
+// is marked as masked and cannot be read back.
+func (r *Record) MarshalMaskedJSON() ([]byte, error) {
+	return r.marshalJSON(true)
+}
+
+func (r *Record) marshalJSON(masked bool) ([]byte, error) {
+	var b jsonObjectBuilder
+	fields := r.fields
+	if masked {
+		b.add("masked", true)
+		fields = r.maskedFields()
+	}
+	raw := marshalFields(&b, recordJSONFields, fields)
+	if len(raw) > 0 {
+		b.add("raw_fields", raw)
+	}
+	return b.bytes()
+}
+
+// UnmarshalJSON decodes a record encoded by MarshalJSON
+func (r *Record) UnmarshalJSON(data []byte) error {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return Corrupted.New("invalid json record: %s", err)
+	}
+	if value, ok := object["masked"]; ok && string(value) != "false" {
+		return Unsupported.New("masked record")
+	}
+	fields, raw, err := unmarshalFields(recordJSONFields, object)
+	if err != nil {
+		return err
+	}
+	for _, field := range raw {
+		if _, ok := fields[field.Type]; ok {
+			return Corrupted.New("duplicate record field %d", field.Type)
+		}
+		fields[field.Type] = field.Data
+	}
+	r.fields = fields
+	return nil
+}
+
+// maskedFields returns a copy of the fields with the secrets replaced by
+// asterisks. A password history that cannot be decoded is left out.
+func (r *Record) maskedFields() map[byte][]byte {
+	mask := func(data []byte) []byte {
+		return bytes.Repeat([]byte("*"), len(data))
+	}
+	fields := make(map[byte][]byte, len(r.fields))
+	for field_type, data := range r.fields {
+		fields[field_type] = data
+	}
+	for _, field_type := range []byte{passwordField, totpField} {
+		if data, ok := fields[field_type]; ok {
+			fields[field_type] = mask(data)
+		}
+	}
+	if data, ok := fields[historyField]; ok {
+		delete(fields, historyField)
+		enabled, max, entries, err := decodeHistory(data)
+		if err == nil {
+			for i := range entries {
+				entries[i].Password = string(mask([]byte(
+					entries[i].Password)))
+			}
+			fields[historyField] = encodeHistory(enabled, max, entries)
+		}
+	}
+	return fields
+}
+
+// marshalFields adds the typed fields to the object and returns the fields
+// that have to be stored raw
+func marshalFields(b *jsonObjectBuilder, json_fields []jsonField,
+	fields map[byte][]byte) []jsonRawField {
+
+	typed := make(map[byte]bool)
+	for _, jf := range json_fields {
+		data, ok := fields[jf.field_type]
+		if !ok {
+			continue
+		}
+		value, ok := encodeJSONValue(jf.kind, data)
+		if !ok {
+			continue
+		}
+		b.add(jf.name, value)
+		typed[jf.field_type] = true
+	}
+
+	var raw []jsonRawField
+	for field_type := 0; field_type <= 0xff; field_type++ {
+		data, ok := fields[byte(field_type)]
+		if ok && !typed[byte(field_type)] {
+			raw = append(raw, jsonRawField{
+				Type: byte(field_type),
+				Data: data,
+			})
+		}
+	}
+	return raw
+}
+
+// unmarshalFields decodes the typed fields of the object and returns the raw
+// fields
+func unmarshalFields(json_fields []jsonField,
+	object map[string]json.RawMessage) (map[byte][]byte, []jsonRawField,
+	error) {
+
+	fields := make(map[byte][]byte)
+	for _, jf := range json_fields {
+		value, ok := object[jf.name]
+		if !ok {
+			continue
+		}
+		data, err := decodeJSONValue(jf.kind, value)
+		if err != nil {
+			return nil, nil, Corrupted.New("invalid %s: %s", jf.name, err)
+		}
+		fields[jf.field_type] = data
+	}
+
+	var raw []jsonRawField
+	if value, ok := object["raw_fields"]; ok {
+		if err := json.Unmarshal(value, &raw); err != nil {
+			return nil, nil, Corrupted.New("invalid raw_fields: %s", err)
+		}
+	}
+	return fields, raw, nil
+}
+
+// encodeJSONValue returns the JSON value for the field data. ok is false if
+// the data cannot be represented exactly, in which case the field is stored
+// raw.
+func encodeJSONValue(kind jsonKind, data []byte) (value interface{},
+	ok bool) {
+
+	if len(data) == 0 {
+		return nil, false
+	}
+
+	switch kind {
+	case jsonText:
+		if utf8.Valid(data) {
+			value = string(data)
+		}
+	case jsonTime:
+		if len(data) == 4 {
+			value = decodeTimeField(data).UTC().Format(time.RFC3339)
+		}
+	case jsonUUID:
+		if len(data) == 16 {
+			value = hex.EncodeToString(data)
+		}
+	case jsonUint16:
+		if len(data) == 2 {
+			value = binary.LittleEndian.Uint16(data)
+		}
+	case jsonUint32:
+		if len(data) == 4 {
+			value = binary.LittleEndian.Uint32(data)
+		}
+	case jsonBool:
+		if len(data) == 1 && data[0] <= 1 {
+			value = data[0] == 1
+		}
+	case jsonHistory:
+		enabled, max, entries, err := decodeHistory(data)
+		if err == nil {
+			history := jsonPasswordHistory{
+				Enabled: enabled,
+				Max:     max,
+				Entries: []jsonHistoryEntry{},
+			}
+			for _, entry := range entries {
+				history.Entries = append(history.Entries, jsonHistoryEntry{
+					Time:     entry.Time.UTC().Format(time.RFC3339),
+					Password: entry.Password,
+				})
+			}
+			value = history
+		}
+	case jsonCustomFields:
+		fields, err := decodeCustomFields(data)
+		if err == nil {
+			custom := []jsonCustomField{}
+			for _, field := range fields {
+				custom = append(custom, jsonCustomField{
+					Name:  field.Name,
+					Value: field.Value,
+				})
+			}
+			value = custom
+		}
+	}
+	if value == nil {
+		return nil, false
+	}
+
+	// only use the typed value if it encodes back to the same data
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, false
+	}
+	decoded, err := decodeJSONValue(kind, encoded)
+	if err != nil || !bytes.Equal(decoded, data) {
+		return nil, false
+	}
+	return value, true
+}
+
+// decodeJSONValue returns the field data for the JSON value
+func decodeJSONValue(kind jsonKind, value json.RawMessage) ([]byte, error) {
+	switch kind {
+	case jsonText:
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			return nil, err
+		}
+		return []byte(s), nil
+	case jsonTime:
+		t, err := decodeJSONTime(value)
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, 4)
+		binary.LittleEndian.PutUint32(data, uint32(t.Unix()))
+		return data, nil
+	case jsonUUID:
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			return nil, err
+		}
+		return decodeUUID(s)
+	case jsonUint16:
+		var v uint16
+		if err := json.Unmarshal(value, &v); err != nil {
+			return nil, err
+		}
+		data := make([]byte, 2)
+		binary.LittleEndian.PutUint16(data, v)
+		return data, nil
+	case jsonUint32:
+		var v uint32
+		if err := json.Unmarshal(value, &v); err != nil {
+			return nil, err
+		}
+		data := make([]byte, 4)
+		binary.LittleEndian.PutUint32(data, v)
+		return data, nil
+	case jsonBool:
+		var v bool
+		if err := json.Unmarshal(value, &v); err != nil {
+			return nil, err
+		}
+		if v {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case jsonHistory:
+		var history jsonPasswordHistory
+		if err := json.Unmarshal(value, &history); err != nil {
+			return nil, err
+		}
+		var entries []pwsafe.HistoryEntry
+		for _, entry := range history.Entries {
+			t, err := time.Parse(time.RFC3339, entry.Time)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, pwsafe.HistoryEntry{
+				Time:     t,
+				Password: entry.Password,
+			})
+		}
+		if history.Max < 0 || history.Max > 0xff || len(entries) > 0xff {
+			return nil, Corrupted.New("history too large")
+		}
+		return encodeHistory(history.Enabled, history.Max, entries), nil
+	case jsonCustomFields:
+		var custom []jsonCustomField
+		if err := json.Unmarshal(value, &custom); err != nil {
+			return nil, err
+		}
+		var fields []pwsafe.CustomField
+		for _, field := range custom {
+			fields = append(fields, pwsafe.CustomField{
+				Name:  field.Name,
+				Value: field.Value,
+			})
+		}
+		return encodeCustomFields(fields), nil
+	}
+	return nil, Error.New("unknown json kind %d", kind)
+}
+
+func decodeJSONTime(value json.RawMessage) (time.Time, error) {
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// jsonObjectBuilder builds a JSON object with the keys in insertion order
+type jsonObjectBuilder struct {
+	buf bytes.Buffer
+	err error
+}
+
+func (b *jsonObjectBuilder) add(key string, value interface{}) {
+	if b.err != nil {
+		return
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		b.err = err
+		return
+	}
+	if b.buf.Len() == 0 {
+		b.buf.WriteByte('{')
+	} else {
+		b.buf.WriteByte(',')
+	}
+	b.buf.WriteString(strconv.Quote(key))
+	b.buf.WriteByte(':')
+	b.buf.Write(encoded)
+}
+
+func (b *jsonObjectBuilder) bytes() ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if b.buf.Len() == 0 {
+		return []byte("{}"), nil
+	}
+	return append(b.buf.Bytes(), '}'), nil
+}
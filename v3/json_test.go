@@ -0,0 +1,60 @@
+package v3
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestReadJSONIterations checks that a database read from JSON is saved
+// with the default key stretching
+func TestReadJSONIterations(t *testing.T) {
+	db, err := NewDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := NewRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record.SetTitle("entry")
+	record.SetPassword("secret")
+	if err = db.AddRecord(record); err != nil {
+		t.Fatal(err)
+	}
+	data, err := db.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported, err := ReadJSON(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved bytes.Buffer
+	if err = imported.SaveWriter(&saved, "passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	opened, err := OpenReader(&saved, func() (string, error) {
+		return "passphrase", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.Iterations() != int(hashIterations) {
+		t.Errorf("expected %d iterations, got %d", hashIterations,
+			opened.Iterations())
+	}
+	if len(opened.Records()) != 1 ||
+		opened.Records()[0].Password() != "secret" {
+		t.Errorf("expected the imported entry, got %v", opened.Records())
+	}
+}
+
+// TestSaveMinIterations checks that databases are not saved without key
+// stretching
+func TestSaveMinIterations(t *testing.T) {
+	db := &Database{header: newHeader(nil)}
+	if err := db.SaveWriter(new(bytes.Buffer), "passphrase"); err == nil {
+		t.Error("expected saving with 0 iterations to fail")
+	}
+}
@@ -0,0 +1,458 @@
+package v3
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/azdagron/pwsafe"
+)
+
+// Merge merges the changes made in theirs since base into a copy of the
+// database. See pwsafe.Merge.
+func (db *Database) Merge(base, theirs pwsafe.Database,
+	options pwsafe.MergeOptions) (pwsafe.Database, []pwsafe.Conflict, error) {
+
+	var base_db *Database
+	if base != nil {
+		var ok bool
+		if base_db, ok = base.(*Database); !ok {
+			return nil, nil, Unsupported.New("cannot merge %s databases",
+				base.Version())
+		}
+	}
+	theirs_db, ok := theirs.(*Database)
+	if !ok {
+		return nil, nil, Unsupported.New("cannot merge %s databases",
+			theirs.Version())
+	}
+	merged, conflicts, err := Merge(base_db, db, theirs_db, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	return merged, conflicts, nil
+}
+
+// Merge returns the three-way merge of the changes made in ours and theirs
+// since base, which may be nil. The merged database has the header and
+// iterations of ours. See pwsafe.Merge.
+func Merge(base, ours, theirs *Database, options pwsafe.MergeOptions) (
+	*Database, []pwsafe.Conflict, error) {
+
+	if ours == nil || theirs == nil {
+		return nil, nil, Error.New("missing database to merge")
+	}
+	if options.Suffix == "" {
+		options.Suffix = pwsafe.DefaultConflictSuffix
+	}
+	m := &merger{
+		options: options,
+		result:  newDatabase(ours.header.clone(), nil),
+	}
+	m.result.iterations = ours.iterations
+	m.result.snapshot = ours.snapshot
+
+	base_records := make(map[string]*Record)
+	if base != nil {
+		for _, record := range base.records {
+			base_records[record.UUID()] = record
+		}
+	}
+	their_records := make(map[string]*Record)
+	for _, record := range theirs.records {
+		their_records[record.UUID()] = record
+	}
+
+	seen := make(map[string]bool)
+	for _, record := range ours.records {
+		uuid := record.UUID()
+		seen[uuid] = true
+		original, theirs := base_records[uuid], their_records[uuid]
+		switch {
+		case theirs != nil:
+			if err := m.mergeRecord(original, record, theirs); err != nil {
+				return nil, nil, err
+			}
+		case original == nil:
+			m.add(record.clone())
+		case !record.changedSince(original):
+			// removed in theirs
+		default:
+			m.removed(record, pwsafe.Theirs)
+		}
+	}
+	for _, record := range theirs.records {
+		uuid := record.UUID()
+		if seen[uuid] {
+			continue
+		}
+		original := base_records[uuid]
+		switch {
+		case original == nil:
+			m.add(record.clone())
+		case !record.changedSince(original):
+			// removed in ours
+		default:
+			m.removed(record, pwsafe.Ours)
+		}
+	}
+
+	var base_header *Header
+	if base != nil {
+		base_header = base.header
+	}
+	m.mergeHeader(base_header, ours.header, theirs.header)
+	if err := m.mergeAuditLog(ours, theirs); err != nil {
+		return nil, nil, err
+	}
+	return m.result, m.conflicts, nil
+}
+
+// merger holds the state of a merge
+type merger struct {
+	options   pwsafe.MergeOptions
+	result    *Database
+	conflicts []pwsafe.Conflict
+}
+
+func (m *merger) add(record *Record) {
+	m.result.records = append(m.result.records, record)
+}
+
+// conflict records a conflict on the record
+func (m *merger) conflict(record *Record, field string, removed,
+	resolution pwsafe.MergeSide) {
+
+	m.conflicts = append(m.conflicts, pwsafe.Conflict{
+		UUID:       record.UUID(),
+		Title:      record.Title(),
+		Group:      record.Group(),
+		Field:      field,
+		Removed:    removed,
+		Resolution: resolution,
+	})
+}
+
+// removed handles a record removed from one database and changed in the
+// other. The changed record is kept, unless our removal is preferred.
+func (m *merger) removed(changed *Record, removed_from pwsafe.MergeSide) {
+	if removed_from == pwsafe.Ours &&
+		m.options.Strategy == pwsafe.PreferOurs {
+		m.conflict(changed, "", removed_from, pwsafe.Ours)
+		return
+	}
+	kept := pwsafe.Ours
+	if removed_from == pwsafe.Ours {
+		kept = pwsafe.Theirs
+	}
+	m.add(changed.clone())
+	m.conflict(changed, "", removed_from, kept)
+}
+
+// mergeRecord adds the merge of a record present in both databases. base is
+// nil if the record is not in the base database.
+func (m *merger) mergeRecord(base, ours, theirs *Record) error {
+	merged := ours.clone()
+
+	field_types := make(map[byte]bool)
+	for _, record := range []*Record{base, ours, theirs} {
+		if record == nil {
+			continue
+		}
+		for field_type := range record.fields {
+			field_types[field_type] = true
+		}
+	}
+	var conflicting []byte
+	for _, field_type := range sortedFieldTypes(field_types) {
+		switch field_type {
+		case uuidField, ctimeField, mtimeField, atimeField,
+			passwordMtimeField, historyField:
+			// merged below
+			continue
+		}
+		our_value, their_value := ours.fields[field_type],
+			theirs.fields[field_type]
+		if bytes.Equal(our_value, their_value) {
+			continue
+		}
+		if base != nil {
+			base_value := base.fields[field_type]
+			if bytes.Equal(our_value, base_value) {
+				takeField(merged, theirs, field_type)
+				continue
+			}
+			if bytes.Equal(their_value, base_value) {
+				continue
+			}
+		}
+		conflicting = append(conflicting, field_type)
+	}
+
+	first := len(m.conflicts)
+	for _, field_type := range conflicting {
+		side := pwsafe.Ours
+		switch m.options.Strategy {
+		case pwsafe.PreferNewer:
+			if fieldTime(theirs, field_type).After(
+				fieldTime(ours, field_type)) {
+				side = pwsafe.Theirs
+				takeField(merged, theirs, field_type)
+			}
+		case pwsafe.KeepBoth:
+			side = pwsafe.Both
+		}
+		m.conflict(merged, fieldName(field_type), 0, side)
+	}
+
+	merged.SetField(ctimeField, earliestTime(ours.Ctime(),
+		theirs.Ctime()))
+	merged.SetField(mtimeField, latestTime(ours.Mtime(), theirs.Mtime()))
+	merged.SetField(atimeField, latestTime(ours.Atime(), theirs.Atime()))
+	keep_both := len(conflicting) > 0 &&
+		m.options.Strategy == pwsafe.KeepBoth
+	mergeHistory(merged, ours, theirs, !keep_both)
+
+	// the title and group may have been merged
+	for i := first; i < len(m.conflicts); i++ {
+		m.conflicts[i].Title = merged.Title()
+		m.conflicts[i].Group = merged.Group()
+	}
+	m.add(merged)
+
+	if keep_both {
+		duplicate := theirs.clone()
+		uuid, err := newUUID()
+		if err != nil {
+			return err
+		}
+		duplicate.fields[uuidField] = uuid
+		duplicate.SetTitle(theirs.Title() + m.options.Suffix)
+		m.add(duplicate)
+		for i := first; i < len(m.conflicts); i++ {
+			m.conflicts[i].Copy = duplicate.UUID()
+		}
+	}
+	return nil
+}
+
+// takeField sets the field of the merged record to the value of their
+// record. The password modification time follows the password.
+func takeField(merged, theirs *Record, field_type byte) {
+	merged.SetField(field_type, theirs.fields[field_type])
+	if field_type == passwordField {
+		merged.SetField(passwordMtimeField, theirs.fields[passwordMtimeField])
+	}
+}
+
+// mergeHistory sets the password history of the merged record to the
+// entries of both histories. With keep_replaced, a password of ours or
+// theirs that lost to the merged password is kept in the history as well.
+// The history settings of ours are kept if it has a history.
+func mergeHistory(merged, ours, theirs *Record, keep_replaced bool) {
+	entries := append(ours.History(), theirs.History()...)
+	for _, record := range []*Record{ours, theirs} {
+		if !keep_replaced || record.Password() == "" ||
+			record.Password() == merged.Password() {
+			continue
+		}
+		set := record.PasswordMtime()
+		if set.IsZero() {
+			set = record.Ctime()
+		}
+		entries = append(entries, pwsafe.HistoryEntry{
+			Time:     set,
+			Password: record.Password(),
+		})
+	}
+	if len(entries) == 0 {
+		return
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	unique := entries[:0]
+	seen := make(map[pwsafe.HistoryEntry]bool)
+	for _, entry := range entries {
+		key := pwsafe.HistoryEntry{
+			Time:     time.Unix(entry.Time.Unix(), 0),
+			Password: entry.Password,
+		}
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, entry)
+		}
+	}
+
+	if merged.fields[historyField] == nil {
+		merged.SetField(historyField, theirs.fields[historyField])
+	}
+	enabled, max, _, err := decodeHistory(merged.fields[historyField])
+	if err == nil && enabled && max > 0 && len(unique) > max {
+		unique = unique[len(unique)-max:]
+	}
+	merged.SetHistory(unique)
+}
+
+// mergeHeader merges the database name, description and empty groups into
+// the header of the result, which is a copy of ours
+func (m *merger) mergeHeader(base, ours, theirs *Header) {
+	header := m.result.header
+	for _, field_type := range []byte{databaseNameHeader,
+		databaseDescHeader} {
+
+		our_value, their_value := ours.fields[field_type],
+			theirs.fields[field_type]
+		if bytes.Equal(our_value, their_value) {
+			continue
+		}
+		if base != nil {
+			base_value := base.fields[field_type]
+			if bytes.Equal(our_value, base_value) {
+				header.SetField(field_type, their_value)
+				continue
+			}
+			if bytes.Equal(their_value, base_value) {
+				continue
+			}
+		}
+		side := pwsafe.Ours
+		if m.options.Strategy == pwsafe.PreferNewer &&
+			theirs.Mtime().After(ours.Mtime()) {
+			side = pwsafe.Theirs
+			header.SetField(field_type, their_value)
+		}
+		m.conflicts = append(m.conflicts, pwsafe.Conflict{
+			Field:      headerFieldName(field_type),
+			Resolution: side,
+		})
+	}
+
+	// an empty group stays removed if either database removed it, and is
+	// no longer empty if a merged record is in it
+	var base_groups []string
+	if base != nil {
+		base_groups = base.emptyGroups
+	}
+	in_base := groupSet(base_groups)
+	in_ours := groupSet(ours.emptyGroups)
+	in_theirs := groupSet(theirs.emptyGroups)
+	used := make(map[string]bool)
+	for _, record := range m.result.records {
+		used[record.Group()] = true
+	}
+	var groups []string
+	for _, group := range append(ours.EmptyGroups(), theirs.emptyGroups...) {
+		if in_base[group] && (!in_ours[group] || !in_theirs[group]) ||
+			used[group] {
+			continue
+		}
+		used[group] = true
+		groups = append(groups, group)
+	}
+	header.SetEmptyGroups(groups)
+}
+
+func groupSet(groups []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, group := range groups {
+		set[group] = true
+	}
+	return set
+}
+
+// changedSince returns true if a field other than the access time differs
+// from the original record
+func (r *Record) changedSince(original *Record) bool {
+	for field_type, data := range r.fields {
+		if field_type != atimeField &&
+			!bytes.Equal(data, original.fields[field_type]) {
+			return true
+		}
+	}
+	for field_type := range original.fields {
+		if _, ok := r.fields[field_type]; !ok && field_type != atimeField {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldTime returns when the field of the record was last changed, which
+// for most fields is only known for the whole record
+func fieldTime(r *Record, field_type byte) time.Time {
+	if field_type == passwordField && !r.PasswordMtime().IsZero() {
+		return r.PasswordMtime()
+	}
+	if mtime := r.Mtime(); !mtime.IsZero() {
+		return mtime
+	}
+	return r.Ctime()
+}
+
+func earliestTime(a, b time.Time) []byte {
+	if a.IsZero() || !b.IsZero() && b.Before(a) {
+		return encodeTimeField(b)
+	}
+	return encodeTimeField(a)
+}
+
+func latestTime(a, b time.Time) []byte {
+	if b.After(a) {
+		return encodeTimeField(b)
+	}
+	return encodeTimeField(a)
+}
+
+func sortedFieldTypes(set map[byte]bool) []byte {
+	field_types := make([]byte, 0, len(set))
+	for field_type := range set {
+		field_types = append(field_types, field_type)
+	}
+	sort.Slice(field_types, func(i, j int) bool {
+		return field_types[i] < field_types[j]
+	})
+	return field_types
+}
+
+// fieldName returns the name of a record field type, as used in the JSON
+// representation
+func fieldName(field_type byte) string {
+	for _, field := range recordJSONFields {
+		if field.field_type == field_type {
+			return field.name
+		}
+	}
+	return fmt.Sprintf("field 0x%02x", field_type)
+}
+
+// headerFieldName returns the name of a header field type, as used in the
+// JSON representation
+func headerFieldName(field_type byte) string {
+	for _, field := range headerJSONFields {
+		if field.field_type == field_type {
+			return field.name
+		}
+	}
+	return fmt.Sprintf("header field 0x%02x", field_type)
+}
+
+func (r *Record) clone() *Record {
+	fields := make(map[byte][]byte, len(r.fields))
+	for field_type, data := range r.fields {
+		fields[field_type] = append([]byte{}, data...)
+	}
+	return newRecord(fields)
+}
+
+func (h *Header) clone() *Header {
+	fields := make(map[byte][]byte, len(h.fields))
+	for field_type, data := range h.fields {
+		fields[field_type] = append([]byte{}, data...)
+	}
+	header := newHeader(fields)
+	header.emptyGroups = h.EmptyGroups()
+	return header
+}
@@ -122,6 +122,7 @@ func OpenReader(r io.Reader, passphrase_fn PassphraseFn) (
 	var header *Header
 	var records []*Record
 	var fields map[byte][]byte
+	var empty_groups []string
 	hm := hmac.New(sha256.New, append(b3, b4...))
 	for {
 		// read in the next record data length
@@ -151,11 +152,15 @@ func OpenReader(r io.Reader, passphrase_fn PassphraseFn) (
 		if raw_record[0] == fieldEnd {
 			if inheader {
 				header = newHeader(fields)
+				header.emptyGroups = empty_groups
 				inheader = false
 			} else {
 				records = append(records, newRecord(fields))
 			}
 			fields = nil
+		} else if inheader && raw_record[0] == emptyGroupsHeader {
+			// the empty groups field can appear multiple times
+			empty_groups = append(empty_groups, string(data))
 		} else {
 			if fields == nil {
 				fields = make(map[byte][]byte)
@@ -172,5 +177,8 @@ func OpenReader(r io.Reader, passphrase_fn PassphraseFn) (
 			expected_hmac, actual_hmac)
 	}
 
-	return newDatabase(header, records), nil
+	database = newDatabase(header, records)
+	database.iterations = iter
+	database.snapshot = database.takeSnapshot()
+	return database, nil
 }
@@ -0,0 +1,243 @@
+package v3
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/azdagron/pwsafe"
+)
+
+// Password policy flags
+const (
+	policyUseLowercase      = 0x8000
+	policyUseUppercase      = 0x4000
+	policyUseDigits         = 0x2000
+	policyUseSymbols        = 0x1000
+	policyUseHexDigits      = 0x0800
+	policyUseEasyVision     = 0x0400
+	policyMakePronounceable = 0x0200
+
+	// policyLength is the length of an encoded "ffffnnnllluuudddsss"
+	// policy
+	policyLength = 19
+)
+
+// PasswordPolicy returns the password policy stored with the record. ok is
+// false if the record has no policy of its own or it is malformed.
+func (r *Record) PasswordPolicy() (policy pwsafe.PasswordPolicy, ok bool) {
+	data := r.fields[policyField]
+	if len(data) != policyLength {
+		return pwsafe.PasswordPolicy{}, false
+	}
+	policy, err := decodePolicy(string(data))
+	if err != nil {
+		return pwsafe.PasswordPolicy{}, false
+	}
+	policy.Symbols = string(r.fields[passwordSymField])
+	return policy, true
+}
+
+// SetPasswordPolicy stores a policy with the record and removes any named
+// policy reference.
+func (r *Record) SetPasswordPolicy(policy pwsafe.PasswordPolicy) {
+	r.setText(policyField, encodePolicy(policy))
+	r.setText(passwordSymField, policy.Symbols)
+	r.SetField(policyNameField, nil)
+}
+
+// PasswordPolicyName returns the name of the named policy the record uses, if
+// any
+func (r *Record) PasswordPolicyName() string {
+	return string(r.fields[policyNameField])
+}
+
+// SetPasswordPolicyName makes the record use the named policy and removes
+// any policy of its own. An empty name removes the reference.
+func (r *Record) SetPasswordPolicyName(name string) {
+	r.setText(policyNameField, name)
+	if name != "" {
+		r.SetField(policyField, nil)
+		r.SetField(passwordSymField, nil)
+	}
+}
+
+// PasswordPolicies returns the named password policies of the database. A
+// malformed field is treated as having no policies.
+func (h *Header) PasswordPolicies() []pwsafe.PasswordPolicy {
+	policies, err := decodeNamedPolicies(h.fields[namedPasswordPoliciesHeader])
+	if err != nil {
+		return nil
+	}
+	return policies
+}
+
+// SetPasswordPolicies sets the named password policies of the database
+func (h *Header) SetPasswordPolicies(policies []pwsafe.PasswordPolicy) error {
+	data, err := encodeNamedPolicies(policies)
+	if err != nil {
+		return err
+	}
+	h.SetField(namedPasswordPoliciesHeader, data)
+	return nil
+}
+
+// PasswordPolicy returns the policy to generate a new password for the
+// record with: its own policy, the named policy it refers to or
+// pwsafe.DefaultPasswordPolicy, in that order.
+func (db *Database) PasswordPolicy(record *Record) pwsafe.PasswordPolicy {
+	if policy, ok := record.PasswordPolicy(); ok {
+		return policy
+	}
+	if name := record.PasswordPolicyName(); name != "" {
+		for _, policy := range db.header.PasswordPolicies() {
+			if policy.Name == name {
+				return policy
+			}
+		}
+	}
+	return pwsafe.DefaultPasswordPolicy
+}
+
+// decodePolicy decodes a "ffffnnnllluuudddsss" policy
+func decodePolicy(data string) (policy pwsafe.PasswordPolicy, err error) {
+	if len(data) != policyLength {
+		return policy, Corrupted.New("invalid password policy length")
+	}
+	values := make([]int, 6)
+	offset := 0
+	for i := range values {
+		size := 3
+		if i == 0 {
+			size = 4
+		}
+		v, err := strconv.ParseUint(data[offset:offset+size], 16, 16)
+		if err != nil {
+			return policy, Corrupted.New("invalid password policy")
+		}
+		values[i] = int(v)
+		offset += size
+	}
+
+	flags := values[0]
+	policy.UseLowercase = flags&policyUseLowercase != 0
+	policy.UseUppercase = flags&policyUseUppercase != 0
+	policy.UseDigits = flags&policyUseDigits != 0
+	policy.UseSymbols = flags&policyUseSymbols != 0
+	policy.UseHexDigits = flags&policyUseHexDigits != 0
+	policy.UseEasyVision = flags&policyUseEasyVision != 0
+	policy.MakePronounceable = flags&policyMakePronounceable != 0
+	policy.Length = values[1]
+	policy.MinLowercase = values[2]
+	policy.MinUppercase = values[3]
+	policy.MinDigits = values[4]
+	policy.MinSymbols = values[5]
+	return policy, nil
+}
+
+// encodePolicy encodes the policy as "ffffnnnllluuudddsss". The symbols and
+// name are not part of the encoding.
+func encodePolicy(policy pwsafe.PasswordPolicy) string {
+	flags := 0
+	set := func(use bool, flag int) {
+		if use {
+			flags |= flag
+		}
+	}
+	set(policy.UseLowercase, policyUseLowercase)
+	set(policy.UseUppercase, policyUseUppercase)
+	set(policy.UseDigits, policyUseDigits)
+	set(policy.UseSymbols, policyUseSymbols)
+	set(policy.UseHexDigits, policyUseHexDigits)
+	set(policy.UseEasyVision, policyUseEasyVision)
+	set(policy.MakePronounceable, policyMakePronounceable)
+	return fmt.Sprintf("%04x%03x%03x%03x%03x%03x", flags, policy.Length,
+		policy.MinLowercase, policy.MinUppercase, policy.MinDigits,
+		policy.MinSymbols)
+}
+
+// decodeNamedPolicies decodes the "NN{LLxxx...ffffnnnllluuudddsssMMSSS...}"
+// named policies header field
+func decodeNamedPolicies(data []byte) ([]pwsafe.PasswordPolicy, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	offset := 0
+	readHex := func(size int) (int, error) {
+		if offset+size > len(data) {
+			return 0, Corrupted.New("named password policies truncated")
+		}
+		v, err := strconv.ParseUint(string(data[offset:offset+size]), 16, 16)
+		if err != nil {
+			return 0, Corrupted.New("invalid named password policies")
+		}
+		offset += size
+		return int(v), nil
+	}
+	readText := func(size int) (string, error) {
+		if offset+size > len(data) {
+			return "", Corrupted.New("named password policies truncated")
+		}
+		text := string(data[offset : offset+size])
+		offset += size
+		return text, nil
+	}
+
+	count, err := readHex(2)
+	if err != nil {
+		return nil, err
+	}
+	policies := make([]pwsafe.PasswordPolicy, 0, count)
+	for i := 0; i < count; i++ {
+		name_len, err := readHex(2)
+		if err != nil {
+			return nil, err
+		}
+		name, err := readText(name_len)
+		if err != nil {
+			return nil, err
+		}
+		encoded, err := readText(policyLength)
+		if err != nil {
+			return nil, err
+		}
+		policy, err := decodePolicy(encoded)
+		if err != nil {
+			return nil, err
+		}
+		symbols_len, err := readHex(2)
+		if err != nil {
+			return nil, err
+		}
+		policy.Name = name
+		policy.Symbols, err = readText(symbols_len)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	if offset != len(data) {
+		return nil, Corrupted.New("trailing data in named password policies")
+	}
+	return policies, nil
+}
+
+// encodeNamedPolicies encodes the named policies header field
+func encodeNamedPolicies(policies []pwsafe.PasswordPolicy) ([]byte, error) {
+	if len(policies) == 0 {
+		return nil, nil
+	}
+	if len(policies) > 0xff {
+		return nil, Error.New("too many named password policies")
+	}
+	data := fmt.Sprintf("%02x", len(policies))
+	for _, policy := range policies {
+		if policy.Name == "" || len(policy.Name) > 0xff ||
+			len(policy.Symbols) > 0xff {
+			return nil, Error.New("invalid named password policy %q",
+				policy.Name)
+		}
+		data += fmt.Sprintf("%02x%s%s%02x%s", len(policy.Name), policy.Name,
+			encodePolicy(policy), len(policy.Symbols), policy.Symbols)
+	}
+	return []byte(data), nil
+}
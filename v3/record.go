@@ -2,7 +2,18 @@ package v3
 
 import (
 	"encoding/hex"
+	"fmt"
+	"strconv"
 	"time"
+	"unicode/utf8"
+
+	"github.com/azdagron/pwsafe"
+)
+
+const (
+	// defaultHistoryMax is the number of passwords kept in a new password
+	// history.
+	defaultHistoryMax = 3
 )
 
 type Record struct {
@@ -16,42 +27,372 @@ func newRecord(fields map[byte][]byte) *Record {
 	return &Record{fields: fields}
 }
 
+// NewRecord returns a new record with a random UUID and the creation and
+// modification times set to now.
+func NewRecord() (*Record, error) {
+	uuid, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+	r := newRecord(nil)
+	r.fields[uuidField] = uuid
+	now := time.Now()
+	r.SetCtime(now)
+	r.SetMtime(now)
+	return r, nil
+}
+
+// Field returns the raw data for the field type
+func (r *Record) Field(field_type byte) []byte {
+	return r.fields[field_type]
+}
+
+// SetField sets the raw data for the field type. An empty value removes the
+// field.
+func (r *Record) SetField(field_type byte, data []byte) {
+	if len(data) == 0 {
+		delete(r.fields, field_type)
+		return
+	}
+	r.fields[field_type] = append([]byte{}, data...)
+}
+
+func (r *Record) setText(field_type byte, value string) {
+	r.SetField(field_type, []byte(value))
+}
+
 func (r *Record) UUID() string {
 	return hex.EncodeToString(r.fields[uuidField])
 }
 
+// SetUUID sets the record UUID from the 32 hex character representation
+func (r *Record) SetUUID(uuid string) error {
+	data, err := decodeUUID(uuid)
+	if err != nil {
+		return err
+	}
+	r.fields[uuidField] = data
+	return nil
+}
+
 func (r *Record) Title() string {
 	return string(r.fields[titleField])
 }
 
+func (r *Record) SetTitle(title string) {
+	r.setText(titleField, title)
+}
+
 func (r *Record) Username() string {
 	return string(r.fields[usernameField])
 }
 
+func (r *Record) SetUsername(username string) {
+	r.setText(usernameField, username)
+}
+
 func (r *Record) Password() string {
 	return string(r.fields[passwordField])
 }
 
+func (r *Record) SetPassword(password string) {
+	r.setText(passwordField, password)
+}
+
 func (r *Record) Notes() string {
 	return string(r.fields[notesField])
 }
 
+func (r *Record) SetNotes(notes string) {
+	r.setText(notesField, notes)
+}
+
 func (r *Record) Group() string {
 	return string(r.fields[groupField])
 }
 
+func (r *Record) SetGroup(group string) {
+	r.setText(groupField, group)
+}
+
 func (r *Record) URL() string {
 	return string(r.fields[urlField])
 }
 
+func (r *Record) SetURL(url string) {
+	r.setText(urlField, url)
+}
+
+func (r *Record) Email() string {
+	return string(r.fields[emailField])
+}
+
+func (r *Record) SetEmail(email string) {
+	r.setText(emailField, email)
+}
+
 func (r *Record) Ctime() time.Time {
 	return decodeTimeField(r.fields[ctimeField])
 }
 
+func (r *Record) SetCtime(t time.Time) {
+	r.SetField(ctimeField, encodeTimeField(t))
+}
+
 func (r *Record) Mtime() time.Time {
 	return decodeTimeField(r.fields[mtimeField])
 }
 
+func (r *Record) SetMtime(t time.Time) {
+	r.SetField(mtimeField, encodeTimeField(t))
+}
+
 func (r *Record) Atime() time.Time {
 	return decodeTimeField(r.fields[atimeField])
 }
+
+func (r *Record) SetAtime(t time.Time) {
+	r.SetField(atimeField, encodeTimeField(t))
+}
+
+func (r *Record) PasswordMtime() time.Time {
+	return decodeTimeField(r.fields[passwordMtimeField])
+}
+
+func (r *Record) SetPasswordMtime(t time.Time) {
+	r.SetField(passwordMtimeField, encodeTimeField(t))
+}
+
+func (r *Record) Expiry() time.Time {
+	return decodeTimeField(r.fields[expiryField])
+}
+
+func (r *Record) SetExpiry(t time.Time) {
+	r.SetField(expiryField, encodeTimeField(t))
+}
+
+// TOTP returns the TOTP secret, either as a base32 secret or an otpauth URI
+func (r *Record) TOTP() string {
+	return string(r.fields[totpField])
+}
+
+func (r *Record) SetTOTP(totp string) {
+	r.setText(totpField, totp)
+}
+
+// Protected returns true if the record is protected from modification
+func (r *Record) Protected() bool {
+	data := r.fields[protectedEntryField]
+	return len(data) > 0 && data[0] != 0
+}
+
+func (r *Record) SetProtected(protected bool) {
+	if protected {
+		r.SetField(protectedEntryField, []byte{1})
+	} else {
+		r.SetField(protectedEntryField, nil)
+	}
+}
+
+// History returns the password history, oldest first. A malformed history
+// field is treated as an empty history.
+func (r *Record) History() []pwsafe.HistoryEntry {
+	_, _, entries, err := decodeHistory(r.fields[historyField])
+	if err != nil {
+		return nil
+	}
+	return entries
+}
+
+// SetHistory replaces the password history entries. The history settings
+// (enabled and maximum size) are preserved if already present, otherwise
+// history is enabled.
+func (r *Record) SetHistory(entries []pwsafe.HistoryEntry) {
+	enabled, max, _, err := decodeHistory(r.fields[historyField])
+	if err != nil || r.fields[historyField] == nil {
+		enabled, max = true, defaultHistoryMax
+	}
+	if len(entries) == 0 && r.fields[historyField] == nil {
+		return
+	}
+	if max < len(entries) {
+		max = len(entries)
+	}
+	r.SetField(historyField, encodeHistory(enabled, max, entries))
+}
+
+// ChangePassword sets a new password, keeping the previous password in the
+// history if the history is enabled, and updates the password modification
+// time.
+func (r *Record) ChangePassword(password string) {
+	old := r.Password()
+	if old == password {
+		return
+	}
+	enabled, max, entries, err := decodeHistory(r.fields[historyField])
+	if err != nil || r.fields[historyField] == nil {
+		enabled, max, entries = true, defaultHistoryMax, nil
+	}
+	if enabled && old != "" && max > 0 {
+		set := r.PasswordMtime()
+		if set.IsZero() {
+			set = r.Ctime()
+		}
+		entries = append(entries, pwsafe.HistoryEntry{
+			Time:     set,
+			Password: old,
+		})
+		if len(entries) > max {
+			entries = entries[len(entries)-max:]
+		}
+		r.SetField(historyField, encodeHistory(enabled, max, entries))
+	}
+	now := time.Now()
+	r.SetPassword(password)
+	r.SetPasswordMtime(now)
+	r.SetMtime(now)
+}
+
+// CustomFields returns the fields stored in the application-specific custom
+// fields field.
+func (r *Record) CustomFields() []pwsafe.CustomField {
+	fields, err := decodeCustomFields(r.fields[customFieldsField])
+	if err != nil {
+		return nil
+	}
+	return fields
+}
+
+// SetCustomFields replaces the custom fields of the record.
+func (r *Record) SetCustomFields(fields []pwsafe.CustomField) {
+	r.SetField(customFieldsField, encodeCustomFields(fields))
+}
+
+// decodeHistory decodes the password history field ("fmmnnTLPTLP...TLP")
+func decodeHistory(data []byte) (enabled bool, max int,
+	entries []pwsafe.HistoryEntry, err error) {
+
+	if len(data) == 0 {
+		return false, 0, nil, nil
+	}
+	s := string(data)
+	next := func(n int) (uint64, error) {
+		if len(s) < n {
+			return 0, Corrupted.New("truncated password history")
+		}
+		v, err := strconv.ParseUint(s[:n], 16, 32)
+		if err != nil {
+			return 0, Corrupted.New("invalid password history: %s", err)
+		}
+		s = s[n:]
+		return v, nil
+	}
+
+	flag, err := next(1)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	mm, err := next(2)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	nn, err := next(2)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	for i := uint64(0); i < nn; i++ {
+		t, err := next(8)
+		if err != nil {
+			return false, 0, nil, err
+		}
+		l, err := next(4)
+		if err != nil {
+			return false, 0, nil, err
+		}
+		// the length is in characters, not bytes
+		end := 0
+		for j := uint64(0); j < l; j++ {
+			if end >= len(s) {
+				return false, 0, nil, Corrupted.New(
+					"truncated password history")
+			}
+			_, size := utf8.DecodeRuneInString(s[end:])
+			end += size
+		}
+		entries = append(entries, pwsafe.HistoryEntry{
+			Time:     time.Unix(int64(t), 0),
+			Password: s[:end],
+		})
+		s = s[end:]
+	}
+	return flag == 1, int(mm), entries, nil
+}
+
+// encodeHistory encodes the password history field
+func encodeHistory(enabled bool, max int,
+	entries []pwsafe.HistoryEntry) []byte {
+
+	// the format can only hold 255 entries; keep the most recent
+	if len(entries) > 0xff {
+		entries = entries[len(entries)-0xff:]
+	}
+	if max > 0xff {
+		max = 0xff
+	}
+	flag := 0
+	if enabled {
+		flag = 1
+	}
+	s := fmt.Sprintf("%01x%02x%02x", flag, max, len(entries))
+	for _, entry := range entries {
+		s += fmt.Sprintf("%08x%04x%s", uint32(entry.Time.Unix()),
+			utf8.RuneCountInString(entry.Password), entry.Password)
+	}
+	return []byte(s)
+}
+
+// decodeCustomFields decodes the custom fields field. Each field is stored
+// as the name and value, each prefixed with an 8 hex digit byte length.
+func decodeCustomFields(data []byte) ([]pwsafe.CustomField, error) {
+	s := string(data)
+	next := func() (string, error) {
+		if len(s) < 8 {
+			return "", Corrupted.New("truncated custom fields")
+		}
+		n, err := strconv.ParseUint(s[:8], 16, 32)
+		if err != nil {
+			return "", Corrupted.New("invalid custom fields: %s", err)
+		}
+		s = s[8:]
+		if uint64(len(s)) < n {
+			return "", Corrupted.New("truncated custom fields")
+		}
+		v := s[:n]
+		s = s[n:]
+		return v, nil
+	}
+
+	var fields []pwsafe.CustomField
+	for len(s) > 0 {
+		name, err := next()
+		if err != nil {
+			return nil, err
+		}
+		value, err := next()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, pwsafe.CustomField{Name: name, Value: value})
+	}
+	return fields, nil
+}
+
+// encodeCustomFields encodes the custom fields field
+func encodeCustomFields(fields []pwsafe.CustomField) []byte {
+	var s string
+	for _, field := range fields {
+		s += fmt.Sprintf("%08x%s%08x%s", len(field.Name), field.Name,
+			len(field.Value), field.Value)
+	}
+	return []byte(s)
+}
@@ -8,6 +8,7 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"io"
+	"sort"
 
 	"github.com/azdagron/pwsafe/utils"
 	"golang.org/x/crypto/twofish"
@@ -15,6 +16,17 @@ import (
 
 // SaveWriter writes a v3 password safe database to an io.Writer
 func (db *Database) SaveWriter(w io.Writer, passphrase string) error {
+	return db.audited(func() error {
+		return db.saveWriter(w, passphrase)
+	})
+}
+
+func (db *Database) saveWriter(w io.Writer, passphrase string) error {
+	if db.iterations < minHashIterations {
+		return Error.New("refusing to save with %d iterations; at least "+
+			"%d are required", db.iterations, minHashIterations)
+	}
+
 	// new random values
 	salt, err := utils.SecureRandBytes(saltLen)
 	if err != nil {
@@ -50,17 +62,17 @@ func (db *Database) SaveWriter(w io.Writer, passphrase string) error {
 	hm := hmac.New(sha256.New, append(b3, b4...))
 
 	var records bytes.Buffer
-	if err = appendFields(hm, &records, db.header.fields); err != nil {
+	if err = appendHeader(hm, &records, db.header); err != nil {
 		return IOError.Wrap(err)
 	}
 	for _, record := range db.records {
-		if err = appendFields(hm, &records, record.fields); err != nil {
+		if err = appendRecord(hm, &records, record); err != nil {
 			return IOError.Wrap(err)
 		}
 	}
 
 	// generate encryption key
-	pkey, phash := makeKey(passphrase, salt, hashIterations)
+	pkey, phash := makeKey(passphrase, salt, db.iterations)
 
 	// encrypt keys
 	key_cipher, err := twofish.NewCipher(pkey)
@@ -94,7 +106,7 @@ func (db *Database) SaveWriter(w io.Writer, passphrase string) error {
 		return IOError.Wrap(err)
 	}
 
-	err = binary.Write(w, binary.LittleEndian, hashIterations)
+	err = binary.Write(w, binary.LittleEndian, db.iterations)
 	if err != nil {
 		return IOError.Wrap(err)
 	}
@@ -147,9 +159,40 @@ func (db *Database) SaveWriter(w io.Writer, passphrase string) error {
 	return nil
 }
 
-func appendFields(h io.Writer, b *bytes.Buffer, fields map[byte][]byte) error {
-	for field_type, field_data := range fields {
-		if err := appendField(h, b, field_type, field_data); err != nil {
+func appendHeader(h io.Writer, b *bytes.Buffer, header *Header) error {
+	return appendFields(h, b, header.fields, func() error {
+		for _, group := range header.emptyGroups {
+			err := appendField(h, b, emptyGroupsHeader, []byte(group))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func appendRecord(h io.Writer, b *bytes.Buffer, record *Record) error {
+	return appendFields(h, b, record.fields, nil)
+}
+
+// appendFields appends the fields in field type order, so the version field
+// leads the header, followed by any extra fields and the end field.
+func appendFields(h io.Writer, b *bytes.Buffer, fields map[byte][]byte,
+	extra func() error) error {
+
+	field_types := make([]int, 0, len(fields))
+	for field_type := range fields {
+		field_types = append(field_types, int(field_type))
+	}
+	sort.Ints(field_types)
+	for _, field_type := range field_types {
+		err := appendField(h, b, byte(field_type), fields[byte(field_type)])
+		if err != nil {
+			return err
+		}
+	}
+	if extra != nil {
+		if err := extra(); err != nil {
 			return err
 		}
 	}